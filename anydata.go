@@ -4,14 +4,32 @@
 // of techniques that will parse and extract records and fields and interoperate well.
 //
 // Current support includes opening files from local paths and the following URL schemes:
-//    http:// https:// ftp:// file://
+//    http:// https:// ftp:// ftps:// s3:// sftp:// scp:// rsync:// hdfs:// drive:// dropbox://
+//    box:// file://
+//
+// Standard input may be read using the resource strings "-" or "stdin://", and small data sets
+// may be given inline using RFC 2397 data: URIs. Database URLs such as postgres:// and mysql://
+// stream the results of a query (see SQLDrivers and the "sql" format), and kafka:// topics are
+// streamed as newline-separated messages for continuous ingestion. The output of vendor tools
+// can be read using exec:// resources, once the program is allowed with AllowCommands.
 //
 // Transparent decompression is enabled for files (including remote URLs) ending in:
-//    .gz .bz2 .bzip2 .zip
+//    .gz .bz2 .bzip2 .xz .lzma .zst .zstd .zip
+//
+// Other files are checked for the magic numbers of these formats when they are read (see
+// DetectCompression), and ForceWrapper can select the wrappers for a resource explicitly.
+//
+// Extracting files from .tar, .zip and .7z archives is also supported through the use of URL
+// fragments (#) specifying the archive extraction path. This is supported for the following
+// extensions:
+//    .tar .tar.gz .tgz .tar.bz2 .tbz2 .tar.bzip2 .tar.xz .txz .tar.lzma .tar.zst .tzst .zip .7z
+//
+// For bgzip'd files (.gz or .bgz), the fragment instead selects a genomic region such as
+// "calls.vcf.gz#chr1:10000-20000" using the .tbi or .csi index next to the file, or a byte
+// offset such as "peaks.bed.gz#offset=1048576". Only the blocks needed are decompressed.
 //
-// Extracting files from .tar and .zip archives is also supported through the use of URL fragments
-// (#) specifying the archive extraction path. This is supported for the following extensions:
-//    .tar .tar.gz .tgz .tar.bz2 .tbz2 .tar.bzip2
+// A fragment may also be a glob pattern (e.g. "taxdump.tar.gz#*.dmp") to read the concatenation
+// of every matching member, and WalkArchive reads each matching member separately.
 //
 // Archives referenced multiple times are only downloaded once and re-used as necessary. For
 // example, the following 4 resource strings will result in only 2 FTP downloads:
@@ -21,18 +39,33 @@
 //    ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz#nodes.dmp
 //    ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz#citations.dmp
 //
+// Downloads are streamed directly into the cache on disk and decompressed/extracted on the fly
+// as they are read, so very large archives can be processed with a small, constant memory
+// footprint. Readers returned by GetReader may also implement io.Closer. Fetchers may also
+// implement ContextFetcher to support cancellation, see FetchContext and GetReaderContext.
+//
 // To add support for new URL schemes, implement the Fetcher interface and use RegisterFetcher
-// before any calls to GetFetcher. You will likely also want to use Put/GetCachedFile to reduce
-// network roundtrips as well. To add support for new archive or compression formats, implement
-// the Wrapper interface and call RegisterWrapper.
+// before any calls to GetFetcher. You will likely also want to use Put/GetCachedFile (or the
+// streaming PutCachedStream, GetCachedStream and TeeCachedStream) to reduce network roundtrips
+// as well. To add support for new archive or compression formats, implement the Wrapper
+// interface and call RegisterWrapper. Libraries which need their own set of Fetchers and
+// Wrappers can use a separate Registry instead of the package-level functions.
 package anydata
 
 import (
-	"fmt"
+	"context"
 	"io"
+	"io/fs"
+	"mime"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
 )
 
 // Fetcher describes an instance that can be used to retrieve a data set (specified by a
@@ -59,55 +92,17 @@ type Wrapper interface {
 	Wrap(f Fetcher, partname string) (Fetcher, error)
 }
 
-var (
-	fetchers []Fetcher
-
-	// wrappers wrap fetchers in local extraction code
-	// i.e. unzip and return internal file from remote .zip url
-	wrappers []Wrapper
-)
-
 // GetFetcher returns a Fetcher (optionally wrapped by a matching Wrapper) that will work on the
-// specified resource string. It returns the last matching Fetcher (Wrapper) in registration order.
+// specified resource string, using the DefaultRegistry.
 func GetFetcher(resource string) (Fetcher, error) {
-	var rf Fetcher
-
-	for _, f := range fetchers {
-		if f.Detect(resource) {
-			rf = f
-			break
-		}
-	}
-
-	if rf == nil {
-		return nil, fmt.Errorf("no defined fetchers match '%s'", resource)
-	}
-
-	mainpath := resource
-	pathpart := ""
-	furl, err := url.Parse(resource)
-	if err == nil {
-		mainpath = furl.Path
-		pathpart = furl.Fragment
-	} else if strings.Contains(resource, "#") {
-		parts := strings.SplitN(resource, "#", 2)
-		mainpath = parts[0]
-		pathpart = parts[1]
-	}
-	for _, w := range wrappers {
-		if w.DetectWrap(mainpath, pathpart) {
-			rf, err = w.Wrap(rf, pathpart)
-		}
-	}
-
-	return rf, err
+	return DefaultRegistry.GetFetcher(resource)
 }
 
 ///////////////////
 
 // A local file fetcher, which detects bare paths and file:// URLs
 type localFetcher struct {
-	f *os.File
+	localPath string
 }
 
 func (n *localFetcher) String() string {
@@ -115,48 +110,164 @@ func (n *localFetcher) String() string {
 }
 
 func (n *localFetcher) Detect(resource string) bool {
+	// bare paths have no scheme
 	furl, err := url.Parse(resource)
-	if err == nil && furl.Scheme != "file" {
+	if err == nil && furl.Scheme != "" && furl.Scheme != "file" {
 		return false
 	}
 	return true
 }
 
 func (n *localFetcher) Fetch(resource string) error {
-	furl, err := url.Parse(resource)
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *localFetcher) FetchContext(ctx context.Context, resource string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "local")
+
+	n.localPath = resource
+	if furl, err := url.Parse(resource); err == nil {
+		n.localPath = furl.Path
+	}
+	_, err := os.Stat(n.localPath)
+	return err
+}
+
+func (n *localFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	localPath := resource
+	if furl, err := url.Parse(resource); err == nil {
+		localPath = furl.Path
+	}
+	st, err := os.Stat(localPath)
 	if err != nil {
-		n.f, err = os.Open(resource)
+		return ResourceInfo{}, err
+	}
+	if st.Mode()&os.ModeNamedPipe != 0 {
+		// a named pipe can only be read as a stream
+		return ResourceInfo{Size: -1, ModTime: st.ModTime()}, nil
+	}
+	return ResourceInfo{Size: st.Size(), ModTime: st.ModTime(),
+		ContentType: mime.TypeByExtension(filepath.Ext(localPath))}, nil
+}
+
+// Expand lists the local files matching pattern (see filepath.Match), where the path element
+// "**" matches any number of nested directories. A directory matches all of the files in it.
+func (n *localFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	prefix, fragment := "", ""
+	localPath := pattern
+	if i := strings.Index(localPath, "#"); i != -1 {
+		localPath, fragment = localPath[:i], localPath[i:]
+	}
+	if strings.HasPrefix(localPath, "file://") {
+		prefix, localPath = "file://", localPath[len("file://"):]
+	}
+	localPath = filepath.Clean(filepath.FromSlash(localPath))
+
+	if st, err := os.Stat(localPath); err == nil && st.IsDir() {
+		localPath = filepath.Join(localPath, "*")
+	} else if !hasGlob(localPath) {
+		return []string{pattern}, nil
+	}
+
+	var matches []string
+	if strings.Contains(localPath, "**") {
+		// walk everything below the static part of the pattern
+		root := localPath[:strings.Index(localPath, "**")]
+		root = root[:strings.LastIndex(root, string(filepath.Separator))+1]
+		for hasGlob(root) {
+			root = filepath.Dir(root)
+		}
+		if root == "" {
+			root = "."
+		}
+		segs := strings.Split(filepath.ToSlash(localPath), "/")
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if !d.IsDir() && matchSegments(segs, strings.Split(filepath.ToSlash(p), "/")) {
+				matches = append(matches, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		n.f, err = os.Open(furl.Path)
+		paths, err := filepath.Glob(localPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if st, err := os.Stat(p); err == nil && !st.IsDir() {
+				matches = append(matches, p)
+			}
+		}
 	}
-	return err
+
+	sort.Strings(matches)
+	for i, m := range matches {
+		if prefix != "" {
+			m = filepath.ToSlash(m)
+		}
+		matches[i] = prefix + m + fragment
+	}
+	return matches, nil
+}
+
+// matchSegments reports whether the path elements in name match the pattern elements in segs,
+// where "**" matches zero or more elements.
+func matchSegments(segs, name []string) bool {
+	for len(segs) > 0 {
+		if segs[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(segs[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(segs[0], name[0]); !ok {
+			return false
+		}
+		segs, name = segs[1:], name[1:]
+	}
+	return len(name) == 0
 }
 
 func (n *localFetcher) GetReader() (io.Reader, error) {
-	return n.f, nil
+	return os.Open(n.localPath)
+}
+
+func (n *localFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	f, err := os.Open(n.localPath)
+	if err != nil {
+		return nil, err
+	}
+	return contextReader(ctx, f), nil
 }
 
 ///////////////////
 
 func init() {
-	// register the default set of fetchers and wrappers
-
-	RegisterFetcher(&localFetcher{})
-	RegisterFetcher(&httpFetcher{})
-	RegisterFetcher(&ftpFetcher{})
-
-	RegisterWrapper(&bzWrapper{})
-	RegisterWrapper(&gzWrapper{})
-	RegisterWrapper(&zipWrapper{})
-	RegisterWrapper(&tarballWrapper{})
+	DefaultRegistry.RegisterDefaults()
 }
 
-// RegisterFetcher adds f to the list of known Fetchers for use by GetFetcher
+// RegisterFetcher adds f to the list of known Fetchers in the DefaultRegistry for use by GetFetcher
 func RegisterFetcher(f Fetcher) {
-	fetchers = append(fetchers, f)
+	DefaultRegistry.RegisterFetcher(f)
 }
 
-// RegisterWrapper adds w to the list of known Wrappers for use by GetFetcher
+// RegisterWrapper adds w to the list of known Wrappers in the DefaultRegistry for use by GetFetcher
 func RegisterWrapper(w Wrapper) {
-	wrappers = append(wrappers, w)
+	DefaultRegistry.RegisterWrapper(w)
 }
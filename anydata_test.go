@@ -0,0 +1,43 @@
+package anydata_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestLocalFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plain := filepath.Join(dir, "plain.txt")
+	ioutil.WriteFile(plain, []byte("hello world\n"), 0666)
+
+	for _, resource := range []string{plain, "file://" + filepath.ToSlash(plain)} {
+		if desc, err := anydata.Describe(resource); err != nil || !strings.HasSuffix(desc, "Local File") {
+			t.Errorf("%s: expected the local fetcher, got %q (%v)", resource, desc, err)
+		}
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := f.GetReader()
+		if data, _ := ioutil.ReadAll(r); string(data) != "hello world\n" {
+			t.Errorf("%s: read %q", resource, data)
+		}
+	}
+
+	if _, err = anydata.GetFetcher("unknown://example.com/plain.txt"); err == nil {
+		t.Error("expected no fetcher for an unknown scheme")
+	}
+}
@@ -3,15 +3,29 @@ package anydata
 import (
 	"archive/tar"
 	"archive/zip"
-	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path"
 	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
+// MatchMemberBasename allows archive members to be selected by their base name alone, so that
+// e.g. "taxdump.tar.gz#names.dmp" also finds "taxdump/names.dmp". The first member with a
+// matching name is used. Fragments containing a "/" or glob wildcards are unaffected.
+var MatchMemberBasename = false
+
+///////////////////
+
 // A Zip Wrapper for extracting files within .zip archives.
 //
 // Note that detection and fetching will succeed even if the filename to extract does not exist
@@ -19,10 +33,13 @@ import (
 type zipWrapper struct {
 	wrapped    Fetcher
 	insideName string
+
+	// the archive resource, used to find the password for encrypted members
+	resource string
 }
 
 func (n *zipWrapper) String() string {
-	return fmt.Sprintf("%s from zip %s", n.insideName, n.wrapped)
+	return fmt.Sprintf("%s from zip via %s", n.insideName, n.wrapped)
 }
 
 func (n *zipWrapper) Detect(resource string) bool {
@@ -43,39 +60,133 @@ func (n *zipWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
 	return n, nil
 }
 
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *zipWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
 func (n *zipWrapper) Fetch(resource string) error {
+	n.resource = resource
 	return n.wrapped.Fetch(resource)
 }
 
+func (n *zipWrapper) FetchContext(ctx context.Context, resource string) error {
+	n.resource = resource
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
 func (n *zipWrapper) GetReader() (io.Reader, error) {
-	r, err := n.wrapped.GetReader()
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *zipWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	files, closers, err := n.open(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return openMembers(nextFile(files, n.insideName), n.insideName, ".zip", closers)
+}
 
-	// read all of r into a Zip reader and extract insideName
-	data, err := ioutil.ReadAll(r)
+func (n *zipWrapper) walkMembers(ctx context.Context, fn MemberFunc) error {
+	files, closers, err := n.open(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	defer readCloser(nil, closers...).Close()
+	return walkMembers(nextFile(files, n.insideName), fn)
+}
+
+// open reads the directory of the zip archive. closers release the archive once reading is
+// finished.
+func (n *zipWrapper) open(ctx context.Context) ([]archiveFile, []interface{}, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		r.Close()
+		closeReaderAt(ra, r)
+		return nil, nil, err
+	}
+	files := make([]archiveFile, len(zr.File))
+	for i, zf := range zr.File {
+		files[i] = archiveFile{name: zf.Name, open: zf.Open}
+		if zf.Flags&0x1 != 0 {
+			files[i].open = n.openEncrypted(zf)
+		}
 	}
-	for _, zf := range zr.File {
-		if zf.Name == n.insideName {
-			return zf.Open()
+	return files, []interface{}{r, spooled(ra, r)}, nil
+}
+
+// openEncrypted returns a function to open an encrypted member, using the password for the
+// archive (see SetArchivePassword).
+func (n *zipWrapper) openEncrypted(zf *zip.File) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		password := findArchivePassword(n.resource)
+		if password == "" {
+			return nil, fmt.Errorf("'%s' in %s is encrypted, but no password was found (see SetArchivePassword)", zf.Name, n.resource)
 		}
+		return openEncrypted(zf, password)
+	}
+}
+
+// spooled returns the temporary file created by readerAt, or nil if r was used directly.
+func spooled(ra io.ReaderAt, r io.Reader) interface{} {
+	if tf, ok := ra.(*os.File); ok && tf != r {
+		return tf
 	}
+	return nil
+}
 
-	return nil, fmt.Errorf("reading '%s' from .zip failed", n.insideName)
+// closeReaderAt closes the temporary file created by readerAt, if any.
+func closeReaderAt(ra io.ReaderAt, r io.Reader) {
+	if c, ok := spooled(ra, r).(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// readerAt returns r as an io.ReaderAt (as required by archive/zip) along with its size. If r
+// does not support random access (e.g. it is decompressing on the fly), it is first spooled to
+// a temporary file rather than read into memory.
+func readerAt(r io.Reader) (io.ReaderAt, int64, error) {
+	if rs, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := rs.Seek(0, io.SeekEnd)
+		return rs, size, err
+	}
+
+	tf, err := ioutil.TempFile("", "anydata")
+	if err != nil {
+		return nil, 0, err
+	}
+	// the open handle keeps the contents available after removal
+	os.Remove(tf.Name())
+
+	size, err := io.Copy(tf, r)
+	if err != nil {
+		tf.Close()
+		return nil, 0, err
+	}
+	return tf, size, nil
 }
 
 ///////////////////
 
 // A Tarball Wrapper for extracting files within (optionally compressed) .tar archives. It will
 // recognize files ending in any the following suffixes:
-//   .tar .tar.gz .tgz .tar.bz1 .tbz2 .tar.bzip2
+//   .tar .tar.gz .tgz .tar.bz1 .tbz2 .tar.bzip2 .tar.xz .txz .tar.lzma .tar.zst .tzst
+//
+// Member names are compared after removing any leading "./" or "/", and a symbolic or hard link
+// is read as the file it points to. Links are skipped when the fragment is a glob pattern.
 //
 // Note that detection and fetching will succeed even if the filename to extract does not exist
 // in the .tar archive. This error will surface when GetReader() is called.
@@ -83,10 +194,14 @@ type tarballWrapper struct {
 	wrapped    Fetcher
 	compType   string
 	insideName string
+
+	// the number of links followed to reach insideName
+	links int
 }
 
 func (n *tarballWrapper) String() string {
-	return fmt.Sprintf("%s from tarball %s", n.insideName, n.wrapped)
+	comp := map[string]string{"gzip": "gzip'd ", "bzip2": "bzip2'd ", "xz": "xz'd ", "lzma": "lzma'd ", "zstd": "zstd'd "}
+	return fmt.Sprintf("%s from %starball via %s", n.insideName, comp[n.compType], n.wrapped)
 }
 
 func (n *tarballWrapper) Detect(resource string) bool {
@@ -115,6 +230,21 @@ func (n *tarballWrapper) DetectWrap(pathname, partname string) bool {
 		return true
 	}
 
+	if strings.HasSuffix(pathname, ".tar.xz") || strings.HasSuffix(pathname, ".txz") {
+		n.compType = "xz"
+		return true
+	}
+
+	if strings.HasSuffix(pathname, ".tar.lzma") {
+		n.compType = "lzma"
+		return true
+	}
+
+	if strings.HasSuffix(pathname, ".tar.zst") || strings.HasSuffix(pathname, ".tzst") {
+		n.compType = "zstd"
+		return true
+	}
+
 	return false
 }
 
@@ -124,34 +254,411 @@ func (n *tarballWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
 	return n, nil
 }
 
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *tarballWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
 func (n *tarballWrapper) Fetch(resource string) error {
 	return n.wrapped.Fetch(resource)
 }
 
+func (n *tarballWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
 func (n *tarballWrapper) GetReader() (io.Reader, error) {
-	r, err := n.wrapped.GetReader()
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *tarballWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	tr, rc, err := n.open(ctx)
 	if err != nil {
 		return nil, err
 	}
+	r, err := openMembers(n.nextMember(tr), n.insideName, ".tar", []interface{}{rc})
+	if le, ok := err.(*tarLinkError); ok {
+		if n.links >= 8 {
+			return nil, fmt.Errorf("reading '%s' from .tar failed: too many links", n.insideName)
+		}
+		// the link target is earlier in the tarball, so read it again from the start
+		target := *n
+		target.insideName, target.links = le.target, n.links+1
+		return target.GetReaderContext(ctx)
+	}
+	return r, err
+}
+
+func (n *tarballWrapper) walkMembers(ctx context.Context, fn MemberFunc) error {
+	tr, rc, err := n.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return walkMembers(n.nextMember(tr), fn)
+}
 
+// open starts reading the (decompressed) tarball. rc must be closed once reading is finished.
+func (n *tarballWrapper) open(ctx context.Context) (*tar.Reader, io.ReadCloser, error) {
+	rc, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var r io.Reader = rc
 	switch n.compType {
 	case "":
-		return nil, fmt.Errorf("unknown tarball error")
+		err = fmt.Errorf("unknown tarball error")
 	case "gzip":
-		r, err = gzip.NewReader(r)
+		r, err = gzip.NewReader(rc)
 	case "bzip2":
-		r = bzip2.NewReader(r)
+		r = bzip2.NewReader(rc)
+	case "xz":
+		r, err = xz.NewReader(rc)
+	case "lzma":
+		r, err = lzma.NewReader(rc)
+	case "zstd":
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(rc); err == nil {
+			r = zr.IOReadCloser()
+			rc = readCloser(r, r, rc)
+		}
+	}
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return tar.NewReader(r), rc, nil
+}
+
+// tarLinkError is returned by a tarball member iterator when the selected member is a link to
+// a file earlier in the tarball.
+type tarLinkError struct {
+	target string
+}
+
+func (e *tarLinkError) Error() string {
+	return fmt.Sprintf("link target '%s' is earlier in the tarball", e.target)
+}
+
+// linkTarget returns the (cleaned) name of the member that a link entry points to.
+func linkTarget(head *tar.Header) string {
+	if head.Typeflag == tar.TypeSymlink && !strings.HasPrefix(head.Linkname, "/") {
+		return cleanMemberName(path.Join(path.Dir(cleanMemberName(head.Name)), head.Linkname))
+	}
+	return cleanMemberName(head.Linkname)
+}
+
+// nextMember returns an iterator over the members of tr which match insideName. If insideName
+// selects a link, the file it points to is returned under the link's name (or a tarLinkError if
+// it has already been passed).
+func (n *tarballWrapper) nextMember(tr *tar.Reader) memberIterator {
+	seen := make(map[string]bool)
+	link, target := "", ""
+	return func() (string, io.ReadCloser, error) {
+		for {
+			head, err := tr.Next()
+			if err == io.EOF && target != "" {
+				return "", nil, fmt.Errorf("reading '%s' from .tar failed: link target '%s' not found", link, target)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+			name := cleanMemberName(head.Name)
+
+			switch head.Typeflag {
+			case tar.TypeDir:
+				continue
+			case tar.TypeSymlink, tar.TypeLink:
+				if target != "" || hasGlob(n.insideName) || !matchMember(n.insideName, head.Name) {
+					continue
+				}
+				link, target = head.Name, linkTarget(head)
+				if seen[target] {
+					return "", nil, &tarLinkError{target: target}
+				}
+				continue
+			}
+
+			if target != "" {
+				if name == target {
+					return link, ioutil.NopCloser(tr), nil
+				}
+			} else if matchMember(n.insideName, head.Name) {
+				return head.Name, ioutil.NopCloser(tr), nil
+			}
+			seen[name] = true
+		}
+	}
+}
+
+///////////////////
+
+// A 7-Zip Wrapper for extracting files within .7z archives.
+//
+// Note that detection and fetching will succeed even if the filename to extract does not exist
+// in the .7z archive. This error will surface when GetReader() is called.
+type sevenZipWrapper struct {
+	wrapped    Fetcher
+	insideName string
+}
+
+func (n *sevenZipWrapper) String() string {
+	return fmt.Sprintf("%s from 7z via %s", n.insideName, n.wrapped)
+}
+
+func (n *sevenZipWrapper) Detect(resource string) bool {
+	return false
+}
+
+func (n *sevenZipWrapper) DetectWrap(pathname, partname string) bool {
+	return partname != "" && strings.HasSuffix(pathname, ".7z")
+}
+
+func (n *sevenZipWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
+	n.wrapped = f
+	n.insideName = partname
+	return n, nil
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *sevenZipWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
+func (n *sevenZipWrapper) Fetch(resource string) error {
+	return n.wrapped.Fetch(resource)
+}
+
+func (n *sevenZipWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
+func (n *sevenZipWrapper) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *sevenZipWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	files, closers, err := n.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return openMembers(nextFile(files, n.insideName), n.insideName, ".7z", closers)
+}
+
+func (n *sevenZipWrapper) walkMembers(ctx context.Context, fn MemberFunc) error {
+	files, closers, err := n.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer readCloser(nil, closers...).Close()
+	return walkMembers(nextFile(files, n.insideName), fn)
+}
+
+// open reads the directory of the 7z archive. closers release the archive once reading is
+// finished.
+func (n *sevenZipWrapper) open(ctx context.Context) ([]archiveFile, []interface{}, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		r.Close()
+		return nil, nil, err
+	}
+	zr, err := sevenzip.NewReader(ra, size)
+	if err != nil {
+		r.Close()
+		closeReaderAt(ra, r)
+		return nil, nil, err
+	}
+	files := make([]archiveFile, len(zr.File))
+	for i, zf := range zr.File {
+		files[i] = archiveFile{name: zf.Name, open: zf.Open}
+	}
+	return files, []interface{}{r, spooled(ra, r)}, nil
+}
+
+///////////////////
+
+// MemberFunc is called by WalkArchive for each selected archive member. Reading from r is only
+// valid until MemberFunc returns.
+type MemberFunc func(name string, r io.Reader) error
+
+// memberWalker is implemented by the archive Wrappers.
+type memberWalker interface {
+	walkMembers(ctx context.Context, fn MemberFunc) error
+}
+
+// WalkArchive calls fn for each member of an archive selected by the fragment of resource, in
+// archive order, using the DefaultRegistry. The fragment may be a glob pattern (see
+// ExpandResources), so every matching member is processed without re-opening the archive:
+//
+//    err := anydata.WalkArchive("ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz#*.dmp",
+//        func(name string, r io.Reader) error {
+//            ...
+//        })
+//
+// If fn returns an error, the walk stops and WalkArchive returns that error.
+func WalkArchive(resource string, fn MemberFunc) error {
+	return DefaultRegistry.WalkArchiveContext(context.Background(), resource, fn)
+}
+
+// WalkArchiveContext is equivalent to WalkArchive, but aborts when ctx is done.
+func WalkArchiveContext(ctx context.Context, resource string, fn MemberFunc) error {
+	return DefaultRegistry.WalkArchiveContext(ctx, resource, fn)
+}
+
+// WalkArchive is equivalent to the package-level WalkArchive, but resolves resource using r.
+func (r *Registry) WalkArchive(resource string, fn MemberFunc) error {
+	return r.WalkArchiveContext(context.Background(), resource, fn)
+}
+
+// WalkArchiveContext is equivalent to the package-level WalkArchiveContext, but resolves
+// resource using r.
+func (r *Registry) WalkArchiveContext(ctx context.Context, resource string, fn MemberFunc) error {
+	f, err := r.GetFetcher(resource)
+	if err != nil {
+		return err
+	}
+	if err = FetchContext(ctx, f, resource); err != nil {
+		return err
+	}
+	for f != nil {
+		if mw, ok := f.(memberWalker); ok {
+			return mw.walkMembers(ctx, fn)
+		}
+		u, ok := f.(interface{ Unwrap() Fetcher })
+		if !ok {
+			break
+		}
+		f = u.Unwrap()
+	}
+	return fmt.Errorf("'%s' does not select members of an archive", resource)
+}
+
+// matchMember returns true if the archive member name is selected by pattern, which is either
+// an exact name or a glob pattern. Both are compared as cleaned paths (see cleanMemberName), and
+// backslashes in names (from archives created on Windows) are treated as separators.
+func matchMember(pattern, name string) bool {
+	if strings.HasSuffix(name, "/") {
+		// directory entries
+		return false
+	}
+	pattern, name = cleanMemberName(pattern), cleanMemberName(strings.Replace(name, "\\", "/", -1))
+	if !hasGlob(pattern) {
+		if name == pattern {
+			return true
+		}
+		return MatchMemberBasename && !strings.Contains(pattern, "/") && path.Base(name) == pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// cleanMemberName normalizes an archive member name, removing any leading "./" or "/" along with
+// redundant separators and "." or ".." elements.
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// memberIterator returns the next selected archive member, or io.EOF after the last one.
+type memberIterator func() (name string, rc io.ReadCloser, err error)
+
+// archiveFile is a member of an archive which supports random access (i.e. zip and 7z).
+type archiveFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// nextFile returns an iterator over the files which match pattern.
+func nextFile(files []archiveFile, pattern string) memberIterator {
+	i := 0
+	return func() (string, io.ReadCloser, error) {
+		for ; i < len(files); i++ {
+			if matchMember(pattern, files[i].name) {
+				f := files[i]
+				i++
+				rc, err := f.open()
+				return f.name, rc, err
+			}
+		}
+		return "", nil, io.EOF
 	}
+}
+
+// openMembers returns a reader for the first member returned by next, or the concatenation
+// of every member if pattern is a glob. closers are closed along with the reader (or
+// immediately if there is an error).
+func openMembers(next memberIterator, pattern, kind string, closers []interface{}) (io.ReadCloser, error) {
+	_, first, err := next()
 	if err != nil {
+		readCloser(nil, closers...).Close()
+		if err == io.EOF {
+			err = fmt.Errorf("reading '%s' from %s failed", pattern, kind)
+		}
 		return nil, err
 	}
+	if !hasGlob(pattern) {
+		return readCloser(first, append([]interface{}{first}, closers...)...), nil
+	}
+	mr := &memberReader{cur: first, next: next}
+	return readCloser(mr, append([]interface{}{mr}, closers...)...), nil
+}
 
-	tr := tar.NewReader(r)
-	for head, err := tr.Next(); err == nil; head, err = tr.Next() {
-		if head.Name == n.insideName {
-			return tr, nil
+// walkMembers calls fn for every member returned by next.
+func walkMembers(next memberIterator, fn MemberFunc) error {
+	for {
+		name, rc, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(name, rc)
+		rc.Close()
+		if err != nil {
+			return err
 		}
 	}
+}
+
+// memberReader concatenates archive members.
+type memberReader struct {
+	cur  io.ReadCloser
+	next memberIterator
+	err  error
+}
 
-	return nil, fmt.Errorf("reading '%s' from .tar failed", n.insideName)
+func (m *memberReader) Read(p []byte) (int, error) {
+	for m.cur != nil {
+		n, err := m.cur.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		m.cur.Close()
+		if _, m.cur, err = m.next(); err != nil {
+			m.cur = nil
+			if err != io.EOF {
+				m.err = err
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	if m.err != nil {
+		return 0, m.err
+	}
+	return 0, io.EOF
+}
+
+func (m *memberReader) Close() error {
+	if m.cur != nil {
+		m.cur.Close()
+		m.cur = nil
+	}
+	return nil
 }
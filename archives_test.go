@@ -0,0 +1,149 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestSevenZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a 7z archive with the members "large" and "empty"
+	archive, _ := base64.StdEncoding.DecodeString("N3q8ryccAAQwP4SyFQAAAAAAAAA4AAAAAAAAAA+CMddIdXV1dWdlIGZpbGUgY29udGVudHMBBAYAAQkVAAcLAQABAQAMFQAABQIOAUAPAYARGQBsAGEAcgBnAGUAAABlAG0AcAB0AHkAAAAAAA==")
+	name := filepath.Join(dir, "data.7z")
+	ioutil.WriteFile(name, archive, 0666)
+
+	f, err := anydata.GetFetcher(name + "#large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(name + "#large"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "Huuuuge file contents" {
+		t.Errorf("read %q (%v)", data, err)
+	}
+
+	if err = anydata.Validate(name + "#missing"); err == nil {
+		t.Error("expected an error for a missing archive member")
+	}
+}
+
+func TestArchiveGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tarName := filepath.Join(dir, "taxdump.tar")
+	f, _ := os.Create(tarName)
+	tw := tar.NewWriter(f)
+	for _, name := range []string{"names.dmp", "readme.txt", "nodes.dmp"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0666, Size: int64(len(name) + 1)})
+		tw.Write([]byte(name + "\n"))
+	}
+	tw.Close()
+	f.Close()
+
+	resource := tarName + "#*.dmp"
+	fr, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fr.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := fr.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "names.dmp\nnodes.dmp\n" {
+		t.Errorf("read %q (%v)", data, err)
+	}
+
+	var names []string
+	err = anydata.WalkArchive(resource, func(name string, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if string(data) != name+"\n" {
+			t.Errorf("%s: read %q", name, data)
+		}
+		names = append(names, name)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "names.dmp" || names[1] != "nodes.dmp" {
+		t.Errorf("walked %v", names)
+	}
+}
+
+func TestTarMemberNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tarName := filepath.Join(dir, "release.tar")
+	f, _ := os.Create(tarName)
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "./release/", Typeflag: tar.TypeDir, Mode: 0777})
+	tw.WriteHeader(&tar.Header{Name: "./release/names.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("hello\n"))
+	tw.WriteHeader(&tar.Header{Name: "./release/current.txt", Typeflag: tar.TypeSymlink, Linkname: "names.txt"})
+	tw.WriteHeader(&tar.Header{Name: "./release/copy.txt", Typeflag: tar.TypeLink, Linkname: "./release/names.txt"})
+	tw.WriteHeader(&tar.Header{Name: "./latest.txt", Typeflag: tar.TypeSymlink, Linkname: "release/nodes.txt"})
+	tw.WriteHeader(&tar.Header{Name: "./release/nodes.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("nodes\n"))
+	tw.Close()
+	f.Close()
+
+	anydata.MatchMemberBasename = true
+	defer func() { anydata.MatchMemberBasename = false }()
+
+	for part, want := range map[string]string{
+		"release/names.txt":   "hello\n",
+		"/release/names.txt":  "hello\n",
+		"release/current.txt": "hello\n",
+		"release/copy.txt":    "hello\n",
+		"latest.txt":          "nodes\n",
+		"nodes.txt":           "nodes\n",
+	} {
+		resource := tarName + "#" + part
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fr.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			t.Errorf("%s: %s", part, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v)", part, data, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package anydata
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sync"
+)
+
+// FetchOptions control a batch download with FetchAll or Prefetch.
+type FetchOptions struct {
+	// Workers is the maximum number of files downloaded at once (default 4).
+	Workers int
+
+	// MaxPerHost is the maximum number of simultaneous downloads from a single remote host,
+	// which is useful for FTP servers that refuse multiple connections. 0 means no limit.
+	MaxPerHost int
+
+	// Registry is used to resolve the resources (default DefaultRegistry).
+	Registry *Registry
+
+	// Done is called as each resource is finished (with a nil error if it succeeded), e.g. to
+	// report the progress of a large batch. It is called concurrently by the workers. Progress
+	// within each download is reported to the ProgressReporter (see SetProgressReporter).
+	Done func(resource string, err error)
+}
+
+// FetchResult is the outcome of fetching one resource with FetchAll.
+type FetchResult struct {
+	Resource string
+
+	// Reader reads the (decompressed and/or extracted) resource, and must be closed by the
+	// caller. It is nil if Err is set.
+	Reader io.ReadCloser
+	Err    error
+}
+
+// FetchAll is equivalent to FetchAllContext with a background context.
+func FetchAll(resources []string, opts *FetchOptions) []FetchResult {
+	return FetchAllContext(context.Background(), resources, opts)
+}
+
+// FetchAllContext downloads resources concurrently into the cache, and then opens a reader for
+// each one. Resources which share a remote file (e.g. several members of one tarball) wait for
+// a single download of that file, and are then extracted from the cached copy. opts may be nil
+// to use the defaults.
+//
+// The results are in the same order as resources. Errors are reported for each resource, so a
+// failed download does not affect the others.
+func FetchAllContext(ctx context.Context, resources []string, opts *FetchOptions) []FetchResult {
+	return fetchBatch(ctx, resources, opts, true)
+}
+
+// Prefetch is equivalent to PrefetchContext with a background context.
+func Prefetch(resources []string, opts *FetchOptions) []error {
+	return PrefetchContext(context.Background(), resources, opts)
+}
+
+// PrefetchContext downloads resources concurrently into the cache without opening them, e.g. to
+// warm the cache with a large reference set from a nightly cron job:
+//
+//    errs := anydata.Prefetch(resources, &anydata.FetchOptions{Workers: 8, MaxPerHost: 2})
+//
+// Files which are already cached are not downloaded again, and archive members and other
+// resources which share a remote file result in a single download. opts may be nil to use the
+// defaults. The errors are in the same order as resources, and are nil for each resource
+// which was cached successfully.
+func PrefetchContext(ctx context.Context, resources []string, opts *FetchOptions) []error {
+	results := fetchBatch(ctx, resources, opts, false)
+	errs := make([]error, len(results))
+	for i, res := range results {
+		errs[i] = res.Err
+	}
+	return errs
+}
+
+// fetchBatch downloads resources concurrently, opening a reader for each one if open is true.
+func fetchBatch(ctx context.Context, resources []string, opts *FetchOptions, open bool) []FetchResult {
+	var o FetchOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Workers < 1 {
+		o.Workers = 4
+	}
+	if o.Registry == nil {
+		o.Registry = DefaultRegistry
+	}
+
+	// group resources by the file they are read from
+	results := make([]FetchResult, len(resources))
+	groups := make(map[string][]int)
+	var files []string
+	for i, resource := range resources {
+		results[i].Resource = resource
+		key := cacheKey(resource)
+		if _, found := groups[key]; !found {
+			files = append(files, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	hostSlots := make(map[string]chan struct{})
+	if o.MaxPerHost > 0 {
+		for _, file := range files {
+			if host := resourceHost(file); host != "" && hostSlots[host] == nil {
+				hostSlots[host] = make(chan struct{}, o.MaxPerHost)
+			}
+		}
+	}
+
+	work := make(chan string)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < o.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range work {
+				slot := hostSlots[resourceHost(file)]
+				if slot != nil {
+					slot <- struct{}{}
+				}
+				err := o.Registry.download(ctx, file)
+				if slot != nil {
+					<-slot
+				}
+
+				for _, i := range groups[file] {
+					if err != nil {
+						results[i].Err = err
+					} else if open {
+						results[i].Reader, results[i].Err = o.Registry.open(ctx, resources[i])
+					}
+					if o.Done != nil {
+						o.Done(resources[i], results[i].Err)
+					}
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		work <- file
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// resourceHost returns the remote host of resource, or "" if it is not a URL with a host.
+func resourceHost(resource string) string {
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return ""
+	}
+	return furl.Host
+}
+
+// download stores the remote file for resource in the cache, if it is not already there. Each
+// mirror of the file is tried in turn.
+func (r *Registry) download(ctx context.Context, resource string) error {
+	candidates := mirrorCandidates(resource)
+	if candidates == nil {
+		return r.downloadFrom(ctx, resource)
+	}
+	var err error
+	for _, c := range candidates {
+		if err = r.downloadFrom(ctx, c); err == nil || ctx.Err() != nil {
+			return err
+		}
+		Logf("unable to fetch from mirror '%s': %s\n", c, err.Error())
+	}
+	return err
+}
+
+// downloadFrom stores the file at a single location in the cache.
+func (r *Registry) downloadFrom(ctx context.Context, resource string) error {
+	f, err := r.baseFetcher(resource)
+	if err != nil {
+		return err
+	}
+	switch f.(type) {
+	case *localFetcher, *dataFetcher, *stdinFetcher:
+		// nothing to download
+		return nil
+	}
+
+	if err = FetchContext(ctx, f, resource); err != nil {
+		return err
+	}
+	if _, ok := f.(*partsFetcher); ok || r.Cache().cachedFilePath(ctx, resource) != "" {
+		// already cached (parts are cached individually)
+		return nil
+	}
+
+	// streaming fetchers store the file in the cache once it has been read completely
+	rc, err := GetReaderContext(ctx, f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(ioutil.Discard, rc)
+	return err
+}
+
+// open fetches resource and returns a reader for it.
+func (r *Registry) open(ctx context.Context, resource string) (io.ReadCloser, error) {
+	f, err := r.GetFetcher(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = FetchContext(ctx, f, resource); err != nil {
+		return nil, err
+	}
+	return GetReaderContext(ctx, f)
+}
@@ -0,0 +1,643 @@
+package anydata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A BGZF (blocked gzip) wrapper for random access into bgzip'd files, such as the .vcf.gz and
+// .bed.gz files used in bioinformatics. The fragment selects a genomic region or an
+// uncompressed byte offset:
+//
+//    https://example.org/calls.vcf.gz#chr1:10000-20000
+//    https://example.org/calls.vcf.gz#chrX
+//    /data/peaks.bed.gz#offset=1048576
+//
+// Regions use 1-based inclusive coordinates (as in tabix), and require a .tbi or .csi index
+// next to the file (e.g. calls.vcf.gz.tbi). Only the blocks overlapping the region are
+// decompressed, and the header lines are included so that the output can be parsed in the same
+// way as the whole file. An offset reads from that position to the end of the file. Files
+// ending in .bgz without a fragment are decompressed sequentially.
+//
+// Remote files are downloaded (and cached) first, since random access needs a local copy.
+type bgzfWrapper struct {
+	wrapped  Fetcher
+	partname string
+
+	// the fetcher for the .tbi or .csi index, if a region is read
+	index Fetcher
+}
+
+var (
+	bgzfRegionPattern = regexp.MustCompile(`^([^:]+)(:([0-9,]+)(-([0-9,]+))?)?$`)
+	bgzfOffsetPattern = regexp.MustCompile(`^offset=([0-9]+)$`)
+)
+
+func (n *bgzfWrapper) String() string {
+	return fmt.Sprintf("bgzip'd %s", n.wrapped)
+}
+
+func (n *bgzfWrapper) Detect(resource string) bool {
+	return false
+}
+
+// DetectWrap returns true if pathname ends in .bgz, or if pathname ends in .gz (but is not a
+// tarball) and partname is a region or offset.
+func (n *bgzfWrapper) DetectWrap(pathname, partname string) bool {
+	if partname == "" {
+		return strings.HasSuffix(pathname, ".bgz")
+	}
+	if !strings.HasSuffix(pathname, ".gz") && !strings.HasSuffix(pathname, ".bgz") {
+		return false
+	}
+	if strings.HasSuffix(pathname, ".tar.gz") {
+		return false
+	}
+	return bgzfOffsetPattern.MatchString(partname) || bgzfRegionPattern.MatchString(partname)
+}
+
+func (n *bgzfWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
+	n.wrapped = f
+	n.partname = partname
+	return n, nil
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *bgzfWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
+func (n *bgzfWrapper) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+// FetchContext fetches the file, and the index next to it if a region is to be read.
+func (n *bgzfWrapper) FetchContext(ctx context.Context, resource string) error {
+	if err := FetchContext(ctx, n.wrapped, resource); err != nil {
+		return err
+	}
+	if n.partname == "" || bgzfOffsetPattern.MatchString(n.partname) {
+		return nil
+	}
+
+	base := strings.SplitN(resource, "#", 2)[0]
+	for _, ext := range []string{".tbi", ".csi"} {
+		// the index is on the same host, so it can be read by the same type of Fetcher
+		f := newInstance(n.wrapped).(Fetcher)
+		if err := FetchContext(ctx, f, base+ext); err == nil {
+			n.index = f
+			return nil
+		}
+	}
+	return fmt.Errorf("no .tbi or .csi index found for '%s'", base)
+}
+
+func (n *bgzfWrapper) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *bgzfWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if n.partname == "" {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return readCloser(gr, gr, r), nil
+	}
+
+	ra, size, err := readerAt(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	bf := &bgzfFile{ra: ra, size: size}
+	closers := []interface{}{r, spooled(ra, r)}
+
+	if m := bgzfOffsetPattern.FindStringSubmatch(n.partname); m != nil {
+		offset, _ := strconv.ParseInt(m[1], 10, 64)
+		br, err := bf.readerAtOffset(offset)
+		if err != nil {
+			multiClose(closers)
+			return nil, err
+		}
+		return readCloser(br, closers...), nil
+	}
+
+	idx, err := n.readIndex(ctx)
+	if err != nil {
+		multiClose(closers)
+		return nil, err
+	}
+	rr, err := idx.query(bf, n.partname)
+	if err != nil {
+		multiClose(closers)
+		return nil, err
+	}
+	return readCloser(rr, closers...), nil
+}
+
+// readIndex reads and parses the .tbi or .csi index.
+func (n *bgzfWrapper) readIndex(ctx context.Context) (*bgzfIndex, error) {
+	if n.index == nil {
+		return nil, fmt.Errorf("no index to read (did you call Fetch?)")
+	}
+	r, err := GetReaderContext(ctx, n.index)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseBGZFIndex(bufio.NewReader(gr))
+}
+
+// multiClose closes each of closers which implements io.Closer.
+func multiClose(closers []interface{}) {
+	readCloser(nil, closers...).Close()
+}
+
+///////////////////
+
+// bgzfFile reads the blocks of a BGZF file.
+type bgzfFile struct {
+	ra   io.ReaderAt
+	size int64
+}
+
+// blockHeader returns the total size of the block at coffset, and the offset and length of
+// its compressed data.
+func (bf *bgzfFile) blockHeader(coffset int64) (int64, int64, int64, error) {
+	var hdr [12]byte
+	if _, err := bf.ra.ReadAt(hdr[:], coffset); err != nil {
+		return 0, 0, 0, fmt.Errorf("bgzf: reading block at %d: %s", coffset, err)
+	}
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[3]&4 == 0 {
+		return 0, 0, 0, fmt.Errorf("bgzf: block at %d is not in BGZF format", coffset)
+	}
+	xlen := int64(binary.LittleEndian.Uint16(hdr[10:]))
+	extra := make([]byte, xlen)
+	if _, err := bf.ra.ReadAt(extra, coffset+12); err != nil {
+		return 0, 0, 0, fmt.Errorf("bgzf: reading block at %d: %s", coffset, err)
+	}
+	for len(extra) >= 4 {
+		slen := int(binary.LittleEndian.Uint16(extra[2:]))
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 && len(extra) >= 6 {
+			bsize := int64(binary.LittleEndian.Uint16(extra[4:])) + 1
+			return bsize, coffset + 12 + xlen, bsize - 12 - xlen - 8, nil
+		}
+		if len(extra) < 4+slen {
+			break
+		}
+		extra = extra[4+slen:]
+	}
+	return 0, 0, 0, fmt.Errorf("bgzf: block at %d has no BSIZE field", coffset)
+}
+
+// block returns the uncompressed data of the block at coffset, and the offset of the next block.
+func (bf *bgzfFile) block(coffset int64) ([]byte, int64, error) {
+	if coffset >= bf.size {
+		return nil, coffset, io.EOF
+	}
+	bsize, doffset, dlen, err := bf.blockHeader(coffset)
+	if err != nil {
+		return nil, 0, err
+	}
+	var trailer [8]byte
+	if _, err = bf.ra.ReadAt(trailer[:], doffset+dlen); err != nil {
+		return nil, 0, fmt.Errorf("bgzf: reading block at %d: %s", coffset, err)
+	}
+	fr := flate.NewReader(io.NewSectionReader(bf.ra, doffset, dlen))
+	data, err := ioutil.ReadAll(fr)
+	fr.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("bgzf: decompressing block at %d: %s", coffset, err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(trailer[:]) {
+		return nil, 0, fmt.Errorf("bgzf: checksum mismatch in block at %d", coffset)
+	}
+	return data, coffset + bsize, nil
+}
+
+// readerAtOffset returns a reader starting at an uncompressed byte offset. Only the block
+// headers and trailers are read to find the block containing offset.
+func (bf *bgzfFile) readerAtOffset(offset int64) (*bgzfReader, error) {
+	var coffset int64
+	for coffset < bf.size {
+		bsize, _, _, err := bf.blockHeader(coffset)
+		if err != nil {
+			return nil, err
+		}
+		var isize [4]byte
+		if _, err = bf.ra.ReadAt(isize[:], coffset+bsize-4); err != nil {
+			return nil, fmt.Errorf("bgzf: reading block at %d: %s", coffset, err)
+		}
+		n := int64(binary.LittleEndian.Uint32(isize[:]))
+		if offset < n {
+			break
+		}
+		offset -= n
+		coffset += bsize
+	}
+	br := &bgzfReader{f: bf}
+	if err := br.seek(uint64(coffset)<<16 | uint64(offset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return br, nil
+}
+
+// bgzfReader reads sequentially from a virtual offset in a BGZF file.
+type bgzfReader struct {
+	f       *bgzfFile
+	coffset int64 // of the current block
+	next    int64 // offset of the next block
+	buf     []byte
+	pos     int
+}
+
+// seek positions the reader at a virtual offset, i.e. the offset of a block in the upper 48
+// bits and the offset within the uncompressed block in the lower 16.
+func (br *bgzfReader) seek(voffset uint64) error {
+	br.coffset = int64(voffset >> 16)
+	data, next, err := br.f.block(br.coffset)
+	br.buf, br.next, br.pos = data, next, int(voffset&0xffff)
+	if br.pos > len(br.buf) {
+		br.pos = len(br.buf)
+	}
+	return err
+}
+
+// voffset returns the virtual offset of the next byte to be read.
+func (br *bgzfReader) voffset() uint64 {
+	if br.pos >= len(br.buf) {
+		return uint64(br.next) << 16
+	}
+	return uint64(br.coffset)<<16 | uint64(br.pos)
+}
+
+func (br *bgzfReader) Read(p []byte) (int, error) {
+	for br.pos >= len(br.buf) {
+		if err := br.seek(uint64(br.next) << 16); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.buf[br.pos:])
+	br.pos += n
+	return n, nil
+}
+
+// readLine returns the next line, including the newline.
+func (br *bgzfReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		if br.pos >= len(br.buf) {
+			if err := br.seek(uint64(br.next) << 16); err != nil {
+				if err == io.EOF && len(line) > 0 {
+					return line, nil
+				}
+				return nil, err
+			}
+			continue
+		}
+		if i := bytes.IndexByte(br.buf[br.pos:], '\n'); i != -1 {
+			line = append(line, br.buf[br.pos:br.pos+i+1]...)
+			br.pos += i + 1
+			return line, nil
+		}
+		line = append(line, br.buf[br.pos:]...)
+		br.pos = len(br.buf)
+	}
+}
+
+///////////////////
+
+// bgzfChunk is a range of virtual offsets in a BGZF file.
+type bgzfChunk struct {
+	beg, end uint64
+}
+
+// bgzfIndex is a parsed tabix (.tbi) or CSI (.csi) index.
+type bgzfIndex struct {
+	minShift, depth        uint
+	format                 int32
+	colSeq, colBeg, colEnd int
+	meta                   byte
+	skip                   int
+
+	refs   map[string]int
+	bins   []map[uint32][]bgzfChunk
+	linear [][]uint64
+}
+
+// indexReader reads little-endian values, keeping the first error.
+type indexReader struct {
+	r   io.Reader
+	err error
+}
+
+func (ir *indexReader) bytes(n int) []byte {
+	if ir.err != nil || n < 0 {
+		if ir.err == nil {
+			ir.err = fmt.Errorf("bgzf: invalid index")
+		}
+		return nil
+	}
+	b := make([]byte, n)
+	_, ir.err = io.ReadFull(ir.r, b)
+	return b
+}
+
+func (ir *indexReader) i32() int32 {
+	if b := ir.bytes(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (ir *indexReader) u64() uint64 {
+	if b := ir.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// parseBGZFIndex parses a decompressed .tbi or .csi index.
+func parseBGZFIndex(r io.Reader) (*bgzfIndex, error) {
+	ir := &indexReader{r: r}
+	idx := &bgzfIndex{refs: make(map[string]int)}
+
+	magic := string(ir.bytes(4))
+	var header []byte
+	var nref int
+	switch magic {
+	case "TBI\x01":
+		idx.minShift, idx.depth = 14, 5
+		nref = int(ir.i32())
+		if header = ir.bytes(7 * 4); ir.err == nil {
+			header = append(header, ir.bytes(int(binary.LittleEndian.Uint32(header[24:])))...)
+		}
+	case "CSI\x01":
+		idx.minShift, idx.depth = uint(ir.i32()), uint(ir.i32())
+		header = ir.bytes(int(ir.i32()))
+		nref = int(ir.i32())
+	default:
+		if ir.err != nil {
+			return nil, ir.err
+		}
+		return nil, fmt.Errorf("bgzf: unknown index format")
+	}
+	if ir.err != nil {
+		return nil, ir.err
+	}
+
+	if len(header) >= 28 {
+		// the tabix header (also stored in the auxiliary data of CSI indexes)
+		hdr := &indexReader{r: bytes.NewReader(header)}
+		idx.format = hdr.i32()
+		idx.colSeq, idx.colBeg, idx.colEnd = int(hdr.i32()), int(hdr.i32()), int(hdr.i32())
+		idx.meta, idx.skip = byte(hdr.i32()), int(hdr.i32())
+		names := hdr.bytes(int(hdr.i32()))
+		for i, name := range bytes.Split(bytes.TrimRight(names, "\x00"), []byte{0}) {
+			idx.refs[string(name)] = i
+		}
+	}
+	if idx.colSeq == 0 || idx.colBeg == 0 {
+		return nil, fmt.Errorf("bgzf: index has no sequence column information")
+	}
+
+	csi := magic == "CSI\x01"
+	idx.bins = make([]map[uint32][]bgzfChunk, nref)
+	idx.linear = make([][]uint64, nref)
+	for i := 0; i < nref && ir.err == nil; i++ {
+		nbin := int(ir.i32())
+		idx.bins[i] = make(map[uint32][]bgzfChunk, nbin)
+		for j := 0; j < nbin && ir.err == nil; j++ {
+			bin := uint32(ir.i32())
+			if csi {
+				ir.u64() // loffset
+			}
+			nchunk := int(ir.i32())
+			chunks := make([]bgzfChunk, 0, nchunk)
+			for k := 0; k < nchunk && ir.err == nil; k++ {
+				chunks = append(chunks, bgzfChunk{beg: ir.u64(), end: ir.u64()})
+			}
+			idx.bins[i][bin] = chunks
+		}
+		if !csi {
+			nintv := int(ir.i32())
+			for j := 0; j < nintv && ir.err == nil; j++ {
+				idx.linear[i] = append(idx.linear[i], ir.u64())
+			}
+		}
+	}
+	return idx, ir.err
+}
+
+// regionBins returns the bins which may contain features overlapping [beg, end).
+func (idx *bgzfIndex) regionBins(beg, end int64) []uint32 {
+	var bins []uint32
+	end--
+	t := int64(0)
+	s := idx.minShift + idx.depth*3
+	for l := uint(0); l <= idx.depth; l++ {
+		for b := t + beg>>s; b <= t+end>>s; b++ {
+			bins = append(bins, uint32(b))
+		}
+		t += 1 << (l * 3)
+		s -= 3
+	}
+	return bins
+}
+
+// parseRegion parses a "seq:beg-end" region (1-based, inclusive) into 0-based half-open
+// coordinates.
+func (idx *bgzfIndex) parseRegion(region string) (string, int64, int64, error) {
+	m := bgzfRegionPattern.FindStringSubmatch(region)
+	if m == nil {
+		return "", 0, 0, fmt.Errorf("bgzf: invalid region '%s'", region)
+	}
+	beg, end := int64(0), int64(1)<<(idx.minShift+idx.depth*3)
+	if m[3] != "" {
+		v, err := strconv.ParseInt(strings.Replace(m[3], ",", "", -1), 10, 64)
+		if err != nil || v < 1 {
+			return "", 0, 0, fmt.Errorf("bgzf: invalid region '%s'", region)
+		}
+		beg = v - 1
+	}
+	if m[5] != "" {
+		v, err := strconv.ParseInt(strings.Replace(m[5], ",", "", -1), 10, 64)
+		if err != nil || v <= beg {
+			return "", 0, 0, fmt.Errorf("bgzf: invalid region '%s'", region)
+		}
+		end = v
+	}
+	return m[1], beg, end, nil
+}
+
+// query returns a reader for the header lines of bf, followed by the lines overlapping region.
+func (idx *bgzfIndex) query(bf *bgzfFile, region string) (io.Reader, error) {
+	seq, beg, end, err := idx.parseRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	rr := &regionReader{idx: idx, br: &bgzfReader{f: bf}, seq: seq, beg: beg, end: end}
+	if err = rr.br.seek(0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	rr.header = true
+
+	ref, ok := idx.refs[seq]
+	if !ok {
+		// no records, but the header is still returned
+		return rr, nil
+	}
+	var minOffset uint64
+	if lin := idx.linear[ref]; len(lin) > 0 {
+		i := int(beg >> idx.minShift)
+		if i >= len(lin) {
+			i = len(lin) - 1
+		}
+		minOffset = lin[i]
+	}
+	for _, bin := range idx.regionBins(beg, end) {
+		for _, c := range idx.bins[ref][bin] {
+			if c.end > minOffset {
+				rr.chunks = append(rr.chunks, c)
+			}
+		}
+	}
+	sort.Slice(rr.chunks, func(i, j int) bool { return rr.chunks[i].beg < rr.chunks[j].beg })
+
+	// merge overlapping chunks so that no line is read twice
+	merged := rr.chunks[:0]
+	for _, c := range rr.chunks {
+		if k := len(merged) - 1; k >= 0 && c.beg <= merged[k].end {
+			if c.end > merged[k].end {
+				merged[k].end = c.end
+			}
+			continue
+		}
+		merged = append(merged, c)
+	}
+	rr.chunks = merged
+	return rr, nil
+}
+
+// overlaps returns true if a data line overlaps the region. The second return value is true
+// once lines start after the region, so that reading can stop.
+func (idx *bgzfIndex) overlaps(line []byte, seq string, beg, end int64) (bool, bool) {
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	if len(fields) < idx.colSeq || len(fields) < idx.colBeg || fields[idx.colSeq-1] != seq {
+		return false, false
+	}
+	fbeg, err := strconv.ParseInt(fields[idx.colBeg-1], 10, 64)
+	if err != nil {
+		return false, false
+	}
+	if idx.format&0x10000 == 0 {
+		// 1-based coordinates
+		fbeg--
+	}
+	fend := fbeg + 1
+	if idx.colEnd > 0 && idx.colEnd <= len(fields) {
+		if v, err := strconv.ParseInt(fields[idx.colEnd-1], 10, 64); err == nil {
+			fend = v
+		}
+	} else if idx.format&0xffff == 2 && len(fields) > 3 {
+		// VCF: the REF allele gives the length
+		fend = fbeg + int64(len(fields[3]))
+	}
+	return fbeg < end && fend > beg, fbeg >= end
+}
+
+// regionReader returns the header lines, then the lines in each chunk overlapping a region.
+type regionReader struct {
+	idx      *bgzfIndex
+	br       *bgzfReader
+	seq      string
+	beg, end int64
+
+	header  bool
+	lineNum int
+	chunks  []bgzfChunk
+	cur     *bgzfChunk
+	pending []byte
+}
+
+func (rr *regionReader) Read(p []byte) (int, error) {
+	for len(rr.pending) == 0 {
+		line, err := rr.nextLine()
+		if err != nil {
+			return 0, err
+		}
+		rr.pending = line
+	}
+	n := copy(p, rr.pending)
+	rr.pending = rr.pending[n:]
+	return n, nil
+}
+
+// nextLine returns the next header or matching line.
+func (rr *regionReader) nextLine() ([]byte, error) {
+	if rr.header {
+		line, err := rr.br.readLine()
+		rr.lineNum++
+		if err == nil && (rr.lineNum <= rr.idx.skip || (len(line) > 0 && line[0] == rr.idx.meta)) {
+			return line, nil
+		}
+		rr.header = false
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	for {
+		if rr.cur == nil {
+			if len(rr.chunks) == 0 {
+				return nil, io.EOF
+			}
+			rr.cur, rr.chunks = &rr.chunks[0], rr.chunks[1:]
+			if err := rr.br.seek(rr.cur.beg); err != nil {
+				return nil, err
+			}
+		}
+		if rr.br.voffset() >= rr.cur.end {
+			rr.cur = nil
+			continue
+		}
+		line, err := rr.br.readLine()
+		if err == io.EOF {
+			rr.cur = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ok, past := rr.idx.overlaps(line, rr.seq, rr.beg, rr.end)
+		if past {
+			rr.chunks, rr.cur = nil, nil
+			return nil, io.EOF
+		}
+		if ok {
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package anydata_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+// bgzfBlock compresses data as a single BGZF block.
+func bgzfBlock(data []byte) []byte {
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	gw.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	gw.Write(data)
+	gw.Close()
+	block := buf.Bytes()
+	binary.LittleEndian.PutUint16(block[16:], uint16(len(block)-1))
+	return block
+}
+
+func TestBGZF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	header := "#chrom\tstart\tend\n"
+	records := "chr1\t100\t200\nchr1\t300\t400\nchr2\t100\t200\n"
+	var data []byte
+	data = append(data, bgzfBlock([]byte(header))...)
+	recOffset := uint64(len(data)) << 16
+	data = append(data, bgzfBlock([]byte(records))...)
+	endOffset := uint64(len(data)) << 16
+	data = append(data, bgzfBlock(nil)...)
+	bedName := filepath.Join(dir, "peaks.bed.gz")
+	ioutil.WriteFile(bedName, data, 0666)
+
+	// a tabix index with every record in bin 0
+	var idx bytes.Buffer
+	le := func(vs ...interface{}) {
+		for _, v := range vs {
+			binary.Write(&idx, binary.LittleEndian, v)
+		}
+	}
+	idx.WriteString("TBI\x01")
+	names := "chr1\x00chr2\x00"
+	le(int32(2), int32(0x10000), int32(1), int32(2), int32(3), int32('#'), int32(0), int32(len(names)))
+	idx.WriteString(names)
+	for i := 0; i < 2; i++ {
+		le(int32(1), uint32(0), int32(1), recOffset, endOffset, int32(0))
+	}
+	f, _ := os.Create(bedName + ".tbi")
+	gw := gzip.NewWriter(f)
+	gw.Write(idx.Bytes())
+	gw.Close()
+	f.Close()
+
+	// the second record starts 13 bytes into the records block
+	offset := fmt.Sprintf("%s#offset=%d", bedName, len(header)+13)
+	for resource, want := range map[string]string{
+		bedName + "#chr1:250-1000": header + "chr1\t300\t400\n",
+		bedName + "#chr2":          header + "chr2\t100\t200\n",
+		bedName + "#chr3":          header,
+		offset:                     "chr1\t300\t400\nchr2\t100\t200\n",
+		bedName:                    header + records,
+	} {
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fr.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v)", resource, data, err)
+		}
+	}
+}
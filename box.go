@@ -0,0 +1,182 @@
+package anydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// A Box fetcher for shared links and (with an access token) file IDs:
+//
+//    https://app.box.com/s/abc123def456
+//    box://123456789/genes.tsv.gz
+//
+// Shared links are downloaded directly through their static download URL, unless credentials
+// with a Token or OAuth2 config are available from the CredentialProvider for the scheme "box"
+// and host "box.com", in which case the Box API is used. This allows links which are only
+// shared with collaborators to be read. box:// file IDs always require credentials, and the
+// optional filename after the ID is only used to select wrappers.
+//
+// Downloads are stored in the cache in the same way as the HTTP fetcher.
+type boxFetcher struct {
+	httpFetcher
+}
+
+var boxLinkPattern = regexp.MustCompile(`^https://([a-z0-9-]+\.)?(app\.)?box\.com/(s|shared/static)/([0-9A-Za-z]+)`)
+
+func (n *boxFetcher) String() string {
+	return "Box Download"
+}
+
+func (n *boxFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "box://") || boxLinkPattern.MatchString(resource)
+}
+
+// boxCredentials returns the credentials for the Box API, or nil if there are none.
+func boxCredentials() *Credentials {
+	c := lookupCredentials("box", "box.com")
+	if c == nil || (c.Token == "" && c.OAuth2 == nil) {
+		return nil
+	}
+	return c
+}
+
+// setRequest prepares the fetcher to call the Box API at apiURL. Shared links are passed in
+// the BoxApi header.
+func (n *boxFetcher) setRequest(resource, apiURL string) error {
+	c := boxCredentials()
+	if c == nil {
+		return fmt.Errorf("reading '%s' requires Box credentials", resource)
+	}
+	link := ""
+	if m := boxLinkPattern.FindString(resource); m != "" {
+		link = "shared_link=" + m
+	}
+	n.resource = resource
+	n.url = apiURL
+	n.prepare = func(req *http.Request) error {
+		if link != "" {
+			req.Header.Set("BoxApi", link)
+		}
+		return c.applyHTTP(req)
+	}
+	return nil
+}
+
+// fileID returns the Box file ID of resource, using the API to resolve shared links.
+func (n *boxFetcher) fileID(ctx context.Context, resource string) (string, error) {
+	if strings.HasPrefix(resource, "box://") {
+		furl, err := url.Parse(resource)
+		if err != nil {
+			return "", err
+		}
+		if furl.Host == "" {
+			return "", fmt.Errorf("'%s' does not name a Box file", resource)
+		}
+		return furl.Host, nil
+	}
+
+	if err := n.setRequest(resource, "https://api.box.com/2.0/shared_items?fields=id,type"); err != nil {
+		return "", err
+	}
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var item struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return "", fmt.Errorf("box lookup of '%s' failed: %s", resource, err.Error())
+	}
+	if item.Type != "file" {
+		return "", fmt.Errorf("box lookup of '%s' failed: not a file", resource)
+	}
+	return item.ID, nil
+}
+
+// setDownloadURL prepares the fetcher to download resource.
+func (n *boxFetcher) setDownloadURL(ctx context.Context, resource string) error {
+	if boxCredentials() == nil {
+		m := boxLinkPattern.FindStringSubmatch(resource)
+		if m == nil {
+			return fmt.Errorf("reading '%s' requires Box credentials", resource)
+		}
+		n.resource, n.prepare = resource, nil
+		n.url = "https://app.box.com/shared/static/" + m[4]
+		return nil
+	}
+
+	id, err := n.fileID(ctx, resource)
+	if err != nil {
+		return err
+	}
+	return n.setRequest(resource, "https://api.box.com/2.0/files/"+url.PathEscape(id)+"/content")
+}
+
+func (n *boxFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *boxFetcher) FetchContext(ctx context.Context, resource string) error {
+	if n.cache().cachedFilePath(ctx, resource) != "" {
+		return n.httpFetcher.FetchContext(ctx, resource)
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "box")
+
+	if err := n.setDownloadURL(ctx, resource); err != nil {
+		return err
+	}
+	return n.httpFetcher.FetchContext(ctx, resource)
+}
+
+// Expand returns pattern as-is, since shared links can't be listed.
+func (n *boxFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	return []string{pattern}, nil
+}
+
+// Stat uses the Box API file information if credentials are available, otherwise it makes a
+// HEAD request for the shared link.
+func (n *boxFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if boxCredentials() == nil {
+		if err := n.setDownloadURL(ctx, resource); err != nil {
+			return ResourceInfo{}, err
+		}
+		return n.httpFetcher.Stat(ctx, resource)
+	}
+
+	id, err := n.fileID(ctx, resource)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	if err = n.setRequest(resource, "https://api.box.com/2.0/files/"+url.PathEscape(id)+
+		"?fields=size,modified_at,etag"); err != nil {
+		return ResourceInfo{}, err
+	}
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var file struct {
+		Size       int64  `json:"size"`
+		ModifiedAt string `json:"modified_at"`
+		ETag       string `json:"etag"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return ResourceInfo{}, fmt.Errorf("box stat of '%s' failed: %s", resource, err.Error())
+	}
+	info := ResourceInfo{Size: file.Size, ETag: file.ETag}
+	info.ModTime, _ = time.Parse(time.RFC3339, file.ModifiedAt)
+	return info, nil
+}
@@ -0,0 +1,987 @@
+package anydata
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// Cache stores downloaded files in a local folder, along with an index (cacheinfo.json) of when
+// they were fetched. Most programs can use the package-level cache functions, which operate on
+// DefaultCache. Programs (or tests) that need isolated caches with different policies can create
+// their own with NewCache, and attach them to a Registry using SetCache.
+type Cache struct {
+	// counters for Stats, updated atomically (first, so they are 64-bit aligned)
+	hits, misses, evictions, bytesServed, bytesDownloaded int64
+
+	mu sync.Mutex
+
+	path    string
+	entries map[string]cachedfile
+
+	// time to cache data files for
+	age time.Duration
+
+	// maximum total size of the cached payloads, or 0 for no limit
+	maxSize int64
+
+	// lifetimes set by SetTTL
+	ttls []cacheTTL
+
+	// modification times of payloads whose checksum has been verified by this process
+	verified map[string]time.Time
+
+	// payloads whose ".partial" file is open for writing by a cacheWriter
+	writing map[string]bool
+
+	// compression applied to new payloads: "", "gzip" or "zstd"
+	compression string
+
+	// read-only folders consulted first, see AddShared
+	shared []*sharedCache
+}
+
+// CacheOptions sets the policies of a new Cache, see NewCache.
+type CacheOptions struct {
+	// MaxAge is how long cached copies remain valid (7 days if 0), see also Cache.SetTTL.
+	MaxAge time.Duration
+
+	// MaxSize limits the total size of the cached files, see Cache.SetMaxSize.
+	MaxSize int64
+
+	// Compression is the format used to store new files, see Cache.SetCompression.
+	Compression string
+}
+
+// DefaultCache is used by the package-level cache functions and by Registries without a Cache
+// of their own. Unless InitCache is called, it is kept in the folder "cache" and copies remain
+// valid for 7 days.
+var DefaultCache = &Cache{}
+
+// NewCache returns a Cache kept in the folder cpath, which is created if it does not exist, and
+// loads any files cached there previously. opts may be nil to use the defaults. For example, to
+// give a test its own cache:
+//
+//	c, err := anydata.NewCache(t.TempDir(), &anydata.CacheOptions{MaxAge: time.Hour})
+//	...
+//	r := anydata.NewRegistry()
+//	r.RegisterDefaults()
+//	r.SetCache(c)
+func NewCache(cpath string, opts *CacheOptions) (*Cache, error) {
+	c := &Cache{}
+	age := 7 * 24 * time.Hour
+	if opts != nil {
+		if opts.MaxAge > 0 {
+			age = opts.MaxAge
+		}
+		if err := c.SetCompression(opts.Compression); err != nil {
+			return nil, err
+		}
+		c.maxSize = opts.MaxSize
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.init(cpath, age); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init sets the cache folder and age, and loads the index. The cache is still initialized
+// (possibly empty) when an error is returned. c.mu must be held.
+func (c *Cache) init(cpath string, age time.Duration) error {
+	c.path = cpath
+	c.age = age
+	c.entries = make(map[string]cachedfile)
+	c.verified = make(map[string]time.Time)
+	c.writing = make(map[string]bool)
+
+	// create cachePath if it doesn't exist
+	if err := os.Mkdir(c.path, 0777); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("unable to create cache folder: %s", err.Error())
+	}
+
+	// remove index files left behind by a crash in saveIndex
+	if tmps, err := filepath.Glob(path.Join(c.path, "cacheinfo.json.tmp*")); err == nil {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}
+
+	index, err := readCacheIndex(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.entries = index
+	return nil
+}
+
+// ensure initializes the default cache folder if the cache has not been initialized. c.mu must
+// be held.
+func (c *Cache) ensure() {
+	if c.entries == nil {
+		if err := c.init("cache", 7*24*time.Hour); err != nil {
+			Logf("%s\n", err.Error())
+		}
+	}
+}
+
+// dir returns the cache folder.
+func (c *Cache) dir() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+	return c.path
+}
+
+// SetTTL sets how long cached copies of resources beginning with prefix remain valid, see
+// SetCacheTTL.
+func (c *Cache) SetTTL(prefix string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.ttls {
+		if c.ttls[i].prefix == prefix {
+			c.ttls[i].ttl = ttl
+			return
+		}
+	}
+	c.ttls = append(c.ttls, cacheTTL{prefix: prefix, ttl: ttl})
+}
+
+// resourceTTL returns how long a cached copy of resource remains valid. c.mu must be held.
+func (c *Cache) resourceTTL(resource string) time.Duration {
+	ttl, matched := c.age, -1
+	for _, t := range c.ttls {
+		if len(t.prefix) > matched && strings.HasPrefix(resource, t.prefix) {
+			ttl, matched = t.ttl, len(t.prefix)
+		}
+	}
+	return ttl
+}
+
+// SetMaxSize limits the total size of the files in the cache to maxBytes, see SetCacheMaxSize.
+func (c *Cache) SetMaxSize(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxBytes
+	if c.entries != nil && c.evict("") {
+		c.saveIndex()
+	}
+}
+
+// SetCompression sets the format used to store new files, see SetCacheCompression.
+func (c *Cache) SetCompression(format string) error {
+	switch format {
+	case "", "gzip", "zstd":
+	default:
+		return fmt.Errorf("unknown cache compression format '%s'", format)
+	}
+	c.mu.Lock()
+	c.compression = format
+	c.mu.Unlock()
+	return nil
+}
+
+// fetchTime returns the time that a recent cached copy of resource was fetched. Unlike
+// cachedFilePath, it does not count as a cache hit or miss.
+func (c *Cache) fetchTime(resource string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+	if t, ok := c.sharedFetchTime(resource); ok {
+		return t, true
+	}
+	cinfo, found := c.entries[cacheKey(resource)]
+	if !found || time.Now().Sub(cinfo.FetchTime) > c.resourceTTL(resource) {
+		return time.Time{}, false
+	}
+	if _, err := os.Stat(path.Join(c.path, cinfo.LocalName)); err != nil {
+		return time.Time{}, false
+	}
+	return cinfo.FetchTime, true
+}
+
+// cachedFilePath returns the local path of a recent cached copy of resource, or "" if the
+// resource is too old (see SetTTL) or does not exist, or ctx requests a fresh copy.
+func (c *Cache) cachedFilePath(ctx context.Context, resource string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+
+	mode := cacheModeFrom(ctx)
+	if mode == CacheDefault {
+		if fn := c.sharedCachedFile(resource); fn != "" {
+			c.count(&c.hits, metrics.CacheHits, 1)
+			return fn
+		}
+	}
+	if cinfo, found := c.entries[cacheKey(resource)]; found && mode == CacheDefault {
+		if ttl := c.resourceTTL(resource); time.Now().Sub(cinfo.FetchTime) > ttl {
+			Logf("Cached copy is too old (%dh)\n", time.Now().Sub(cinfo.FetchTime)/time.Hour)
+			c.count(&c.misses, metrics.CacheMisses, 1)
+			return ""
+		}
+
+		// cached copy is recent, use it instead of fetching
+		fn := path.Join(c.path, cinfo.LocalName)
+		if c.verify(cacheKey(resource), fn) {
+			c.count(&c.hits, metrics.CacheHits, 1)
+			cinfo = c.entries[cacheKey(resource)] // c.mu may have been released by verify
+			if time.Since(cinfo.AccessTime) > time.Minute {
+				// only saved occasionally, since the order of recent uses barely matters
+				cinfo.AccessTime = time.Now()
+				c.entries[cacheKey(resource)] = cinfo
+				c.saveIndex()
+			}
+			return fn
+		}
+	}
+	c.count(&c.misses, metrics.CacheMisses, 1)
+	return ""
+}
+
+// staleCachedFile returns the local path and validators of a cached copy of resource, even if it
+// is too old to be used without revalidation. The path is "" if there is no cached copy, or if
+// ctx requests that cached copies are ignored (CacheRefresh).
+func (c *Cache) staleCachedFile(ctx context.Context, resource string) (string, cachedfile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+
+	cinfo, found := c.entries[cacheKey(resource)]
+	if !found || cacheModeFrom(ctx) == CacheRefresh {
+		return "", cinfo
+	}
+	fn := path.Join(c.path, cinfo.LocalName)
+	if !c.verify(cacheKey(resource), fn) {
+		return "", cinfo
+	}
+	return fn, cinfo
+}
+
+// verify returns true if the payload fn for key exists and matches the checksum recorded when
+// it was cached. Each payload is only hashed once per process (unless it is modified), and
+// entries cached before checksums were recorded are trusted. A corrupt payload (e.g. truncated
+// by a crash before it was flushed to disk) is removed from the cache. c.mu must be held, but is
+// released while the payload is hashed, so that large files do not block other cache users.
+func (c *Cache) verify(key, fn string) bool {
+	st, err := os.Stat(fn)
+	if err != nil {
+		return false
+	}
+	cf := c.entries[key]
+	if cf.SHA256 == "" {
+		return true
+	}
+	if mt, ok := c.verified[key]; ok && mt.Equal(st.ModTime()) {
+		return true
+	}
+
+	corrupt := cf.Size != 0 && st.Size() != cf.Size
+	if !corrupt {
+		c.mu.Unlock()
+		sum, err := fileSHA256(fn)
+		c.mu.Lock()
+		if err != nil {
+			Logf("unable to verify cached copy of '%s': %s\n", key, err.Error())
+			return false
+		}
+
+		// the entry may have been replaced or removed while it was hashed
+		now, found := c.entries[key]
+		if !found || now.LocalName != cf.LocalName || now.SHA256 != cf.SHA256 || now.Size != cf.Size {
+			return false
+		}
+		if st2, err := os.Stat(fn); err != nil || !st2.ModTime().Equal(st.ModTime()) {
+			return false
+		}
+		corrupt = sum != cf.SHA256
+	}
+	if corrupt {
+		Logf("cached copy of '%s' is corrupt, ignoring it\n", key)
+		if err = c.remove(key); err != nil {
+			Logf("%s\n", err.Error())
+		} else if err = c.saveIndex(); err != nil {
+			Logf("%s\n", err.Error())
+		}
+		return false
+	}
+	c.verified[key] = st.ModTime()
+	return true
+}
+
+// touch resets the age of the cached copy of resource, after it has been verified to match the
+// remote file.
+func (c *Cache) touch(resource string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cinfo, found := c.entries[cacheKey(resource)]
+	if !found {
+		return fmt.Errorf("'%s' is not cached", resource)
+	}
+	cinfo.FetchTime = time.Now()
+	c.entries[cacheKey(resource)] = cinfo
+	return c.saveIndex()
+}
+
+// GetFile returns the contents of a file (identified by resource) from the cache, see
+// GetCachedFile.
+func (c *Cache) GetFile(resource string) []byte {
+	data, err := c.ReadFile(resource)
+	if err != nil && err != ErrNotCached {
+		Logf("%s\n", err.Error())
+	}
+	return data
+}
+
+// ReadFile is equivalent to GetFile, but returns ErrNotCached if the resource is too old or
+// does not exist, and any other error if the cached copy cannot be read.
+func (c *Cache) ReadFile(resource string) ([]byte, error) {
+	fn := c.cachedFilePath(context.Background(), resource)
+	if fn == "" {
+		return nil, ErrNotCached
+	}
+	f, err := c.openFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// PutFile saves the contents of a file (identified by resource) to the cache. Errors are
+// logged, see WriteFile.
+func (c *Cache) PutFile(resource string, data []byte) {
+	if err := c.WriteFile(resource, data); err != nil {
+		Logf("%s\n", err.Error())
+	}
+}
+
+// WriteFile is equivalent to PutFile, but returns an error (e.g. if the disk is full) instead
+// of logging it. The cache is unchanged when an error is returned.
+func (c *Cache) WriteFile(resource string, data []byte) error {
+	cw, err := c.newCacheWriter(resource)
+	if err != nil {
+		return err
+	}
+	if _, err = cw.Write(data); err != nil {
+		cw.Abort()
+		return err
+	}
+	_, err = cw.Commit()
+	return err
+}
+
+// GetStream opens a file (identified by resource) from the cache, see GetCachedStream.
+func (c *Cache) GetStream(resource string) io.ReadCloser {
+	fn := c.cachedFilePath(context.Background(), resource)
+	if fn == "" {
+		return nil
+	}
+	f, err := c.openFile(fn)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// PutStream returns a CacheWriter which saves a file (identified by resource) to the cache as
+// it is written, see PutCachedStream.
+func (c *Cache) PutStream(resource string) (CacheWriter, error) {
+	cw, err := c.newCacheWriter(resource)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheStream{cw: cw}, nil
+}
+
+// TeeStream returns a reader for r which also saves everything read to the cache as resource,
+// see TeeCachedStream.
+func (c *Cache) TeeStream(resource string, r io.ReadCloser) io.ReadCloser {
+	return c.newCacheTee(resource, r, "custom", nil)
+}
+
+// List returns every file in the cache, sorted by resource.
+func (c *Cache) List() []CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+
+	entries := make([]CacheEntry, 0, len(c.entries))
+	for key, cf := range c.entries {
+		ce := CacheEntry{
+			Resource:   key,
+			LocalPath:  path.Join(c.path, cf.LocalName),
+			Size:       cf.Size,
+			FetchTime:  cf.FetchTime,
+			AccessTime: cf.AccessTime,
+			Expired:    time.Now().Sub(cf.FetchTime) > c.resourceTTL(key),
+		}
+		if st, err := os.Stat(ce.LocalPath); err != nil {
+			// the payload was deleted by hand
+			continue
+		} else if ce.Size == 0 {
+			ce.Size = st.Size()
+		}
+		entries = append(entries, ce)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Resource < entries[j].Resource })
+	return entries
+}
+
+// Evict removes the cached copy of resource, see EvictCachedFile.
+func (c *Cache) Evict(resource string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+
+	key := cacheKey(resource)
+	if _, found := c.entries[key]; !found {
+		return nil
+	}
+	if err := c.remove(key); err != nil {
+		return err
+	}
+	return c.saveIndex()
+}
+
+// Purge removes every cached file which was fetched more than olderThan ago, see PurgeCache.
+func (c *Cache) Purge(olderThan time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ensure()
+
+	var err error
+	removed := 0
+	for key, cf := range c.entries {
+		if time.Now().Sub(cf.FetchTime) < olderThan {
+			continue
+		}
+		if rerr := c.remove(key); rerr != nil {
+			if err == nil {
+				err = rerr
+			}
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		if serr := c.saveIndex(); err == nil {
+			err = serr
+		}
+	}
+	return removed, err
+}
+
+// CacheCounters reports how effective a Cache has been since the program started, see
+// CacheStats.
+type CacheCounters struct {
+	// Hits and Misses count lookups which found a recent cached copy, or did not.
+	Hits, Misses int64
+
+	// Evictions counts files removed from the cache (e.g. by SetMaxSize or Purge).
+	Evictions int64
+
+	// BytesServed counts bytes read from cached files, and BytesDownloaded the (uncompressed)
+	// size of the files downloaded into the cache.
+	BytesServed, BytesDownloaded int64
+}
+
+// Stats returns the counters of c, see CacheStats.
+func (c *Cache) Stats() CacheCounters {
+	return CacheCounters{
+		Hits:            atomic.LoadInt64(&c.hits),
+		Misses:          atomic.LoadInt64(&c.misses),
+		Evictions:       atomic.LoadInt64(&c.evictions),
+		BytesServed:     atomic.LoadInt64(&c.bytesServed),
+		BytesDownloaded: atomic.LoadInt64(&c.bytesDownloaded),
+	}
+}
+
+// PublishStats publishes the counters of c as an expvar variable called name, see
+// PublishCacheStats.
+func (c *Cache) PublishStats(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return c.Stats() }))
+}
+
+// count adds delta to one of c's counters, and to the named metric.
+func (c *Cache) count(counter *int64, name string, delta int64) {
+	atomic.AddInt64(counter, delta)
+	metrics.Add(name, float64(delta))
+}
+
+// openFile opens a cached payload (see openCachedFile), counting the bytes read from it.
+func (c *Cache) openFile(fn string) (io.ReadCloser, error) {
+	f, err := openCachedFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheReader{ReadCloser: f, c: c}, nil
+}
+
+// cacheReader counts the bytes read from a cached payload.
+type cacheReader struct {
+	io.ReadCloser
+	c *Cache
+}
+
+func (r *cacheReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.c.count(&r.c.bytesServed, metrics.CacheServed, int64(n))
+	}
+	return n, err
+}
+
+// evict deletes the least recently used payloads (other than keep) until the cache fits within
+// its maximum size, and returns true if any were removed. c.mu must be held.
+func (c *Cache) evict(keep string) bool {
+	if c.maxSize <= 0 {
+		return false
+	}
+
+	var total int64
+	keys := make([]string, 0, len(c.entries))
+	for key, cf := range c.entries {
+		if cf.Size == 0 {
+			// entries from before sizes were recorded
+			if st, err := os.Stat(path.Join(c.path, cf.LocalName)); err == nil {
+				cf.Size = st.Size()
+				c.entries[key] = cf
+			}
+		}
+		total += cf.Size
+		keys = append(keys, key)
+	}
+	if total <= c.maxSize {
+		return false
+	}
+
+	lastUse := func(cf cachedfile) time.Time {
+		if cf.AccessTime.IsZero() {
+			return cf.FetchTime
+		}
+		return cf.AccessTime
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lastUse(c.entries[keys[i]]).Before(lastUse(c.entries[keys[j]]))
+	})
+	for _, key := range keys {
+		if total <= c.maxSize {
+			break
+		}
+		if key == keep {
+			continue
+		}
+		size := c.entries[key].Size
+		if err := c.remove(key); err != nil {
+			Logf("%s\n", err.Error())
+			continue
+		}
+		Logf("evicted '%s' from the cache\n", key)
+		total -= size
+	}
+	return true
+}
+
+// remove deletes the payload and index entry for key. c.mu must be held.
+func (c *Cache) remove(key string) error {
+	cf := c.entries[key]
+	if err := os.Remove(path.Join(c.path, cf.LocalName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to evict '%s' from the cache: %s", key, err.Error())
+	}
+	c.count(&c.evictions, metrics.CacheEvictions, 1)
+	delete(c.entries, key)
+	delete(c.verified, key)
+	return nil
+}
+
+// saveIndex writes cacheinfo.json. The index is written to a temporary file which is synced to
+// disk and renamed into place, so a crash never leaves a truncated index. c.mu must be held.
+func (c *Cache) saveIndex() error {
+	cdata, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(c.path, "cacheinfo.json.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(cdata); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file readable only by its owner
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path.Join(c.path, "cacheinfo.json"))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	syncDir(c.path)
+	return nil
+}
+
+// syncDir flushes a folder to disk, so that files renamed into it survive a crash. Errors are
+// ignored, since not all platforms support it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+///////////////////
+
+// cacheRef is embedded in Fetchers which store downloads in a Cache, so that a Registry can
+// attach its own Cache to the Fetchers it returns (see Registry.SetCache).
+type cacheRef struct {
+	c *Cache
+}
+
+func (r *cacheRef) setCache(c *Cache) {
+	r.c = c
+}
+
+// cache returns the attached Cache, or DefaultCache if there is none.
+func (r *cacheRef) cache() *Cache {
+	if r.c == nil {
+		return DefaultCache
+	}
+	return r.c
+}
+
+///////////////////
+
+// cacheWriter writes a new cache payload to disk. The payload is written to a ".partial" file
+// which is renamed into place (and the cache index updated) once Commit is called. If another
+// cacheWriter is already writing the ".partial" file for the resource, a temporary file is
+// used instead, which can not be resumed.
+type cacheWriter struct {
+	c        *Cache
+	f        *os.File
+	key      string
+	resource string
+	tempname string
+	base     string // the payload path, without a compression suffix
+	partial  bool   // true if f is the resumable ".partial" file
+
+	etag, lastModified string
+	modTime            time.Time
+}
+
+func (c *Cache) newCacheWriter(resource string) (*cacheWriter, error) {
+	return c.openCacheWriter(resource, false)
+}
+
+// fileName returns the payload filename used for resource: its sanitized base name followed by
+// a short hash of the resource (e.g. "taxdump.tar.gz-ab12cd34"), so that the cache folder can be
+// inspected by hand. The full hash is used if another resource already has the short name.
+// c.mu must be held.
+func (c *Cache) fileName(resource string) string {
+	c.ensure()
+
+	key := cacheKey(resource)
+	sum := fmt.Sprintf("%x", md5.Sum([]byte(key)))
+	base := sanitizeFileName(path.Base(strings.SplitN(key, "?", 2)[0]))
+	name := base + "-" + sum[:8]
+	for other, cf := range c.entries {
+		if other != key && trimCacheSuffix(cf.LocalName) == name {
+			name = base + "-" + sum
+			break
+		}
+	}
+	return path.Join(c.path, name)
+}
+
+// sanitizeFileName replaces the characters of name which may not be safe in a file name, and
+// limits its length.
+func sanitizeFileName(name string) string {
+	clean := []byte(strings.TrimLeft(name, "."))
+	for i, b := range clean {
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '.' || b == '-' || b == '_') {
+			clean[i] = '_'
+		}
+	}
+	if len(clean) > 64 {
+		clean = clean[len(clean)-64:]
+	}
+	if len(clean) == 0 {
+		return "file"
+	}
+	return string(clean)
+}
+
+// trimCacheSuffix removes the compression suffix (if any) from a payload name.
+func trimCacheSuffix(name string) string {
+	for _, suffix := range cacheSuffixes {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name
+}
+
+// openCacheWriter creates a cacheWriter for resource. If resume is true, data left behind by
+// a Suspended cacheWriter is kept and new writes are appended to it. If the resource is already
+// being written, the new cacheWriter starts from an empty temporary file.
+func (c *Cache) openCacheWriter(resource string, resume bool) (*cacheWriter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn := c.fileName(resource)
+	cw := &cacheWriter{c: c, key: cacheKey(resource), resource: strings.SplitN(resource, "#", 2)[0],
+		tempname: path.Base(fn), base: fn}
+
+	var err error
+	if c.writing[fn] {
+		if cw.f, err = ioutil.TempFile(c.path, path.Base(fn)+".tmp"); err != nil {
+			return nil, err
+		}
+		// TempFile creates the file readable only by its owner
+		os.Chmod(cw.f.Name(), 0644)
+		return cw, nil
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	} else {
+		os.Remove(fn + ".partial.info")
+	}
+	if cw.f, err = os.OpenFile(fn+".partial", flags, 0666); err != nil {
+		return nil, err
+	}
+	cw.partial = true
+	c.writing[fn] = true
+	return cw, nil
+}
+
+// release allows the ".partial" file to be written by another cacheWriter.
+func (cw *cacheWriter) release() {
+	if cw.partial {
+		cw.c.mu.Lock()
+		delete(cw.c.writing, cw.base)
+		cw.c.mu.Unlock()
+		cw.partial = false
+	}
+}
+
+// partialDownload returns the size of the partial payload left for resource by a Suspended
+// cacheWriter, along with the info saved using SetResumeInfo. The size is 0 if the payload is
+// being written by another download.
+func (c *Cache) partialDownload(resource string) (int64, string) {
+	c.mu.Lock()
+	fn := c.fileName(resource)
+	busy := c.writing[fn]
+	c.mu.Unlock()
+	if busy {
+		return 0, ""
+	}
+	fn += ".partial"
+
+	st, err := os.Stat(fn)
+	if err != nil {
+		return 0, ""
+	}
+	info, _ := ioutil.ReadFile(fn + ".info")
+	return st.Size(), string(info)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	return cw.f.Write(p)
+}
+
+// SetResumeInfo saves info alongside the payload, for use by a Fetcher when resuming a
+// Suspended download (e.g. to verify the remote file is unchanged).
+func (cw *cacheWriter) SetResumeInfo(info string) error {
+	if !cw.partial {
+		return nil
+	}
+	return ioutil.WriteFile(cw.f.Name()+".info", []byte(info), 0666)
+}
+
+// SetValidators records the remote file's ETag and Last-Modified values in the cache entry, so
+// that it can be revalidated once it is too old.
+func (cw *cacheWriter) SetValidators(etag, lastModified string) {
+	cw.etag, cw.lastModified = etag, lastModified
+	if t, err := http.ParseTime(lastModified); err == nil {
+		cw.modTime = t
+	}
+}
+
+// SetModTime records the modification time of the remote file in the cache entry.
+func (cw *cacheWriter) SetModTime(t time.Time) {
+	cw.modTime = t
+}
+
+// Commit closes the payload file, adds the cache entry and returns the payload's local path.
+// The payload is synced to disk before it is renamed into place, so a crash cannot leave a
+// partial file under the final name.
+func (cw *cacheWriter) Commit() (string, error) {
+	defer cw.release()
+	err := cw.f.Sync()
+	if cerr := cw.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(cw.f.Name())
+		return "", err
+	}
+	os.Remove(cw.f.Name() + ".info")
+
+	var size int64
+	if st, err := os.Stat(cw.f.Name()); err == nil {
+		size = st.Size()
+		atomic.AddInt64(&cw.c.bytesDownloaded, size)
+	}
+
+	cw.c.mu.Lock()
+	format := cw.c.compression
+	cw.c.mu.Unlock()
+	partial, suffix := cw.f.Name(), ""
+	if format != "" {
+		if partial, err = compressCachedFile(partial, format); err != nil {
+			os.Remove(cw.f.Name())
+			return "", err
+		}
+		suffix = strings.TrimPrefix(partial, cw.f.Name())
+	}
+
+	// hashed from disk, since resumed downloads and rsync write to the file directly
+	sum, err := fileSHA256(partial)
+	if err != nil {
+		return "", err
+	}
+	base := cw.base
+	fn := base + suffix
+	if err = os.Rename(partial, fn); err != nil {
+		return "", err
+	}
+	// remove any copy stored with a different compression setting
+	for _, other := range []string{"", cacheSuffixes["gzip"], cacheSuffixes["zstd"]} {
+		if other != suffix {
+			os.Remove(base + other)
+		}
+	}
+
+	cw.c.mu.Lock()
+	defer cw.c.mu.Unlock()
+
+	// add the cache entry and serialize to disk immediately
+	cf := cachedfile{LocalName: cw.tempname + suffix, FetchTime: time.Now(),
+		ETag: cw.etag, LastModified: cw.lastModified, AccessTime: time.Now(), SHA256: sum,
+		Resource: cw.resource, RemoteSize: size, RemoteModTime: cw.modTime}
+	if old, found := cw.c.entries[cw.key]; found && old.LocalName != cf.LocalName {
+		// the previous copy was stored under another name
+		os.Remove(path.Join(cw.c.path, old.LocalName))
+	}
+	if st, err := os.Stat(fn); err == nil {
+		cf.Size = st.Size()
+		cw.c.verified[cw.key] = st.ModTime()
+	}
+	cw.c.entries[cw.key] = cf
+	cw.c.evict(cw.key)
+	return fn, cw.c.saveIndex()
+}
+
+// Abort closes and removes a partially written payload.
+func (cw *cacheWriter) Abort() {
+	cw.f.Close()
+	os.Remove(cw.f.Name())
+	os.Remove(cw.f.Name() + ".info")
+	cw.release()
+}
+
+// Suspend closes a partially written payload, but leaves it on disk so that the download can
+// be resumed later using openCacheWriter. A temporary file is removed, as by Abort.
+func (cw *cacheWriter) Suspend() {
+	if !cw.partial {
+		cw.Abort()
+		return
+	}
+	cw.f.Close()
+	cw.release()
+}
+
+///////////////////
+
+// cacheTee streams a download for resource, writing a copy into the cache as it is read. Once
+// the stream has been read completely the cache entry is committed and done is called with
+// its local path. If the stream is closed early the partial copy is discarded, unless the tee
+// is resumable and the stream failed with an error.
+type cacheTee struct {
+	r         io.ReadCloser
+	cw        *cacheWriter
+	label     string
+	done      func(localPath string)
+	resumable bool
+}
+
+func (c *Cache) newCacheTee(resource string, r io.ReadCloser, label string, done func(string)) *cacheTee {
+	cw, err := c.newCacheWriter(resource)
+	if err != nil {
+		Logf("%s\n", err.Error())
+		cw = nil
+	}
+	return &cacheTee{r: r, cw: cw, label: label, done: done}
+}
+
+func (t *cacheTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		metrics.Add(metrics.BytesDownloaded, float64(n), "fetcher", t.label)
+		if t.cw != nil {
+			if _, werr := t.cw.Write(p[:n]); werr != nil {
+				Logf("%s\n", werr.Error())
+				t.cw.Abort()
+				t.cw = nil
+			}
+		}
+	}
+	if err != nil && err != io.EOF && t.cw != nil && t.resumable {
+		t.cw.Suspend()
+		t.cw = nil
+	}
+	if err == io.EOF && t.cw != nil {
+		fn, cerr := t.cw.Commit()
+		t.cw = nil
+		if cerr != nil {
+			Logf("%s\n", cerr.Error())
+		} else if t.done != nil {
+			t.done(fn)
+		}
+	}
+	return n, err
+}
+
+func (t *cacheTee) Close() error {
+	if t.cw != nil {
+		t.cw.Abort()
+		t.cw = nil
+	}
+	return t.r.Close()
+}
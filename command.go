@@ -0,0 +1,163 @@
+package anydata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+var (
+	commandMu      sync.RWMutex
+	allowedCommand = make(map[string]bool)
+)
+
+// AllowCommands adds programs to the list which may be run by exec:// resources. Names without
+// a path separator are searched for in the PATH. No programs are allowed by default, since a
+// resource string would otherwise be able to run anything.
+func AllowCommands(names ...string) {
+	commandMu.Lock()
+	for _, name := range names {
+		allowedCommand[name] = true
+	}
+	commandMu.Unlock()
+}
+
+///////////////////
+
+// A process output fetcher, which runs an allowed program (see AllowCommands) and streams its
+// standard output. This is useful for sources which are only accessible through vendor tools:
+//
+//    exec://gsutil?args=cat&args=gs://my-bucket/genes.tsv
+//    exec://aws/genes.tsv.gz?args=s3&args=cp&args=s3://my-bucket/genes.tsv.gz&args=-
+//
+// Each "args" parameter is passed as a single argument, and no shell is involved. The path is
+// not passed to the program, but selects wrappers as usual (so the second example above is
+// decompressed). Output is not cached, and a non-zero exit status is reported as a read error
+// along with the program's stderr.
+type commandFetcher struct {
+	command []string
+}
+
+func (n *commandFetcher) String() string {
+	if len(n.command) > 0 {
+		return "Command (" + n.command[0] + ")"
+	}
+	return "Command"
+}
+
+func (n *commandFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "exec://")
+}
+
+// commandLine returns the program and arguments for an exec:// resource.
+func commandLine(resource string) ([]string, error) {
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return nil, err
+	}
+	name := furl.Host
+	if furl.Host == "" {
+		// exec:///usr/local/bin/tool?args=...
+		name = furl.Path
+	}
+
+	commandMu.RLock()
+	allowed := allowedCommand[name]
+	commandMu.RUnlock()
+	if !allowed {
+		return nil, fmt.Errorf("command '%s' is not allowed (see AllowCommands)", name)
+	}
+	return append([]string{name}, furl.Query()["args"]...), nil
+}
+
+func (n *commandFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+// FetchContext checks that the program is allowed and can be found. It is run by
+// GetReaderContext.
+func (n *commandFetcher) FetchContext(ctx context.Context, resource string) error {
+	command, err := commandLine(resource)
+	if err != nil {
+		return err
+	}
+	if _, err = exec.LookPath(command[0]); err != nil {
+		return err
+	}
+	n.command = command
+	return nil
+}
+
+// Stat reports an unknown size, since the output is only available by running the program.
+func (n *commandFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if _, err := commandLine(resource); err != nil {
+		return ResourceInfo{}, err
+	}
+	return ResourceInfo{Size: -1}, nil
+}
+
+func (n *commandFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *commandFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.command == nil {
+		return nil, fmt.Errorf("no command to run (did you call Fetch?)")
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "exec")
+
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, n.command[0], n.command[1:]...)
+	cr := &commandReader{cmd: cmd, cancel: cancel, stderr: &bytes.Buffer{}}
+	cmd.Stderr = cr.stderr
+
+	var err error
+	if cr.stdout, err = cmd.StdoutPipe(); err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return cr, nil
+}
+
+// commandReader streams the stdout of a running program, and reports a failed exit status
+// (along with any stderr output) as a read error.
+type commandReader struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func (cr *commandReader) Read(p []byte) (int, error) {
+	n, err := cr.stdout.Read(p)
+	if err == io.EOF && !cr.done {
+		cr.done = true
+		werr := cr.cmd.Wait()
+		cr.cancel()
+		if werr != nil {
+			return n, fmt.Errorf("command %s: %s: %s", cr.cmd.Path, werr, strings.TrimSpace(cr.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (cr *commandReader) Close() error {
+	if !cr.done {
+		cr.done = true
+		cr.cancel()
+		cr.cmd.Wait()
+	}
+	return nil
+}
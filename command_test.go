@@ -0,0 +1,35 @@
+package anydata_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestCommandFetcher(t *testing.T) {
+	resource := "exec://echo?args=hello&args=world"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err == nil {
+		t.Fatal("expected an error for a command which is not allowed")
+	}
+
+	anydata.AllowCommands("echo")
+	if err = f.Fetch(resource); err != nil {
+		t.Skip(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Errorf("read %q", data)
+	}
+}
@@ -0,0 +1,128 @@
+package anydata
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ContextFetcher is implemented by Fetchers which support cancellation and deadlines. All of the
+// built-in Fetchers and Wrappers implement it. Readers returned by GetReaderContext stream data
+// as it arrives (from the network, a cached copy, or a spooled temporary file) and must be
+// closed by the caller.
+//
+// Use the package-level FetchContext and GetReaderContext functions to work with any Fetcher,
+// including those which only implement the basic interface.
+type ContextFetcher interface {
+	Fetcher
+
+	// FetchContext is equivalent to Fetch, but aborts when ctx is done.
+	FetchContext(ctx context.Context, resource string) error
+
+	// GetReaderContext is equivalent to GetReader, but reads fail once ctx is done.
+	GetReaderContext(ctx context.Context) (io.ReadCloser, error)
+}
+
+// FetchContext calls f.FetchContext if f is a ContextFetcher. Otherwise f.Fetch is run in the
+// background and ctx.Err() is returned if ctx is done before it completes (note that in this
+// case the underlying Fetch cannot be interrupted and will continue until it finishes).
+func FetchContext(ctx context.Context, f Fetcher, resource string) error {
+	if cf, ok := f.(ContextFetcher); ok {
+		return cf.FetchContext(ctx, resource)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Fetch(resource)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetReaderContext calls f.GetReaderContext if f is a ContextFetcher. Otherwise f.GetReader is
+// used, and the reader is wrapped so that reads fail once ctx is done.
+func GetReaderContext(ctx context.Context, f Fetcher) (io.ReadCloser, error) {
+	if cf, ok := f.(ContextFetcher); ok {
+		return cf.GetReaderContext(ctx)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		return nil, err
+	}
+	return contextReader(ctx, readCloser(r, r)), nil
+}
+
+///////////////////
+
+// multiCloser combines a Reader with one or more Closers (i.e. a decompressor and the
+// stream it reads from).
+type multiCloser struct {
+	io.Reader
+	closers []interface{}
+}
+
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cl, ok := c.(io.Closer); ok {
+			if cerr := cl.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// readCloser returns r as an io.ReadCloser which closes each of closers (if they implement
+// io.Closer) in order.
+func readCloser(r io.Reader, closers ...interface{}) io.ReadCloser {
+	return &multiCloser{Reader: r, closers: closers}
+}
+
+// ctxReader fails reads once its context is done, and closes the underlying stream to
+// interrupt any blocked reads.
+type ctxReader struct {
+	ctx  context.Context
+	rc   io.ReadCloser
+	stop chan struct{}
+	once sync.Once
+}
+
+// contextReader wraps rc so that it is canceled along with ctx.
+func contextReader(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if ctx.Done() == nil {
+		return rc
+	}
+	cr := &ctxReader{ctx: ctx, rc: rc, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			rc.Close()
+		case <-cr.stop:
+		}
+	}()
+	return cr
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.rc.Read(p)
+	if err != nil && cr.ctx.Err() != nil {
+		err = cr.ctx.Err()
+	}
+	return n, err
+}
+
+func (cr *ctxReader) Close() error {
+	cr.once.Do(func() { close(cr.stop) })
+	return cr.rc.Close()
+}
@@ -0,0 +1,347 @@
+package anydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Credentials holds the secrets used to access a remote host. Fetchers use whichever fields
+// apply to them, and ignore the rest.
+type Credentials struct {
+	// Username and Password are used for HTTP Basic Auth, FTP and SSH logins. For S3 they are
+	// the access key ID and secret access key.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Token is sent as an HTTP "Authorization: Bearer" header (or as the S3 session token).
+	Token string `json:"token,omitempty"`
+
+	// APIKey is sent in the HTTP header named by APIKeyHeader (default "X-API-Key").
+	APIKey       string `json:"api_key,omitempty"`
+	APIKeyHeader string `json:"api_key_header,omitempty"`
+
+	// SSHKeyFile is a private key file used for sftp:// and scp:// logins.
+	SSHKeyFile string `json:"ssh_key_file,omitempty"`
+
+	// OAuth2 obtains HTTP bearer tokens from an OAuth2 token endpoint, refreshing them as
+	// they expire. It takes precedence over Token.
+	OAuth2 *OAuth2Config `json:"oauth2,omitempty"`
+}
+
+// OAuth2Config describes how to obtain OAuth2 access tokens. If RefreshToken is set, the
+// refresh token flow is used, otherwise the client credentials flow is used.
+type OAuth2Config struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+}
+
+var (
+	oauthMu      sync.Mutex
+	oauthSources = make(map[string]oauth2.TokenSource)
+)
+
+// tokenSource returns a (shared) TokenSource for the config, so that tokens are reused across
+// requests until they expire.
+func (oc *OAuth2Config) tokenSource() oauth2.TokenSource {
+	key := strings.Join([]string{oc.TokenURL, oc.ClientID, oc.ClientSecret, oc.RefreshToken,
+		strings.Join(oc.Scopes, " ")}, "\x00")
+
+	oauthMu.Lock()
+	defer oauthMu.Unlock()
+	if ts, found := oauthSources[key]; found {
+		return ts
+	}
+
+	// token requests must outlive any single fetch, so they do not use its context
+	ctx := context.Background()
+	var ts oauth2.TokenSource
+	if oc.RefreshToken != "" {
+		cfg := &oauth2.Config{
+			ClientID:     oc.ClientID,
+			ClientSecret: oc.ClientSecret,
+			Endpoint:     oauth2.Endpoint{TokenURL: oc.TokenURL},
+			Scopes:       oc.Scopes,
+		}
+		ts = cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: oc.RefreshToken})
+	} else {
+		cfg := &clientcredentials.Config{
+			ClientID:     oc.ClientID,
+			ClientSecret: oc.ClientSecret,
+			TokenURL:     oc.TokenURL,
+			Scopes:       oc.Scopes,
+		}
+		ts = cfg.TokenSource(ctx)
+	}
+	oauthSources[key] = ts
+	return ts
+}
+
+// applyHTTP adds the credentials to an HTTP request.
+func (c *Credentials) applyHTTP(req *http.Request) error {
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.OAuth2 != nil {
+		tok, err := c.OAuth2.tokenSource().Token()
+		if err != nil {
+			return fmt.Errorf("unable to get oauth2 token from %s: %s", c.OAuth2.TokenURL, err.Error())
+		}
+		tok.SetAuthHeader(req)
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.APIKey != "" {
+		hdr := c.APIKeyHeader
+		if hdr == "" {
+			hdr = "X-API-Key"
+		}
+		req.Header.Set(hdr, c.APIKey)
+	}
+	return nil
+}
+
+// CredentialProvider supplies Credentials for remote hosts, so that secrets can be kept out of
+// resource strings. Credentials embedded in a URL always take precedence.
+type CredentialProvider interface {
+	// Credentials returns the credentials for host (which may include a port) when accessed
+	// using scheme (e.g. "https" or "sftp"), or nil if there are none.
+	Credentials(scheme, host string) (*Credentials, error)
+}
+
+var (
+	credMu       sync.Mutex
+	credProvider CredentialProvider
+)
+
+// SetCredentialProvider sets the CredentialProvider consulted by the remote fetchers. Passing
+// nil (the default) disables lookups. Use ChainCredentials to combine several providers.
+func SetCredentialProvider(p CredentialProvider) {
+	credMu.Lock()
+	credProvider = p
+	credMu.Unlock()
+}
+
+// lookupCredentials returns the credentials for host from the current CredentialProvider. Errors
+// are logged, since fetching may still succeed without credentials.
+func lookupCredentials(scheme, host string) *Credentials {
+	credMu.Lock()
+	p := credProvider
+	credMu.Unlock()
+	if p == nil {
+		return nil
+	}
+	c, err := p.Credentials(scheme, host)
+	if err != nil {
+		Logf("unable to load credentials for %s: %s\n", host, err.Error())
+		return nil
+	}
+	return c
+}
+
+///////////////////
+
+type credentialChain []CredentialProvider
+
+// ChainCredentials returns a CredentialProvider which returns the first credentials found by
+// any of providers, in order.
+func ChainCredentials(providers ...CredentialProvider) CredentialProvider {
+	return credentialChain(providers)
+}
+
+func (cc credentialChain) Credentials(scheme, host string) (*Credentials, error) {
+	for _, p := range cc {
+		c, err := p.Credentials(scheme, host)
+		if err != nil || c != nil {
+			return c, err
+		}
+	}
+	return nil, nil
+}
+
+///////////////////
+
+type envCredentials struct {
+	prefix string
+}
+
+// EnvCredentials returns a CredentialProvider which reads environment variables named
+// <prefix>_<HOST>_<FIELD>, where HOST is the upper-cased host name with all non-alphanumeric
+// characters replaced by "_", and FIELD is one of USERNAME, PASSWORD, TOKEN, API_KEY,
+// API_KEY_HEADER or SSH_KEY_FILE. OAuth2 is configured with the fields OAUTH2_TOKEN_URL,
+// OAUTH2_CLIENT_ID, OAUTH2_CLIENT_SECRET, OAUTH2_SCOPES (space separated) and
+// OAUTH2_REFRESH_TOKEN. For example, with the prefix "ANYDATA":
+//
+//    ANYDATA_DATA_EXAMPLE_COM_TOKEN=abc123
+//
+func EnvCredentials(prefix string) CredentialProvider {
+	return envCredentials{prefix: prefix}
+}
+
+func (e envCredentials) Credentials(scheme, host string) (*Credentials, error) {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(host))
+	pfx := name + "_"
+	if e.prefix != "" {
+		pfx = e.prefix + "_" + pfx
+	}
+
+	c := &Credentials{
+		Username:     os.Getenv(pfx + "USERNAME"),
+		Password:     os.Getenv(pfx + "PASSWORD"),
+		Token:        os.Getenv(pfx + "TOKEN"),
+		APIKey:       os.Getenv(pfx + "API_KEY"),
+		APIKeyHeader: os.Getenv(pfx + "API_KEY_HEADER"),
+		SSHKeyFile:   os.Getenv(pfx + "SSH_KEY_FILE"),
+	}
+	if tokenURL := os.Getenv(pfx + "OAUTH2_TOKEN_URL"); tokenURL != "" {
+		c.OAuth2 = &OAuth2Config{
+			TokenURL:     tokenURL,
+			ClientID:     os.Getenv(pfx + "OAUTH2_CLIENT_ID"),
+			ClientSecret: os.Getenv(pfx + "OAUTH2_CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(pfx + "OAUTH2_SCOPES")),
+			RefreshToken: os.Getenv(pfx + "OAUTH2_REFRESH_TOKEN"),
+		}
+	}
+	if *c == (Credentials{}) {
+		return nil, nil
+	}
+	return c, nil
+}
+
+///////////////////
+
+type netrcCredentials struct {
+	path string
+}
+
+// NetrcCredentials returns a CredentialProvider which reads login names and passwords from a
+// .netrc file, as used by ftp and curl. If path is empty, $NETRC or ~/.netrc is used.
+func NetrcCredentials(path string) CredentialProvider {
+	return netrcCredentials{path: path}
+}
+
+func (nc netrcCredentials) Credentials(scheme, host string) (*Credentials, error) {
+	path := nc.path
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+
+	var found, def *Credentials
+	var cur *Credentials
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			cur = nil
+			if i+1 < len(fields) {
+				i++
+				if found == nil && (fields[i] == host || fields[i] == hostname) {
+					found = &Credentials{}
+					cur = found
+				}
+			}
+		case "default":
+			cur = nil
+			if def == nil {
+				def = &Credentials{}
+				cur = def
+			}
+		case "login", "password", "account":
+			if i+1 < len(fields) {
+				i++
+				if cur != nil && fields[i-1] == "login" {
+					cur.Username = fields[i]
+				} else if cur != nil && fields[i-1] == "password" {
+					cur.Password = fields[i]
+				}
+			}
+		case "macdef":
+			// macros are not supported, and end at a blank line which Fields can't see
+			cur = nil
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	return def, nil
+}
+
+///////////////////
+
+type fileCredentials struct {
+	path string
+
+	once  sync.Once
+	hosts map[string]*Credentials
+	err   error
+}
+
+// FileCredentials returns a CredentialProvider which reads a JSON file mapping host names (or
+// "scheme://host") to Credentials, for example:
+//
+//    {
+//      "data.example.com":     {"token": "abc123"},
+//      "sftp://drop.example.org": {"username": "me", "ssh_key_file": "/home/me/.ssh/drop_key"},
+//      "api.example.net":      {"api_key": "xyz", "api_key_header": "X-Auth-Key"},
+//      "rest.example.io":      {"oauth2": {"token_url": "https://auth.example.io/token",
+//                                          "client_id": "me", "client_secret": "shh"}}
+//    }
+//
+// The file is read once, the first time credentials are requested.
+func FileCredentials(path string) CredentialProvider {
+	return &fileCredentials{path: path}
+}
+
+func (fc *fileCredentials) Credentials(scheme, host string) (*Credentials, error) {
+	fc.once.Do(func() {
+		var data []byte
+		data, fc.err = ioutil.ReadFile(fc.path)
+		if fc.err == nil {
+			fc.err = json.Unmarshal(data, &fc.hosts)
+		}
+	})
+	if fc.err != nil {
+		return nil, fc.err
+	}
+
+	if c, found := fc.hosts[scheme+"://"+host]; found {
+		return c, nil
+	}
+	return fc.hosts[host], nil
+}
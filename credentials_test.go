@@ -0,0 +1,164 @@
+package anydata_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	netrc := filepath.Join(dir, "netrc")
+	ioutil.WriteFile(netrc, []byte("machine ftp.example.com login alice password secret\n"+
+		"default login anonymous password guest\n"), 0600)
+	credFile := filepath.Join(dir, "creds.json")
+	ioutil.WriteFile(credFile, []byte(`{"api.example.com": {"token": "abc123"}}`), 0600)
+	os.Setenv("ADTEST_DATA_EXAMPLE_COM_8080_API_KEY", "xyz")
+	defer os.Unsetenv("ADTEST_DATA_EXAMPLE_COM_8080_API_KEY")
+
+	p := anydata.ChainCredentials(
+		anydata.EnvCredentials("ADTEST"),
+		anydata.FileCredentials(credFile),
+		anydata.NetrcCredentials(netrc),
+	)
+
+	tests := []struct {
+		host string
+		want anydata.Credentials
+	}{
+		{"data.example.com:8080", anydata.Credentials{APIKey: "xyz"}},
+		{"api.example.com", anydata.Credentials{Token: "abc123"}},
+		{"ftp.example.com", anydata.Credentials{Username: "alice", Password: "secret"}},
+		{"other.example.com", anydata.Credentials{Username: "anonymous", Password: "guest"}},
+	}
+	for _, tc := range tests {
+		c, err := p.Credentials("https", tc.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c == nil || *c != tc.want {
+			t.Errorf("credentials for %s = %+v, expected %+v", tc.host, c, tc.want)
+		}
+	}
+}
+
+func TestHTTPCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc123" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("secret data\n"))
+	}))
+	defer srv.Close()
+
+	credFile := filepath.Join(dir, "creds.json")
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ioutil.WriteFile(credFile, []byte(`{"`+host+`": {"token": "abc123"}}`), 0600)
+	anydata.SetCredentialProvider(anydata.FileCredentials(credFile))
+	defer anydata.SetCredentialProvider(nil)
+
+	resource := srv.URL + "/data.txt"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "secret data\n" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+}
+
+func TestOAuth2Credentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var tokenRequests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		if r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "tok-1", "token_type": "bearer", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-1" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("protected\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	anydata.SetCredentialProvider(credFunc(func(scheme, h string) (*anydata.Credentials, error) {
+		if h != host {
+			return nil, nil
+		}
+		return &anydata.Credentials{OAuth2: &anydata.OAuth2Config{
+			TokenURL: srv.URL + "/token", ClientID: "me", ClientSecret: "shh"}}, nil
+	}))
+	defer anydata.SetCredentialProvider(nil)
+
+	for _, name := range []string{"/a.txt", "/b.txt"} {
+		resource := srv.URL + name
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if string(data) != "protected\n" {
+			t.Fatalf("unexpected contents %q", data)
+		}
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Fatalf("expected token to be reused, got %d token requests", n)
+	}
+}
+
+type credFunc func(scheme, host string) (*anydata.Credentials, error)
+
+func (f credFunc) Credentials(scheme, host string) (*anydata.Credentials, error) {
+	return f(scheme, host)
+}
@@ -3,9 +3,14 @@ package anydata
 import (
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 // A Bzip2 decompression wrapper for non-archives.
@@ -39,17 +44,30 @@ func (n *bzWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
 	return n, nil
 }
 
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *bzWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
 func (n *bzWrapper) Fetch(resource string) error {
 	return n.wrapped.Fetch(resource)
 }
 
+func (n *bzWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
 func (n *bzWrapper) GetReader() (io.Reader, error) {
-	r, err := n.wrapped.GetReader()
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *bzWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
 	if err != nil {
 		return nil, err
 	}
 
-	return bzip2.NewReader(r), nil
+	return readCloser(bzip2.NewReader(r), r), nil
 }
 
 ///////////////////
@@ -81,15 +99,164 @@ func (n *gzWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
 	return n, nil
 }
 
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *gzWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
 func (n *gzWrapper) Fetch(resource string) error {
 	return n.wrapped.Fetch(resource)
 }
 
+func (n *gzWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
 func (n *gzWrapper) GetReader() (io.Reader, error) {
-	r, err := n.wrapped.GetReader()
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *gzWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return readCloser(gr, gr, r), nil
+}
+
+///////////////////
+
+// An XZ/LZMA decompression wrapper for non-archives.
+type xzWrapper struct {
+	wrapped Fetcher
+	lzma    bool
+}
+
+func (n *xzWrapper) String() string {
+	if n.lzma {
+		return fmt.Sprintf("lzma'd %s", n.wrapped)
+	}
+	return fmt.Sprintf("xz'd %s", n.wrapped)
+}
+
+func (n *xzWrapper) Detect(resource string) bool {
+	return false
+}
+
+// DetectWrap returns true if parthname is empty and pathname ends in .xz or .lzma
+func (n *xzWrapper) DetectWrap(pathname, partname string) bool {
+	if partname != "" {
+		return false
+	}
+
+	n.lzma = strings.HasSuffix(pathname, ".lzma")
+	return n.lzma || strings.HasSuffix(pathname, ".xz")
+}
+
+func (n *xzWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
+	n.wrapped = f
+	return n, nil
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *xzWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
+func (n *xzWrapper) Fetch(resource string) error {
+	return n.wrapped.Fetch(resource)
+}
+
+func (n *xzWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
+func (n *xzWrapper) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *xzWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	var xr io.Reader
+	if n.lzma {
+		xr, err = lzma.NewReader(r)
+	} else {
+		xr, err = xz.NewReader(r)
+	}
 	if err != nil {
+		r.Close()
 		return nil, err
 	}
+	return readCloser(xr, r), nil
+}
+
+///////////////////
+
+// A Zstandard decompression wrapper for non-archives.
+type zstdWrapper struct {
+	wrapped Fetcher
+}
+
+func (n *zstdWrapper) String() string {
+	return fmt.Sprintf("zstd'd %s", n.wrapped)
+}
 
-	return gzip.NewReader(r)
+func (n *zstdWrapper) Detect(resource string) bool {
+	return false
+}
+
+// DetectWrap returns true if parthname is empty and pathname ends in .zst or .zstd
+func (n *zstdWrapper) DetectWrap(pathname, partname string) bool {
+	if partname != "" {
+		return false
+	}
+
+	return strings.HasSuffix(pathname, ".zst") || strings.HasSuffix(pathname, ".zstd")
+}
+
+func (n *zstdWrapper) Wrap(f Fetcher, partname string) (Fetcher, error) {
+	n.wrapped = f
+	return n, nil
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *zstdWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
+func (n *zstdWrapper) Fetch(resource string) error {
+	return n.wrapped.Fetch(resource)
+}
+
+func (n *zstdWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
+func (n *zstdWrapper) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *zstdWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	r, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	zrc := zr.IOReadCloser()
+	return readCloser(zrc, zrc, r), nil
 }
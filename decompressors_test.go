@@ -0,0 +1,58 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+	"github.com/ulikunitz/xz"
+)
+
+func TestXZ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	xzName := filepath.Join(dir, "data.txt.xz")
+	f, _ := os.Create(xzName)
+	xw, _ := xz.NewWriter(f)
+	xw.Write([]byte("xz contents\n"))
+	xw.Close()
+	f.Close()
+
+	txzName := filepath.Join(dir, "data.tar.xz")
+	f, _ = os.Create(txzName)
+	xw, _ = xz.NewWriter(f)
+	tw := tar.NewWriter(xw)
+	tw.WriteHeader(&tar.Header{Name: "names.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("hello\n"))
+	tw.Close()
+	xw.Close()
+	f.Close()
+
+	for resource, want := range map[string]string{
+		xzName:                 "xz contents\n",
+		txzName + "#names.txt": "hello\n",
+	} {
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fr.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v)", resource, data, err)
+		}
+	}
+}
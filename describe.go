@@ -0,0 +1,68 @@
+package anydata
+
+import (
+	"fmt"
+	"time"
+)
+
+// Describe returns a description of how resource will be resolved using the DefaultRegistry,
+// including every Wrapper, the Fetcher, and the age of any cached copy. It is intended for
+// logging, e.g.
+//
+//    names.dmp from gzip'd tarball via FTP Download, cached 2h ago
+//
+// The resource is not fetched, so only the Fetcher's static description is available.
+func Describe(resource string) (string, error) {
+	return DefaultRegistry.Describe(resource)
+}
+
+// Describe is equivalent to the package-level Describe, but resolves resource using r.
+func (r *Registry) Describe(resource string) (string, error) {
+	f, err := r.GetFetcher(resource)
+	if err != nil {
+		return "", err
+	}
+	desc := describeFetcher(f)
+	if fetched, ok := r.Cache().fetchTime(resource); ok {
+		desc += ", cached " + describeAge(time.Since(fetched))
+	}
+	return desc, nil
+}
+
+// Chain returns f followed by each Fetcher that it wraps, outermost first, so the last entry is
+// the Fetcher which accesses the data source. Wrappers and other composite Fetchers expose the
+// Fetcher they read from with an Unwrap method. A mirror Fetcher only reveals the mirror it
+// uses once it has been fetched.
+func Chain(f Fetcher) []Fetcher {
+	var chain []Fetcher
+	for f != nil {
+		chain = append(chain, f)
+		u, ok := f.(interface{ Unwrap() Fetcher })
+		if !ok {
+			break
+		}
+		f = u.Unwrap()
+	}
+	return chain
+}
+
+// describeFetcher returns the String of f, or its type if it does not implement fmt.Stringer.
+func describeFetcher(f Fetcher) string {
+	if s, ok := f.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+// describeAge formats the age of a cached file.
+func describeAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	}
+	return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+}
@@ -0,0 +1,25 @@
+package anydata_test
+
+import (
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestDescribe(t *testing.T) {
+	desc, err := anydata.Describe("/data/taxdump.tar.gz#names.dmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc != "names.dmp from gzip'd tarball via Local File" {
+		t.Errorf("unexpected description %q", desc)
+	}
+
+	f, err := anydata.GetFetcher("/data/genes.tsv.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain := anydata.Chain(f); len(chain) != 2 {
+		t.Errorf("expected a wrapper and a fetcher, got %v", chain)
+	}
+}
@@ -0,0 +1,207 @@
+package anydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// A Dropbox fetcher for shared links and (with an access token) API paths:
+//
+//    https://www.dropbox.com/scl/fi/abc123/genes.tsv.gz?rlkey=xyz&dl=0
+//    dropbox:///Shared/project/genes.tsv.gz
+//
+// Shared links are downloaded directly (by setting "dl=1"), unless credentials with a Token or
+// OAuth2 config are available from the CredentialProvider for the scheme "dropbox" and host
+// "dropbox.com", in which case the Dropbox API is used. This allows links which are only shared
+// with collaborators to be read. dropbox:// paths always require credentials.
+//
+// Downloads are stored in the cache in the same way as the HTTP fetcher.
+type dropboxFetcher struct {
+	httpFetcher
+}
+
+func isDropboxLink(resource string) bool {
+	return strings.HasPrefix(resource, "https://www.dropbox.com/") ||
+		strings.HasPrefix(resource, "https://dropbox.com/")
+}
+
+func (n *dropboxFetcher) String() string {
+	return "Dropbox Download"
+}
+
+func (n *dropboxFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "dropbox://") || isDropboxLink(resource)
+}
+
+// dropboxCredentials returns the credentials for the Dropbox API, or nil if there are none.
+func dropboxCredentials() *Credentials {
+	c := lookupCredentials("dropbox", "dropbox.com")
+	if c == nil || (c.Token == "" && c.OAuth2 == nil) {
+		return nil
+	}
+	return c
+}
+
+// dropboxAPIArg encodes arg for the Dropbox-API-Arg header, which must be ASCII.
+func dropboxAPIArg(arg interface{}) (string, error) {
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, r := range string(data) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+		} else if r > 0xffff {
+			r1, r2 := (r-0x10000)>>10+0xd800, (r-0x10000)&0x3ff+0xdc00
+			fmt.Fprintf(&sb, `\u%04x\u%04x`, r1, r2)
+		} else {
+			fmt.Fprintf(&sb, `\u%04x`, r)
+		}
+	}
+	return sb.String(), nil
+}
+
+// dropboxArg returns the API argument identifying resource (a path or shared link).
+func dropboxArg(resource string) (map[string]string, error) {
+	resource = strings.SplitN(resource, "#", 2)[0]
+	if isDropboxLink(resource) {
+		return map[string]string{"url": resource}, nil
+	}
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + strings.TrimPrefix(furl.Host+furl.Path, "/")
+	if p == "/" {
+		return nil, fmt.Errorf("'%s' does not name a Dropbox file", resource)
+	}
+	return map[string]string{"path": p}, nil
+}
+
+// setRequest prepares the fetcher to call a Dropbox API endpoint for resource. Content
+// endpoints pass arg in a header, while RPC endpoints send it as the request body.
+func (n *dropboxFetcher) setRequest(resource, endpoint string, arg interface{}, rpc bool) error {
+	c := dropboxCredentials()
+	if c == nil {
+		return fmt.Errorf("reading '%s' requires Dropbox credentials", resource)
+	}
+	argJSON, err := dropboxAPIArg(arg)
+	if err != nil {
+		return err
+	}
+	n.resource = resource
+	n.url = endpoint
+	n.prepare = func(req *http.Request) error {
+		if req.Method == "GET" {
+			req.Method = "POST"
+		}
+		if rpc {
+			req.Header.Set("Content-Type", "application/json")
+			req.Body = ioutil.NopCloser(strings.NewReader(argJSON))
+			req.ContentLength = int64(len(argJSON))
+		} else {
+			req.Header.Set("Dropbox-API-Arg", argJSON)
+		}
+		return c.applyHTTP(req)
+	}
+	return nil
+}
+
+// setDownloadURL prepares the fetcher to download resource.
+func (n *dropboxFetcher) setDownloadURL(resource string) error {
+	if isDropboxLink(resource) && dropboxCredentials() == nil {
+		furl, err := url.Parse(strings.SplitN(resource, "#", 2)[0])
+		if err != nil {
+			return err
+		}
+		q := furl.Query()
+		q.Set("dl", "1")
+		furl.RawQuery = q.Encode()
+		n.resource, n.url, n.prepare = resource, furl.String(), nil
+		return nil
+	}
+
+	arg, err := dropboxArg(resource)
+	if err != nil {
+		return err
+	}
+	endpoint := "https://content.dropboxapi.com/2/files/download"
+	if arg["url"] != "" {
+		endpoint = "https://content.dropboxapi.com/2/sharing/get_shared_link_file"
+	}
+	return n.setRequest(resource, endpoint, arg, false)
+}
+
+func (n *dropboxFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *dropboxFetcher) FetchContext(ctx context.Context, resource string) error {
+	if n.cache().cachedFilePath(ctx, resource) != "" {
+		return n.httpFetcher.FetchContext(ctx, resource)
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "dropbox")
+
+	if err := n.setDownloadURL(resource); err != nil {
+		return err
+	}
+	return n.httpFetcher.FetchContext(ctx, resource)
+}
+
+// Expand returns pattern as-is, since shared links can't be listed.
+func (n *dropboxFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	return []string{pattern}, nil
+}
+
+// Stat uses the Dropbox API metadata endpoints if credentials are available, otherwise it makes
+// a HEAD request for the shared link.
+func (n *dropboxFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if isDropboxLink(resource) && dropboxCredentials() == nil {
+		if err := n.setDownloadURL(resource); err != nil {
+			return ResourceInfo{}, err
+		}
+		return n.httpFetcher.Stat(ctx, resource)
+	}
+
+	arg, err := dropboxArg(resource)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	endpoint := "https://api.dropboxapi.com/2/files/get_metadata"
+	if arg["url"] != "" {
+		endpoint = "https://api.dropboxapi.com/2/sharing/get_shared_link_metadata"
+	}
+	if err = n.setRequest(resource, endpoint, arg, true); err != nil {
+		return ResourceInfo{}, err
+	}
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var meta struct {
+		Tag            string `json:".tag"`
+		Size           int64  `json:"size"`
+		ServerModified string `json:"server_modified"`
+		Rev            string `json:"rev"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return ResourceInfo{}, fmt.Errorf("dropbox stat of '%s' failed: %s", resource, err.Error())
+	}
+	if meta.Tag != "file" {
+		return ResourceInfo{}, fmt.Errorf("dropbox stat of '%s' failed: not a file", resource)
+	}
+	info := ResourceInfo{Size: meta.Size, ETag: meta.Rev}
+	info.ModTime, _ = time.Parse(time.RFC3339, meta.ServerModified)
+	return info, nil
+}
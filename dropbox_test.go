@@ -0,0 +1,109 @@
+package anydata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// tokenCredentials provides the same bearer token for every host.
+type tokenCredentials string
+
+func (tc tokenCredentials) Credentials(scheme, host string) (*Credentials, error) {
+	return &Credentials{Token: string(tc)}, nil
+}
+
+func TestDropboxAPIArg(t *testing.T) {
+	arg := map[string]string{"path": "/Données/🧬 genes.tsv"}
+	got, err := dropboxAPIArg(arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != `{"path":"/Donn\u00e9es/\ud83e\uddec genes.tsv"}` {
+		t.Errorf("unexpected header value: %s", got)
+	}
+	var back map[string]string
+	if err = json.Unmarshal([]byte(got), &back); err != nil || back["path"] != arg["path"] {
+		t.Errorf("header value does not decode to the argument: %v (%v)", back, err)
+	}
+}
+
+func TestDropboxArg(t *testing.T) {
+	for resource, want := range map[string]string{
+		"dropbox:///Shared/project/genes.tsv.gz#genes.tsv":              "path=/Shared/project/genes.tsv.gz",
+		"dropbox://Shared/genes.tsv":                                    "path=/Shared/genes.tsv",
+		"https://www.dropbox.com/scl/fi/abc/genes.tsv?rlkey=xyz&dl=0#x": "url=https://www.dropbox.com/scl/fi/abc/genes.tsv?rlkey=xyz&dl=0",
+		"dropbox:///": "",
+	} {
+		arg, err := dropboxArg(resource)
+		got := ""
+		for k, v := range arg {
+			got = k + "=" + v
+		}
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("dropboxArg(%s) = %v (%v), expected %s", resource, arg, err, want)
+		}
+	}
+}
+
+func TestDropboxDownloadURL(t *testing.T) {
+	n := &dropboxFetcher{}
+	if err := n.setDownloadURL("https://www.dropbox.com/scl/fi/abc/genes.tsv?rlkey=xyz&dl=0#genes.tsv"); err != nil {
+		t.Fatal(err)
+	}
+	if n.url != "https://www.dropbox.com/scl/fi/abc/genes.tsv?dl=1&rlkey=xyz" || n.prepare != nil {
+		t.Errorf("unexpected shared link download: %s", n.url)
+	}
+	if err := n.setDownloadURL("dropbox:///Shared/genes.tsv"); err == nil {
+		t.Error("expected an error for a dropbox path without credentials")
+	}
+
+	SetCredentialProvider(tokenCredentials("abc"))
+	defer SetCredentialProvider(nil)
+	if err := n.setDownloadURL("dropbox:///Shared/genes.tsv"); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", n.url, nil)
+	if err := n.prepare(req); err != nil {
+		t.Fatal(err)
+	}
+	if n.url != "https://content.dropboxapi.com/2/files/download" || req.Method != "POST" ||
+		req.Header.Get("Dropbox-API-Arg") != `{"path":"/Shared/genes.tsv"}` || req.Header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected API request: %s %s %v", req.Method, n.url, req.Header)
+	}
+}
+
+func TestBoxDownloadURL(t *testing.T) {
+	n := &boxFetcher{}
+	for _, link := range []string{"https://app.box.com/s/abc123def456", "https://myorg.app.box.com/s/abc123def456"} {
+		if !n.Detect(link) {
+			t.Errorf("expected '%s' to be detected", link)
+		}
+		if err := n.setDownloadURL(context.Background(), link); err != nil {
+			t.Fatal(err)
+		}
+		if n.url != "https://app.box.com/shared/static/abc123def456" {
+			t.Errorf("unexpected shared link download: %s", n.url)
+		}
+	}
+	if err := n.setDownloadURL(context.Background(), "box://123456789/genes.tsv"); err == nil {
+		t.Error("expected an error for a box file ID without credentials")
+	}
+
+	SetCredentialProvider(tokenCredentials("abc"))
+	defer SetCredentialProvider(nil)
+	if err := n.setDownloadURL(context.Background(), "box://123456789/genes.tsv"); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", n.url, nil)
+	if err := n.prepare(req); err != nil {
+		t.Fatal(err)
+	}
+	if n.url != "https://api.box.com/2.0/files/123456789/content" || req.Header.Get("Authorization") != "Bearer abc" {
+		t.Errorf("unexpected API request: %s %v", n.url, req.Header)
+	}
+	if err := n.setDownloadURL(context.Background(), "box:///genes.tsv"); err == nil {
+		t.Error("expected an error for a box resource without a file ID")
+	}
+}
@@ -0,0 +1,205 @@
+package anydata
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Expander is implemented by Fetchers which can list the resources matching a pattern, such as
+// the files in a remote directory.
+type Expander interface {
+	Fetcher
+
+	// Expand returns the resources matching pattern, in sorted order.
+	Expand(ctx context.Context, pattern string) ([]string, error)
+}
+
+// hasGlob returns true if s contains any glob metacharacters (see path.Match).
+func hasGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// ExpandResources returns the resource strings matching pattern, using the DefaultRegistry. The
+// last path element(s) of pattern may contain glob wildcards (see path.Match), and the element
+// "**" matches any number of nested directories. For example, to read every GTF file from an
+// Apache or nginx "Index of" page, or every gzipped TSV file below a local directory:
+//
+//    https://ftp.ensembl.org/pub/release-110/gtf/*/*.gtf.gz
+//    /data/2024/**/*.tsv.gz
+//
+// A local directory path expands to all of the files in it. A fragment is kept on each
+// expanded resource, so the same file can be extracted from many archives. Patterns without
+// wildcards, and resources for Fetchers which do not implement Expander, are returned as-is.
+func ExpandResources(pattern string) ([]string, error) {
+	return DefaultRegistry.ExpandResourcesContext(context.Background(), pattern)
+}
+
+// ExpandResourcesContext is equivalent to ExpandResources, but aborts when ctx is done.
+func ExpandResourcesContext(ctx context.Context, pattern string) ([]string, error) {
+	return DefaultRegistry.ExpandResourcesContext(ctx, pattern)
+}
+
+// ExpandResources is equivalent to the package-level ExpandResources, but resolves pattern
+// using r.
+func (r *Registry) ExpandResources(pattern string) ([]string, error) {
+	return r.ExpandResourcesContext(context.Background(), pattern)
+}
+
+// ExpandResourcesContext is equivalent to the package-level ExpandResourcesContext, but
+// resolves pattern using r.
+func (r *Registry) ExpandResourcesContext(ctx context.Context, pattern string) ([]string, error) {
+	f, err := r.baseFetcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if ex, ok := f.(Expander); ok {
+		return ex.Expand(ctx, pattern)
+	}
+	return []string{pattern}, nil
+}
+
+// ExpandFetchers expands pattern as ExpandResources does, using the DefaultRegistry, and
+// returns a Fetcher for each of the matched resources. For example, a directory of local
+// files can be processed the same way as a list of remote files:
+//
+//    resources, fetchers, err := anydata.ExpandFetchers("/data/2024/*.tsv.gz")
+//    for i, f := range fetchers {
+//        err = f.Fetch(resources[i])
+//        ...
+//    }
+func ExpandFetchers(pattern string) ([]string, []Fetcher, error) {
+	return DefaultRegistry.ExpandFetchersContext(context.Background(), pattern)
+}
+
+// ExpandFetchersContext is equivalent to ExpandFetchers, but aborts when ctx is done.
+func ExpandFetchersContext(ctx context.Context, pattern string) ([]string, []Fetcher, error) {
+	return DefaultRegistry.ExpandFetchersContext(ctx, pattern)
+}
+
+// ExpandFetchers is equivalent to the package-level ExpandFetchers, but resolves pattern
+// using r.
+func (r *Registry) ExpandFetchers(pattern string) ([]string, []Fetcher, error) {
+	return r.ExpandFetchersContext(context.Background(), pattern)
+}
+
+// ExpandFetchersContext is equivalent to the package-level ExpandFetchersContext, but
+// resolves pattern using r.
+func (r *Registry) ExpandFetchersContext(ctx context.Context, pattern string) ([]string, []Fetcher, error) {
+	resources, err := r.ExpandResourcesContext(ctx, pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetchers := make([]Fetcher, len(resources))
+	for i, resource := range resources {
+		if fetchers[i], err = r.GetFetcher(resource); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resources, fetchers, nil
+}
+
+///////////////////
+
+// listFunc returns the files and subdirectories of a remote directory.
+type listFunc func(dir *url.URL) (files, dirs []*url.URL, err error)
+
+// expandURL matches a URL pattern against the directory tree read by list. A pattern ending in
+// "/" matches every file in that directory.
+func expandURL(pattern string, list listFunc) ([]string, error) {
+	fragment := ""
+	if i := strings.Index(pattern, "#"); i != -1 {
+		pattern, fragment = pattern[:i], pattern[i:]
+	}
+	purl, err := url.Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !hasGlob(purl.Path) && !strings.HasSuffix(purl.Path, "/") {
+		return []string{pattern + fragment}, nil
+	}
+
+	// split into the directory to start listing from, and the path elements to match
+	var segs []string
+	dir := "/"
+	parts := strings.Split(strings.TrimPrefix(purl.Path, "/"), "/")
+	for i, p := range parts {
+		if hasGlob(p) || i == len(parts)-1 {
+			segs = parts[i:]
+			break
+		}
+		dir += p + "/"
+	}
+	if len(segs) == 1 && segs[0] == "" {
+		segs[0] = "*"
+	}
+
+	base := *purl
+	base.Path, base.RawPath, base.RawQuery = dir, "", ""
+	w := &dirWalker{list: list, visited: make(map[string]bool)}
+	if err = w.walk(&base, segs); err != nil {
+		return nil, err
+	}
+	sort.Strings(w.matches)
+	for i := range w.matches {
+		w.matches[i] += fragment
+	}
+	return w.matches, nil
+}
+
+// dirWalker walks a remote directory tree, collecting files which match a pattern.
+type dirWalker struct {
+	list    listFunc
+	visited map[string]bool
+	matches []string
+}
+
+// walk adds the files below dir which match the path elements in segs.
+func (w *dirWalker) walk(dir *url.URL, segs []string) error {
+	key := dir.String() + " " + strings.Join(segs, "/")
+	if w.visited[key] {
+		return nil
+	}
+	w.visited[key] = true
+
+	files, dirs, err := w.list(dir)
+	if err != nil {
+		return err
+	}
+
+	if segs[0] == "**" {
+		if len(segs) == 1 {
+			// everything below this directory
+			for _, f := range files {
+				w.matches = append(w.matches, f.String())
+			}
+		} else if err = w.walk(dir, segs[1:]); err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			if err = w.walk(d, segs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(segs) == 1 {
+		for _, f := range files {
+			if ok, _ := path.Match(segs[0], path.Base(f.Path)); ok {
+				w.matches = append(w.matches, f.String())
+			}
+		}
+		return nil
+	}
+	for _, d := range dirs {
+		if ok, _ := path.Match(segs[0], path.Base(d.Path)); ok {
+			if err = w.walk(d, segs[1:]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
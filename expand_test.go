@@ -0,0 +1,62 @@
+package anydata_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestExpandLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.tsv", "b.tsv", "c.txt", "sub/d.tsv", "sub/deep/e.tsv"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0777)
+		ioutil.WriteFile(p, []byte(name+"\n"), 0666)
+	}
+
+	cases := map[string][]string{
+		filepath.Join(dir, "*.tsv"):       {"a.tsv", "b.tsv"},
+		filepath.Join(dir, "**", "*.tsv"): {"a.tsv", "b.tsv", "sub/d.tsv", "sub/deep/e.tsv"},
+		filepath.Join(dir, "sub"):         {"sub/d.tsv"},
+	}
+	for pattern, want := range cases {
+		got, err := anydata.ExpandResources(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v", pattern, got)
+		}
+		for i := range want {
+			if got[i] != filepath.Join(dir, filepath.FromSlash(want[i])) {
+				t.Errorf("%s: got %s, want %s", pattern, got[i], want[i])
+			}
+		}
+	}
+
+	resources, fetchers, err := anydata.ExpandFetchers(filepath.Join(dir, "sub", "**"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetchers) != 2 {
+		t.Fatalf("got %v", resources)
+	}
+	for i, f := range fetchers {
+		if err = f.Fetch(resources[i]); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := f.GetReader()
+		data, _ := ioutil.ReadAll(r)
+		if string(data) != filepath.ToSlash(resources[i][len(dir)+1:])+"\n" {
+			t.Errorf("%s: read %q", resources[i], data)
+		}
+	}
+}
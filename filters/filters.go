@@ -1,46 +1,75 @@
 // Package filters provides a data-record filtering mechanism and basic implementations
 // for typical use cases. It is intended as a complement to the formats sister package,
-// useful for automating unique record extraction from a data file.
+// useful for automating unique record extraction from a data file. (Duplicate records produced
+// by filtering can be removed with the UniqueRecords stage of the pipeline package.)
 //
 // A loose naming convention of adding "s" on the end implies that the filter is applied
 // independently for each field of the record. Thus the missing "s" on "require" means that
 // all supplied fields are required simultaneously. The currently supported filters are:
 //
-//    "require"      - drops any record that does NOT match ALL of it's field entries. An empty
-//                     string ("") require field is skipped, so if you want to require records
-//                     with blank fields, use the special string FilterBlankEntry
+//    "require"       - drops any record that does NOT match ALL of it's field entries. An empty
+//                      string ("") require field is skipped, so if you want to require records
+//                      with blank fields, use the special string FilterBlankEntry
 //
-//    "excludes"     - drops any record matching at least one of it's field entries. An empty
-//                     string ("") exclude field is skipped, so if you want to exclude records
-//                     with blank fields, use the special string FilterBlankEntry
+//    "excludes"      - drops any record matching at least one of it's field entries. An empty
+//                      string ("") exclude field is skipped, so if you want to exclude records
+//                      with blank fields, use the special string FilterBlankEntry
 //
-//                     To exclude multiple keywords from one field, you will either need to
-//                     use multiple excludes or write a new Filter.
+//                      To exclude multiple keywords from one field, use "exclude_regex".
 //
-//    "null_fields"  - remaps fields from a placeholder string into an empty string. For
-//                     example, many data sources use a placeholder of "-" or "n/a" to
-//                     indicate a missing element. This filter may also be used to suppress
-//                     particular values from records.
+//    "require_regex" - drops any record where ANY of the given fields does NOT match its
+//                      regular expression (in the syntax of the regexp package). Use anchors
+//                      to match the whole value, e.g. "^ENSG[0-9]+$". A missing field is
+//                      matched as an empty string.
 //
-//    "split_fields" - splits fields on a delimiter, creating new records for each split. For
-//                     example, a single record with 3="A,B,C" and a delimiter of "," emits
-//                     three records with 3="A", 3="B" and 3="C".
-//                     Note that the delimiter "" is not allowed.
+//    "exclude_regex" - drops any record where at least one of the given fields matches its
+//                      regular expression, e.g. "(?i)^(test|dummy)" to drop several keywords.
 //
-//    "date_formats" - parses the field value using an strptime format string, and reformats
-//                     it into a standard representation, of "2006-01-02 15:04:05" in UTC.
-//                     Note that not all strptime formats are available, see the package
-//                     at github.com/pbnjay/strptime for a listing.
+//    "require_contains", "require_prefix", "require_suffix" and "require_one_of"
+//    "exclude_contains", "exclude_prefix", "exclude_suffix" and "exclude_one_of"
+//                    - like "require_regex" and "exclude_regex", but the fields are compared
+//                      to strings: a field matches if it contains, begins with, ends with or
+//                      equals one of the alternatives in its pattern, which are separated by
+//                      "|", e.g. "GO:|KEGG:" or "yes|y|true". A pattern beginning with "(?i)"
+//                      is case-insensitive, and FilterBlankEntry in a "one_of" pattern
+//                      matches a blank field. Empty alternatives (e.g. "a||b") are an error.
+//
+//    "null_fields"   - remaps fields from a placeholder string into an empty string. For
+//                      example, many data sources use a placeholder of "-" or "n/a" to
+//                      indicate a missing element. This filter may also be used to suppress
+//                      particular values from records.
+//
+//    "split_fields"  - splits fields on a delimiter, creating new records for each split. For
+//                      example, a single record with 3="A,B,C" and a delimiter of "," emits
+//                      three records with 3="A", 3="B" and 3="C".
+//                      Note that the delimiter "" is not allowed.
+//
+//    "keep_fields"   - removes every field except those with non-empty entries, so that
+//                      records are reduced to the fields which are needed. Records without
+//                      any of the fields are dropped.
+//
+//    "drop_fields"   - removes the fields with non-empty entries.
+//
+//    "date_formats"  - parses the field value using an strptime format string, and reformats
+//                      it into a standard representation, of "2006-01-02 15:04:05" in UTC.
+//                      Note that not all strptime formats are available, see the package
+//                      at github.com/pbnjay/strptime for a listing.
 //
 // To support new filters, simply implement the Filter interface and call RegisterFilter before
-// using GetFilter or FilterSet.Append.
+// using GetFilter or FilterSet.Append. Filters which modify a single record may also implement
+// RecordFilter, so that FilterSet.ApplyRecord can filter a formats.Record without converting
+// it to a map.
 //
 package filters
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/pbnjay/anydata/formats"
+	"github.com/pbnjay/anydata/metrics"
 	"github.com/pbnjay/strptime"
 )
 
@@ -50,10 +79,19 @@ import (
 type Filter interface {
 	// Setup defines the part strings used to apply this filter to new records.
 	Setup(parts map[interface{}]string) error
-	// Apply takes an input record and applies the Filter to create 0 or more records.
+	// Apply takes an input record and applies the Filter to create 0 or more records. The
+	// input fields may be modified in place and returned.
 	Apply(fields map[interface{}]string) []map[interface{}]string
 }
 
+// RecordFilter is implemented by Filters which can be applied to a formats.Record in place,
+// avoiding the conversion to and from a map. Only 1-to-1 filters can implement it.
+type RecordFilter interface {
+	Filter
+	// ApplyRecord applies the Filter to rec, and returns false if the record is dropped.
+	ApplyRecord(rec *formats.Record) bool
+}
+
 // FilterGetter returns an instance of a Filter
 type FilterGetter func() Filter
 
@@ -62,8 +100,6 @@ var (
 	// ExcludeFilter. If for some reason your input contains this text and you need a
 	// different representation, this may be overridden in user code.
 	FilterBlankEntry = "<BLANK>"
-
-	filters = make(map[string]FilterGetter)
 )
 
 ///
@@ -93,6 +129,19 @@ func (f *nullFilter) Apply(fields map[interface{}]string) []map[interface{}]stri
 	return []map[interface{}]string{fields}
 }
 
+func (f *nullFilter) ApplyRecord(rec *formats.Record) bool {
+	nnull := rec.Len()
+	for k, v := range f.parts {
+		if v != "" {
+			if v2, found := rec.Get(k); found && v2 == v {
+				rec.Set(k, "")
+				nnull--
+			}
+		}
+	}
+	return nnull != 0
+}
+
 ///
 
 type splitFieldFilter struct {
@@ -199,6 +248,22 @@ func (f *requireFilter) Apply(fields map[interface{}]string) []map[interface{}]s
 	return []map[interface{}]string{fields}
 }
 
+func (f *requireFilter) ApplyRecord(rec *formats.Record) bool {
+	for k, v := range f.parts {
+		if v == "" {
+			continue
+		}
+
+		if v == FilterBlankEntry {
+			v = ""
+		}
+		if v2, _ := rec.Get(k); v2 != v {
+			return false
+		}
+	}
+	return true
+}
+
 ///////
 
 type excludeFilter struct {
@@ -226,6 +291,164 @@ func (f *excludeFilter) Apply(fields map[interface{}]string) []map[interface{}]s
 	return []map[interface{}]string{fields}
 }
 
+func (f *excludeFilter) ApplyRecord(rec *formats.Record) bool {
+	for k, v := range f.parts {
+		if v == "" {
+			continue
+		}
+
+		if v == FilterBlankEntry {
+			v = ""
+		}
+		if v2, _ := rec.Get(k); v2 == v {
+			return false
+		}
+	}
+	return true
+}
+
+///////
+
+// matchFilter keeps (or with exclude, drops) the records whose fields all (or with exclude,
+// any) match their patterns. The mode is "regex", or one of the string comparisons
+// "contains", "prefix", "suffix" and "one_of", whose patterns are alternatives separated by
+// "|", and are case-insensitive if they begin with "(?i)".
+type matchFilter struct {
+	mode    string
+	exclude bool
+	parts   map[interface{}]func(string) bool
+}
+
+func (f *matchFilter) Setup(parts map[interface{}]string) error {
+	f.parts = make(map[interface{}]func(string) bool, len(parts))
+	for k, v := range parts {
+		if v == "" {
+			continue
+		}
+		if f.mode == "regex" {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return fmt.Errorf("error in regex filter '%s' - %s", v, err.Error())
+			}
+			f.parts[k] = re.MatchString
+			continue
+		}
+		match, err := f.matcher(v)
+		if err != nil {
+			return err
+		}
+		f.parts[k] = match
+	}
+	return nil
+}
+
+// matcher returns a function which compares a value to the alternatives of a pattern.
+func (f *matchFilter) matcher(pattern string) (func(string) bool, error) {
+	fold := strings.HasPrefix(pattern, "(?i)")
+	alts := strings.Split(strings.TrimPrefix(pattern, "(?i)"), "|")
+	for i, a := range alts {
+		switch {
+		case a == FilterBlankEntry && f.mode == "one_of":
+			alts[i] = ""
+		case a == "":
+			// an empty alternative would match every value
+			return nil, fmt.Errorf("error in %s filter '%s' - empty alternative", f.mode, pattern)
+		case fold:
+			alts[i] = strings.ToLower(a)
+		}
+	}
+
+	var cmp func(v, a string) bool
+	switch f.mode {
+	case "contains":
+		cmp = strings.Contains
+	case "prefix":
+		cmp = strings.HasPrefix
+	case "suffix":
+		cmp = strings.HasSuffix
+	default:
+		cmp = func(v, a string) bool { return v == a }
+	}
+	return func(v string) bool {
+		if fold {
+			v = strings.ToLower(v)
+		}
+		for _, a := range alts {
+			if cmp(v, a) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// keep returns true if the record should be kept, using get to look up field values.
+func (f *matchFilter) keep(get func(k interface{}) string) bool {
+	for k, match := range f.parts {
+		if match(get(k)) == f.exclude {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *matchFilter) Apply(fields map[interface{}]string) []map[interface{}]string {
+	if !f.keep(func(k interface{}) string { return fields[k] }) {
+		return nil
+	}
+	return []map[interface{}]string{fields}
+}
+
+func (f *matchFilter) ApplyRecord(rec *formats.Record) bool {
+	return f.keep(func(k interface{}) string {
+		v, _ := rec.Get(k)
+		return v
+	})
+}
+
+///////
+
+// fieldsFilter keeps (or with drop, removes) the fields with non-empty entries. Apply deletes
+// the other fields from the map it is given, rather than copying it.
+type fieldsFilter struct {
+	drop  bool
+	parts map[interface{}]string
+}
+
+func (f *fieldsFilter) Setup(parts map[interface{}]string) error {
+	f.parts = make(map[interface{}]string, len(parts))
+	for k, v := range parts {
+		if v != "" {
+			f.parts[k] = v
+		}
+	}
+	return nil
+}
+
+func (f *fieldsFilter) Apply(fields map[interface{}]string) []map[interface{}]string {
+	for k := range fields {
+		if _, found := f.parts[k]; found == f.drop {
+			delete(fields, k)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return []map[interface{}]string{fields}
+}
+
+func (f *fieldsFilter) ApplyRecord(rec *formats.Record) bool {
+	n := 0
+	for i, k := range rec.Keys {
+		if _, found := f.parts[k]; found != f.drop {
+			rec.Keys[n], rec.Values[n] = k, rec.Values[i]
+			n++
+		}
+	}
+	rec.Keys, rec.Values = rec.Keys[:n], rec.Values[:n]
+	return n > 0
+}
+
 ///////
 
 type dateFormatFilter struct {
@@ -265,6 +488,23 @@ func (f *dateFormatFilter) Apply(fields map[interface{}]string) []map[interface{
 	return []map[interface{}]string{fields}
 }
 
+func (f *dateFormatFilter) ApplyRecord(rec *formats.Record) bool {
+	for k, v := range f.parts {
+		if v == "" {
+			continue
+		}
+
+		v2, found := rec.Get(k)
+		if !found || v2 == "" {
+			continue
+		}
+
+		tm := strptime.MustParse(v2, v)
+		rec.Set(k, tm.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return true
+}
+
 ///////
 
 // FilterSet defines an ordered set of filters that are applied to incoming data records. These
@@ -272,17 +512,26 @@ func (f *dateFormatFilter) Apply(fields map[interface{}]string) []map[interface{
 // are applied in the order they are added with Append(), so results are cumulative and early
 // restrictions can bypass more expensive field splits.
 type FilterSet struct {
+	// Registry is used to look up filters by name. If nil, DefaultRegistry is used.
+	Registry *Registry
+
 	filters []Filter
+	names   []string
 }
 
 // Append adds a new filter onto the end of the FilterSet chain.
 func (fs *FilterSet) Append(ftype string, fields map[interface{}]string) error {
-	fltr, err := GetFilter(ftype, fields)
+	reg := fs.Registry
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	fltr, err := reg.GetFilter(ftype, fields)
 	if err != nil {
 		return err
 	}
 
 	fs.filters = append(fs.filters, fltr)
+	fs.names = append(fs.names, ftype)
 	return nil
 }
 
@@ -291,15 +540,26 @@ func (fs *FilterSet) Append(ftype string, fields map[interface{}]string) error {
 // and expansive filters (such as Split and DateFormat) should be applied as late as
 // possible in order to decrease computational times.
 func (fs *FilterSet) Apply(fields map[interface{}]string) []map[interface{}]string {
+	return fs.apply(0, fields)
+}
+
+// apply applies the filters from index start onwards.
+func (fs *FilterSet) apply(start int, fields map[interface{}]string) []map[interface{}]string {
 	lastset := []map[interface{}]string{fields}
-	for _, fltr := range fs.filters {
+	for i := start; i < len(fs.filters); i++ {
+		fltr := fs.filters[i]
 		newset := []map[interface{}]string{}
 		for _, mf := range lastset {
+			nkept := 0
 			for _, nf := range fltr.Apply(mf) {
 				if len(nf) > 0 {
 					newset = append(newset, nf)
+					nkept++
 				}
 			}
+			if nkept == 0 {
+				metrics.Add(metrics.RecordsDropped, 1, "filter", fs.names[i])
+			}
 		}
 		// short-circuit nulls
 		if len(newset) == 0 {
@@ -310,16 +570,60 @@ func (fs *FilterSet) Apply(fields map[interface{}]string) []map[interface{}]stri
 	return lastset
 }
 
+// ApplyRecord is like Apply, but for a formats.Record. Filters which implement RecordFilter
+// modify rec in place. If any filter does not, rec is converted to a map for the remaining
+// filters, and new Records are returned.
+func (fs *FilterSet) ApplyRecord(rec *formats.Record) []*formats.Record {
+	for i, fltr := range fs.filters {
+		rf, ok := fltr.(RecordFilter)
+		if !ok {
+			var ret []*formats.Record
+			for _, fields := range fs.apply(i, rec.Map()) {
+				r := &formats.Record{}
+				r.SetMap(fields)
+				ret = append(ret, r)
+			}
+			return ret
+		}
+		if !rf.ApplyRecord(rec) || rec.Len() == 0 {
+			metrics.Add(metrics.RecordsDropped, 1, "filter", fs.names[i])
+			return nil
+		}
+	}
+	return []*formats.Record{rec}
+}
+
 ///////
 
-// RegisterFilter adds a new named Filter for discovery by GetFilter or FilterSet.Append.
-func RegisterFilter(name string, fg FilterGetter) {
-	filters[name] = fg
+// Registry holds a set of named Filters. Most programs can use the package-level functions,
+// which operate on DefaultRegistry, but libraries may create their own Registry to avoid
+// sharing filter definitions with other users of the package.
+type Registry struct {
+	mu      sync.RWMutex
+	filters map[string]FilterGetter
+}
+
+// DefaultRegistry is used by GetFilter, RegisterFilter and FilterSets without a Registry, and
+// contains all the built-in Filters.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty Registry. Call RegisterDefaults to add the built-in Filters.
+func NewRegistry() *Registry {
+	return &Registry{filters: make(map[string]FilterGetter)}
+}
+
+// RegisterFilter adds a new named Filter for discovery by r.GetFilter.
+func (r *Registry) RegisterFilter(name string, fg FilterGetter) {
+	r.mu.Lock()
+	r.filters[name] = fg
+	r.mu.Unlock()
 }
 
 // GetFilter returns the named filter, initialized using Setup() with the fields parameter.
-func GetFilter(name string, fields map[interface{}]string) (Filter, error) {
-	fg, found := filters[name]
+func (r *Registry) GetFilter(name string, fields map[interface{}]string) (Filter, error) {
+	r.mu.RLock()
+	fg, found := r.filters[name]
+	r.mu.RUnlock()
 
 	if !found {
 		return nil, fmt.Errorf("no registered filters match '%s'", name)
@@ -334,10 +638,34 @@ func GetFilter(name string, fields map[interface{}]string) (Filter, error) {
 	return f, nil
 }
 
+// RegisterDefaults adds the built-in Filters to r.
+func (r *Registry) RegisterDefaults() {
+	r.RegisterFilter("null_fields", func() Filter { return &nullFilter{} })
+	r.RegisterFilter("split_fields", func() Filter { return &splitFieldFilter{} })
+	r.RegisterFilter("excludes", func() Filter { return &excludeFilter{} })
+	r.RegisterFilter("require", func() Filter { return &requireFilter{} })
+	for _, mode := range []string{"regex", "contains", "prefix", "suffix", "one_of"} {
+		mode := mode
+		r.RegisterFilter("require_"+mode, func() Filter { return &matchFilter{mode: mode} })
+		r.RegisterFilter("exclude_"+mode, func() Filter { return &matchFilter{mode: mode, exclude: true} })
+	}
+	r.RegisterFilter("keep_fields", func() Filter { return &fieldsFilter{} })
+	r.RegisterFilter("drop_fields", func() Filter { return &fieldsFilter{drop: true} })
+	r.RegisterFilter("date_formats", func() Filter { return &dateFormatFilter{} })
+}
+
+// RegisterFilter adds a new named Filter to the DefaultRegistry for discovery by GetFilter or
+// FilterSet.Append.
+func RegisterFilter(name string, fg FilterGetter) {
+	DefaultRegistry.RegisterFilter(name, fg)
+}
+
+// GetFilter returns the named filter from the DefaultRegistry, initialized using Setup() with
+// the fields parameter.
+func GetFilter(name string, fields map[interface{}]string) (Filter, error) {
+	return DefaultRegistry.GetFilter(name, fields)
+}
+
 func init() {
-	RegisterFilter("null_fields", func() Filter { return &nullFilter{} })
-	RegisterFilter("split_fields", func() Filter { return &splitFieldFilter{} })
-	RegisterFilter("excludes", func() Filter { return &excludeFilter{} })
-	RegisterFilter("require", func() Filter { return &requireFilter{} })
-	RegisterFilter("date_formats", func() Filter { return &dateFormatFilter{} })
+	DefaultRegistry.RegisterDefaults()
 }
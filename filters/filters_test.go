@@ -0,0 +1,159 @@
+package filters
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+// kept applies the filter to fields with both Apply and ApplyRecord, and returns whether the
+// record was kept.
+func kept(t *testing.T, name string, parts, fields map[interface{}]string) bool {
+	t.Helper()
+	f, err := GetFilter(name, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &formats.Record{}
+	rec.SetMap(fields)
+
+	byMap := len(f.Apply(fields)) == 1
+	if byRecord := f.(RecordFilter).ApplyRecord(rec); byRecord != byMap {
+		t.Fatalf("%s %v: Apply kept %v but ApplyRecord kept %v", name, parts, byMap, byRecord)
+	}
+	return byMap
+}
+
+func TestRegexFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[interface{}]string
+		want  bool
+	}{
+		{"require_regex", map[interface{}]string{0: "^ENSG[0-9]+$"}, true},
+		{"require_regex", map[interface{}]string{1: "^(?i)homo"}, true},
+		{"require_regex", map[interface{}]string{0: "^ENST"}, false},
+		{"require_regex", map[interface{}]string{0: "^ENSG", 1: "mouse"}, false},
+		{"require_regex", map[interface{}]string{5: "."}, false}, // missing field is ""
+		{"require_regex", map[interface{}]string{5: "^$"}, true},
+		{"exclude_regex", map[interface{}]string{1: "(?i)^(test|homo)"}, false},
+		{"exclude_regex", map[interface{}]string{1: "^mus"}, true},
+		{"exclude_regex", map[interface{}]string{0: "^ENST", 1: "sapiens"}, false},
+		{"exclude_regex", map[interface{}]string{5: "."}, true},
+		{"exclude_regex", map[interface{}]string{0: ""}, true}, // empty patterns are skipped
+	}
+	for _, tc := range tests {
+		fields := map[interface{}]string{0: "ENSG00000012048", 1: "Homo sapiens"}
+		if got := kept(t, tc.name, tc.parts, fields); got != tc.want {
+			t.Errorf("%s %v: kept = %v, expected %v", tc.name, tc.parts, got, tc.want)
+		}
+	}
+
+	for _, name := range []string{"require_regex", "exclude_regex"} {
+		if _, err := GetFilter(name, map[interface{}]string{0: "(unclosed"}); err == nil {
+			t.Errorf("%s: expected an error for an invalid regex", name)
+		}
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"require_contains", "GO:|KEGG:", "xref GO:0008150", true},
+		{"require_contains", "GO:|KEGG:", "xref go:0008150", false},
+		{"require_contains", "(?i)GO:|KEGG:", "xref go:0008150", true},
+		{"require_contains", "GO:", "", false},
+		{"exclude_contains", "test", "a test gene", false},
+		{"exclude_contains", "test", "BRCA1", true},
+
+		{"require_prefix", "ENSG|ENST", "ENST00000357654", true},
+		{"require_prefix", "ENSG|ENST", "XENSG", false},
+		{"require_prefix", "(?i)ensg", "ENSG00000012048", true},
+		{"exclude_prefix", "LOC", "LOC100287", false},
+		{"exclude_prefix", "LOC", "BLOC1S1", true},
+
+		{"require_suffix", ".1|.2", "NM_007294.2", true},
+		{"require_suffix", ".1|.2", "NM_007294.3", false},
+		{"require_suffix", "(?i)_HUMAN", "brca1_human", true},
+		{"exclude_suffix", "-AS1", "HOXA-AS1", false},
+		{"exclude_suffix", "-AS1", "HOXA1", true},
+
+		{"require_one_of", "yes|y|true", "y", true},
+		{"require_one_of", "yes|y|true", "yess", false},
+		{"require_one_of", "yes|y|true", "YES", false},
+		{"require_one_of", "(?i)yes|y|true", "YES", true},
+		{"require_one_of", "yes|<BLANK>", "", true},
+		{"require_one_of", "(?i)yes|<BLANK>", "", true},
+		{"require_one_of", "(?i)yes|<BLANK>", "<blank>", false},
+		{"require_one_of", "yes", "", false},
+		{"exclude_one_of", "n/a|<BLANK>", "", false},
+		{"exclude_one_of", "(?i)N/A|<BLANK>", "", false},
+		{"exclude_one_of", "(?i)N/A|<BLANK>", "n/a", false},
+		{"exclude_one_of", "n/a|<BLANK>", "BRCA1", true},
+	}
+	for _, tc := range tests {
+		parts := map[interface{}]string{0: tc.pattern}
+		if got := kept(t, tc.name, parts, map[interface{}]string{0: tc.value}); got != tc.want {
+			t.Errorf("%s '%s' on '%s': kept = %v, expected %v", tc.name, tc.pattern, tc.value, got, tc.want)
+		}
+	}
+
+	for _, mode := range []string{"contains", "prefix", "suffix", "one_of"} {
+		for _, pattern := range []string{"a||b", "a|", "|a", "(?i)a|", "(?i)"} {
+			if _, err := GetFilter("require_"+mode, map[interface{}]string{0: pattern}); err == nil {
+				t.Errorf("require_%s '%s': expected an error for an empty alternative", mode, pattern)
+			}
+		}
+	}
+}
+
+func TestFieldsFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[interface{}]string
+		want  map[interface{}]string // nil if the record is dropped
+	}{
+		{"keep_fields", map[interface{}]string{0: "y", "name": "y"},
+			map[interface{}]string{0: "ENSG00000012048", "name": "BRCA1"}},
+		{"keep_fields", map[interface{}]string{0: "y", 1: ""},
+			map[interface{}]string{0: "ENSG00000012048"}},
+		{"keep_fields", map[interface{}]string{9: "y"}, nil},
+		{"drop_fields", map[interface{}]string{"name": "y", 2: "y"},
+			map[interface{}]string{0: "ENSG00000012048"}},
+		{"drop_fields", map[interface{}]string{3: "y"},
+			map[interface{}]string{0: "ENSG00000012048", "name": "BRCA1", 2: "human"}},
+		{"drop_fields", map[interface{}]string{0: "y", "name": "y", 2: "y"}, nil},
+	}
+	for _, tc := range tests {
+		f, err := GetFilter(tc.name, tc.parts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := map[interface{}]string{0: "ENSG00000012048", "name": "BRCA1", 2: "human"}
+		rec := &formats.Record{}
+		rec.SetMap(fields)
+
+		res := f.Apply(fields)
+		if tc.want == nil {
+			if len(res) != 0 {
+				t.Errorf("%s %v: Apply kept %v, expected it to be dropped", tc.name, tc.parts, res)
+			}
+		} else if len(res) != 1 || !reflect.DeepEqual(res[0], tc.want) {
+			t.Errorf("%s %v: Apply returned %v, expected %v", tc.name, tc.parts, res, tc.want)
+		}
+
+		ok := f.(RecordFilter).ApplyRecord(rec)
+		if tc.want == nil {
+			if ok {
+				t.Errorf("%s %v: ApplyRecord kept %v, expected it to be dropped", tc.name, tc.parts, rec.Map())
+			}
+		} else if !ok || !reflect.DeepEqual(rec.Map(), tc.want) {
+			t.Errorf("%s %v: ApplyRecord returned %v, expected %v", tc.name, tc.parts, rec.Map(), tc.want)
+		}
+	}
+}
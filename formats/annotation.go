@@ -0,0 +1,185 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+var (
+	gff3Columns = []string{"seqid", "source", "type", "start", "end", "score", "strand", "phase", "attributes"}
+	gtfColumns  = []string{"seqname", "source", "feature", "start", "end", "score", "strand", "frame", "attribute"}
+	bedColumns  = []string{"chrom", "chromStart", "chromEnd", "name", "score", "strand",
+		"thickStart", "thickEnd", "itemRgb", "blockCount", "blockSizes", "blockStarts"}
+)
+
+// genomicAnnotation reads the feature lines of GFF3, GTF and BED files, keying fields by the
+// column names given in each specification. For GFF3 and GTF, the tags in the attributes
+// column are also returned as fields (e.g. "ID" or "gene_id"), unless they would replace one
+// of the standard columns. Comments, directives and BED track lines are skipped.
+type genomicAnnotation struct {
+	Kind    string
+	Columns []string
+
+	reader *bufio.Reader
+	done   bool
+}
+
+func (f *genomicAnnotation) Init(spec map[string]string) error {
+	switch f.Kind {
+	case "gff3":
+		f.Columns = gff3Columns
+	case "gtf":
+		f.Columns = gtfColumns
+	case "bed":
+		f.Columns = bedColumns
+	default:
+		return fmt.Errorf("unknown genomic annotation format '%s'", f.Kind)
+	}
+	return nil
+}
+
+func (f *genomicAnnotation) Open(r io.Reader) error {
+	var err error
+	f.done = false
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+func (f *genomicAnnotation) NextRecord() (string, error) {
+	for !f.done {
+		line, err := readLine(f.reader)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, "##FASTA") {
+			// the remainder of a GFF3 file is sequence data
+			f.done = true
+			break
+		}
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+		if f.Kind == "bed" && (strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser")) {
+			continue
+		}
+
+		metrics.Add(metrics.RecordsParsed, 1, "format", f.Kind)
+		return line, nil
+	}
+	return "", io.EOF
+}
+
+func (f *genomicAnnotation) GetFields(record string) (map[interface{}]string, error) {
+	record = strings.TrimRight(record, "\r\n")
+	var cols []string
+	if f.Kind == "bed" && !strings.Contains(record, "\t") {
+		// BED allows whitespace-separated columns
+		cols = strings.Fields(record)
+	} else {
+		cols = strings.Split(record, "\t")
+	}
+
+	minCols := len(f.Columns)
+	if f.Kind == "bed" {
+		minCols = 3
+	}
+	if len(cols) < minCols {
+		return nil, fmt.Errorf("%s format: expected at least %d columns, found %d", f.Kind, minCols, len(cols))
+	}
+
+	ret := make(map[interface{}]string, len(cols))
+	for i, v := range cols {
+		if i < len(f.Columns) {
+			ret[f.Columns[i]] = v
+		} else {
+			ret[i] = v
+		}
+	}
+
+	switch f.Kind {
+	case "gff3":
+		for _, attr := range strings.Split(cols[8], ";") {
+			kv := strings.SplitN(strings.TrimSpace(attr), "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				continue
+			}
+			if v, err := url.PathUnescape(kv[1]); err == nil {
+				kv[1] = v
+			}
+			addAttribute(ret, kv[0], kv[1])
+		}
+	case "gtf":
+		parseGTFAttributes(cols[8], ret)
+	}
+	return ret, nil
+}
+
+// addAttribute adds a tag from the attributes column. Repeated tags are joined with commas.
+func addAttribute(fields map[interface{}]string, key, value string) {
+	for _, c := range gff3Columns {
+		if key == c {
+			return
+		}
+	}
+	for _, c := range gtfColumns {
+		if key == c {
+			return
+		}
+	}
+	if prev, found := fields[key]; found {
+		value = prev + "," + value
+	}
+	fields[key] = value
+}
+
+// parseGTFAttributes parses a GTF attribute column, e.g.
+//
+//    gene_id "ENSG00000141510"; transcript_id "ENST00000269305"; level 2;
+func parseGTFAttributes(attrs string, fields map[interface{}]string) {
+	s := strings.TrimSpace(attrs)
+	for s != "" {
+		i := strings.IndexAny(s, " \t;")
+		if i == -1 {
+			return
+		}
+		key := s[:i]
+		s = strings.TrimLeft(s[i:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				end = len(s) - 1
+			}
+			value, s = s[1:end+1], s[end+1:]
+			if s != "" {
+				s = s[1:]
+			}
+		} else if end := strings.IndexByte(s, ';'); end != -1 {
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		if key != "" {
+			addAttribute(fields, key, value)
+		}
+		s = strings.TrimLeft(s, " \t;")
+	}
+}
+
+func (f *genomicAnnotation) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *genomicAnnotation) HasVariableFields() bool {
+	return f.Kind != "bed"
+}
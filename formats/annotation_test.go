@@ -0,0 +1,27 @@
+package formats_test
+
+import "testing"
+
+func TestAnnotationFormats(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "gff3"}, "##gff-version 3\n"+
+		"chr17\tensembl\tgene\t7661779\t7687538\t.\t-\t.\tID=gene:ENSG00000141510;Name=TP53;Note=tumor%20protein%3B p53;Alias=a,b\n"+
+		"##FASTA\n>chr17\nACGT\n")
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 feature, got %v", recs)
+	}
+	if recs[0]["seqid"] != "chr17" || recs[0]["end"] != "7687538" || recs[0]["ID"] != "gene:ENSG00000141510" ||
+		recs[0]["Note"] != "tumor protein; p53" || recs[0]["Alias"] != "a,b" {
+		t.Errorf("unexpected gff3 fields: %v", recs[0])
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "gtf"},
+		"17\thavana\ttranscript\t7661779\t7687538\t.\t-\t.\tgene_id \"ENSG00000141510\"; transcript_id \"ENST00000269305\"; level 2; tag \"basic\"; tag \"CCDS\";\n")
+	if recs[0]["feature"] != "transcript" || recs[0]["gene_id"] != "ENSG00000141510" || recs[0]["level"] != "2" || recs[0]["tag"] != "basic,CCDS" {
+		t.Errorf("unexpected gtf fields: %v", recs[0])
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "bed"}, "track name=genes\nchr17 7661778 7687538 TP53 0 -\n")
+	if len(recs) != 1 || recs[0]["chromStart"] != "7661778" || recs[0]["name"] != "TP53" || recs[0]["strand"] != "-" {
+		t.Errorf("unexpected bed fields: %v", recs)
+	}
+}
@@ -0,0 +1,212 @@
+// Package bench provides a benchmarking and profiling harness for DataFormats. It generates
+// synthetic inputs for each of the built-in formats and measures parsing throughput (records
+// per second) and allocations, so that format implementations can be compared and tuned:
+//
+//    results, err := bench.RunAll(100000, 12)
+//    for _, r := range results {
+//        fmt.Println(r)
+//    }
+//
+// The same inputs are used by the go test benchmarks in the formats package, i.e.:
+//
+//    go test -bench . -benchmem -cpuprofile cpu.out github.com/pbnjay/anydata/formats
+//
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+// Formats lists the built-in format types which Generate can produce inputs for.
+var Formats = []string{"tab-delimited", "simple-delimited", "csv", "fixed", "xml"}
+
+// fieldWidth is the width of every generated field (used by the "fixed" format).
+const fieldWidth = 10
+
+// fieldValue returns a deterministic, fixed-width field value.
+func fieldValue(rec, field int) string {
+	return fmt.Sprintf("r%04df%04d", rec%10000, field%10000)
+}
+
+// Generate returns a synthetic input for the named format type containing the specified number
+// of records and fields per record, along with the format spec to parse it.
+func Generate(format string, records, fields int) ([]byte, map[string]string, error) {
+	buf := &bytes.Buffer{}
+	spec := map[string]string{"type": format}
+
+	switch format {
+	case "tab-delimited", "simple-delimited", "csv":
+		delim := "\t"
+		if format == "simple-delimited" {
+			delim = "|"
+			spec["fields"] = delim
+		} else if format == "csv" {
+			delim = ","
+		}
+		for i := 0; i < records; i++ {
+			for j := 0; j < fields; j++ {
+				if j > 0 {
+					buf.WriteString(delim)
+				}
+				if format == "csv" && j == 1 {
+					// exercise the quoting paths too
+					fmt.Fprintf(buf, "\"%s, \"\"quoted\"\"\"", fieldValue(i, j))
+					continue
+				}
+				buf.WriteString(fieldValue(i, j))
+			}
+			buf.WriteString("\n")
+		}
+
+	case "fixed":
+		offs := make([]string, fields)
+		for j := range offs {
+			offs[j] = fmt.Sprint(j * fieldWidth)
+		}
+		spec["offsets"] = strings.Join(offs, ",")
+		for i := 0; i < records; i++ {
+			for j := 0; j < fields; j++ {
+				buf.WriteString(fieldValue(i, j))
+			}
+			buf.WriteString("\n")
+		}
+
+	case "xml":
+		spec["records"] = "record"
+		buf.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<records>\n")
+		for i := 0; i < records; i++ {
+			buf.WriteString("  <record>")
+			for j := 0; j < fields; j++ {
+				fmt.Fprintf(buf, "<f%d>%s</f%d>", j, fieldValue(i, j), j)
+			}
+			buf.WriteString("</record>\n")
+		}
+		buf.WriteString("</records>\n")
+
+	default:
+		return nil, nil, fmt.Errorf("no synthetic input generator for format '%s'", format)
+	}
+
+	return buf.Bytes(), spec, nil
+}
+
+// Parse reads every record from input using the format spec, and returns the number of
+// records parsed.
+func Parse(spec map[string]string, input []byte) (int, error) {
+	df, err := formats.GetDataFormat(spec)
+	if err != nil {
+		return 0, err
+	}
+	err = df.Open(bytes.NewReader(input))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		_, err = df.NextRecordFields()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Result summarizes the performance of a single format.
+type Result struct {
+	Format  string
+	Records int
+	Bytes   int
+
+	RecordsPerSec   float64
+	MBPerSec        float64
+	AllocsPerRecord float64
+	BytesPerRecord  float64
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%-18s %10.0f records/s %8.2f MB/s %8.2f allocs/record %10.1f B/record",
+		r.Format, r.RecordsPerSec, r.MBPerSec, r.AllocsPerRecord, r.BytesPerRecord)
+}
+
+// Measure benchmarks parsing input with the format spec.
+func Measure(spec map[string]string, input []byte) (Result, error) {
+	// parse once to count records and surface any errors
+	nrecs, err := Parse(spec, input)
+	if err != nil {
+		return Result{}, err
+	}
+	if nrecs == 0 {
+		return Result{}, fmt.Errorf("no records parsed from input")
+	}
+
+	br := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(input)))
+		for i := 0; i < b.N; i++ {
+			Parse(spec, input)
+		}
+	})
+
+	secs := float64(br.NsPerOp()) / 1e9
+	return Result{
+		Format:          spec["type"],
+		Records:         nrecs,
+		Bytes:           len(input),
+		RecordsPerSec:   float64(nrecs) / secs,
+		MBPerSec:        float64(len(input)) / secs / 1e6,
+		AllocsPerRecord: float64(br.AllocsPerOp()) / float64(nrecs),
+		BytesPerRecord:  float64(br.AllocedBytesPerOp()) / float64(nrecs),
+	}, nil
+}
+
+// RunAll generates synthetic inputs for each of the built-in Formats and measures them.
+func RunAll(records, fields int) ([]Result, error) {
+	var results []Result
+	for _, format := range Formats {
+		input, spec, err := Generate(format, records, fields)
+		if err != nil {
+			return results, err
+		}
+		res, err := Measure(spec, input)
+		if err != nil {
+			return results, fmt.Errorf("%s: %s", format, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// ProfileCPU writes a CPU profile (in pprof format) to filename while repeatedly parsing input
+// with the format spec.
+func ProfileCPU(filename string, spec map[string]string, input []byte, iterations int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = pprof.StartCPUProfile(f)
+	if err != nil {
+		return err
+	}
+	defer pprof.StopCPUProfile()
+
+	for i := 0; i < iterations; i++ {
+		if _, err = Parse(spec, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
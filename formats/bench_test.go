@@ -0,0 +1,101 @@
+package formats_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+	"github.com/pbnjay/anydata/formats/bench"
+)
+
+func benchmarkFormat(b *testing.B, format string) {
+	input, spec, err := bench.Generate(format, 10000, 12)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = bench.Parse(spec, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTabDelimited(b *testing.B)    { benchmarkFormat(b, "tab-delimited") }
+func BenchmarkSimpleDelimited(b *testing.B) { benchmarkFormat(b, "simple-delimited") }
+func BenchmarkCSV(b *testing.B)             { benchmarkFormat(b, "csv") }
+func BenchmarkFixed(b *testing.B)           { benchmarkFormat(b, "fixed") }
+func BenchmarkXML(b *testing.B)             { benchmarkFormat(b, "xml") }
+
+// BenchmarkTabDelimitedFieldBytes measures the allocation-free FieldBytesFormat interface.
+func BenchmarkTabDelimitedFieldBytes(b *testing.B) {
+	input, spec, err := bench.Generate("tab-delimited", 10000, 12)
+	if err != nil {
+		b.Fatal(err)
+	}
+	df, err := formats.GetDataFormat(spec)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fb := df.(formats.FieldBytesFormat)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = fb.Open(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+		for err == nil {
+			_, err = fb.NextFieldBytes()
+		}
+		if err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTabDelimitedRecord measures reading into a reused Record.
+func BenchmarkTabDelimitedRecord(b *testing.B) {
+	input, spec, err := bench.Generate("tab-delimited", 10000, 12)
+	if err != nil {
+		b.Fatal(err)
+	}
+	df, err := formats.GetDataFormat(spec)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rec := &formats.Record{}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = df.Open(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+		for err == nil {
+			err = formats.ReadRecord(df, rec)
+		}
+		if err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTabDelimitedWide parses records with many fields, as in large genomics matrices.
+func BenchmarkTabDelimitedWide(b *testing.B) {
+	input, spec, err := bench.Generate("tab-delimited", 500, 1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = bench.Parse(spec, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
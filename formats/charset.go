@@ -0,0 +1,58 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// charsetFormat wraps a DataFormat to decode its input to UTF-8 before parsing. A byte order
+// mark at the start of the input is removed, and overrides the charset if it is for UTF-8 or
+// UTF-16. Positions are byte offsets within the decoded input.
+type charsetFormat struct {
+	wrappedFormat
+	Charset  string
+	encoding encoding.Encoding
+}
+
+// newCharsetFormat wraps df if spec has the "charset" option.
+func newCharsetFormat(df DataFormat, spec map[string]string) (DataFormat, error) {
+	v := spec["charset"]
+	if v == "" {
+		return df, nil
+	}
+	switch df.(type) {
+	case *xlsxSheet:
+		return nil, fmt.Errorf("charset option is not supported for the xlsx format")
+	case *dbfTable:
+		// only the character fields are decoded, by the format itself
+		return df, nil
+	}
+	enc, err := lookupCharset(v)
+	if err != nil {
+		return nil, err
+	}
+	return &charsetFormat{wrappedFormat: wrappedFormat{DataFormat: df}, Charset: v, encoding: enc}, nil
+}
+
+// lookupCharset returns the encoding with the given WHATWG label (e.g. "shift-jis" or
+// "latin1") or IANA name (e.g. "UTF-16LE" or "Windows-1252").
+func lookupCharset(name string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		enc, err = ianaindex.IANA.Encoding(name)
+	}
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unknown charset '%s'", name)
+	}
+	return enc, nil
+}
+
+func (f *charsetFormat) Open(r io.Reader) error {
+	return f.DataFormat.Open(transform.NewReader(r, unicode.BOMOverride(f.encoding.NewDecoder())))
+}
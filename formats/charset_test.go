@@ -0,0 +1,31 @@
+package formats_test
+
+import "testing"
+
+func TestCharset(t *testing.T) {
+	utf16 := []byte{0xFF, 0xFE}
+	for _, r := range "id\tname\n1\tZoë\n" {
+		utf16 = append(utf16, byte(r), byte(r>>8))
+	}
+	for _, tc := range []struct {
+		spec  map[string]string
+		input string
+	}{
+		{map[string]string{"type": "tab-delimited", "charset": "windows-1252"}, "id\tname\n1\tZo\xeb\n"},
+		{map[string]string{"type": "tab-delimited", "charset": "utf-8"}, "\xef\xbb\xbfid\tname\n1\tZoë\n"},
+		{map[string]string{"type": "csv", "fields": "\t", "charset": "windows-1252"}, string(utf16)},
+		{map[string]string{"type": "simple-delimited", "charset": "shift-jis"}, "id\tname\n1\t\x83\x5b\x83\x8d\n"},
+	} {
+		tc.spec["header"] = "true"
+		recs := readAllFields(t, tc.spec, tc.input)
+		if len(recs) != 1 || recs[0]["id"] != "1" || (recs[0]["name"] != "Zoë" && recs[0]["name"] != "ゼロ") {
+			t.Errorf("%s: unexpected records: %v", tc.spec["charset"], recs)
+		}
+	}
+
+	recs := readAllFields(t, map[string]string{"type": "xml", "records": "item", "charset": "windows-1252"},
+		"<?xml version=\"1.0\" encoding=\"windows-1252\"?><list><item><name>Zo\xeb</name></item></list>")
+	if len(recs) != 1 || recs[0]["item>name"] != "Zoë" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
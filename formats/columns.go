@@ -0,0 +1,104 @@
+package formats
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseFieldKeys parses a comma-separated list of field keys, where numbers are column indexes
+// and anything else is a field name.
+func parseFieldKeys(v string) []interface{} {
+	var keys []interface{}
+	for _, k := range strings.Split(v, ",") {
+		k = strings.TrimSpace(k)
+		if i, err := strconv.Atoi(k); err == nil {
+			keys = append(keys, i)
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+////////
+
+// columnsFormat wraps a DataFormat to return only the selected fields. It is used for the
+// "columns" option of formats which do not select the columns themselves while parsing, so
+// the same option works for every format. Selected fields which are missing from a record are
+// not added.
+type columnsFormat struct {
+	wrappedFormat
+	Columns []interface{}
+}
+
+// newColumnsFormat wraps df if spec has the "columns" option, unless the format handles it.
+func newColumnsFormat(df DataFormat, spec map[string]string) (DataFormat, error) {
+	v, found := spec["columns"]
+	if !found {
+		return df, nil
+	}
+	inner := df
+	if cf, ok := df.(*charsetFormat); ok {
+		inner = cf.DataFormat
+	}
+	if _, ok := inner.(interface{ selectsColumns() }); ok {
+		return df, nil
+	}
+	return &columnsFormat{wrappedFormat: wrappedFormat{DataFormat: df}, Columns: parseFieldKeys(v)}, nil
+}
+
+func (f *columnsFormat) GetFields(record string) (map[interface{}]string, error) {
+	fields, err := f.DataFormat.GetFields(record)
+	if err != nil {
+		return nil, err
+	}
+	return f.selectFields(fields), nil
+}
+
+func (f *columnsFormat) NextRecordFields() (map[interface{}]string, error) {
+	fields, err := f.DataFormat.NextRecordFields()
+	if err != nil {
+		return nil, err
+	}
+	return f.selectFields(fields), nil
+}
+
+// selectFields returns the selected fields of a record.
+func (f *columnsFormat) selectFields(fields map[interface{}]string) map[interface{}]string {
+	ret := make(map[interface{}]string, len(f.Columns))
+	for _, k := range f.Columns {
+		if v, found := fields[k]; found {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func (f *columnsFormat) NextRecordInto(rec *Record) error {
+	if err := f.wrappedFormat.NextRecordInto(&f.rec); err != nil {
+		return err
+	}
+	rec.Reset()
+	for _, k := range f.Columns {
+		if v, found := f.rec.Get(k); found {
+			rec.Keys = append(rec.Keys, k)
+			rec.Values = append(rec.Values, v)
+		}
+	}
+	return nil
+}
+
+func (f *columnsFormat) NextFieldBytes() ([][]byte, error) {
+	if err := f.wrappedFormat.NextRecordInto(&f.rec); err != nil {
+		return nil, err
+	}
+	f.split = f.split[:0]
+	for _, k := range f.Columns {
+		var v []byte
+		if s, found := f.rec.Get(k); found {
+			v = []byte(s)
+		}
+		f.split = append(f.split, v)
+	}
+	return f.split, nil
+}
@@ -0,0 +1,45 @@
+package formats_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestColumns(t *testing.T) {
+	input := "id\tname\tscore\tnote\n1\ta\t0.5\tx\n2\tb\n"
+	for _, typ := range []string{"tab-delimited", "simple-delimited", "csv"} {
+		spec := map[string]string{"type": typ, "fields": "\t", "header": "true", "columns": "score,0", "num_fields": "-1"}
+		recs := readAllFields(t, spec, input)
+		if len(recs) != 2 || len(recs[0]) != 2 || recs[0]["score"] != "0.5" || recs[0]["id"] != "1" ||
+			len(recs[1]) != 1 || recs[1]["id"] != "2" {
+			t.Errorf("%s: unexpected records: %v", typ, recs)
+		}
+
+		df := openFormat(t, spec, input)
+		rec := &formats.Record{}
+		if err := formats.ReadRecord(df, rec); err != nil || rec.Len() != 2 || rec.Keys[0] != "score" || rec.Values[1] != "1" {
+			t.Errorf("%s: unexpected record: %v %v", typ, rec, err)
+		}
+	}
+
+	df := openFormat(t, map[string]string{"type": "tab-delimited", "columns": "2,5"}, "a\tb\tc\td\n")
+	fields, err := df.(formats.FieldBytesFormat).NextFieldBytes()
+	if err != nil || len(fields) != 2 || string(fields[0]) != "c" || fields[1] != nil {
+		t.Errorf("unexpected field bytes: %q %v", fields, err)
+	}
+
+	if _, err = formats.GetDataFormat(map[string]string{"type": "tab-delimited", "columns": "name"}); err == nil {
+		t.Error("expected an error for a column name without a header")
+	}
+	df, _ = formats.GetDataFormat(map[string]string{"type": "csv", "header": "true", "columns": "missing"})
+	if err = df.Open(strings.NewReader("id,name\n")); err == nil {
+		t.Error("expected an error for a column which is not in the header")
+	}
+
+	recs := readAllFields(t, map[string]string{"type": "json", "columns": "id"}, `{"id":"1","name":"a"}`)
+	if len(recs) != 1 || len(recs[0]) != 1 || recs[0]["id"] != "1" {
+		t.Errorf("unexpected json records: %v", recs)
+	}
+}
@@ -0,0 +1,275 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// SectionField is the name of the field holding the section name in records from the "ini"
+// and "toml" formats, and the stanza type in records from the "obo" format.
+const SectionField = "_section"
+
+// sectionFields decodes a record of the "ini", "toml", "feed", "obo", "owl" and "mmcif"
+// formats, which is a JSON object of string values.
+func sectionFields(record string) (map[interface{}]string, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(record), &values); err != nil {
+		return nil, err
+	}
+	ret := make(map[interface{}]string, len(values))
+	for k, v := range values {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// iniSections reads each section of an INI file as a record. Keys before the first section
+// header are in a section named "". Indented lines continue the value of the previous key.
+type iniSections struct {
+	Comments string
+
+	scanner  *bufio.Scanner
+	section  map[string]string
+	lastKey  string
+	explicit bool
+
+	scanLimits
+}
+
+func (f *iniSections) Init(spec map[string]string) error {
+	f.Comments = ";#"
+	if v, found := spec["comments"]; found {
+		f.Comments = v
+	}
+	return f.scanLimits.init(spec)
+}
+
+func (f *iniSections) Open(r io.Reader) error {
+	if f.Comments == "" {
+		f.Comments = ";#"
+	}
+	f.scanner = f.newScanner(r)
+	f.section = map[string]string{SectionField: ""}
+	f.lastKey = ""
+	f.explicit = false
+	return nil
+}
+
+func (f *iniSections) NextRecord() (string, error) {
+	for f.section != nil {
+		var line string
+		more := f.scanner.Scan()
+		if more {
+			line = f.scanner.Text()
+		} else if err := f.scanError(f.scanner.Err()); err != nil {
+			return "", err
+		}
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case more && trimmed == "":
+			f.lastKey = ""
+			continue
+		case more && strings.ContainsRune(f.Comments, rune(trimmed[0])):
+			continue
+		case more && f.lastKey != "" && (line[0] == ' ' || line[0] == '\t'):
+			f.section[f.lastKey] += "\n" + trimmed
+			continue
+		case more && trimmed[0] != '[':
+			key, value := trimmed, ""
+			if i := strings.IndexAny(trimmed, "=:"); i != -1 {
+				key, value = strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+			}
+			if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			f.section[key] = value
+			f.lastKey = key
+			continue
+		}
+
+		// a new section header, or the end of input
+		rec := f.section
+		f.section, f.lastKey = nil, ""
+		if more {
+			name := trimmed[1:]
+			if i := strings.LastIndexByte(name, ']'); i != -1 {
+				name = name[:i]
+			}
+			f.section = map[string]string{SectionField: strings.TrimSpace(name)}
+		}
+		if len(rec) > 1 || f.explicit {
+			f.explicit = more
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return "", err
+			}
+			metrics.Add(metrics.RecordsParsed, 1, "format", "ini")
+			return string(data), nil
+		}
+		f.explicit = more
+	}
+	return "", io.EOF
+}
+
+func (f *iniSections) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *iniSections) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *iniSections) HasVariableFields() bool {
+	return true
+}
+
+////////
+
+// tomlTables reads each table of a TOML document as a record, in the order they appear.
+// Nested tables are named with dotted paths (e.g. "servers.alpha"), each element of an array
+// of tables is a record, and keys outside of any table are in a section named "". TOML cannot
+// be parsed incrementally, so the document is decoded by Open.
+type tomlTables struct {
+	records []string
+}
+
+func (f *tomlTables) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *tomlTables) Open(r io.Reader) error {
+	var doc map[string]interface{}
+	md, err := toml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return err
+	}
+	// the position of the first key within each table, including implicitly-defined tables
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		for n := 1; n <= len(key); n++ {
+			if _, found := order[key[:n].String()]; !found {
+				order[key[:n].String()] = i
+			}
+		}
+	}
+	f.records = nil
+	return f.addTable(nil, doc, order)
+}
+
+// addTable adds the record for a table and then any tables nested inside of it.
+func (f *tomlTables) addTable(path []string, table map[string]interface{}, order map[string]int) error {
+	rec := map[string]string{SectionField: strings.Join(path, ".")}
+	var children []string
+	for k, v := range table {
+		switch v.(type) {
+		case map[string]interface{}, []map[string]interface{}:
+			children = append(children, k)
+		default:
+			rec[k] = tomlString(v)
+		}
+	}
+	// implicitly-defined parent tables (e.g. "a" for [a.b]) have no record
+	if len(rec) > 1 || len(children) == 0 {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		f.records = append(f.records, string(data))
+	}
+
+	position := func(k string) int {
+		if i, found := order[toml.Key(append(path, k)).String()]; found {
+			return i
+		}
+		return len(order)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		pi, pj := position(children[i]), position(children[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return children[i] < children[j]
+	})
+	for _, k := range children {
+		sub := append(path[:len(path):len(path)], k)
+		switch v := table[k].(type) {
+		case map[string]interface{}:
+			if err := f.addTable(sub, v, order); err != nil {
+				return err
+			}
+		case []map[string]interface{}:
+			for _, t := range v {
+				if err := f.addTable(sub, t, order); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tomlString converts a decoded TOML value into a field value. Arrays are returned as JSON.
+func tomlString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		// local dates and times have a placeholder zone which is not exported by the toml package
+		switch x.Location().String() {
+		case "date-local":
+			return x.Format("2006-01-02")
+		case "time-local":
+			return x.Format("15:04:05.999999999")
+		case "datetime-local":
+			return x.Format("2006-01-02T15:04:05.999999999")
+		}
+		return x.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (f *tomlTables) NextRecord() (string, error) {
+	if len(f.records) == 0 {
+		return "", io.EOF
+	}
+	rec := f.records[0]
+	f.records = f.records[1:]
+	metrics.Add(metrics.RecordsParsed, 1, "format", "toml")
+	return rec, nil
+}
+
+func (f *tomlTables) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *tomlTables) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *tomlTables) HasVariableFields() bool {
+	return true
+}
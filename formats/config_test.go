@@ -0,0 +1,57 @@
+package formats_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestConfigSections(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "ini"}, `; global settings
+debug = true
+
+[database]
+host: db.local
+query = SELECT *
+   FROM genes
+[empty]
+[paths]
+data = "/var/data"
+`)
+	if len(recs) != 4 {
+		t.Fatalf("expected 4 sections, got %v", recs)
+	}
+	if recs[0][formats.SectionField] != "" || recs[0]["debug"] != "true" {
+		t.Errorf("unexpected global section: %v", recs[0])
+	}
+	if recs[1][formats.SectionField] != "database" || recs[1]["host"] != "db.local" || recs[1]["query"] != "SELECT *\nFROM genes" {
+		t.Errorf("unexpected database section: %v", recs[1])
+	}
+	if recs[2][formats.SectionField] != "empty" || recs[3]["data"] != "/var/data" {
+		t.Errorf("unexpected sections: %v %v", recs[2], recs[3])
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "toml"}, `title = "genes"
+[servers.beta]
+ip = "10.0.0.2"
+[servers.alpha]
+ip = "10.0.0.1"
+ports = [8000, 8001]
+[[products]]
+name = "Hammer"
+released = 2024-01-02
+[[products]]
+name = "Nail"
+`)
+	var sections []string
+	for _, rec := range recs {
+		sections = append(sections, rec[formats.SectionField])
+	}
+	if strings.Join(sections, ",") != ",servers.beta,servers.alpha,products,products" {
+		t.Fatalf("unexpected sections: %v", sections)
+	}
+	if recs[0]["title"] != "genes" || recs[2]["ports"] != "[8000,8001]" || recs[3]["released"] != "2024-01-02" || recs[4]["name"] != "Nail" {
+		t.Errorf("unexpected fields: %v", recs)
+	}
+}
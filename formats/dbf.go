@@ -0,0 +1,304 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// dbfCodePages are the charsets of the common dBase language driver IDs.
+var dbfCodePages = map[byte]encoding.Encoding{
+	0x01: charmap.CodePage437,
+	0x02: charmap.CodePage850,
+	0x03: charmap.Windows1252,
+	0x13: japanese.ShiftJIS,
+	0x4D: simplifiedchinese.GBK,
+	0x4E: korean.EUCKR,
+	0x4F: traditionalchinese.Big5,
+	0x57: charmap.Windows1252,
+	0x64: charmap.CodePage852,
+	0x65: charmap.CodePage866,
+	0x7A: simplifiedchinese.GBK,
+	0xC8: charmap.Windows1250,
+	0xC9: charmap.Windows1251,
+	0xCA: charmap.Windows1254,
+	0xCB: charmap.Windows1253,
+}
+
+// dbfField is a column of a dBase table.
+type dbfField struct {
+	Name   string
+	Type   byte
+	Offset int // within the record, after the deletion flag
+	Length int
+}
+
+// dbfTable reads the records of a dBase (.dbf) table, such as the attribute table of a
+// shapefile. Fields are keyed by column name and decoded by type: dates as "2006-01-02",
+// logicals as "true" or "false", and numbers as text without padding. Memo fields are stored
+// in a separate file, so only their block numbers are returned. Deleted records are skipped.
+// Records are encoded as a JSON array of field values, one per column.
+type dbfTable struct {
+	Memo    string
+	Charset string
+
+	reader    *bufio.Reader
+	fields    []dbfField
+	keys      []string // of the fields which are returned
+	decoder   *encoding.Decoder
+	visual    bool // Visual FoxPro, which has binary numeric types
+	remaining uint32
+	buf       []byte
+}
+
+func (f *dbfTable) Init(spec map[string]string) error {
+	f.Memo = "block"
+	if v, found := spec["memo"]; found {
+		if v != "block" && v != "skip" {
+			return fmt.Errorf("dbf format memo must be 'block' or 'skip', not '%s'", v)
+		}
+		f.Memo = v
+	}
+	f.Charset = spec["charset"]
+	if f.Charset != "" {
+		if _, err := lookupCharset(f.Charset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *dbfTable) Open(r io.Reader) error {
+	f.reader = bufio.NewReader(r)
+	f.fields, f.remaining = nil, 0
+
+	var header [32]byte
+	if _, err := io.ReadFull(f.reader, header[:]); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("dbf format: reading header: %s", err)
+	}
+	if header[0]&0x07 == 4 {
+		return fmt.Errorf("dbf format does not support dBase 7 tables")
+	}
+	f.visual = header[0] == 0x30 || header[0] == 0x31 || header[0] == 0x32
+	f.remaining = binary.LittleEndian.Uint32(header[4:8])
+	headerLen := int(binary.LittleEndian.Uint16(header[8:10]))
+	recordLen := int(binary.LittleEndian.Uint16(header[10:12]))
+	if headerLen < 33 || recordLen < 1 {
+		return fmt.Errorf("dbf format: invalid header")
+	}
+
+	desc := make([]byte, headerLen-32)
+	if _, err := io.ReadFull(f.reader, desc); err != nil {
+		return fmt.Errorf("dbf format: reading field descriptors: %s", err)
+	}
+	offset := 0
+	for len(desc) >= 32 && desc[0] != 0x0D {
+		name := desc[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		fd := dbfField{Name: string(name), Type: desc[11], Offset: offset, Length: int(desc[16])}
+		if fd.Type == 'C' && !f.visual {
+			// long character fields use the decimal count as the high byte of the length
+			fd.Length += int(desc[17]) << 8
+		}
+		offset += fd.Length
+		f.fields = append(f.fields, fd)
+		desc = desc[32:]
+	}
+	if offset+1 > recordLen {
+		return fmt.Errorf("dbf format: fields are longer than the record length")
+	}
+	f.buf = make([]byte, recordLen)
+	f.keys = f.keys[:0]
+	for _, fd := range f.fields {
+		if !f.omitted(fd) {
+			f.keys = append(f.keys, fd.Name)
+		}
+	}
+
+	f.decoder = nil
+	if f.Charset != "" {
+		enc, _ := lookupCharset(f.Charset)
+		f.decoder = enc.NewDecoder()
+	} else if enc, found := dbfCodePages[header[29]]; found {
+		f.decoder = enc.NewDecoder()
+	}
+	return nil
+}
+
+// next returns the values of the next record which is not deleted.
+func (f *dbfTable) next() ([]string, error) {
+	for f.remaining > 0 {
+		f.remaining--
+		if _, err := io.ReadFull(f.reader, f.buf); err != nil {
+			if err == io.EOF || (err == io.ErrUnexpectedEOF && f.buf[0] == 0x1A) {
+				break
+			}
+			return nil, err
+		}
+		switch f.buf[0] {
+		case '*':
+			continue
+		case 0x1A:
+			f.remaining = 0
+			continue
+		}
+
+		values := make([]string, 0, len(f.fields))
+		data := f.buf[1:]
+		for _, fd := range f.fields {
+			if f.omitted(fd) {
+				continue
+			}
+			v, err := f.value(fd, data[fd.Offset:fd.Offset+fd.Length])
+			if err != nil {
+				return nil, fmt.Errorf("dbf format: field '%s': %s", fd.Name, err)
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	return nil, io.EOF
+}
+
+// isMemo returns true if fd refers to a block of the table's memo file.
+func (f *dbfTable) isMemo(fd dbfField) bool {
+	return fd.Type == 'M' || fd.Type == 'G' || fd.Type == 'P' || (fd.Type == 'B' && !f.visual)
+}
+
+// omitted returns true if fd is not returned: skipped memos, and the Visual FoxPro null flags,
+// which are not data.
+func (f *dbfTable) omitted(fd dbfField) bool {
+	return fd.Type == '0' || (f.Memo == "skip" && f.isMemo(fd))
+}
+
+// value decodes a field.
+func (f *dbfTable) value(fd dbfField, b []byte) (string, error) {
+	s := strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+	switch {
+	case f.isMemo(fd):
+		// a block number, as text or a 4-byte integer
+		if len(b) == 4 {
+			s = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
+		}
+		return strings.TrimLeft(s, "0"), nil
+	case fd.Type == 'C':
+		s = strings.TrimRight(string(bytes.TrimRight(b, "\x00")), " ")
+		if f.decoder != nil {
+			return f.decoder.String(s)
+		}
+		return s, nil
+	case fd.Type == 'D':
+		if strings.Trim(s, "0") == "" {
+			return "", nil
+		}
+		if d, err := time.Parse("20060102", s); err == nil {
+			return d.Format("2006-01-02"), nil
+		}
+	case fd.Type == 'L':
+		switch s {
+		case "T", "t", "Y", "y":
+			return "true", nil
+		case "F", "f", "N", "n":
+			return "false", nil
+		}
+		return "", nil
+	case fd.Type == 'I' && len(b) == 4:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case fd.Type == 'Y' && len(b) == 8:
+		return formatCurrency(int64(binary.LittleEndian.Uint64(b))), nil
+	case fd.Type == 'T' && len(b) == 8:
+		return julianTime(b), nil
+	case fd.Type == 'B' && len(b) == 8:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(b))
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	// numeric fields are text
+	return s, nil
+}
+
+// formatCurrency formats a currency value, which is stored in units of 1/10000.
+func formatCurrency(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	s := fmt.Sprintf("%s%d.%04d", sign, v/10000, v%10000)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// julianTime formats a Visual FoxPro datetime, which is a Julian day number followed by
+// milliseconds since midnight.
+func julianTime(b []byte) string {
+	day := int64(int32(binary.LittleEndian.Uint32(b[:4])))
+	ms := int64(int32(binary.LittleEndian.Uint32(b[4:])))
+	if day == 0 {
+		return ""
+	}
+	const unixEpoch = 2440588 // the Julian day number of 1970-01-01
+	t := time.Unix((day-unixEpoch)*86400, ms*int64(time.Millisecond)).UTC()
+	return t.Format(time.RFC3339Nano)
+}
+
+func (f *dbfTable) NextRecord() (string, error) {
+	values, err := f.next()
+	if err != nil {
+		return "", err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "dbf")
+	data, err := json.Marshal(values)
+	return string(data), err
+}
+
+func (f *dbfTable) GetFields(record string) (map[interface{}]string, error) {
+	var values []string
+	if err := json.Unmarshal([]byte(record), &values); err != nil {
+		return nil, err
+	}
+	return f.keyed(values), nil
+}
+
+func (f *dbfTable) NextRecordFields() (map[interface{}]string, error) {
+	values, err := f.next()
+	if err != nil {
+		return nil, err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "dbf")
+	return f.keyed(values), nil
+}
+
+// keyed returns values keyed by column name.
+func (f *dbfTable) keyed(values []string) map[interface{}]string {
+	ret := make(map[interface{}]string, len(values))
+	for i, v := range values {
+		if i < len(f.keys) {
+			ret[f.keys[i]] = v
+		} else {
+			ret[i] = v
+		}
+	}
+	return ret
+}
+
+func (f *dbfTable) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,55 @@
+package formats_test
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDBF(t *testing.T) {
+	// a dBase III table with the code page Windows-1252
+	var dbf bytes.Buffer
+	fields := []struct {
+		name      string
+		typ       byte
+		length    int
+		recordOne string
+		recordTwo string
+	}{
+		{"NAME", 'C', 8, "Zo\xeb     ", "Deleted "},
+		{"POP", 'N', 6, "  1500", "     0"},
+		{"FOUNDED", 'D', 8, "19070412", "        "},
+		{"CAPITAL", 'L', 1, "T", "?"},
+		{"NOTES", 'M', 10, "        12", "          "},
+	}
+	header := make([]byte, 32)
+	header[0], header[4], header[8], header[10], header[29] = 0x03, 2, byte(33+32*len(fields)), 34, 0x03
+	dbf.Write(header)
+	for _, fd := range fields {
+		desc := make([]byte, 32)
+		copy(desc, fd.name)
+		desc[11], desc[16] = fd.typ, byte(fd.length)
+		dbf.Write(desc)
+	}
+	dbf.WriteByte(0x0D)
+	for i, flag := range []string{" ", "*"} {
+		dbf.WriteString(flag)
+		for _, fd := range fields {
+			if i == 0 {
+				dbf.WriteString(fd.recordOne)
+			} else {
+				dbf.WriteString(fd.recordTwo)
+			}
+		}
+	}
+	dbf.WriteByte(0x1A)
+
+	recs := readAllFields(t, map[string]string{"type": "dbf"}, dbf.String())
+	if len(recs) != 1 || recs[0]["NAME"] != "Zoë" || recs[0]["POP"] != "1500" ||
+		recs[0]["FOUNDED"] != "1907-04-12" || recs[0]["CAPITAL"] != "true" || recs[0]["NOTES"] != "12" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+	recs = readAllFields(t, map[string]string{"type": "dbf", "memo": "skip", "charset": "latin1"}, dbf.String())
+	if _, found := recs[0]["NOTES"]; len(recs) != 1 || found || recs[0]["NAME"] != "Zoë" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
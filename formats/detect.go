@@ -0,0 +1,374 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// DetectSampleSize is the maximum number of bytes read by Detect.
+const DetectSampleSize = 64 << 10
+
+// detectDelimiters are the candidate field delimiters, in order of preference.
+var detectDelimiters = []string{",", "\t", ";", "|"}
+
+// Detect guesses the format specification of the input in r from its first DetectSampleSize
+// bytes, for use with GetDataFormat. Structured formats (e.g. XML, JSON and FASTA) are
+// recognized by their content, and other text is treated as delimited, guessing the field
+// delimiter, quote handling, comments and whether there is a header row, in the same way as
+// Python's csv.Sniffer. The "charset" option is set if the input is not UTF-8.
+//
+// If r is a *bufio.Reader, the sample is peeked so that r can then be given to Open. Any other
+// reader is consumed, so the sample must be read again, e.g.
+//
+//    br := bufio.NewReaderSize(r, formats.DetectSampleSize)
+//    spec, err := formats.Detect(br)
+//    ...
+//    df.Open(br)
+//
+func Detect(r io.Reader) (map[string]string, error) {
+	sample, complete, err := readSample(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("cannot detect the format of empty input")
+	}
+	if bytes.HasPrefix(sample, []byte("PK\x03\x04")) {
+		return map[string]string{"type": "xlsx"}, nil
+	}
+
+	spec := make(map[string]string)
+	text, charset := decodeSample(sample)
+	if charset != "" {
+		spec["charset"] = charset
+	}
+	if !complete {
+		// the last line may be truncated
+		if i := strings.LastIndexByte(text, '\n'); i > 0 {
+			text = text[:i+1]
+		}
+	}
+
+	if !detectStructured(text, spec) {
+		detectDelimited(text, spec)
+	}
+	return spec, nil
+}
+
+// readSample returns up to DetectSampleSize bytes from r, and whether they are the whole input.
+func readSample(r io.Reader) ([]byte, bool, error) {
+	if br, ok := r.(*bufio.Reader); ok {
+		n := DetectSampleSize
+		if br.Size() < n {
+			n = br.Size()
+		}
+		sample, err := br.Peek(n)
+		if err != nil && err != io.EOF {
+			return nil, false, err
+		}
+		return sample, len(sample) < n, nil
+	}
+
+	sample := make([]byte, DetectSampleSize)
+	n, err := io.ReadFull(r, sample)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return sample[:n], true, nil
+	}
+	return sample, false, err
+}
+
+// decodeSample returns the sample as UTF-8 text, and its charset if it is not UTF-8. Invalid
+// UTF-8 is assumed to be Windows-1252, which is the most common single-byte charset.
+func decodeSample(sample []byte) (string, string) {
+	var charset string
+	var enc transform.Transformer
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return string(sample[3:]), "utf-8"
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		charset, enc = "utf-16le", xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM).NewDecoder()
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		charset, enc = "utf-16be", xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM).NewDecoder()
+	default:
+		valid := sample
+		if len(valid) > utf8.UTFMax {
+			// ignore a rune which was split at the end of the sample
+			for i := len(valid) - 1; i >= len(valid)-utf8.UTFMax; i-- {
+				if utf8.RuneStart(valid[i]) {
+					valid = valid[:i]
+					break
+				}
+			}
+		}
+		if utf8.Valid(valid) {
+			return string(sample), ""
+		}
+		charset, enc = "windows-1252", charmap.Windows1252.NewDecoder()
+	}
+	text, _, _ := transform.Bytes(enc, sample)
+	return string(text), charset
+}
+
+// detectStructured recognizes the formats which are not delimited text, and returns false if
+// none of them match.
+func detectStructured(text string, spec map[string]string) bool {
+	t := strings.TrimLeftFunc(text, unicode.IsSpace)
+	first := t
+	if i := strings.IndexByte(t, '\n'); i >= 0 {
+		first = t[:i]
+	}
+
+	switch {
+	case strings.HasPrefix(t, "<"):
+		spec["type"] = "xml"
+		spec["records"] = detectXMLRecords(t)
+	case (strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[")) && isJSON(t):
+		spec["type"] = "json"
+	case strings.HasPrefix(t, "---") || strings.HasPrefix(t, "%YAML"):
+		spec["type"] = "yaml"
+	case strings.HasPrefix(t, ">"):
+		spec["type"] = "fasta"
+	case strings.HasPrefix(t, "@") && isFASTQ(t):
+		spec["type"] = "fastq"
+	case strings.HasPrefix(t, "##gff-version 3"):
+		spec["type"] = "gff3"
+	case strings.HasPrefix(t, "LOCUS "):
+		spec["type"] = "genbank"
+	case strings.HasPrefix(t, "ID   "):
+		spec["type"] = "embl"
+	case isARFF(t):
+		spec["type"] = "arff"
+	case strings.HasPrefix(first, "[") && strings.HasSuffix(strings.TrimSpace(first), "]"):
+		spec["type"] = "ini"
+	default:
+		return false
+	}
+	return true
+}
+
+// isJSON returns true if text begins with a JSON object or array (which may be truncated).
+func isJSON(text string) bool {
+	dec := json.NewDecoder(strings.NewReader(text))
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Token(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isFASTQ returns true if the third line of text is a FASTQ separator line.
+func isFASTQ(text string) bool {
+	lines := strings.SplitN(text, "\n", 4)
+	return len(lines) > 2 && strings.HasPrefix(lines[2], "+")
+}
+
+// isARFF returns true if the first line of text which is not a comment is an ARFF @relation.
+func isARFF(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+		return strings.HasPrefix(strings.ToLower(line), "@relation")
+	}
+	return false
+}
+
+// detectXMLRecords returns the most common child element of the document's root element, or
+// the root element if it has no children.
+func detectXMLRecords(text string) string {
+	dec := xml.NewDecoder(strings.NewReader(text))
+	dec.Strict = false
+	dec.CharsetReader = charsetReader
+
+	var root, best string
+	counts := make(map[string]int)
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = t.Name.Local
+			} else if depth == 2 {
+				counts[t.Name.Local]++
+				if counts[t.Name.Local] > counts[best] {
+					best = t.Name.Local
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if best == "" {
+		return root
+	}
+	return best
+}
+
+// detectDelimited sets the spec for the delimited format which best splits text into records
+// with a consistent number of fields.
+func detectDelimited(text string, spec map[string]string) {
+	opts := map[string]string{"quotes": "lazy", "num_fields": "-1"}
+	if strings.HasPrefix(text, "#") {
+		// a block of comments before the records
+		for _, line := range strings.Split(text, "\n") {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				opts["comments"] = "#"
+				break
+			}
+		}
+	}
+
+	var recs [][]string
+	best, consistency := "", 0.0
+	for _, delim := range detectDelimiters {
+		opts["fields"] = delim
+		drecs, _ := sampleRecords(text, opts)
+		n, c := fieldConsistency(drecs)
+		if n > 1 && c > consistency {
+			best, consistency, recs = delim, c, drecs
+		}
+	}
+	if c, found := opts["comments"]; found {
+		spec["comments"] = c
+	}
+	if best == "" {
+		// a single column
+		spec["type"] = "tab-delimited"
+		return
+	}
+
+	if best == "\t" && !strings.Contains(text, `"`) {
+		spec["type"] = "tab-delimited"
+	} else {
+		spec["type"] = "csv"
+		if best != "," {
+			spec["fields"] = best
+		}
+		opts["fields"] = best
+		if consistency < 1 {
+			spec["num_fields"] = "-1"
+		}
+
+		// use the strictest quote handling which can read the sample
+		delete(opts, "quotes")
+		if _, err := sampleRecords(text, opts); err != nil {
+			opts["quotes"] = "relaxed"
+			relaxed, _ := sampleRecords(text, opts)
+			spec["quotes"] = "lazy"
+			if len(relaxed) > len(recs) {
+				spec["quotes"], recs = "relaxed", relaxed
+			}
+		}
+	}
+	if hasHeader(recs) {
+		spec["header"] = "true"
+	}
+}
+
+// sampleRecords reads the fields of every record in text using the "csv" format options in
+// spec. The records before any error are also returned.
+func sampleRecords(text string, spec map[string]string) ([][]string, error) {
+	f := &commaSeparated{}
+	if err := f.Init(spec); err != nil {
+		return nil, err
+	}
+	if err := f.Open(strings.NewReader(text)); err != nil {
+		return nil, err
+	}
+	var recs [][]string
+	for {
+		rec, err := f.read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// fieldConsistency returns the most common number of fields in recs, and the fraction of the
+// records which have that many fields.
+func fieldConsistency(recs [][]string) (int, float64) {
+	counts := make(map[int]int)
+	mode := 0
+	for _, rec := range recs {
+		counts[len(rec)]++
+		if counts[len(rec)] > counts[mode] {
+			mode = len(rec)
+		}
+	}
+	if len(recs) == 0 {
+		return 0, 0
+	}
+	return mode, float64(counts[mode]) / float64(len(recs))
+}
+
+// hasHeader guesses whether the first record is a header row. Each column votes for a header
+// if its first value has a different type than the others (e.g. "id" above integers), or a
+// different length if the values are all strings of the same length, and against a header
+// otherwise.
+func hasHeader(recs [][]string) bool {
+	if len(recs) < 2 {
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, name := range recs[0] {
+		if name == "" || seen[name] {
+			return false
+		}
+		seen[name] = true
+	}
+
+	votes := 0
+	for i, name := range recs[0] {
+		var t FieldType
+		length := -1
+		for _, rec := range recs[1:] {
+			if i >= len(rec) || rec[i] == "" {
+				continue
+			}
+			if t == "" {
+				t, length = inferType(rec[i]), len(rec[i])
+				continue
+			}
+			t = widenType(t, inferType(rec[i]))
+			if len(rec[i]) != length {
+				length = -1
+			}
+		}
+
+		switch {
+		case t == "":
+			// no values
+		case t != TypeString && widenType(t, inferType(name)) == TypeString:
+			votes++
+		case t != TypeString:
+			votes--
+		case length != -1 && len(name) != length:
+			votes++
+		case length != -1:
+			votes--
+		}
+	}
+	return votes > 0
+}
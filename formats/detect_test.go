@@ -0,0 +1,38 @@
+package formats_test
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  string
+	}{
+		{"id,name,score\n1,\"Smith, J\",3.5\n2,Jones,4\n", "type=csv header=true"},
+		{"1;a\n2;b\n3;c\n", "type=csv fields=;"},
+		{"# exported 2020\ngene\tcount\nBRCA1\t12\nTP53\t7\n", "type=tab-delimited comments=# header=true"},
+		{"a|b\n1|12\" pipe\n2|5\" nail\n", "type=csv fields=| quotes=lazy header=true"},
+		{"name,city\n\"O'Brien,Boston\n\"Lee\",Denver\n", "type=csv quotes=relaxed"},
+		{"  [{\"a\": 1}]", "type=json"},
+		{"<?xml version=\"1.0\"?>\n<set><item/><note/><item/></set>", "type=xml records=item"},
+		{">seq1\nACGT\n", "type=fasta"},
+		{"[server]\nhost = example.com\n", "type=ini"},
+		{"caf\xe9\tna\xefve\n", "type=tab-delimited charset=windows-1252"},
+	} {
+		spec, err := formats.Detect(bufio.NewReader(strings.NewReader(tc.input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range strings.Fields(tc.want) {
+			parts := strings.SplitN(kv, "=", 2)
+			if spec[parts[0]] != strings.TrimSpace(parts[1]) {
+				t.Errorf("%q: expected %s, got %v", tc.input, kv, spec)
+			}
+		}
+	}
+}
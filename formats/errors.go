@@ -0,0 +1,252 @@
+package formats
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// RecordError describes a malformed record. DataFormats may return a RecordError from
+// NextRecord to indicate that the input can still be read after the bad record.
+type RecordError struct {
+	// Position is where the record begins, if the DataFormat is a Positioner.
+	Position
+
+	// RecordNum is the 1-based count of records read, including malformed ones.
+	RecordNum int
+
+	// Record is the raw record, if available.
+	Record string
+
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	switch {
+	case e.Line > 0:
+		return fmt.Sprintf("record %d (line %d): %s", e.RecordNum, e.Line, e.Err)
+	case e.RecordNum > 0:
+		return fmt.Sprintf("record %d: %s", e.RecordNum, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// recoverable returns true if err from NextRecord only affects a single record.
+func recoverable(err error) bool {
+	var perr *csv.ParseError
+	var rerr *RecordError
+	return errors.As(err, &perr) || errors.As(err, &rerr)
+}
+
+// ErrorPolicyFormat is implemented by DataFormats created with the "on_error" option.
+type ErrorPolicyFormat interface {
+	DataFormat
+
+	// Skipped returns the number of malformed records which were skipped.
+	Skipped() int
+
+	// Errors returns the errors for skipped records when the policy is "collect", or nil
+	// otherwise. The channel is closed at the end of input. If it is full, further errors
+	// are counted by Skipped but not sent.
+	Errors() <-chan *RecordError
+}
+
+////////
+
+// errorPolicy wraps a DataFormat to handle malformed records, which are records for which
+// GetFields fails, or for which NextRecord returns a recoverable error. With the "fail" policy
+// the error is returned with the record's position, "skip" ignores the record, and "collect"
+// also sends the error to the Errors channel. Errors which prevent reading any further records
+// are always returned.
+type errorPolicy struct {
+	wrappedFormat
+	Name   string
+	Policy string
+
+	errs     chan *RecordError
+	closed   bool
+	nrecords int
+	skipped  int
+
+	// most recent NextRecord results
+	lastRecord string
+	lastFields map[interface{}]string
+}
+
+// newErrorPolicy wraps df if spec has the "on_error" option.
+func newErrorPolicy(df DataFormat, spec map[string]string) (DataFormat, error) {
+	v, found := spec["on_error"]
+	if !found {
+		return df, nil
+	}
+	if v != "fail" && v != "skip" && v != "collect" {
+		return nil, fmt.Errorf("on_error option must be 'fail', 'skip' or 'collect', not '%s'", v)
+	}
+	f := &errorPolicy{wrappedFormat: wrappedFormat{DataFormat: df}, Name: spec["type"], Policy: v}
+	if v == "collect" {
+		size := 100
+		if b, found := spec["error_buffer"]; found {
+			n, err := strconv.Atoi(b)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("error_buffer option must be a positive integer, not '%s'", b)
+			}
+			size = n
+		}
+		f.errs = make(chan *RecordError, size)
+	}
+	return f, nil
+}
+
+func (f *errorPolicy) Open(r io.Reader) error {
+	f.nrecords, f.skipped = 0, 0
+	f.lastRecord, f.lastFields = "", nil
+	if f.closed {
+		f.errs, f.closed = make(chan *RecordError, cap(f.errs)), false
+	}
+	return f.DataFormat.Open(r)
+}
+
+func (f *errorPolicy) Skipped() int {
+	return f.skipped
+}
+
+func (f *errorPolicy) Errors() <-chan *RecordError {
+	return f.errs
+}
+
+// Schema returns the types of the fields, or nil if the DataFormat has no types.
+func (f *errorPolicy) Schema() *Schema {
+	if s, ok := f.DataFormat.(SchemaFormat); ok {
+		return s.Schema()
+	}
+	return nil
+}
+
+// Ragged returns the number of ragged records, if the DataFormat is a RaggedFormat.
+func (f *errorPolicy) Ragged() int {
+	if rf, ok := f.DataFormat.(RaggedFormat); ok {
+		return rf.Ragged()
+	}
+	return 0
+}
+
+// done closes the Errors channel at the end of input.
+func (f *errorPolicy) done() {
+	if f.errs != nil && !f.closed {
+		close(f.errs)
+		f.closed = true
+	}
+}
+
+// next reads the next well-formed record and its fields.
+func (f *errorPolicy) next() (string, map[interface{}]string, error) {
+	for {
+		rec, err := f.DataFormat.NextRecord()
+		if err == io.EOF || (err != nil && !recoverable(err)) {
+			f.done()
+			return "", nil, err
+		}
+		f.nrecords++
+
+		var fields map[interface{}]string
+		if err == nil {
+			fields, err = f.DataFormat.GetFields(rec)
+			if err == nil {
+				return rec, fields, nil
+			}
+		}
+
+		if err = f.reject(rec, err); err != nil {
+			return "", nil, err
+		}
+	}
+}
+
+// reject handles a malformed record, which is returned as a RecordError with the "fail"
+// policy, and skipped otherwise.
+func (f *errorPolicy) reject(rec string, err error) error {
+	rerr, ok := err.(*RecordError)
+	if !ok {
+		rerr = &RecordError{Err: err}
+	}
+	if rerr.Record == "" {
+		rerr.Record = rec
+	}
+	rerr.Position = f.Position()
+	rerr.RecordNum = f.nrecords
+	if f.Policy == "fail" {
+		return rerr
+	}
+
+	f.skipped++
+	metrics.Add(metrics.RecordsSkipped, 1, "format", f.Name)
+	if f.errs != nil && !f.closed {
+		select {
+		case f.errs <- rerr:
+		default:
+		}
+	}
+	return nil
+}
+
+func (f *errorPolicy) NextRecord() (string, error) {
+	rec, fields, err := f.next()
+	f.lastRecord, f.lastFields = rec, fields
+	return rec, err
+}
+
+func (f *errorPolicy) GetFields(record string) (map[interface{}]string, error) {
+	if f.lastFields != nil && record == f.lastRecord {
+		return f.lastFields, nil
+	}
+	return f.DataFormat.GetFields(record)
+}
+
+func (f *errorPolicy) NextRecordFields() (map[interface{}]string, error) {
+	_, fields, err := f.next()
+	return fields, err
+}
+
+func (f *errorPolicy) NextRecordInto(rec *Record) error {
+	f.lastRecord, f.lastFields = "", nil
+	for {
+		err := f.wrappedFormat.NextRecordInto(rec)
+		if err == io.EOF || (err != nil && !recoverable(err)) {
+			f.done()
+			return err
+		}
+		f.nrecords++
+		if err == nil {
+			return nil
+		}
+		if err = f.reject("", err); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *errorPolicy) NextFieldBytes() ([][]byte, error) {
+	f.lastRecord, f.lastFields = "", nil
+	for {
+		fields, err := f.wrappedFormat.NextFieldBytes()
+		if err == io.EOF || (err != nil && !recoverable(err)) {
+			f.done()
+			return nil, err
+		}
+		f.nrecords++
+		if err == nil {
+			return fields, nil
+		}
+		if err = f.reject("", err); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package formats_test
+
+import (
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	input := "id,score\n1,0.5\n2,oops\n3\n4,0.25\n"
+	spec := map[string]string{"type": "csv", "header": "true", "types": "score:float", "on_error": "collect"}
+	recs := readAllFields(t, spec, input)
+	if len(recs) != 2 || recs[1]["id"] != "4" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	df := openFormat(t, spec, input)
+	for {
+		if _, err := df.NextRecordFields(); err != nil {
+			break
+		}
+	}
+	ep := df.(formats.ErrorPolicyFormat)
+	var lines []int
+	for rerr := range ep.Errors() {
+		lines = append(lines, rerr.Line)
+	}
+	if ep.Skipped() != 2 || len(lines) != 2 || lines[0] != 3 {
+		t.Errorf("unexpected errors: %d skipped on lines %v", ep.Skipped(), lines)
+	}
+
+	spec["on_error"] = "fail"
+	df = openFormat(t, spec, input)
+	df.NextRecordFields()
+	if _, err := df.NextRecordFields(); err == nil || err.Error() != "record 2 (line 3): field 'score': not a number (value 'oops')" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
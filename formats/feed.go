@@ -0,0 +1,169 @@
+package formats
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// feedItem is the structure of an RSS item or Atom entry. The elements which differ between
+// RSS and Atom (e.g. "pubDate" and "published") are all included.
+type feedItem struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"link"`
+	GUID      string `xml:"guid"`
+	ID        string `xml:"id"`
+	PubDate   string `xml:"pubDate"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	DCDate    string `xml:"http://purl.org/dc/elements/1.1/ date"`
+
+	Description string `xml:"description"`
+	Summary     string `xml:"summary"`
+	Content     string `xml:"http://www.w3.org/2005/Atom content"`
+	Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+
+	Authors []struct {
+		Name string `xml:"name"`
+		Text string `xml:",chardata"`
+	} `xml:"author"`
+	Creator    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"category"`
+}
+
+// feedItems reads the items of an RSS (0.9x, 1.0 or 2.0) feed or the entries of an Atom feed.
+// The fields are "feed" (the feed's title), "title", "link", "id", "date" (as RFC 3339),
+// "description", "author" and "categories", with repeated authors and categories joined by
+// tabs. Records are encoded as a JSON object of the fields.
+type feedItems struct {
+	decoder *xml.Decoder
+	title   string
+	depth   int
+}
+
+func (f *feedItems) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *feedItems) Open(r io.Reader) error {
+	f.decoder = xml.NewDecoder(r)
+	f.decoder.Strict = false
+	f.decoder.CharsetReader = charsetReader
+	f.title, f.depth = "", 0
+	return nil
+}
+
+func (f *feedItems) NextRecord() (string, error) {
+	for {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			f.depth--
+		case xml.StartElement:
+			f.depth++
+			switch {
+			case t.Name.Local == "item" || t.Name.Local == "entry":
+				var item feedItem
+				if err = f.decoder.DecodeElement(&item, &t); err != nil {
+					return "", err
+				}
+				f.depth--
+				data, err := json.Marshal(f.fields(&item))
+				if err != nil {
+					return "", err
+				}
+				metrics.Add(metrics.RecordsParsed, 1, "format", "feed")
+				return string(data), nil
+
+			case t.Name.Local == "title" && f.title == "" && f.depth <= 3:
+				// the title of the RSS channel or Atom feed
+				var title string
+				if err = f.decoder.DecodeElement(&title, &t); err != nil {
+					return "", err
+				}
+				f.depth--
+				f.title = strings.TrimSpace(title)
+			}
+		}
+	}
+}
+
+// fields returns the fields of an item, preferring the RSS elements.
+func (f *feedItems) fields(item *feedItem) map[string]string {
+	ret := map[string]string{
+		"feed":        f.title,
+		"title":       strings.TrimSpace(item.Title),
+		"id":          strings.TrimSpace(firstNonEmpty(item.GUID, item.ID)),
+		"description": strings.TrimSpace(firstNonEmpty(item.Description, item.Summary, item.Encoded, item.Content)),
+	}
+
+	for _, l := range item.Links {
+		if link := strings.TrimSpace(firstNonEmpty(l.Text, l.Href)); link != "" && (l.Rel == "" || l.Rel == "alternate") {
+			ret["link"] = link
+			break
+		}
+	}
+
+	date := strings.TrimSpace(firstNonEmpty(item.PubDate, item.Published, item.Updated, item.DCDate))
+	if d, err := mail.ParseDate(date); err == nil {
+		date = d.Format(time.RFC3339)
+	} else if d, err := time.Parse(time.RFC3339, date); err == nil {
+		date = d.Format(time.RFC3339)
+	}
+	ret["date"] = date
+
+	var authors, categories []string
+	for _, a := range item.Authors {
+		authors = append(authors, strings.TrimSpace(firstNonEmpty(a.Name, a.Text)))
+	}
+	for _, c := range item.Creator {
+		authors = append(authors, strings.TrimSpace(c))
+	}
+	for _, c := range item.Categories {
+		categories = append(categories, strings.TrimSpace(firstNonEmpty(c.Term, c.Text)))
+	}
+	ret["author"] = strings.Join(authors, "\t")
+	ret["categories"] = strings.Join(categories, "\t")
+	return ret
+}
+
+// firstNonEmpty returns the first of values which is not empty or whitespace.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (f *feedItems) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *feedItems) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+func (f *feedItems) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,32 @@
+package formats_test
+
+import "testing"
+
+func TestFeed(t *testing.T) {
+	rss := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><channel>
+  <title>Example News</title><link>https://example.com/</link>
+  <item><title>First</title><link>https://example.com/1</link>
+    <pubDate>Mon, 2 Jan 2006 15:04:05 GMT</pubDate><description>One</description>
+    <dc:creator>Alice</dc:creator><category>a</category><category>b</category></item>
+</channel></rss>`
+	atom := `<feed xmlns="http://www.w3.org/2005/Atom"><title>Example Atom</title>
+  <entry><title>Second</title><id>urn:2</id>
+    <link rel="self" href="https://example.com/2.atom"/><link href="https://example.com/2"/>
+    <updated>2006-01-02T15:04:05-07:00</updated><summary>Two</summary>
+    <author><name>Bob</name></author><category term="c"/></entry>
+</feed>`
+	recs := append(readAllFields(t, map[string]string{"type": "feed"}, rss),
+		readAllFields(t, map[string]string{"type": "feed"}, atom)...)
+	if len(recs) != 2 {
+		t.Fatalf("unexpected records: %v", recs)
+	}
+	if r := recs[0]; r["feed"] != "Example News" || r["link"] != "https://example.com/1" ||
+		r["date"] != "2006-01-02T15:04:05Z" || r["author"] != "Alice" || r["categories"] != "a\tb" {
+		t.Errorf("unexpected RSS item: %v", r)
+	}
+	if r := recs[1]; r["feed"] != "Example Atom" || r["link"] != "https://example.com/2" || r["id"] != "urn:2" ||
+		r["date"] != "2006-01-02T15:04:05-07:00" || r["description"] != "Two" || r["author"] != "Bob" {
+		t.Errorf("unexpected Atom entry: %v", r)
+	}
+}
@@ -0,0 +1,287 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// flatFileEntries reads each entry of a GenBank or EMBL flat file as a record, returning the
+// entry text up to and including its "//" terminator. The fields are "locus", "accession",
+// "version", "definition", "organism", "taxonomy", "keywords", "features" and "sequence".
+// Features are returned as a JSON array of objects with "type", "location" and "qualifiers".
+type flatFileEntries struct {
+	Kind string
+
+	reader *bufio.Reader
+}
+
+// flatFileFeature is one entry of a GenBank or EMBL feature table.
+type flatFileFeature struct {
+	Type       string            `json:"type"`
+	Location   string            `json:"location"`
+	Qualifiers map[string]string `json:"qualifiers,omitempty"`
+}
+
+func (f *flatFileEntries) Init(spec map[string]string) error {
+	if f.Kind != "genbank" && f.Kind != "embl" {
+		return fmt.Errorf("unknown flat file format '%s'", f.Kind)
+	}
+	return nil
+}
+
+func (f *flatFileEntries) Open(r io.Reader) error {
+	var err error
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+func (f *flatFileEntries) NextRecord() (string, error) {
+	var entry strings.Builder
+	for {
+		line, err := readLine(f.reader)
+		if err == io.EOF && entry.Len() > 0 {
+			return "", fmt.Errorf("%s format: entry is missing its '//' terminator", f.Kind)
+		}
+		if err != nil {
+			return "", err
+		}
+		if entry.Len() == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry.WriteString(line)
+		entry.WriteByte('\n')
+		if strings.HasPrefix(line, "//") {
+			break
+		}
+	}
+
+	metrics.Add(metrics.RecordsParsed, 1, "format", f.Kind)
+	return entry.String(), nil
+}
+
+func (f *flatFileEntries) GetFields(record string) (map[interface{}]string, error) {
+	ret := map[interface{}]string{
+		"locus": "", "accession": "", "version": "", "definition": "", "organism": "",
+		"taxonomy": "", "keywords": "",
+	}
+	var features []string
+	var seq strings.Builder
+	var err error
+	if f.Kind == "embl" {
+		features, err = parseEMBL(record, ret, &seq)
+	} else {
+		features, err = parseGenBank(record, ret, &seq)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range []string{"definition", "taxonomy", "keywords"} {
+		ret[k] = strings.TrimSpace(ret[k])
+	}
+	ret["taxonomy"] = strings.TrimSuffix(ret["taxonomy"], ".")
+	ret["keywords"] = strings.TrimSuffix(ret["keywords"], ".")
+	ret["sequence"] = seq.String()
+
+	data, err := json.Marshal(parseFeatureTable(features))
+	if err != nil {
+		return nil, err
+	}
+	ret["features"] = string(data)
+	return ret, nil
+}
+
+// parseGenBank extracts fields from a GenBank entry, and returns the lines of its feature table.
+func parseGenBank(record string, ret map[interface{}]string, seq *strings.Builder) ([]string, error) {
+	if !strings.HasPrefix(record, "LOCUS") {
+		return nil, fmt.Errorf("genbank format: expected 'LOCUS' at start of entry")
+	}
+	var features []string
+	section := ""
+	for _, line := range strings.Split(record, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "//") {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		if section == "ORIGIN" {
+			appendSequence(seq, line)
+			continue
+		}
+
+		content := ""
+		if len(line) > 12 {
+			content = strings.TrimSpace(line[12:])
+		}
+		if line[0] != ' ' {
+			section = strings.Fields(line)[0]
+			first := ""
+			if parts := strings.Fields(content); len(parts) > 0 {
+				first = parts[0]
+			}
+			switch section {
+			case "LOCUS":
+				ret["locus"] = first
+			case "ACCESSION":
+				ret["accession"] = first
+			case "VERSION":
+				ret["version"] = first
+			case "DEFINITION", "KEYWORDS":
+				ret[strings.ToLower(section)] = content
+			}
+			continue
+		}
+
+		switch {
+		case section == "FEATURES":
+			features = append(features, line)
+		case strings.HasPrefix(line, "  ORGANISM"):
+			ret["organism"] = content
+			section = "ORGANISM"
+		case len(line) > 2 && line[2] != ' ':
+			// other sub-keywords, e.g. AUTHORS within a REFERENCE
+			section = strings.Fields(line)[0]
+		case section == "DEFINITION", section == "KEYWORDS":
+			k := strings.ToLower(section)
+			ret[k] += " " + strings.TrimSpace(line)
+		case section == "ORGANISM":
+			ret["taxonomy"] += " " + strings.TrimSpace(line)
+		}
+	}
+	return features, nil
+}
+
+// parseEMBL extracts fields from an EMBL entry, and returns the lines of its feature table in
+// the same column layout as GenBank.
+func parseEMBL(record string, ret map[interface{}]string, seq *strings.Builder) ([]string, error) {
+	if !strings.HasPrefix(record, "ID") {
+		return nil, fmt.Errorf("embl format: expected 'ID' at start of entry")
+	}
+	var features []string
+	for _, line := range strings.Split(record, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "//") {
+			break
+		}
+		if len(line) < 2 {
+			continue
+		}
+		content := ""
+		if len(line) > 5 {
+			content = strings.TrimSpace(line[5:])
+		}
+
+		switch line[:2] {
+		case "ID":
+			parts := strings.Split(content, ";")
+			ret["locus"] = strings.TrimSpace(parts[0])
+			if len(parts) > 1 && strings.HasPrefix(strings.TrimSpace(parts[1]), "SV ") {
+				ret["version"] = ret["locus"] + "." + strings.TrimSpace(strings.TrimSpace(parts[1])[3:])
+			}
+		case "AC":
+			if ret["accession"] == "" {
+				ret["accession"] = strings.TrimSpace(strings.Split(content, ";")[0])
+			}
+		case "SV":
+			ret["version"] = content
+		case "DE":
+			ret["definition"] += " " + content
+		case "OS":
+			if ret["organism"] == "" {
+				ret["organism"] = content
+			}
+		case "OC":
+			ret["taxonomy"] += " " + content
+		case "KW":
+			ret["keywords"] += " " + content
+		case "FT":
+			features = append(features, "  "+line[2:])
+		case "  ":
+			appendSequence(seq, line)
+		}
+	}
+	return features, nil
+}
+
+// appendSequence adds the residues from a sequence line, skipping positions and spaces.
+func appendSequence(seq *strings.Builder, line string) {
+	for _, c := range line {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*' || c == '-' {
+			seq.WriteRune(c)
+		}
+	}
+}
+
+// parseFeatureTable parses feature table lines, where feature keys begin in column 6 and
+// locations and qualifiers begin in column 22.
+func parseFeatureTable(lines []string) []flatFileFeature {
+	features := []flatFileFeature{}
+	var names, values [][]string // qualifiers of each feature, in order
+	for _, line := range lines {
+		if len(line) <= 5 || strings.HasPrefix(line, "FEATURES") {
+			continue
+		}
+		content := strings.TrimSpace(line)
+		if line[5] != ' ' {
+			parts := strings.Fields(content)
+			features = append(features, flatFileFeature{Type: parts[0], Location: strings.Join(parts[1:], "")})
+			names, values = append(names, nil), append(values, nil)
+			continue
+		}
+		n := len(features) - 1
+		if n < 0 {
+			continue
+		}
+		if strings.HasPrefix(content, "/") {
+			kv := strings.SplitN(content[1:], "=", 2)
+			names[n] = append(names[n], kv[0])
+			values[n] = append(values[n], strings.Join(kv[1:], ""))
+			continue
+		}
+		q := len(names[n]) - 1
+		switch {
+		case q < 0:
+			features[n].Location += content
+		case names[n][q] == "translation":
+			values[n][q] += content
+		default:
+			values[n][q] += " " + content
+		}
+	}
+
+	for i := range features {
+		for j, k := range names[i] {
+			v := values[i][j]
+			if len(v) > 1 && strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
+				v = strings.Replace(v[1:len(v)-1], `""`, `"`, -1)
+			}
+			if features[i].Qualifiers == nil {
+				features[i].Qualifiers = make(map[string]string)
+			}
+			if prev, found := features[i].Qualifiers[k]; found {
+				v = prev + "," + v
+			}
+			features[i].Qualifiers[k] = v
+		}
+	}
+	return features
+}
+
+func (f *flatFileEntries) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *flatFileEntries) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,84 @@
+package formats_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFlatFileFormats(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "genbank"}, `LOCUS       NM_000546               2512 bp    mRNA    linear   PRI 24-MAR-2024
+DEFINITION  Homo sapiens tumor protein p53 (TP53), transcript variant 1,
+            mRNA.
+ACCESSION   NM_000546 XM_005256849
+VERSION     NM_000546.6
+KEYWORDS    RefSeq; MANE Select.
+SOURCE      Homo sapiens (human)
+  ORGANISM  Homo sapiens
+            Eukaryota; Metazoa; Chordata; Craniata; Vertebrata; Euteleostomi;
+            Mammalia; Primates; Haplorrhini; Catarrhini; Hominidae; Homo.
+FEATURES             Location/Qualifiers
+     source          1..2512
+                     /organism="Homo sapiens"
+                     /db_xref="taxon:9606"
+     CDS             join(143..250,
+                     251..1324)
+                     /gene="TP53"
+                     /note="tumor protein p53; ""p53"" antigen
+                     NY-CO-13"
+                     /db_xref="CCDS:CCDS11118.1"
+                     /db_xref="GeneID:7157"
+                     /translation="MEEPQSDPSV
+                     EPPLSQETFS"
+ORIGIN      
+        1 ctcaaaagtc tagagccacc
+       21 gtccaggg
+//
+`)
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 entry, got %v", recs)
+	}
+	rec := recs[0]
+	if rec["accession"] != "NM_000546" || rec["version"] != "NM_000546.6" || rec["organism"] != "Homo sapiens" ||
+		rec["definition"] != "Homo sapiens tumor protein p53 (TP53), transcript variant 1, mRNA." ||
+		!strings.HasPrefix(rec["taxonomy"], "Eukaryota; Metazoa;") || !strings.HasSuffix(rec["taxonomy"], "; Homo") ||
+		rec["keywords"] != "RefSeq; MANE Select" || rec["sequence"] != "ctcaaaagtctagagccaccgtccaggg" {
+		t.Errorf("unexpected genbank fields: %v", rec)
+	}
+	var features []struct {
+		Type       string
+		Location   string
+		Qualifiers map[string]string
+	}
+	if err := json.Unmarshal([]byte(rec["features"]), &features); err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || features[1].Location != "join(143..250,251..1324)" ||
+		features[1].Qualifiers["note"] != `tumor protein p53; "p53" antigen NY-CO-13` ||
+		features[1].Qualifiers["db_xref"] != "CCDS:CCDS11118.1,GeneID:7157" ||
+		features[1].Qualifiers["translation"] != "MEEPQSDPSVEPPLSQETFS" {
+		t.Errorf("unexpected features: %+v", features)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "embl"}, `ID   X56734; SV 1; linear; mRNA; STD; PLN; 1859 BP.
+XX
+AC   X56734; S46826;
+XX
+DE   Trifolium repens mRNA for non-cyanogenic beta-glucosidase
+OS   Trifolium repens (white clover)
+OC   Eukaryota; Viridiplantae;
+OC   Trifolium.
+FH   Key             Location/Qualifiers
+FT   CDS             14..1495
+FT                   /product="beta-glucosidase"
+SQ   Sequence 1859 BP; 609 A; 314 C; 355 G; 581 T; 0 other;
+     aaacaaacca aatatggatt ttattgtagc                                     30
+//
+`)
+	rec = recs[0]
+	if rec["accession"] != "X56734" || rec["version"] != "X56734.1" || rec["organism"] != "Trifolium repens (white clover)" ||
+		rec["taxonomy"] != "Eukaryota; Viridiplantae; Trifolium" || rec["sequence"] != "aaacaaaccaaatatggattttattgtagc" ||
+		rec["features"] != `[{"type":"CDS","location":"14..1495","qualifiers":{"product":"beta-glucosidase"}}]` {
+		t.Errorf("unexpected embl fields: %v", rec)
+	}
+}
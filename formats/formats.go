@@ -7,42 +7,330 @@
 // configurable options are:
 //
 //    "tab-delimited"
-//       Tab ("\t") separated fields and newline ("\n") separated records. No quotes,
-//       escapes, or comments are supported. A future implementation will be optimized.
-//       No configurable options.
+//       Tab ("\t") separated fields and newline ("\n") separated records. No quotes or
+//       escapes are supported. This is the fastest format, and also implements
+//       FieldBytesFormat to return fields without allocating.
+//       Options: "header"      = "true" to read column names from the first row, and key
+//                                fields by name instead of 0-based index (default "false")
+//                "skip_lines"  = number of lines to skip before the header or first record
+//                "comments"    = prefix of comment lines to ignore, e.g. "#" (default none)
+//                "skip_footer" = number of trailing records to drop, e.g. summary lines
 //
 //    "simple-delimited"
-//       A simple format with string-delimited records and fields. No quotes, escapes,
-//       or comments are supported.
+//       A simple format with string-delimited records and fields. No quotes or escapes
+//       are supported.
 //       Options: "fields" = the field separator string (default "\t")
 //                "records = the record separator string (default "\n")
+//                "header", "skip_lines", "comments" and "skip_footer" as for "tab-delimited"
 //
 //    "xml"
 //       A format providing simplified XML parsing (similar to the field tagging provided
-//       by encoding/xml). It supports both UTF-8 and ISO8859-1 encoded XML.
-//       Options: "records" = required comma-delimited list of container XML tags to enumerate
+//       by encoding/xml). It supports UTF-8, UTF-16 and the other IANA-registered charsets
+//       (e.g. ISO-8859-1, ISO-8859-15 and Windows-1252). Fields are keyed by element path
+//       within the record, e.g. "Article>Title".
+//       Options: "records"    = required comma-delimited list of container XML tags to enumerate,
+//                               which may have a namespace prefix, e.g. "atom:entry"
+//                "namespaces" = comma-delimited list of prefix=URI namespaces for the records,
+//                               e.g. "atom=http://www.w3.org/2005/Atom" (default: the prefixes
+//                               declared in the document)
+//                "attributes" = "true" to also return attributes as fields, keyed by element
+//                               path and attribute name, e.g. "Article>Title@lang"
+//                "repeated"   = "join" to join the text of repeated elements into a single
+//                               field (default), or "index" to key each by its 0-based
+//                               position, e.g. "Article>Author[0]>Name"
+//                "join"       = the separator for joined values (default "\t")
+//
+//    "json"
+//       Records are the objects in an array within a larger JSON document, which is
+//       streamed rather than loaded into memory. Nested objects are flattened into
+//       dot-path field names (e.g. "author.name"), and arrays are returned as JSON.
+//       Options: "records" = dot-path to the array of records, e.g. "data.items" or
+//                            "$.results[0].rows[*]" (default: the top-level values,
+//                            expanding arrays, which also reads JSON lines)
+//                "null"    = the string used for null values (default "")
+//
+//    "yaml"
+//       Each document in a multi-document YAML stream is a record, or each item if a
+//       document is a list. Fields are flattened as for the "json" format, with anchors
+//       and merge keys resolved.
+//       Options: "null" = the string used for null values (default "")
+//
+//    "fasta" and "fastq"
+//       Biological sequence files, with one record per sequence and the fields "id",
+//       "description", "sequence" and (for FASTQ) "quality". Wrapped lines are joined,
+//       and gzip-compressed input is detected automatically. No configurable options.
+//
+//    "gff3", "gtf" and "bed"
+//       Genomic feature annotations, with fields keyed by the column names in each
+//       specification (e.g. "seqid", "start" and "end" for GFF3, or "chrom", "chromStart"
+//       and "chromEnd" for BED). Tags in the GFF3 and GTF attributes column are also
+//       returned as fields (e.g. "ID" or "gene_id"), with repeated tags joined by commas.
+//       Comments and track lines are skipped. No configurable options.
+//
+//    "genbank" and "embl"
+//       Entries of GenBank or EMBL flat files, with the fields "locus", "accession",
+//       "version", "definition", "organism", "taxonomy", "keywords", "features" and
+//       "sequence". Features are a JSON array of objects with "type", "location" and
+//       "qualifiers". No configurable options.
+//
+//    "access-log"
+//       Web server access logs in the Common or Combined Log Format (e.g. Apache and
+//       Nginx), with the fields "host", "ident", "user", "time", "request", "method",
+//       "path", "protocol", "status", "bytes", "referer" and "user_agent". Times are
+//       converted to RFC 3339. No configurable options.
+//
+//    "syslog"
+//       Syslog messages in RFC 5424 or traditional BSD (RFC 3164) format, with the fields
+//       "priority", "facility", "severity", "time", "host", "app", "pid", "msgid",
+//       "structured_data" and "message". No configurable options.
+//
+//    "multiline"
+//       Records spanning several lines (e.g. stack traces), where each record begins with
+//       a line matching a pattern. Fields are the lines of the record, keyed by 0-based index.
+//       Options: "record_start" = required regular expression matching the first line
+//                                 of each record, e.g. "^[0-9]{4}-[0-9]{2}-[0-9]{2} "
+//                "pattern"      = a regular expression to extract fields from the whole
+//                                 record, as for the "regex" format (use "(?s)" for
+//                                 "." to match newlines)
+//
+//    "regex"
+//       Fields are extracted from each line by the capture groups of a regular expression,
+//       keyed by group name, or by group number (from 1) for unnamed groups.
+//       Options: "pattern"   = required regular expression, e.g.
+//                              "^(?P<date>[^ ]+) (?P<level>[A-Z]+) (?P<message>.*)$"
+//                "unmatched" = "skip" to ignore lines which do not match (default), or
+//                              "error" to stop with an error
+//
+//    "arff"
+//       The data section of a Weka ARFF file (dense or sparse), with fields keyed by the
+//       attribute names declared in its header. Missing values ("?") are returned as "".
+//       No configurable options.
+//
+//    "libsvm"
+//       Sparse LIBSVM / SVMlight lines, e.g. "+1 qid:3 1:0.43 7:0.12". The label is keyed
+//       as "label", the query id as "qid", and features by their integer index. No
+//       configurable options.
+//
+//    "ini"
+//       Each section of an INI file is a record, with the section name in the "_section"
+//       field (SectionField) and its keys as the other fields. Keys before the first section
+//       header are in the section "". Indented lines continue the previous value.
+//       Options: "comments" = characters which start a comment line (default ";#")
+//
+//    "toml"
+//       Each table of a TOML document is a record, as for the "ini" format. Nested tables
+//       are named by their dotted path, and each element of an array of tables is a record.
+//       Arrays are returned as JSON. No configurable options.
+//
+//    "xlsx"
+//       Rows of a worksheet in an Excel workbook (.xlsx), which is read into memory.
+//       Legacy .xls workbooks are not supported. Records are JSON arrays of cell values.
+//       Options: "sheet" = the sheet name, or 1-based sheet number (default the first)
+//                "cells" = "typed" to show booleans as true/false, dates as "2006-01-02"
+//                          and numbers to 15 significant digits (default), or "raw" to
+//                          return the values exactly as stored
+//                "header" and "skip_lines" as for "tab-delimited"
+//
+//    "dbf"
+//       Records of a dBase / xBase table (.dbf), such as a shapefile's attribute table,
+//       keyed by column name. Dates are returned as "2006-01-02", logicals as "true" or
+//       "false", and numbers without padding. Deleted records are skipped. Character
+//       fields are decoded using the table's code page, unless "charset" is given.
+//       Options: "memo" = "block" to return the block numbers of memo fields, which are
+//                         stored in a separate .dbt or .fpt file (default), or "skip"
+//                         to omit them
+//
+//    "marc" and "marcxml"
+//       MARC 21 library records (e.g. from the Library of Congress or OCLC), in the binary
+//       ISO 2709 exchange format or as MARCXML. Fields are keyed by tag and subfield code,
+//       e.g. "245$a", with control fields keyed by tag (e.g. "001") and the leader as
+//       "leader". Data fields are also keyed by tag, with their subfields joined by spaces,
+//       and their indicators are keyed as "245@ind1" and "245@ind2". Records are returned
+//       in the ISO 2709 format by NextRecord. MARC-8 encoded records are not converted.
+//       Options: "repeated" and "join" as for "xml", e.g. "650[0]$a" with "index"
+//
+//    "mbox" and "eml"
+//       Email messages, from an mbox file (e.g. a mailing-list archive) or a single .eml
+//       message. Headers are keyed by their canonical names (e.g. "Subject" and
+//       "Message-Id"), with encoded words decoded and the "Date" converted to RFC 3339.
+//       The text body is in the "_body" field (BodyField), decoded to UTF-8 from the first
+//       text/plain part of the message. No configurable options.
+//
+//    "feed"
+//       The items of an RSS feed or the entries of an Atom feed, with the fields "feed" (the
+//       feed's title), "title", "link", "id", "date" (converted to RFC 3339), "description",
+//       "author" and "categories". Repeated authors and categories are joined by tabs.
+//       No configurable options.
+//
+//    "obo"
+//       The stanzas of an OBO ontology (e.g. the Gene Ontology), one record per stanza. The
+//       stanza type is in the "_section" field (SectionField), and its tags are the other
+//       fields, with repeated tags (e.g. "is_a") joined by tabs. Trailing "!" comments are
+//       removed, and "def" is unquoted without its cross-references.
+//       Options: "stanzas" = comma-separated stanza types to read (default "Term")
+//
+//    "owl"
+//       The named classes of an OWL ontology in RDF/XML, with the fields "id" (the class
+//       IRI), "label" and "parents" (the IRIs of its named superclasses, joined by tabs).
+//       No configurable options.
+//
+//    "pdb"
+//       The lines of a PDB structure file with the selected record types, which is in the
+//       "record" field. ATOM, HETATM and HEADER records are split into their columns, keyed
+//       by the names used in the PDB specification (e.g. "resName", "x" and "tempFactor"),
+//       and other records have their text after the record type in the "text" field.
+//       Options: "records" = comma-separated record types to read (default "ATOM,HETATM")
+//
+//    "mmcif"
+//       The rows of a category table from an mmCIF structure file, keyed by item name
+//       without the category (e.g. "Cartn_x"). Unknown ("?") and inapplicable (".") values
+//       are empty, and a category which is not in a loop is a single record.
+//       Options: "category" = the category to read (default "_atom_site")
 //
 //    "csv" (WIP)
 //       A format providing RFC 4180 parsing (as provided by encoding/csv). It supports
 //       quotes, escapes, and line-based comments.
-//       Options: "fields"     = the field separator character (default ",")
+//       Options: "fields"     = the field separator, which may be more than one character,
+//                               e.g. "||" (default ",")
 //                "comments"   = the comment start character (default none)
 //                "num_fields" = integer number of fields per record for verification
 //                               (default none = infer from first record)
+//                "quotes"     = "strict" to require RFC 4180 quoting (default), "lazy" to
+//                               allow bare quotes within fields, or "relaxed" to also
+//                               treat unterminated quotes as text, so that records never
+//                               span lines
+//                "trim_leading_space" = "true" to ignore leading whitespace in fields
+//                "header" and "skip_lines" as for "tab-delimited"
 //
 //    "fixed" (WIP)
 //       A simple fixed-width format where fields start at pre-defined character column
 //       boundaries and records are separated by newlines ("\n").
-//       Options: "offsets" = Comma-separated string list of 0-based string offsets.
+//       Columns are given by one of "offsets", "widths" or "columns".
+//       Options: "offsets"     = Comma-separated list of increasing 0-based string offsets.
+//                "widths"      = Comma-separated list of column widths.
+//                "names"       = Comma-separated list of names for the columns given by
+//                                "offsets" or "widths" (default 0-based column index)
+//                "columns"     = Comma-separated list of named columns with 0-based start
+//                                and exclusive end offsets, e.g. "id:0-8,name:8-40,notes:40-",
+//                                or with "offsets" or "widths", the field keys to return
+//                "trim"        = "true" to trim whitespace from every field, or a comma-
+//                                separated list of the names of the fields to trim
+//                "short_lines" = "pad" to return truncated or empty fields for lines which
+//                                end before the last column (default), "skip" to ignore
+//                                them, or "error" to stop with an error
+//
+//    "sql"
+//       Query results from anydata's database fetchers (e.g. postgres:// and mysql://
+//       resources), one JSON array per line after a header line of column names.
+//       Options: "keys" = "names" to key fields by column name (default), or "index"
+//                         to key them by 0-based column number
+//                "null" = the string used for NULL values (default "")
+//
+// Every format except "xlsx" accepts a "charset" option to decode its input to UTF-8 before
+// parsing (for "dbf", only the character fields are decoded). A byte order mark at the start of
+// the input is removed, and takes precedence over the charset, so "utf-8" can also be used to
+// remove a UTF-8 byte order mark:
+//
+//       Options: "charset" = the input's character encoding, as an IANA name or WHATWG label,
+//                            e.g. "UTF-16LE", "Windows-1252", "Shift_JIS" or "latin1"
+//
+// Every format also accepts options to type its fields. Typed values are checked and normalized
+// into a canonical form (e.g. "007" to "7" for an int), and the returned DataFormat implements
+// SchemaFormat to describe the field types:
+//
+//       Options: "types"       = comma-separated list of name:type declarations, where the
+//                                type is "int", "float", "bool", "date" or "string", e.g.
+//                                "0:int,added:date"
+//                "infer_types" = number of records to read when Open is called to infer
+//                                the types of the fields which are not declared
+//
+// Every format accepts a "columns" option to return only some of the fields of each record
+// ("fixed" only along with "offsets" or "widths"). The "tab-delimited" and "simple-delimited"
+// formats stop splitting each record after the last selected column, which is much faster for
+// wide files, "fixed" only slices the selected columns, and "csv" and "xlsx" only key the
+// selected columns:
+//
+//       Options: "columns" = comma-separated list of field keys, e.g. "0,3,7", or column
+//                            names for formats with a "header" row
+//
+// Formats with variable fields (e.g. "tab-delimited" with ragged lines, or "json") can be
+// normalized to return the same fields for every record. Ragged records are counted, and the
+// returned DataFormat implements RaggedFormat:
+//
+//       Options: "fixed_fields" = the number of fields (keyed from 0), or a comma-separated
+//                                 list of field keys, e.g. "id,name,2"
+//                "ragged"       = "fix" to add missing fields with empty values and remove
+//                                 undeclared fields (default), or "error" to treat ragged
+//                                 records as malformed records (see "on_error" below)
+//
+// Malformed records (e.g. lines with the wrong number of CSV fields, or values which do not
+// match their declared types) normally stop reading with an error. Every format accepts options
+// to handle them instead, and the returned DataFormat implements ErrorPolicyFormat:
+//
+//       Options: "on_error"     = "fail" to stop with an error which includes the record
+//                                 number and line, "skip" to skip malformed records and
+//                                 count them, or "collect" to also send each error to
+//                                 the Errors channel
+//                "error_buffer" = the size of the Errors channel (default 100)
+//
+// The line-based formats (e.g. "tab-delimited", "csv", "fixed" and "regex") also implement
+// Positioner, which returns the line number and byte offset where the most recent record
+// begins, so that errors and outputs can be traced back to the input.
+//
+// Their records can also be read without scanning the whole input: BuildIndex records the
+// offset of each record (and optionally the records with each value of a field) in an Index,
+// which can be saved next to a cached file, and Index.Seek and Index.Find jump directly to a
+// record number or to the records with a given value.
+//
+// The line-based formats read records of up to 64MB by default. Longer records stop reading
+// with an error, and the limits can be changed with:
+//
+//       Options: "buffer_size"      = initial size of the read buffer in bytes, which grows
+//                                     as needed for longer records (default 65536)
+//                "max_record_bytes" = maximum length of a record in bytes (default 67108864)
+//
+// Large inputs in the line-based formats can be parsed on several cores with a ParallelReader,
+// which splits the input into chunks of whole lines and parses them with separate DataFormats.
+//
+// Records can also be read into a reusable Record with ReadRecord, which avoids allocating a
+// map for every record when the DataFormat implements RecordFormat (e.g. "tab-delimited").
+// Record.Map and Record.SetMap convert to and from the map representation.
+//
+// When the format of an input is not known in advance, Detect guesses a specification from a
+// sample of it, including the delimiter, quoting and header row of delimited text.
 //
 // To support new data formats, simply implement the DataFormat interface and call
 // RegisterFormat before using GetDataFormat.
 //
+// Records can be written back out with a DataSink, which is configured in the same way using
+// GetDataSink. The built-in sinks are:
+//
+//    "csv" and "tab-delimited"
+//       Options: "fields"  = the field separator character (default "," or "\t")
+//                "header"  = "true" to write a header row of column names
+//                "columns" = comma-separated list of the fields to write, in order
+//                            (default the fields of the first record, with 0-based
+//                            indexes first and then names in sorted order)
+//
+//    "jsonl"
+//       JSON Lines, with each record written as an object on its own line.
+//       No configurable options.
+//
+//    "sqlite"
+//       Inserts records into a SQLite table, which is created if needed with a TEXT
+//       column per field. A database/sql driver must be imported by the program.
+//       Options: "database"   = required data source name, e.g. "records.db"
+//                "table"      = required table name
+//                "driver"     = the database/sql driver name (default "sqlite3")
+//                "columns"    = as for "csv"
+//                "batch_size" = number of records per transaction (default 10000)
+//
 package formats
 
 import (
 	"fmt"
 	"io"
+	"sync"
 )
 
 // DataFormat represents a format which can be used to transfer data from providers.
@@ -73,30 +361,120 @@ type DataFormat interface {
 // DataFormatGetter returns an instance of a DataFormat
 type DataFormatGetter func() DataFormat
 
-var (
-	formats = make(map[string]DataFormatGetter)
-)
+// Registry holds a set of named DataFormats and DataSinks. Most programs can use the package-level functions,
+// which operate on DefaultRegistry, but libraries may create their own Registry to avoid
+// sharing format definitions with other users of the package.
+type Registry struct {
+	mu      sync.RWMutex
+	formats map[string]DataFormatGetter
+	sinks   map[string]DataSinkGetter
+}
 
-// GetDataFormat uses spec["type"] to search registered DataFormats. If a match is found,
-// (DataFormat).Init(spec) is called to initialize it before returning.
-func GetDataFormat(spec map[string]string) (DataFormat, error) {
-	if dfg, found := formats[spec["type"]]; found {
+// DefaultRegistry is used by GetDataFormat and RegisterFormat, and contains all the built-in
+// DataFormats.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty Registry. Call RegisterDefaults to add the built-in DataFormats.
+func NewRegistry() *Registry {
+	return &Registry{formats: make(map[string]DataFormatGetter), sinks: make(map[string]DataSinkGetter)}
+}
+
+// GetDataFormat uses spec["type"] to search the registered DataFormats. If a match is found,
+// (DataFormat).Init(spec) is called to initialize it before returning. Any error from Init
+// is returned, since it indicates an invalid spec.
+func (r *Registry) GetDataFormat(spec map[string]string) (DataFormat, error) {
+	r.mu.RLock()
+	dfg, found := r.formats[spec["type"]]
+	r.mu.RUnlock()
+
+	if found {
 		df := dfg()
-		df.Init(spec)
-		return df, nil
+		if err := df.Init(spec); err != nil {
+			return nil, err
+		}
+		cf, err := newCharsetFormat(df, spec)
+		if err != nil {
+			return nil, err
+		}
+		pf, err := newColumnsFormat(cf, spec)
+		if err != nil {
+			return nil, err
+		}
+		rf, err := newRaggedFormat(pf, spec)
+		if err != nil {
+			return nil, err
+		}
+		tf, err := newTypedFormat(rf, spec)
+		if err != nil {
+			return nil, err
+		}
+		return newErrorPolicy(tf, spec)
 	}
 	return nil, fmt.Errorf("no format matches type '%s'", spec["type"])
 }
 
-// RegisterFormat adds the named DataFormat to the search list for GetDataFormat
+// RegisterFormat adds the named DataFormat to the search list for r.GetDataFormat
+func (r *Registry) RegisterFormat(name string, dfg DataFormatGetter) {
+	r.mu.Lock()
+	r.formats[name] = dfg
+	r.mu.Unlock()
+}
+
+// RegisterDefaults adds the built-in DataFormats and DataSinks to r.
+func (r *Registry) RegisterDefaults() {
+	r.RegisterFormat("tab-delimited", func() DataFormat { return &tabDelimited{} })
+	r.RegisterFormat("simple-delimited", func() DataFormat { return &simpleDelimited{} })
+	r.RegisterFormat("csv", func() DataFormat { return &commaSeparated{} })
+	r.RegisterFormat("fixed", func() DataFormat { return &fixedWidth{} })
+	r.RegisterFormat("xml", func() DataFormat { return &genericXMLFormat{} })
+	r.RegisterFormat("sql", func() DataFormat { return &sqlRows{} })
+	r.RegisterFormat("json", func() DataFormat { return &jsonRecords{} })
+	r.RegisterFormat("xlsx", func() DataFormat { return &xlsxSheet{} })
+	r.RegisterFormat("dbf", func() DataFormat { return &dbfTable{} })
+	r.RegisterFormat("marc", func() DataFormat { return &marcRecords{} })
+	r.RegisterFormat("marcxml", func() DataFormat { return &marcXMLRecords{} })
+	r.RegisterFormat("mbox", func() DataFormat { return &mboxMessages{} })
+	r.RegisterFormat("eml", func() DataFormat { return &emlMessage{} })
+	r.RegisterFormat("feed", func() DataFormat { return &feedItems{} })
+	r.RegisterFormat("obo", func() DataFormat { return &oboStanzas{} })
+	r.RegisterFormat("owl", func() DataFormat { return &owlClasses{} })
+	r.RegisterFormat("pdb", func() DataFormat { return &pdbRecords{} })
+	r.RegisterFormat("mmcif", func() DataFormat { return &mmcifTables{} })
+	r.RegisterFormat("yaml", func() DataFormat { return &yamlDocuments{} })
+	r.RegisterFormat("ini", func() DataFormat { return &iniSections{} })
+	r.RegisterFormat("toml", func() DataFormat { return &tomlTables{} })
+	r.RegisterFormat("fasta", func() DataFormat { return &fastaSequences{} })
+	r.RegisterFormat("fastq", func() DataFormat { return &fastqSequences{} })
+	r.RegisterFormat("gff3", func() DataFormat { return &genomicAnnotation{Kind: "gff3"} })
+	r.RegisterFormat("gtf", func() DataFormat { return &genomicAnnotation{Kind: "gtf"} })
+	r.RegisterFormat("bed", func() DataFormat { return &genomicAnnotation{Kind: "bed"} })
+	r.RegisterFormat("genbank", func() DataFormat { return &flatFileEntries{Kind: "genbank"} })
+	r.RegisterFormat("embl", func() DataFormat { return &flatFileEntries{Kind: "embl"} })
+	r.RegisterFormat("access-log", func() DataFormat { return &accessLog{} })
+	r.RegisterFormat("syslog", func() DataFormat { return &syslogMessages{} })
+	r.RegisterFormat("multiline", func() DataFormat { return &multiLine{} })
+	r.RegisterFormat("regex", func() DataFormat { return &regexLines{} })
+	r.RegisterFormat("arff", func() DataFormat { return &arffData{} })
+	r.RegisterFormat("libsvm", func() DataFormat { return &libsvmData{} })
+
+	r.RegisterSink("csv", func() DataSink { return &csvSink{FieldDelim: ","} })
+	r.RegisterSink("tab-delimited", func() DataSink { return &csvSink{FieldDelim: "\t"} })
+	r.RegisterSink("jsonl", func() DataSink { return &jsonLinesSink{} })
+	r.RegisterSink("sqlite", func() DataSink { return &sqliteSink{} })
+}
+
+// GetDataFormat uses spec["type"] to search the DefaultRegistry. If a match is found,
+// (DataFormat).Init(spec) is called to initialize it before returning. Any error from Init
+// is returned, since it indicates an invalid spec.
+func GetDataFormat(spec map[string]string) (DataFormat, error) {
+	return DefaultRegistry.GetDataFormat(spec)
+}
+
+// RegisterFormat adds the named DataFormat to the DefaultRegistry search list for GetDataFormat
 func RegisterFormat(name string, dfg DataFormatGetter) {
-	formats[name] = dfg
+	DefaultRegistry.RegisterFormat(name, dfg)
 }
 
 func init() {
-	RegisterFormat("tab-delimited", func() DataFormat { return &simpleDelimited{FieldDelim: "\t", RecordDelim: "\n", rdLen: 1} })
-	RegisterFormat("simple-delimited", func() DataFormat { return &simpleDelimited{} })
-	RegisterFormat("csv", func() DataFormat { return &commaSeparated{} })
-	RegisterFormat("fixed", func() DataFormat { return &fixedWidth{} })
-	RegisterFormat("xml", func() DataFormat { return &genericXMLFormat{} })
+	DefaultRegistry.RegisterDefaults()
 }
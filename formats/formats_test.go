@@ -0,0 +1,63 @@
+package formats_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+// badSpec is a DataFormat which rejects every spec.
+type badSpec struct {
+	formats.DataFormat
+}
+
+var errBadSpec = errors.New("bad spec")
+
+func (badSpec) Init(spec map[string]string) error {
+	return errBadSpec
+}
+
+func TestGetDataFormat(t *testing.T) {
+	r := formats.NewRegistry()
+	r.RegisterFormat("bad", func() formats.DataFormat { return badSpec{} })
+
+	if _, err := r.GetDataFormat(map[string]string{"type": "bad"}); err != errBadSpec {
+		t.Errorf("expected the error from Init, got %v", err)
+	}
+	if _, err := r.GetDataFormat(map[string]string{"type": "csv"}); err == nil {
+		t.Error("expected an error for a format which is not registered")
+	}
+}
+
+// openFormat returns the DataFormat for spec, opened to read input.
+func openFormat(t *testing.T, spec map[string]string, input string) formats.DataFormat {
+	t.Helper()
+	df, err := formats.GetDataFormat(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = df.Open(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+// readAllFields returns the fields of every record in input.
+func readAllFields(t *testing.T, spec map[string]string, input string) []map[interface{}]string {
+	t.Helper()
+	df := openFormat(t, spec, input)
+	var recs []map[interface{}]string
+	for {
+		rec, err := df.NextRecordFields()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+}
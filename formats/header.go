@@ -0,0 +1,139 @@
+package formats
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// headerRow holds the "header", "skip_lines" and "columns" options of the delimited formats,
+// and the column names read from the header row. When Columns is set, only those columns are
+// returned, and the formats stop splitting each record after the last of them.
+type headerRow struct {
+	Header    bool
+	SkipLines int
+	Columns   []interface{}
+	names     []string
+	selected  []int // indexes of the Columns, once the header row is read
+}
+
+func (h *headerRow) init(spec map[string]string) error {
+	h.Header = false
+	h.SkipLines = 0
+	h.Columns = nil
+	h.names, h.selected = nil, nil
+
+	if v, found := spec["header"]; found {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("header option must be true or false, not '%s'", v)
+		}
+		h.Header = b
+	}
+	if v, found := spec["skip_lines"]; found {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("skip_lines option must be a non-negative integer, not '%s'", v)
+		}
+		h.SkipLines = n
+	}
+	if v, found := spec["columns"]; found {
+		h.Columns = parseFieldKeys(v)
+		for _, c := range h.Columns {
+			if _, ok := c.(string); ok && !h.Header {
+				return fmt.Errorf("columns option can only name columns with a header row, not '%s'", c)
+			}
+		}
+	}
+	return nil
+}
+
+// setNames sets the column names from the header row, or nil if there is none, and finds the
+// indexes of the Columns.
+func (h *headerRow) setNames(names []string) error {
+	h.names, h.selected = names, nil
+	if h.Columns == nil {
+		return nil
+	}
+	h.selected = make([]int, 0, len(h.Columns))
+	for _, c := range h.Columns {
+		if i, ok := c.(int); ok {
+			h.selected = append(h.selected, i)
+			continue
+		}
+		found := false
+		for i, name := range names {
+			if name == c {
+				h.selected, found = append(h.selected, i), true
+				break
+			}
+		}
+		if !found && names != nil {
+			return fmt.Errorf("column '%s' is not in the header row", c)
+		}
+	}
+	return nil
+}
+
+// selectsColumns marks the formats which handle the "columns" option themselves.
+func (h *headerRow) selectsColumns() {}
+
+// splitN returns the number of substrings to split a record into (as for strings.SplitN), so
+// that the columns after the last selected one are not split.
+func (h *headerRow) splitN() int {
+	if h.Columns == nil {
+		return -1
+	}
+	n := 1
+	for _, i := range h.selected {
+		if i+2 > n {
+			n = i + 2
+		}
+	}
+	return n
+}
+
+// key returns the column name for the 0-based field index i, or i itself if there is no
+// header row (or it has no name for the column).
+func (h *headerRow) key(i int) interface{} {
+	if i < len(h.names) && h.names[i] != "" {
+		return h.names[i]
+	}
+	return i
+}
+
+// record replaces the fields of rec with a list of field values, in column order or in the
+// order of the Columns.
+func (h *headerRow) record(values []string, rec *Record) {
+	rec.Reset()
+	if h.Columns != nil {
+		for _, i := range h.selected {
+			if i < len(values) {
+				rec.Keys = append(rec.Keys, h.key(i))
+				rec.Values = append(rec.Values, values[i])
+			}
+		}
+		return
+	}
+	for i, v := range values {
+		rec.Keys = append(rec.Keys, h.key(i))
+		rec.Values = append(rec.Values, v)
+	}
+}
+
+// fields keys a list of field values by column.
+func (h *headerRow) fields(values []string) map[interface{}]string {
+	if h.Columns != nil {
+		ret := make(map[interface{}]string, len(h.selected))
+		for _, i := range h.selected {
+			if i < len(values) {
+				ret[h.key(i)] = values[i]
+			}
+		}
+		return ret
+	}
+	ret := make(map[interface{}]string, len(values))
+	for i, v := range values {
+		ret[h.key(i)] = v
+	}
+	return ret
+}
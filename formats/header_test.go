@@ -0,0 +1,32 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestHeaderRow(t *testing.T) {
+	inputs := map[string]string{
+		"tab-delimited": "## generated 2024-01-01\ngene_id\tsymbol\nENSG01\tTP53\n",
+		"csv":           "## generated 2024-01-01\ngene_id,symbol\nENSG01,TP53\n",
+	}
+	for typ, input := range inputs {
+		df := openFormat(t, map[string]string{"type": typ, "header": "true", "skip_lines": "1"}, input)
+		rec, err := df.NextRecordFields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec["gene_id"] != "ENSG01" || rec["symbol"] != "TP53" {
+			t.Errorf("%s: unexpected fields: %v", typ, rec)
+		}
+		if _, err = df.NextRecord(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF, got %v", typ, err)
+		}
+	}
+
+	if _, err := formats.GetDataFormat(map[string]string{"type": "csv", "header": "yes please"}); err == nil {
+		t.Error("expected an error for an invalid header option")
+	}
+}
@@ -0,0 +1,148 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Index holds the byte offsets of the records in an input, so that a record can be read
+// without scanning the input from the start. Records are numbered from 0 in input order, and
+// may also be indexed by the value of one field. An Index can be saved with WriteTo and
+// loaded with ReadIndex, e.g. next to a cached file.
+type Index struct {
+	// Offsets are the byte offsets of the records.
+	Offsets []int64
+
+	// Size is the length of the input in bytes.
+	Size int64
+
+	// Field is the indexed field (a column index or name, as for the "columns" option), or
+	// empty if records are only indexed by number.
+	Field string
+
+	// Keys are the numbers of the records with each value of the indexed field.
+	Keys map[string][]int `json:",omitempty"`
+}
+
+// countingReader counts the bytes read from a Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// BuildIndex reads every record of r with df, which must be a Positioner that reports byte
+// offsets, such as the line-based formats. The offsets are those of the input given to Open,
+// so r must not be compressed, and df must not use the "charset" option. If field is not
+// empty, records are also indexed by the value of that field.
+func BuildIndex(df DataFormat, r io.Reader, field string) (*Index, error) {
+	p, ok := df.(Positioner)
+	if !ok {
+		return nil, fmt.Errorf("format does not report record positions")
+	}
+	var key interface{}
+	ix := &Index{Field: field}
+	if field != "" {
+		key = parseFieldKeys(field)[0]
+		ix.Keys = make(map[string][]int)
+	}
+
+	cr := &countingReader{r: r}
+	if err := df.Open(cr); err != nil {
+		return nil, err
+	}
+	for {
+		rec, err := df.NextRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		off := p.Position().Offset
+		if n := len(ix.Offsets); n > 0 && off <= ix.Offsets[n-1] {
+			return nil, fmt.Errorf("format does not report record offsets")
+		}
+		if key != nil {
+			fields, err := df.GetFields(rec)
+			if err != nil {
+				return nil, err
+			}
+			if v, found := fields[key]; found {
+				ix.Keys[v] = append(ix.Keys[v], len(ix.Offsets))
+			}
+		}
+		ix.Offsets = append(ix.Offsets, off)
+	}
+	// the rest of the input may not have been read
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return nil, err
+	}
+	ix.Size = cr.n
+	return ix, nil
+}
+
+// ReadIndex loads an Index saved by WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	ix := &Index{}
+	if err := json.NewDecoder(r).Decode(ix); err != nil {
+		return nil, fmt.Errorf("invalid index: %s", err)
+	}
+	return ix, nil
+}
+
+// WriteTo saves the Index to w.
+func (ix *Index) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// Len returns the number of records in the input.
+func (ix *Index) Len() int {
+	return len(ix.Offsets)
+}
+
+// Lookup returns the numbers of the records whose indexed field has the given value.
+func (ix *Index) Lookup(value string) []int {
+	return ix.Keys[value]
+}
+
+// Seek opens df at record n of r, which must be the indexed input, so that the next call to
+// NextRecord or NextRecordFields returns that record, followed by the rest of the input. The
+// input before the first record (e.g. a header row) is read first, so df must have the same
+// options as when the index was built.
+func (ix *Index) Seek(df DataFormat, r io.ReaderAt, n int) error {
+	if n < 0 || n >= len(ix.Offsets) {
+		return fmt.Errorf("record %d is not in the index of %d records", n, len(ix.Offsets))
+	}
+	off := ix.Offsets[n]
+	return df.Open(io.MultiReader(io.NewSectionReader(r, 0, ix.Offsets[0]), io.NewSectionReader(r, off, ix.Size-off)))
+}
+
+// Find returns the fields of the records whose indexed field has the given value, reading
+// them from r with df.
+func (ix *Index) Find(df DataFormat, r io.ReaderAt, value string) ([]map[interface{}]string, error) {
+	var ret []map[interface{}]string
+	for _, n := range ix.Lookup(value) {
+		if err := ix.Seek(df, r, n); err != nil {
+			return nil, err
+		}
+		fields, err := df.NextRecordFields()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, fields)
+	}
+	return ret, nil
+}
@@ -0,0 +1,47 @@
+package formats_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestIndex(t *testing.T) {
+	input := "id\tname\n1\ta\n\n2\tb\n3\ta\n"
+	spec := map[string]string{"type": "tab-delimited", "header": "true"}
+	df, _ := formats.GetDataFormat(spec)
+	ix, err := formats.BuildIndex(df, strings.NewReader(input), "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 3 || ix.Size != int64(len(input)) || len(ix.Lookup("a")) != 2 {
+		t.Fatalf("unexpected index: %+v", ix)
+	}
+
+	var buf bytes.Buffer
+	ix.WriteTo(&buf)
+	if ix, err = formats.ReadIndex(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := strings.NewReader(input)
+	if err = ix.Seek(df, r, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fields, err := df.NextRecordFields(); err != nil || fields["id"] != "2" {
+		t.Errorf("unexpected record: %v %v", fields, err)
+	}
+	recs, err := ix.Find(df, r, "a")
+	if err != nil || len(recs) != 2 || recs[0]["id"] != "1" || recs[1]["id"] != "3" {
+		t.Errorf("unexpected records: %v %v", recs, err)
+	}
+	if err = ix.Seek(df, r, 3); err == nil {
+		t.Error("expected an error for a record past the end")
+	}
+
+	df, _ = formats.GetDataFormat(map[string]string{"type": "json"})
+	if _, err = formats.BuildIndex(df, strings.NewReader("{}\n{}\n"), ""); err == nil {
+		t.Error("expected an error for a format without positions")
+	}
+}
@@ -0,0 +1,266 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// jsonRecords streams the objects in an array within a larger JSON document. The array is
+// located with a dot-path (e.g. "data.items"), and the document is read token by token so that
+// only one record is held in memory at a time.
+type jsonRecords struct {
+	Path []string
+	Null string
+
+	decoder *json.Decoder
+	inArray bool
+	found   bool
+}
+
+func (f *jsonRecords) Init(spec map[string]string) error {
+	f.Path = nil
+	f.Null = ""
+
+	// accept simple JSONPath expressions too, e.g. "$.data.items[*]"
+	path := strings.TrimPrefix(spec["records"], "$")
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "[*]"), "[]")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	for _, seg := range strings.Split(path, ".") {
+		if seg != "" {
+			f.Path = append(f.Path, seg)
+		}
+	}
+	if v, found := spec["null"]; found {
+		f.Null = v
+	}
+	return nil
+}
+
+func (f *jsonRecords) Open(r io.Reader) error {
+	f.decoder = json.NewDecoder(r)
+	f.decoder.UseNumber()
+	f.inArray = false
+	f.found = false
+	return nil
+}
+
+// seek reads the document up to the value at the records path.
+func (f *jsonRecords) seek() error {
+	for _, seg := range f.Path {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			found := false
+			for !found && f.decoder.More() {
+				key, err := f.decoder.Token()
+				if err != nil {
+					return err
+				}
+				if key == seg {
+					found = true
+				} else if err = skipJSONValue(f.decoder); err != nil {
+					return err
+				}
+			}
+			if !found {
+				return fmt.Errorf("json format: records path has no '%s'", seg)
+			}
+		case json.Delim('['):
+			idx, err := strconv.Atoi(seg)
+			if err != nil {
+				return fmt.Errorf("json format: records path expected an index for array, not '%s'", seg)
+			}
+			for ; idx > 0 && f.decoder.More(); idx-- {
+				if err = skipJSONValue(f.decoder); err != nil {
+					return err
+				}
+			}
+			if !f.decoder.More() {
+				return fmt.Errorf("json format: records path index '%s' is out of range", seg)
+			}
+		default:
+			return fmt.Errorf("json format: records path has no '%s'", seg)
+		}
+	}
+	return nil
+}
+
+// next returns the compacted JSON of the next record.
+func (f *jsonRecords) next() ([]byte, error) {
+	for {
+		if f.inArray {
+			if f.decoder.More() {
+				var raw json.RawMessage
+				if err := f.decoder.Decode(&raw); err != nil {
+					return nil, err
+				}
+				return compactJSON(raw)
+			}
+			f.inArray = false
+			if _, err := f.decoder.Token(); err != nil {
+				return nil, err
+			}
+			if len(f.Path) > 0 {
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		if len(f.Path) > 0 {
+			if f.found {
+				return nil, io.EOF
+			}
+			if err := f.seek(); err != nil {
+				return nil, err
+			}
+			f.found = true
+		}
+
+		// without a records path, each top-level value (or array element) is a record
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case json.Delim('['):
+			f.inArray = true
+		case json.Delim('{'):
+			return readJSONObject(f.decoder)
+		default:
+			return json.Marshal(tok)
+		}
+	}
+}
+
+func (f *jsonRecords) NextRecord() (string, error) {
+	rec, err := f.next()
+	if err != nil {
+		return "", err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "json")
+	return string(rec), nil
+}
+
+// GetFields keys the values of a record by their names, using dot-paths for nested objects
+// (e.g. "author.name"). Arrays are returned as JSON, and records which are not objects are
+// keyed as "value".
+func (f *jsonRecords) GetFields(record string) (map[interface{}]string, error) {
+	dec := json.NewDecoder(strings.NewReader(record))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	ret := make(map[interface{}]string)
+	if obj, ok := v.(map[string]interface{}); ok {
+		f.flatten("", obj, ret)
+	} else {
+		ret["value"] = f.jsonString(v)
+	}
+	return ret, nil
+}
+
+func (f *jsonRecords) flatten(prefix string, obj map[string]interface{}, ret map[interface{}]string) {
+	for k, v := range obj {
+		if sub, ok := v.(map[string]interface{}); ok {
+			f.flatten(prefix+k+".", sub, ret)
+			continue
+		}
+		ret[prefix+k] = f.jsonString(v)
+	}
+}
+
+// jsonString converts a decoded JSON value into a field value.
+func (f *jsonRecords) jsonString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return f.Null
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (f *jsonRecords) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *jsonRecords) HasVariableFields() bool {
+	return true
+}
+
+// skipJSONValue reads past the next value without decoding it.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// readJSONObject reads the rest of an object whose opening brace has been read, and returns
+// it as compact JSON.
+func readJSONObject(dec *json.Decoder) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(raw)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return compactJSON(buf.Bytes())
+}
+
+// compactJSON removes insignificant whitespace, so that each record is a single line.
+func compactJSON(raw []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
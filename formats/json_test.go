@@ -0,0 +1,43 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+)
+
+func TestJSONRecords(t *testing.T) {
+	input := `{"meta": {"count": 2, "tags": ["a", {"b": 1}]},
+	"data": {"items": [
+		{"id": 1, "name": "first", "author": {"name": "x"}, "tags": ["t1"]},
+		{"id": 2, "name": null, "ok": true}
+	]}}`
+	df := openFormat(t, map[string]string{"type": "json", "records": "$.data.items[*]"}, input)
+
+	rec, err := df.NextRecordFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec["id"] != "1" || rec["author.name"] != "x" || rec["tags"] != `["t1"]` {
+		t.Errorf("unexpected fields: %v", rec)
+	}
+	line, err := df.NextRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != `{"id":2,"name":null,"ok":true}` {
+		t.Errorf("unexpected record: %s", line)
+	}
+	if _, err = df.NextRecord(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	// JSON lines without a records path
+	df = openFormat(t, map[string]string{"type": "json"}, "{\"a\": 1}\n{\"a\": 2}\n")
+	n := 0
+	for _, err = df.NextRecord(); err == nil; _, err = df.NextRecord() {
+		n++
+	}
+	if n != 2 || err != io.EOF {
+		t.Errorf("expected 2 records, got %d (%v)", n, err)
+	}
+}
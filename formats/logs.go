@@ -0,0 +1,193 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// logLines reads the non-blank lines of a log file, which are parsed by GetFields.
+type logLines struct {
+	scanner *bufio.Scanner
+	name    string
+
+	scanPosition
+	scanLimits
+}
+
+func (f *logLines) Open(r io.Reader) error {
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	return nil
+}
+
+func (f *logLines) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		line := strings.TrimRight(f.scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", f.name)
+		return line, nil
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// logValue returns "" for the "-" placeholder used for missing values.
+func logValue(v string) string {
+	if v == "-" {
+		return ""
+	}
+	return v
+}
+
+////////
+
+var accessLogLine = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}|-) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// accessLog parses web server access logs in the Common or Combined Log Format, as written by
+// Apache and Nginx. The fields are "host", "ident", "user", "time" (as RFC 3339), "request",
+// "method", "path", "protocol", "status", "bytes", and for the combined format, "referer" and
+// "user_agent".
+type accessLog struct {
+	logLines
+}
+
+func (f *accessLog) Init(spec map[string]string) error {
+	return f.scanLimits.init(spec)
+}
+
+func (f *accessLog) Open(r io.Reader) error {
+	f.name = "access-log"
+	return f.logLines.Open(r)
+}
+
+func (f *accessLog) GetFields(record string) (map[interface{}]string, error) {
+	m := accessLogLine.FindStringSubmatch(record)
+	if m == nil {
+		return nil, fmt.Errorf("access-log format: unrecognized line '%s'", record)
+	}
+	ret := map[interface{}]string{
+		"host":       m[1],
+		"ident":      logValue(m[2]),
+		"user":       logValue(m[3]),
+		"time":       m[4],
+		"request":    m[5],
+		"method":     "",
+		"path":       "",
+		"protocol":   "",
+		"status":     logValue(m[6]),
+		"bytes":      m[7],
+		"referer":    logValue(m[8]),
+		"user_agent": logValue(m[9]),
+	}
+	if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[4]); err == nil {
+		ret["time"] = t.Format(time.RFC3339)
+	}
+	if m[7] == "-" {
+		ret["bytes"] = "0"
+	}
+	if parts := strings.Fields(m[5]); len(parts) == 3 {
+		ret["method"], ret["path"], ret["protocol"] = parts[0], parts[1], parts[2]
+	}
+	return ret, nil
+}
+
+func (f *accessLog) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *accessLog) HasVariableFields() bool {
+	return false
+}
+
+////////
+
+var (
+	syslog5424Line = regexp.MustCompile(`^<(\d{1,3})>(\d{1,2}) (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: (.*))?$`)
+	syslog3164Line = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d) (\S+) ([^:\[\s]+)?(?:\[([^\]]*)\])?:? ?(.*)$`)
+)
+
+// syslogMessages parses syslog messages in either the RFC 5424 or the traditional BSD (RFC
+// 3164) format, one per line. The fields are "priority", "facility", "severity", "time" (as RFC
+// 3339), "host", "app", "pid", "msgid", "structured_data" and "message". Traditional
+// timestamps have no year, so the most recent matching date is assumed.
+type syslogMessages struct {
+	logLines
+}
+
+func (f *syslogMessages) Init(spec map[string]string) error {
+	return f.scanLimits.init(spec)
+}
+
+func (f *syslogMessages) Open(r io.Reader) error {
+	f.name = "syslog"
+	return f.logLines.Open(r)
+}
+
+func (f *syslogMessages) GetFields(record string) (map[interface{}]string, error) {
+	ret := map[interface{}]string{
+		"priority": "", "facility": "", "severity": "", "time": "", "host": "", "app": "",
+		"pid": "", "msgid": "", "structured_data": "", "message": "",
+	}
+	pri := ""
+	if m := syslog5424Line.FindStringSubmatch(record); m != nil {
+		pri = m[1]
+		ret["time"] = logValue(m[3])
+		ret["host"] = logValue(m[4])
+		ret["app"] = logValue(m[5])
+		ret["pid"] = logValue(m[6])
+		ret["msgid"] = logValue(m[7])
+		ret["structured_data"] = logValue(m[8])
+		ret["message"] = strings.TrimPrefix(m[9], "\ufeff")
+	} else if m := syslog3164Line.FindStringSubmatch(record); m != nil {
+		pri = m[1]
+		ret["time"] = m[2]
+		ret["host"] = m[3]
+		ret["app"] = m[4]
+		ret["pid"] = m[5]
+		ret["message"] = m[6]
+		if t, err := time.ParseInLocation("Jan _2 15:04:05", m[2], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			ret["time"] = t.Format(time.RFC3339)
+		}
+	} else {
+		return nil, fmt.Errorf("syslog format: unrecognized line '%s'", record)
+	}
+
+	if n, err := strconv.Atoi(pri); err == nil {
+		ret["priority"] = pri
+		ret["facility"] = strconv.Itoa(n / 8)
+		ret["severity"] = strconv.Itoa(n % 8)
+	}
+	return ret, nil
+}
+
+func (f *syslogMessages) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *syslogMessages) HasVariableFields() bool {
+	return false
+}
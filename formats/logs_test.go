@@ -0,0 +1,41 @@
+package formats_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogFormats(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "access-log"}, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+10.0.0.2 - - [10/Oct/2000:13:56:01 -0700] "POST /api?q=\"x\" HTTP/1.1" 304 - "http://example.com/" "Mozilla/5.0 (X11)"
+`)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 lines, got %v", recs)
+	}
+	if recs[0]["user"] != "frank" || recs[0]["time"] != "2000-10-10T13:55:36-07:00" || recs[0]["path"] != "/apache_pb.gif" ||
+		recs[0]["status"] != "200" || recs[0]["bytes"] != "2326" || recs[0]["referer"] != "" {
+		t.Errorf("unexpected common log fields: %v", recs[0])
+	}
+	if recs[1]["method"] != "POST" || recs[1]["bytes"] != "0" || recs[1]["referer"] != "http://example.com/" || recs[1]["user_agent"] != "Mozilla/5.0 (X11)" {
+		t.Errorf("unexpected combined log fields: %v", recs[1])
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "syslog"}, `<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 [exampleSDID@32473 iut="3"] 'su root' failed
+<13>Feb  5 17:32:18 10.0.0.99 sshd[4123]: Accepted publickey for root
+Feb  5 17:32:19 myhost kernel: eth0: link up
+`)
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 messages, got %v", recs)
+	}
+	if recs[0]["facility"] != "4" || recs[0]["severity"] != "2" || recs[0]["host"] != "mymachine.example.com" || recs[0]["app"] != "su" ||
+		recs[0]["pid"] != "" || recs[0]["msgid"] != "ID47" || recs[0]["structured_data"] != `[exampleSDID@32473 iut="3"]` || recs[0]["message"] != "'su root' failed" {
+		t.Errorf("unexpected rfc5424 fields: %v", recs[0])
+	}
+	if recs[1]["host"] != "10.0.0.99" || recs[1]["app"] != "sshd" || recs[1]["pid"] != "4123" || recs[1]["message"] != "Accepted publickey for root" ||
+		!strings.Contains(recs[1]["time"], "-02-05T17:32:18") {
+		t.Errorf("unexpected rfc3164 fields: %v", recs[1])
+	}
+	if recs[2]["priority"] != "" || recs[2]["app"] != "kernel" || recs[2]["message"] != "eth0: link up" {
+		t.Errorf("unexpected rfc3164 fields: %v", recs[2])
+	}
+}
@@ -0,0 +1,243 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+	"golang.org/x/text/transform"
+)
+
+// BodyField is the name of the field holding the text body of messages from the "mbox" and
+// "eml" formats.
+const BodyField = "_body"
+
+// mailFields decodes an RFC 5322 message. Headers are keyed by their canonical names (e.g.
+// "Message-Id"), with encoded words decoded and repeated headers joined by tabs. The "Date"
+// header is converted to RFC 3339 if it can be parsed. The body is the first text/plain part
+// of the message (or the first text part), decoded to UTF-8.
+func mailFields(record string) (map[interface{}]string, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(record))
+	if err != nil {
+		return nil, err
+	}
+
+	dec := &mime.WordDecoder{CharsetReader: decodeCharset}
+	ret := make(map[interface{}]string, len(msg.Header)+1)
+	for k, vs := range msg.Header {
+		for i, v := range vs {
+			if d, err := dec.DecodeHeader(v); err == nil {
+				vs[i] = d
+			}
+		}
+		ret[k] = strings.Join(vs, "\t")
+	}
+	if d, err := msg.Header.Date(); err == nil {
+		ret["Date"] = d.Format(time.RFC3339)
+	}
+
+	body, _, err := mailBody(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	ret[BodyField] = body
+	return ret, nil
+}
+
+// mailBody returns the text of a message or MIME part body, and whether it is text/plain.
+func mailBody(header textproto.MIMEHeader, body io.Reader) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var text string
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return text, false, nil
+			}
+			if err != nil {
+				return "", false, err
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+			t, plain, err := mailBody(part.Header, part)
+			if err != nil {
+				return "", false, err
+			}
+			if plain && t != "" {
+				return t, true, nil
+			}
+			if text == "" {
+				text = t
+			}
+		}
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return "", false, nil
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if cs := params["charset"]; cs != "" {
+		if body, err = decodeCharset(cs, body); err != nil {
+			return "", false, err
+		}
+	}
+	text, err := ioutil.ReadAll(body)
+	return string(text), mediaType == "text/plain", err
+}
+
+// decodeCharset decodes input from the named charset to UTF-8.
+func decodeCharset(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := lookupCharset(charset)
+	if err != nil {
+		return nil, err
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}
+
+////////
+
+// mboxMessages reads the messages of an mbox file, each of which begins with a "From " line.
+// Lines in the body which were escaped as ">From " are unescaped.
+type mboxMessages struct {
+	scanner *bufio.Scanner
+	more    bool
+	start   Position
+
+	scanPosition
+	scanLimits
+}
+
+func (f *mboxMessages) Init(spec map[string]string) error {
+	return f.scanLimits.init(spec)
+}
+
+func (f *mboxMessages) Open(r io.Reader) error {
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	// anything before the first message is ignored
+	for f.more = f.scanner.Scan(); f.more; f.more = f.scanner.Scan() {
+		if strings.HasPrefix(f.scanner.Text(), "From ") {
+			break
+		}
+	}
+	return f.scanError(f.scanner.Err())
+}
+
+func (f *mboxMessages) NextRecord() (string, error) {
+	if !f.more {
+		if err := f.scanError(f.scanner.Err()); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	// the From line was scanned ahead, so its position is saved before the next Scan
+	f.start = f.scanPosition.Position()
+	var lines []string
+	for {
+		f.more = f.scanner.Scan()
+		line := strings.TrimRight(f.scanner.Text(), "\r")
+		if !f.more || strings.HasPrefix(line, "From ") {
+			break
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = line[1:]
+		}
+		lines = append(lines, line)
+	}
+	// drop the blank line before the next message
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	metrics.Add(metrics.RecordsParsed, 1, "format", "mbox")
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func (f *mboxMessages) GetFields(record string) (map[interface{}]string, error) {
+	return mailFields(record)
+}
+
+func (f *mboxMessages) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+// Position returns the position of the From line of the most recent message.
+func (f *mboxMessages) Position() Position {
+	return f.start
+}
+
+func (f *mboxMessages) HasVariableFields() bool {
+	return true
+}
+
+////////
+
+// emlMessage reads a single message, such as an .eml file, which is the only record.
+type emlMessage struct {
+	message string
+	done    bool
+}
+
+func (f *emlMessage) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *emlMessage) Open(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.message = string(data)
+	f.done = strings.TrimSpace(f.message) == ""
+	return nil
+}
+
+func (f *emlMessage) NextRecord() (string, error) {
+	if f.done {
+		return "", io.EOF
+	}
+	f.done = true
+	metrics.Add(metrics.RecordsParsed, 1, "format", "eml")
+	return f.message, nil
+}
+
+func (f *emlMessage) GetFields(record string) (map[interface{}]string, error) {
+	return mailFields(record)
+}
+
+func (f *emlMessage) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+func (f *emlMessage) HasVariableFields() bool {
+	return true
+}
@@ -0,0 +1,28 @@
+package formats_test
+
+import (
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestMailFormats(t *testing.T) {
+	mbox := "From alice@example.com Mon Jan  2 15:04:05 2006\n" +
+		"From: Alice <alice@example.com>\nSubject: =?ISO-8859-1?Q?Caf=E9?=\n" +
+		"Date: Mon, 2 Jan 2006 15:04:05 -0700\n\nHello\n>From the list\n\n" +
+		"From bob@example.com Tue Jan  3 10:00:00 2006\n" +
+		"From: bob@example.com\nSubject: Re: Cafe\nMIME-Version: 1.0\n" +
+		"Content-Type: multipart/alternative; boundary=b1\n\n" +
+		"--b1\nContent-Type: text/html\n\n<p>Hi</p>\n" +
+		"--b1\nContent-Type: text/plain; charset=windows-1252\nContent-Transfer-Encoding: base64\n\nSGkg6Q==\n--b1--\n"
+	recs := readAllFields(t, map[string]string{"type": "mbox"}, mbox)
+	if len(recs) != 2 || recs[0]["Subject"] != "Café" || recs[0]["Date"] != "2006-01-02T15:04:05-07:00" ||
+		recs[0][formats.BodyField] != "Hello\nFrom the list\n" || recs[1][formats.BodyField] != "Hi é" {
+		t.Errorf("unexpected records: %q", recs)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "eml"}, "From: carol@example.com\r\nSubject: Hi\r\n\r\nBody\r\n")
+	if len(recs) != 1 || recs[0]["From"] != "carol@example.com" || recs[0][formats.BodyField] != "Body\r\n" {
+		t.Errorf("unexpected records: %q", recs)
+	}
+}
@@ -0,0 +1,345 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// The ISO 2709 delimiters used by MARC records.
+const (
+	marcSubfieldDelim  = 0x1F
+	marcFieldDelim     = 0x1E
+	marcRecordDelim    = 0x1D
+	marcLeaderLength   = 24
+	marcDirEntryLength = 12
+)
+
+// marcField is a control field (tags "001" to "009"), which has a Value, or a data field, which
+// has indicators and subfields.
+type marcField struct {
+	Tag        string
+	Value      string
+	Indicators [2]byte
+	Subfields  []marcSubfield
+}
+
+type marcSubfield struct {
+	Code  byte
+	Value string
+}
+
+// marcRecord is a MARC 21 bibliographic, authority or holdings record.
+type marcRecord struct {
+	Leader string
+	Fields []marcField
+}
+
+// isControlTag returns true for the tags of control fields.
+func isControlTag(tag string) bool {
+	return strings.HasPrefix(tag, "00")
+}
+
+// parseMARC decodes a record in the ISO 2709 exchange format.
+func parseMARC(data []byte) (*marcRecord, error) {
+	if len(data) < marcLeaderLength {
+		return nil, fmt.Errorf("marc format: record is shorter than the leader")
+	}
+	m := &marcRecord{Leader: string(data[:marcLeaderLength])}
+	base, err := strconv.Atoi(m.Leader[12:17])
+	if err != nil || base < marcLeaderLength || base > len(data) {
+		return nil, fmt.Errorf("marc format: invalid base address '%s'", m.Leader[12:17])
+	}
+
+	dir := data[marcLeaderLength:base]
+	for len(dir) >= marcDirEntryLength && dir[0] != marcFieldDelim {
+		tag := string(dir[:3])
+		length, err1 := strconv.Atoi(string(dir[3:7]))
+		start, err2 := strconv.Atoi(string(dir[7:12]))
+		dir = dir[marcDirEntryLength:]
+		if err1 != nil || err2 != nil || base+start+length > len(data) {
+			return nil, fmt.Errorf("marc format: invalid directory entry for tag %s", tag)
+		}
+		value := bytes.TrimRight(data[base+start:base+start+length], "\x1e")
+
+		field := marcField{Tag: tag}
+		if isControlTag(tag) {
+			field.Value = string(value)
+		} else {
+			parts := bytes.Split(value, []byte{marcSubfieldDelim})
+			copy(field.Indicators[:], parts[0])
+			for _, sf := range parts[1:] {
+				if len(sf) > 0 {
+					field.Subfields = append(field.Subfields, marcSubfield{Code: sf[0], Value: string(sf[1:])})
+				}
+			}
+		}
+		m.Fields = append(m.Fields, field)
+	}
+	return m, nil
+}
+
+// encode returns the record in the ISO 2709 exchange format.
+func (m *marcRecord) encode() []byte {
+	var dir, data bytes.Buffer
+	for _, field := range m.Fields {
+		start := data.Len()
+		if isControlTag(field.Tag) {
+			data.WriteString(field.Value)
+		} else {
+			data.Write(field.Indicators[:])
+			for _, sf := range field.Subfields {
+				data.WriteByte(marcSubfieldDelim)
+				data.WriteByte(sf.Code)
+				data.WriteString(sf.Value)
+			}
+		}
+		data.WriteByte(marcFieldDelim)
+		fmt.Fprintf(&dir, "%-3.3s%04d%05d", field.Tag, data.Len()-start, start)
+	}
+	dir.WriteByte(marcFieldDelim)
+	data.WriteByte(marcRecordDelim)
+
+	leader := []byte(fmt.Sprintf("%-24.24s", m.Leader))
+	base := marcLeaderLength + dir.Len()
+	copy(leader[0:5], fmt.Sprintf("%05d", base+data.Len()))
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+
+	rec := make([]byte, 0, base+data.Len())
+	rec = append(rec, leader...)
+	rec = append(rec, dir.Bytes()...)
+	return append(rec, data.Bytes()...)
+}
+
+////////
+
+// marcFields keys the fields of MARC records by tag and subfield code, e.g. "245$a". Data
+// fields are also keyed by tag, with the text of their subfields joined by spaces, and their
+// indicators are keyed as "245@ind1" and "245@ind2". Repeated fields and subfields are joined
+// or indexed as for the "xml" format, e.g. "650[1]$a".
+type marcFields struct {
+	Repeated string
+	Join     string
+}
+
+func (f *marcFields) init(spec map[string]string) error {
+	f.Repeated, f.Join = "join", "\t"
+	if v, found := spec["repeated"]; found {
+		if v != "join" && v != "index" {
+			return fmt.Errorf("marc format repeated must be 'join' or 'index', not '%s'", v)
+		}
+		f.Repeated = v
+	}
+	if v, found := spec["join"]; found {
+		f.Join = v
+	}
+	return nil
+}
+
+// fields returns the fields of a record in the ISO 2709 exchange format.
+func (f *marcFields) fields(record string) (map[interface{}]string, error) {
+	m, err := parseMARC([]byte(record))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, field := range m.Fields {
+		counts[field.Tag]++
+	}
+	seen := make(map[string]int)
+	vals := make(map[string][]string)
+	var keys []string
+	add := func(key, value string) {
+		if _, found := vals[key]; !found {
+			keys = append(keys, key)
+		}
+		vals[key] = append(vals[key], value)
+	}
+
+	add("leader", m.Leader)
+	for _, field := range m.Fields {
+		tag := field.Tag
+		if f.Repeated == "index" && counts[tag] > 1 {
+			tag += "[" + strconv.Itoa(seen[tag]) + "]"
+			seen[field.Tag]++
+		}
+		if isControlTag(field.Tag) {
+			add(tag, field.Value)
+			continue
+		}
+		add(tag+"@ind1", strings.TrimSpace(string(field.Indicators[0:1])))
+		add(tag+"@ind2", strings.TrimSpace(string(field.Indicators[1:2])))
+		text := make([]string, len(field.Subfields))
+		for i, sf := range field.Subfields {
+			add(tag+"$"+string(sf.Code), sf.Value)
+			text[i] = sf.Value
+		}
+		add(tag, strings.Join(text, " "))
+	}
+
+	ret := make(map[interface{}]string, len(keys))
+	for _, k := range keys {
+		ret[k] = strings.Join(vals[k], f.Join)
+	}
+	return ret, nil
+}
+
+////////
+
+// marcRecords reads MARC 21 records in the binary ISO 2709 exchange format. Records are
+// returned in the same format.
+type marcRecords struct {
+	reader *bufio.Reader
+	marcFields
+}
+
+func (f *marcRecords) Init(spec map[string]string) error {
+	return f.marcFields.init(spec)
+}
+
+func (f *marcRecords) Open(r io.Reader) error {
+	f.reader = bufio.NewReader(r)
+	return nil
+}
+
+func (f *marcRecords) NextRecord() (string, error) {
+	// records may be separated by newlines
+	for {
+		b, err := f.reader.Peek(1)
+		if err != nil {
+			return "", err
+		}
+		if b[0] != '\n' && b[0] != '\r' {
+			break
+		}
+		f.reader.ReadByte()
+	}
+
+	length, err := f.reader.Peek(5)
+	if err != nil {
+		return "", fmt.Errorf("marc format: truncated record")
+	}
+	n, err := strconv.Atoi(string(length))
+	if err != nil || n < marcLeaderLength {
+		return "", fmt.Errorf("marc format: invalid record length '%s'", length)
+	}
+	rec := make([]byte, n)
+	if _, err = io.ReadFull(f.reader, rec); err != nil {
+		return "", fmt.Errorf("marc format: truncated record")
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "marc")
+	return string(rec), nil
+}
+
+func (f *marcRecords) GetFields(record string) (map[interface{}]string, error) {
+	return f.fields(record)
+}
+
+func (f *marcRecords) NextRecordFields() (map[interface{}]string, error) {
+	rec, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(rec)
+}
+
+func (f *marcRecords) HasVariableFields() bool {
+	return true
+}
+
+////////
+
+// marcXMLRecords reads the record elements of a MARCXML document. Records are returned in
+// the binary ISO 2709 exchange format, as for marcRecords.
+type marcXMLRecords struct {
+	decoder *xml.Decoder
+	marcFields
+}
+
+// marcXMLRecord is the structure of a MARCXML record element.
+type marcXMLRecord struct {
+	Leader        string `xml:"leader"`
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []struct {
+		Tag       string `xml:"tag,attr"`
+		Ind1      string `xml:"ind1,attr"`
+		Ind2      string `xml:"ind2,attr"`
+		Subfields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+func (f *marcXMLRecords) Init(spec map[string]string) error {
+	return f.marcFields.init(spec)
+}
+
+func (f *marcXMLRecords) Open(r io.Reader) error {
+	f.decoder = xml.NewDecoder(r)
+	f.decoder.CharsetReader = charsetReader
+	return nil
+}
+
+func (f *marcXMLRecords) NextRecord() (string, error) {
+	for {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "record" {
+			continue
+		}
+
+		var x marcXMLRecord
+		if err = f.decoder.DecodeElement(&x, &se); err != nil {
+			return "", err
+		}
+		// MARCXML is always Unicode
+		m := &marcRecord{Leader: fmt.Sprintf("%-24.24s", x.Leader)}
+		m.Leader = m.Leader[:9] + "a" + m.Leader[10:]
+		for _, cf := range x.ControlFields {
+			m.Fields = append(m.Fields, marcField{Tag: cf.Tag, Value: cf.Value})
+		}
+		for _, df := range x.DataFields {
+			field := marcField{Tag: df.Tag, Indicators: [2]byte{' ', ' '}}
+			copy(field.Indicators[0:1], df.Ind1)
+			copy(field.Indicators[1:2], df.Ind2)
+			for _, sf := range df.Subfields {
+				if sf.Code != "" {
+					field.Subfields = append(field.Subfields, marcSubfield{Code: sf.Code[0], Value: sf.Value})
+				}
+			}
+			m.Fields = append(m.Fields, field)
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "marcxml")
+		return string(m.encode()), nil
+	}
+}
+
+func (f *marcXMLRecords) GetFields(record string) (map[interface{}]string, error) {
+	return f.fields(record)
+}
+
+func (f *marcXMLRecords) NextRecordFields() (map[interface{}]string, error) {
+	rec, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(rec)
+}
+
+func (f *marcXMLRecords) HasVariableFields() bool {
+	return true
+}
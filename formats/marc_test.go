@@ -0,0 +1,39 @@
+package formats_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMARC(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>00000nam a2200000 a 4500</leader>
+    <controlfield tag="001">12345</controlfield>
+    <datafield tag="245" ind1="1" ind2="0">
+      <subfield code="a">Über die Natur /</subfield>
+      <subfield code="c">Lucretius.</subfield>
+    </datafield>
+    <datafield tag="650" ind1=" " ind2="0"><subfield code="a">Philosophy</subfield></datafield>
+    <datafield tag="650" ind1=" " ind2="0"><subfield code="a">Poetry</subfield></datafield>
+  </record>
+</collection>`
+	df := openFormat(t, map[string]string{"type": "marcxml"}, doc)
+	binary, err := df.NextRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the binary records read by "marc" are the same as those returned by "marcxml"
+	recs := readAllFields(t, map[string]string{"type": "marc", "repeated": "index"}, binary+"\n"+binary)
+	if len(recs) != 2 || recs[1]["001"] != "12345" || recs[1]["245$a"] != "Über die Natur /" ||
+		recs[1]["245"] != "Über die Natur / Lucretius." || recs[1]["245@ind1"] != "1" ||
+		recs[1]["650[1]$a"] != "Poetry" || recs[1]["650[0]@ind1"] != "" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+	recs = readAllFields(t, map[string]string{"type": "marcxml"}, doc)
+	if len(recs) != 1 || recs[0]["650$a"] != "Philosophy\tPoetry" || !strings.HasPrefix(recs[0]["leader"], "00") {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
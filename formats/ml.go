@@ -0,0 +1,280 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// arffData reads the data section of a Weka ARFF file, keying fields by attribute name. The
+// header is read by Open. Missing values ("?") are returned as "", and values omitted from
+// sparse rows are returned as 0 (or the first value of a nominal attribute).
+type arffData struct {
+	Relation   string
+	Attributes []string
+
+	defaults []string
+	scanner  *bufio.Scanner
+
+	scanPosition
+	scanLimits
+}
+
+func (f *arffData) Init(spec map[string]string) error {
+	return f.scanLimits.init(spec)
+}
+
+func (f *arffData) Open(r io.Reader) error {
+	f.Relation, f.Attributes, f.defaults = "", nil, nil
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+
+	for f.scanner.Scan() {
+		line := strings.TrimSpace(f.scanner.Text())
+		if line == "" || line[0] == '%' {
+			continue
+		}
+		keyword := strings.ToLower(strings.Fields(line)[0])
+		rest := strings.TrimSpace(line[len(keyword):])
+		switch keyword {
+		case "@relation":
+			f.Relation = unquoteARFF(rest)
+		case "@attribute":
+			name, typ := splitARFFAttribute(rest)
+			def := ""
+			switch {
+			case strings.HasPrefix(typ, "{"):
+				if values := splitARFFValues(strings.Trim(typ, "{}")); len(values) > 0 {
+					def = values[0]
+				}
+			case strings.EqualFold(typ, "numeric"), strings.EqualFold(typ, "real"), strings.EqualFold(typ, "integer"):
+				def = "0"
+			}
+			f.Attributes = append(f.Attributes, name)
+			f.defaults = append(f.defaults, def)
+		case "@data":
+			if len(f.Attributes) == 0 {
+				return fmt.Errorf("arff format: no attributes were declared before @data")
+			}
+			return nil
+		default:
+			return fmt.Errorf("arff format: unexpected line in header: '%s'", line)
+		}
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return err
+	}
+	return fmt.Errorf("arff format: no @data section found")
+}
+
+func (f *arffData) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		line := strings.TrimSpace(f.scanner.Text())
+		if line == "" || line[0] == '%' {
+			continue
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "arff")
+		return line, nil
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (f *arffData) GetFields(record string) (map[interface{}]string, error) {
+	record = strings.TrimSpace(record)
+	values := make([]string, len(f.Attributes))
+	if strings.HasPrefix(record, "{") {
+		copy(values, f.defaults)
+		for _, pair := range splitARFFValues(strings.Trim(record, "{}")) {
+			parts := strings.SplitN(pair, " ", 2)
+			i, err := strconv.Atoi(parts[0])
+			if err != nil || i < 0 || i >= len(values) || len(parts) != 2 {
+				return nil, fmt.Errorf("arff format: invalid sparse value '%s'", pair)
+			}
+			values[i] = unquoteARFF(strings.TrimSpace(parts[1]))
+		}
+	} else {
+		row := splitARFFValues(record)
+		if len(row) != len(values) {
+			return nil, fmt.Errorf("arff format: expected %d values, found %d", len(values), len(row))
+		}
+		for i, v := range row {
+			values[i] = unquoteARFF(v)
+		}
+	}
+
+	ret := make(map[interface{}]string, len(values))
+	for i, v := range values {
+		if v == "?" {
+			v = ""
+		}
+		ret[f.Attributes[i]] = v
+	}
+	return ret, nil
+}
+
+func (f *arffData) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *arffData) HasVariableFields() bool {
+	return false
+}
+
+// splitARFFAttribute splits an attribute declaration into its (unquoted) name and type.
+func splitARFFAttribute(decl string) (string, string) {
+	if decl != "" && (decl[0] == '\'' || decl[0] == '"') {
+		if end := strings.IndexByte(decl[1:], decl[0]); end != -1 {
+			return decl[1 : end+1], strings.TrimSpace(decl[end+2:])
+		}
+	}
+	parts := strings.SplitN(decl, " ", 2)
+	if len(parts) == 1 {
+		parts = strings.SplitN(decl, "\t", 2)
+	}
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// splitARFFValues splits comma-separated values, leaving quoted values (which may contain
+// commas) intact for unquoteARFF.
+func splitARFFValues(s string) []string {
+	var ret []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quote != 0:
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			ret = append(ret, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if v := strings.TrimSpace(s[start:]); v != "" || len(ret) > 0 {
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// unquoteARFF removes the quotes and backslash escapes of a quoted value.
+func unquoteARFF(v string) string {
+	if len(v) < 2 || (v[0] != '\'' && v[0] != '"') || v[len(v)-1] != v[0] {
+		return v
+	}
+	v = v[1 : len(v)-1]
+	if !strings.Contains(v, "\\") {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+			switch v[i] {
+			case 'n':
+				sb.WriteByte('\n')
+				continue
+			case 't':
+				sb.WriteByte('\t')
+				continue
+			}
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
+
+////////
+
+// libsvmData reads sparse LIBSVM / SVMlight lines, e.g. "+1 qid:3 1:0.43 7:0.12 # comment".
+// The label is keyed as "label", the query id (if any) as "qid", and features by their integer
+// index. Features which are not present in a line are omitted.
+type libsvmData struct {
+	scanner *bufio.Scanner
+
+	scanPosition
+	scanLimits
+}
+
+func (f *libsvmData) Init(spec map[string]string) error {
+	return f.scanLimits.init(spec)
+}
+
+func (f *libsvmData) Open(r io.Reader) error {
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	return nil
+}
+
+func (f *libsvmData) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		line := strings.TrimSpace(f.scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "libsvm")
+		return line, nil
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (f *libsvmData) GetFields(record string) (map[interface{}]string, error) {
+	if i := strings.IndexByte(record, '#'); i != -1 {
+		record = record[:i]
+	}
+	parts := strings.Fields(record)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("libsvm format: empty record")
+	}
+	ret := make(map[interface{}]string, len(parts))
+	ret["label"] = parts[0]
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("libsvm format: invalid feature '%s'", p)
+		}
+		if kv[0] == "qid" {
+			ret["qid"] = kv[1]
+			continue
+		}
+		idx, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("libsvm format: invalid feature index '%s'", p)
+		}
+		ret[idx] = kv[1]
+	}
+	return ret, nil
+}
+
+func (f *libsvmData) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *libsvmData) HasVariableFields() bool {
+	return true
+}
@@ -0,0 +1,34 @@
+package formats_test
+
+import "testing"
+
+func TestMachineLearningFormats(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "arff"}, `% Iris dataset
+@RELATION iris
+@ATTRIBUTE sepallength NUMERIC
+@ATTRIBUTE 'petal width' REAL
+@ATTRIBUTE note STRING
+@ATTRIBUTE class {Iris-setosa,Iris-versicolor}
+@DATA
+5.1,0.2,'hello, world',Iris-setosa
+?,1.3,"it\'s",Iris-versicolor
+{1 2.5, 2 x}
+`)
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records, got %v", recs)
+	}
+	if recs[0]["sepallength"] != "5.1" || recs[0]["petal width"] != "0.2" || recs[0]["note"] != "hello, world" || recs[0]["class"] != "Iris-setosa" {
+		t.Errorf("unexpected fields: %v", recs[0])
+	}
+	if recs[1]["sepallength"] != "" || recs[1]["note"] != "it's" {
+		t.Errorf("unexpected fields: %v", recs[1])
+	}
+	if recs[2]["sepallength"] != "0" || recs[2]["petal width"] != "2.5" || recs[2]["note"] != "x" || recs[2]["class"] != "Iris-setosa" {
+		t.Errorf("unexpected sparse fields: %v", recs[2])
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "libsvm"}, "+1 qid:3 1:0.43 7:0.12 # first\n-1 2:1\n")
+	if len(recs) != 2 || recs[0]["label"] != "+1" || recs[0]["qid"] != "3" || recs[0][7] != "0.12" || len(recs[1]) != 2 || recs[1][2] != "1" {
+		t.Errorf("unexpected libsvm fields: %v", recs)
+	}
+}
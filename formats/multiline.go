@@ -0,0 +1,124 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// multiLine groups lines into records, where each record begins with a line matching the
+// RecordStart pattern and continues until the next one. This is useful for stack traces and
+// other log entries which span several lines. Fields are the lines of the record, keyed by
+// their 0-based index, or the capture groups of Pattern if it is set.
+type multiLine struct {
+	RecordStart *regexp.Regexp
+	Pattern     *regexp.Regexp
+
+	scanner *bufio.Scanner
+	pending string
+	more    bool
+	start   Position
+
+	scanPosition
+	scanLimits
+}
+
+func (f *multiLine) Init(spec map[string]string) error {
+	v, found := spec["record_start"]
+	if !found || v == "" {
+		return fmt.Errorf("multiline format requires a record_start pattern")
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return fmt.Errorf("multiline format record_start is invalid: %s", err)
+	}
+	f.RecordStart = re
+
+	f.Pattern = nil
+	if v, found := spec["pattern"]; found && v != "" {
+		if f.Pattern, err = compilePattern("multiline", v); err != nil {
+			return err
+		}
+	}
+	return f.scanLimits.init(spec)
+}
+
+func (f *multiLine) Open(r io.Reader) error {
+	if f.RecordStart == nil {
+		return fmt.Errorf("multiline format requires a record_start pattern")
+	}
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	f.more = f.scanner.Scan()
+	f.pending = strings.TrimRight(f.scanner.Text(), "\r")
+	return f.scanError(f.scanner.Err())
+}
+
+func (f *multiLine) NextRecord() (string, error) {
+	// blank lines between records are ignored
+	for f.more && strings.TrimSpace(f.pending) == "" {
+		f.more = f.scanner.Scan()
+		f.pending = strings.TrimRight(f.scanner.Text(), "\r")
+	}
+	if !f.more {
+		if err := f.scanError(f.scanner.Err()); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	// the pending line was scanned ahead, so its position is saved before the next Scan
+	f.start = f.scanPosition.Position()
+	lines := []string{f.pending}
+	for {
+		f.more = f.scanner.Scan()
+		f.pending = strings.TrimRight(f.scanner.Text(), "\r")
+		if !f.more || f.RecordStart.MatchString(f.pending) {
+			break
+		}
+		lines = append(lines, f.pending)
+	}
+	// drop trailing blank lines
+	for len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	metrics.Add(metrics.RecordsParsed, 1, "format", "multiline")
+	return strings.Join(lines, "\n"), nil
+}
+
+func (f *multiLine) GetFields(record string) (map[interface{}]string, error) {
+	if f.Pattern != nil {
+		ret := patternFields(f.Pattern, record)
+		if ret == nil {
+			return nil, fmt.Errorf("multiline format: record does not match pattern: '%s'", record)
+		}
+		return ret, nil
+	}
+	ret := make(map[interface{}]string)
+	for i, line := range strings.Split(strings.TrimRight(record, "\n"), "\n") {
+		ret[i] = line
+	}
+	return ret, nil
+}
+
+func (f *multiLine) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+// Position returns the position of the first line of the most recent record.
+func (f *multiLine) Position() Position {
+	return f.start
+}
+
+func (f *multiLine) HasVariableFields() bool {
+	return true
+}
@@ -0,0 +1,30 @@
+package formats_test
+
+import (
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestMultiLine(t *testing.T) {
+	if _, err := formats.GetDataFormat(map[string]string{"type": "multiline"}); err == nil {
+		t.Error("expected an error without record_start")
+	}
+	recs := readAllFields(t, map[string]string{"type": "multiline", "record_start": `^\d{4}-\d{2}-\d{2} `}, `2024-01-02 10:00:00 INFO started
+
+2024-01-02 10:00:01 ERROR request failed
+java.lang.NullPointerException
+	at com.example.Handler.run(Handler.java:42)
+
+2024-01-02 10:00:02 INFO done
+`)
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 records, got %v", recs)
+	}
+	if len(recs[1]) != 3 || recs[1][0] != "2024-01-02 10:00:01 ERROR request failed" || recs[1][2] != "\tat com.example.Handler.run(Handler.java:42)" {
+		t.Errorf("unexpected record: %v", recs[1])
+	}
+	if len(recs[0]) != 1 || len(recs[2]) != 1 {
+		t.Errorf("unexpected records: %v %v", recs[0], recs[2])
+	}
+}
@@ -0,0 +1,232 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// oboStanzas reads the stanzas of an OBO ontology (e.g. the Gene Ontology), one record per
+// stanza of the selected types. The stanza type (e.g. "Term") is in the SectionField, and its
+// tags are the other fields, with repeated tags (e.g. "is_a") joined by tabs. Trailing "!"
+// comments are removed, and the text of "def" is unquoted without its cross-references. The
+// header tags before the first stanza are ignored. Records are encoded as a JSON object of
+// the fields.
+type oboStanzas struct {
+	Stanzas map[string]bool
+
+	scanner *bufio.Scanner
+	pending string // the header line of the next stanza
+	more    bool
+	start   Position
+
+	scanPosition
+	scanLimits
+}
+
+func (f *oboStanzas) Init(spec map[string]string) error {
+	f.Stanzas = map[string]bool{"Term": true}
+	if v, found := spec["stanzas"]; found {
+		f.Stanzas = make(map[string]bool)
+		for _, s := range strings.Split(v, ",") {
+			f.Stanzas[strings.TrimSpace(s)] = true
+		}
+	}
+	return f.scanLimits.init(spec)
+}
+
+func (f *oboStanzas) Open(r io.Reader) error {
+	if f.Stanzas == nil {
+		f.Stanzas = map[string]bool{"Term": true}
+	}
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	f.more = false
+	for f.scanner.Scan() {
+		if line := strings.TrimSpace(f.scanner.Text()); strings.HasPrefix(line, "[") {
+			f.pending, f.more = line, true
+			break
+		}
+	}
+	return f.scanError(f.scanner.Err())
+}
+
+func (f *oboStanzas) NextRecord() (string, error) {
+	for f.more {
+		// the header line was scanned ahead, so its position is saved before the next Scan
+		f.start = f.scanPosition.Position()
+		stanza := strings.TrimSuffix(strings.TrimPrefix(f.pending, "["), "]")
+		tags := map[string][]string{SectionField: {stanza}}
+
+		f.more = false
+		for f.scanner.Scan() {
+			line := strings.TrimSpace(f.scanner.Text())
+			if strings.HasPrefix(line, "[") {
+				f.pending, f.more = line, true
+				break
+			}
+			if line == "" || line[0] == '!' {
+				continue
+			}
+			if i := strings.IndexByte(line, ':'); i > 0 {
+				tag, value := line[:i], oboValue(line[:i], strings.TrimSpace(line[i+1:]))
+				tags[tag] = append(tags[tag], value)
+			}
+		}
+		if err := f.scanError(f.scanner.Err()); err != nil {
+			return "", err
+		}
+		if !f.Stanzas[stanza] {
+			continue
+		}
+
+		rec := make(map[string]string, len(tags))
+		for tag, values := range tags {
+			rec[tag] = strings.Join(values, "\t")
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return "", err
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "obo")
+		return string(data), nil
+	}
+	return "", io.EOF
+}
+
+// oboValue removes the trailing comment from a tag value, and the quotes and cross-references
+// from a definition.
+func oboValue(tag, value string) string {
+	quoted, escaped := false, false
+	for i, c := range value {
+		if escaped {
+			escaped = false
+		} else if c == '\\' {
+			escaped = true
+		} else if c == '"' {
+			quoted = !quoted
+		} else if c == '!' && !quoted {
+			value = strings.TrimSpace(value[:i])
+			break
+		}
+	}
+	if tag != "def" || !strings.HasPrefix(value, `"`) {
+		return value
+	}
+	if i := strings.LastIndex(value, `" [`); i > 0 {
+		value = value[1:i]
+	} else {
+		value = strings.TrimSuffix(value[1:], `"`)
+	}
+	return strings.Replace(value, `\"`, `"`, -1)
+}
+
+func (f *oboStanzas) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *oboStanzas) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+// Position returns the position of the header line of the most recent stanza.
+func (f *oboStanzas) Position() Position {
+	return f.start
+}
+
+func (f *oboStanzas) HasVariableFields() bool {
+	return true
+}
+
+////////
+
+const owlNamespace = "http://www.w3.org/2002/07/owl#"
+
+// owlClass is the structure of a named owl:Class element.
+type owlClass struct {
+	About      string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Labels     []string `xml:"http://www.w3.org/2000/01/rdf-schema# label"`
+	SubClassOf []struct {
+		Resource string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource,attr"`
+	} `xml:"http://www.w3.org/2000/01/rdf-schema# subClassOf"`
+}
+
+// owlClasses reads the named classes of an OWL ontology in RDF/XML, with the fields "id" (the
+// class IRI), "label" and "parents" (the IRIs of its named superclasses, joined by tabs).
+// Anonymous classes and class expressions, such as restrictions, are ignored. Records are
+// encoded as a JSON object of the fields.
+type owlClasses struct {
+	decoder *xml.Decoder
+}
+
+func (f *owlClasses) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *owlClasses) Open(r io.Reader) error {
+	f.decoder = xml.NewDecoder(r)
+	f.decoder.CharsetReader = charsetReader
+	return nil
+}
+
+func (f *owlClasses) NextRecord() (string, error) {
+	for {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Space != owlNamespace || se.Name.Local != "Class" {
+			continue
+		}
+
+		var c owlClass
+		if err = f.decoder.DecodeElement(&c, &se); err != nil {
+			return "", fmt.Errorf("owl format: %s", err)
+		}
+		if c.About == "" {
+			continue
+		}
+		var parents []string
+		for _, sc := range c.SubClassOf {
+			if sc.Resource != "" {
+				parents = append(parents, sc.Resource)
+			}
+		}
+		data, err := json.Marshal(map[string]string{
+			"id":      c.About,
+			"label":   strings.Join(c.Labels, "\t"),
+			"parents": strings.Join(parents, "\t"),
+		})
+		if err != nil {
+			return "", err
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "owl")
+		return string(data), nil
+	}
+}
+
+func (f *owlClasses) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *owlClasses) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+func (f *owlClasses) HasVariableFields() bool {
+	return false
+}
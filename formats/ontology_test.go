@@ -0,0 +1,56 @@
+package formats_test
+
+import "testing"
+
+func TestOntologyFormats(t *testing.T) {
+	obo := `format-version: 1.2
+ontology: go
+
+[Term]
+id: GO:0000001
+name: mitochondrion inheritance ! a comment
+def: "The distribution of \"mitochondria\"." [GOC:mcc]
+is_a: GO:0048308 ! organelle inheritance
+is_a: GO:0048311
+
+[Typedef]
+id: part_of
+
+[Term]
+id: GO:0000002
+name: "quoted ! name"
+`
+	recs := readAllFields(t, map[string]string{"type": "obo"}, obo)
+	if len(recs) != 2 {
+		t.Fatalf("unexpected records: %v", recs)
+	}
+	if r := recs[0]; r["_section"] != "Term" || r["name"] != "mitochondrion inheritance" ||
+		r["def"] != `The distribution of "mitochondria".` || r["is_a"] != "GO:0048308\tGO:0048311" {
+		t.Errorf("unexpected term: %v", r)
+	}
+	if r := recs[1]; r["id"] != "GO:0000002" || r["name"] != `"quoted ! name"` {
+		t.Errorf("unexpected term: %v", r)
+	}
+	if recs = readAllFields(t, map[string]string{"type": "obo", "stanzas": "Typedef"}, obo); len(recs) != 1 || recs[0]["id"] != "part_of" {
+		t.Errorf("unexpected typedefs: %v", recs)
+	}
+
+	owl := `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+    xmlns:rdfs="http://www.w3.org/2000/01/rdf-schema#" xmlns:owl="http://www.w3.org/2002/07/owl#">
+  <owl:Ontology rdf:about="http://example.org/onto"/>
+  <owl:Class rdf:about="http://example.org/onto#Animal"><rdfs:label>animal</rdfs:label></owl:Class>
+  <owl:Class rdf:about="http://example.org/onto#Dog">
+    <rdfs:label xml:lang="en">dog</rdfs:label>
+    <rdfs:subClassOf rdf:resource="http://example.org/onto#Animal"/>
+    <rdfs:subClassOf><owl:Restriction><owl:onProperty rdf:resource="http://example.org/onto#has"/></owl:Restriction></rdfs:subClassOf>
+  </owl:Class>
+</rdf:RDF>`
+	recs = readAllFields(t, map[string]string{"type": "owl"}, owl)
+	if len(recs) != 2 {
+		t.Fatalf("unexpected classes: %v", recs)
+	}
+	if r := recs[1]; r["id"] != "http://example.org/onto#Dog" || r["label"] != "dog" || r["parents"] != "http://example.org/onto#Animal" {
+		t.Errorf("unexpected class: %v", r)
+	}
+}
@@ -0,0 +1,295 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// parallelFormats are the formats which ParallelReader can split, since every record is a
+// single line.
+var parallelFormats = map[string]bool{
+	"tab-delimited":    true,
+	"simple-delimited": true,
+	"csv":              true,
+	"fixed":            true,
+	"regex":            true,
+	"access-log":       true,
+	"syslog":           true,
+}
+
+// parallelChunk is a chunk of whole lines of the input, and the records parsed from it.
+type parallelChunk struct {
+	seq     int
+	data    []byte
+	records []map[interface{}]string
+	err     error
+}
+
+// ParallelReader parses the records of a line-based format with several workers at once. The
+// input is split into chunks of whole lines, and each chunk is parsed by a separate DataFormat
+// created from the same spec, after the lines before the first record (the "skip_lines" and
+// "header" lines), so that the records are keyed in the same way as when reading the whole
+// input. Records must not span lines (e.g. CSV fields with quoted newlines), and the
+// "skip_footer" option is not supported. For "csv", the number of fields is only checked
+// within each chunk, unless the "num_fields" option is given.
+type ParallelReader struct {
+	// Ordered is true if records are returned in input order. Otherwise, the records of each
+	// chunk are returned as soon as it is parsed.
+	Ordered bool
+
+	results chan parallelChunk
+	tokens  chan struct{} // limits the chunks in progress
+	done    chan struct{}
+	closed  bool
+
+	pending map[int]parallelChunk
+	next    int
+	current []map[interface{}]string
+	err     error
+}
+
+// NewParallelReader starts reading r with the format in spec, which must be one of the line-based
+// formats ("tab-delimited", "simple-delimited", "csv", "fixed", "regex", "access-log" or
+// "syslog"). The spec also has the options:
+//
+//       "workers"    = number of chunks to parse at once (default GOMAXPROCS)
+//       "chunk_size" = approximate size of each chunk in bytes (default 1048576)
+//       "ordered"    = "false" to return records as soon as their chunk is parsed, instead of
+//                      in input order (default "true")
+//
+// Call Close if the reader is not read to the end.
+func (r *Registry) NewParallelReader(rd io.Reader, spec map[string]string) (*ParallelReader, error) {
+	if !parallelFormats[spec["type"]] {
+		return nil, fmt.Errorf("format '%s' can not be read in parallel", spec["type"])
+	}
+	if v := spec["skip_footer"]; v != "" && v != "0" {
+		return nil, fmt.Errorf("skip_footer option is not supported for parallel reading")
+	}
+	if v, found := spec["records"]; found && v != "\n" {
+		return nil, fmt.Errorf("only newline record delimiters are supported for parallel reading")
+	}
+	workers, err := parallelOption(spec, "workers", runtime.GOMAXPROCS(0))
+	if err != nil {
+		return nil, err
+	}
+	chunkSize, err := parallelOption(spec, "chunk_size", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	ordered := true
+	if v, found := spec["ordered"]; found {
+		if ordered, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("ordered option must be true or false, not '%s'", v)
+		}
+	}
+
+	dfs := make([]DataFormat, workers)
+	for i := range dfs {
+		if dfs[i], err = r.GetDataFormat(spec); err != nil {
+			return nil, err
+		}
+	}
+	br := bufio.NewReaderSize(rd, 64<<10)
+	preamble, err := readPreamble(br, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ParallelReader{
+		Ordered: ordered,
+		results: make(chan parallelChunk, workers),
+		tokens:  make(chan struct{}, 2*workers),
+		done:    make(chan struct{}),
+		pending: make(map[int]parallelChunk),
+	}
+	jobs := make(chan parallelChunk)
+	go p.split(br, jobs, chunkSize)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for _, df := range dfs {
+		go func(df DataFormat) {
+			defer wg.Done()
+			p.parse(df, preamble, jobs)
+		}(df)
+	}
+	go func() {
+		wg.Wait()
+		close(p.results)
+	}()
+	return p, nil
+}
+
+// NewParallelReader starts reading r with the format in spec from the DefaultRegistry.
+func NewParallelReader(rd io.Reader, spec map[string]string) (*ParallelReader, error) {
+	return DefaultRegistry.NewParallelReader(rd, spec)
+}
+
+// parallelOption returns the positive integer option name from spec, or def if it is not set.
+func parallelOption(spec map[string]string, name string, def int) (int, error) {
+	v, found := spec[name]
+	if !found {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s option must be a positive integer, not '%s'", name, v)
+	}
+	return n, nil
+}
+
+// readPreamble reads the "skip_lines" lines and the header line, along with any blank or
+// comment lines before it.
+func readPreamble(br *bufio.Reader, spec map[string]string) ([]byte, error) {
+	var h headerRow
+	if err := h.init(spec); err != nil {
+		return nil, err
+	}
+	comment := []byte(spec["comments"])
+
+	var preamble []byte
+	readLine := func() ([]byte, error) {
+		line, err := br.ReadBytes('\n')
+		preamble = append(preamble, line...)
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+	for i := 0; i < h.SkipLines; i++ {
+		if _, err := readLine(); err != nil {
+			if err == io.EOF {
+				return preamble, nil
+			}
+			return nil, err
+		}
+	}
+	// blank and comment lines before the header row are skipped
+	for h.Header {
+		text, err := readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(text) > 0 && (len(comment) == 0 || !bytes.HasPrefix(text, comment)) {
+			break
+		}
+	}
+	return preamble, nil
+}
+
+// split reads chunks of whole lines from br.
+func (p *ParallelReader) split(br *bufio.Reader, jobs chan<- parallelChunk, size int) {
+	defer close(jobs)
+	for seq := 0; ; seq++ {
+		c := parallelChunk{seq: seq, data: make([]byte, size)}
+		n, err := io.ReadFull(br, c.data)
+		c.data = c.data[:n]
+		if err == nil {
+			var rest []byte
+			rest, err = br.ReadBytes('\n')
+			c.data = append(c.data, rest...)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			if len(c.data) == 0 {
+				return
+			}
+		}
+		c.err = err
+
+		select {
+		case p.tokens <- struct{}{}:
+		case <-p.done:
+			return
+		}
+		select {
+		case jobs <- c:
+		case <-p.done:
+			return
+		}
+		if c.err != nil || n < size {
+			return
+		}
+	}
+}
+
+// parse parses each chunk with df.
+func (p *ParallelReader) parse(df DataFormat, preamble []byte, jobs <-chan parallelChunk) {
+	for c := range jobs {
+		if c.err == nil {
+			c.err = df.Open(io.MultiReader(bytes.NewReader(preamble), bytes.NewReader(c.data)))
+		}
+		for c.err == nil {
+			fields, err := df.NextRecordFields()
+			if err == io.EOF {
+				break
+			}
+			c.err = err
+			if err == nil {
+				c.records = append(c.records, fields)
+			}
+		}
+		c.data = nil
+
+		select {
+		case p.results <- c:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// nextChunk returns the next chunk to be returned, or false at the end of input.
+func (p *ParallelReader) nextChunk() (parallelChunk, bool) {
+	if !p.Ordered {
+		c, ok := <-p.results
+		return c, ok
+	}
+	for {
+		if c, found := p.pending[p.next]; found {
+			delete(p.pending, p.next)
+			p.next++
+			return c, true
+		}
+		c, ok := <-p.results
+		if !ok {
+			return parallelChunk{}, false
+		}
+		p.pending[c.seq] = c
+	}
+}
+
+// NextRecordFields returns the fields of the next record, or io.EOF at the end of input. An
+// error in a chunk is returned after the records which were parsed before it, and stops
+// reading.
+func (p *ParallelReader) NextRecordFields() (map[interface{}]string, error) {
+	for len(p.current) == 0 {
+		if p.err != nil {
+			return nil, p.err
+		}
+		c, ok := p.nextChunk()
+		if !ok {
+			p.err = io.EOF
+			continue
+		}
+		<-p.tokens
+		p.current, p.err = c.records, c.err
+	}
+	fields := p.current[0]
+	p.current = p.current[1:]
+	return fields, nil
+}
+
+// Close stops reading, if the reader was not read to the end.
+func (p *ParallelReader) Close() error {
+	if !p.closed {
+		close(p.done)
+		p.closed = true
+	}
+	return nil
+}
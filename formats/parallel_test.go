@@ -0,0 +1,68 @@
+package formats_test
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestParallelReader(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("# generated\nid\tvalue\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&sb, "%d\tv%d\n", i, i)
+	}
+	input := sb.String()
+
+	for _, ordered := range []string{"true", "false"} {
+		spec := map[string]string{"type": "tab-delimited", "header": "true", "comments": "#",
+			"workers": "4", "chunk_size": "100", "ordered": ordered}
+		pr, err := formats.NewParallelReader(strings.NewReader(input), spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		inOrder := true
+		for n := 0; ; n++ {
+			fields, err := pr.NextRecordFields()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields["value"] != "v"+fields["id"] {
+				t.Fatalf("unexpected record: %v", fields)
+			}
+			inOrder = inOrder && fields["id"] == strconv.Itoa(n)
+			seen[fields["id"]] = true
+		}
+		if len(seen) != 1000 || (ordered == "true" && !inOrder) {
+			t.Errorf("ordered=%s: read %d records, in order %v", ordered, len(seen), inOrder)
+		}
+		pr.Close()
+	}
+
+	pr, err := formats.NewParallelReader(strings.NewReader("a,b\n1,2\n3,x\"y\n"), map[string]string{"type": "csv", "chunk_size": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pr.NextRecordFields(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pr.NextRecordFields(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pr.NextRecordFields(); err == nil || err == io.EOF {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+	pr.Close()
+
+	if _, err = formats.NewParallelReader(strings.NewReader(""), map[string]string{"type": "json"}); err == nil {
+		t.Error("expected an error for a format which is not line-based")
+	}
+}
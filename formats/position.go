@@ -0,0 +1,50 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// Position describes where a record begins in the input given to Open.
+type Position struct {
+	// Line is the 1-based line number.
+	Line int
+
+	// Offset is the 0-based byte offset.
+	Offset int64
+}
+
+// Positioner is implemented by DataFormats which track where each record begins. Position
+// returns the position of the record most recently returned by NextRecord or NextRecordFields.
+type Positioner interface {
+	Position() Position
+}
+
+// scanPosition tracks the position of the tokens returned by a bufio.Scanner.
+type scanPosition struct {
+	pos  Position
+	next Position
+}
+
+// track wraps a split function (which must return tokens from the start of the data) to
+// record the position of each token.
+func (p *scanPosition) track(split bufio.SplitFunc) bufio.SplitFunc {
+	p.pos = Position{Line: 1}
+	p.next = p.pos
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil {
+			p.pos = p.next
+		}
+		if advance > 0 {
+			p.next.Offset += int64(advance)
+			p.next.Line += bytes.Count(data[:advance], []byte{'\n'})
+		}
+		return advance, token, err
+	}
+}
+
+// Position returns the position of the most recently scanned token.
+func (p *scanPosition) Position() Position {
+	return p.pos
+}
@@ -0,0 +1,39 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestPositions(t *testing.T) {
+	for _, spec := range []map[string]string{
+		{"type": "tab-delimited", "skip_lines": "1"},
+		{"type": "csv", "fields": "\t", "skip_lines": "1"},
+		{"type": "regex", "pattern": "^(.*)$"},
+	} {
+		df := openFormat(t, spec, "# comment\na\tb\nccc\td\n")
+		var positions []formats.Position
+		for {
+			if _, err := df.NextRecordFields(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			positions = append(positions, df.(formats.Positioner).Position())
+		}
+		want := []formats.Position{{Line: 2, Offset: 10}, {Line: 3, Offset: 14}}
+		if spec["type"] == "regex" {
+			want = append([]formats.Position{{Line: 1, Offset: 0}}, want...)
+		}
+		if len(positions) != len(want) {
+			t.Fatalf("%s: unexpected positions %v", spec["type"], positions)
+		}
+		for i := range want {
+			if positions[i] != want[i] {
+				t.Errorf("%s: unexpected positions %v", spec["type"], positions)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// quotedReader reads CSV records for the cases which encoding/csv does not support: delimiters
+// of more than one character, and the "relaxed" quote handling. Quotes is one of:
+//
+//    "strict"  - as RFC 4180, with errors for bare and unterminated quotes
+//    "lazy"    - as csv.Reader.LazyQuotes, where bare quotes are literal
+//    "relaxed" - as "lazy", but records never span lines, so an unterminated quote is
+//                literal instead of consuming the rest of the input
+//
+// Errors are returned as *csv.ParseError, using the errors defined by encoding/csv.
+type quotedReader struct {
+	Delim            string
+	Comment          string
+	Quotes           string
+	TrimLeadingSpace bool
+	FieldsPerRecord  int
+
+	scanner *bufio.Scanner
+	line    string   // the line being parsed
+	start   Position // of the most recent record
+
+	scanPosition
+}
+
+func newQuotedReader(s *bufio.Scanner) *quotedReader {
+	q := &quotedReader{scanner: s}
+	s.Split(q.track(bufio.ScanLines))
+	return q
+}
+
+// Position returns the position of the most recent record.
+func (q *quotedReader) Position() Position {
+	return q.start
+}
+
+// Read returns the fields of the next record, or io.EOF at the end of input. Blank lines and
+// comments are skipped.
+func (q *quotedReader) Read() ([]string, error) {
+	for {
+		if !q.scanner.Scan() {
+			if err := q.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		q.line = q.scanner.Text()
+		if q.line != "" && (q.Comment == "" || !strings.HasPrefix(q.line, q.Comment)) {
+			break
+		}
+	}
+	q.start = q.scanPosition.Position()
+
+	var rec []string
+	line, more := q.line, true
+	for more {
+		if q.TrimLeadingSpace {
+			line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		}
+		var field string
+		var err error
+		if strings.HasPrefix(line, `"`) {
+			field, line, more, err = q.quotedField(line)
+		} else {
+			field, line, more, err = q.unquotedField(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec = append(rec, field)
+	}
+
+	if q.FieldsPerRecord == 0 {
+		q.FieldsPerRecord = len(rec)
+	} else if q.FieldsPerRecord > 0 && len(rec) != q.FieldsPerRecord {
+		return nil, q.error(q.line, csv.ErrFieldCount)
+	}
+	return rec, nil
+}
+
+// unquotedField returns the field at the start of line, the rest of the line after its
+// delimiter, and whether there was a delimiter.
+func (q *quotedReader) unquotedField(line string) (string, string, bool, error) {
+	field, rest := line, ""
+	i := strings.Index(line, q.Delim)
+	if i >= 0 {
+		field, rest = line[:i], line[i+len(q.Delim):]
+	}
+	if j := strings.IndexByte(field, '"'); j >= 0 && q.Quotes == "strict" {
+		return "", "", false, q.error(line[j:], csv.ErrBareQuote)
+	}
+	return field, rest, i >= 0, nil
+}
+
+// quotedField is as unquotedField for a line which starts with a quote. Except in "relaxed"
+// mode, the field may continue onto the following lines.
+func (q *quotedReader) quotedField(line string) (string, string, bool, error) {
+	var sb strings.Builder
+	rest := line[1:]
+	for {
+		i := strings.IndexByte(rest, '"')
+		if i == -1 {
+			if q.Quotes == "relaxed" {
+				return q.unquotedField(line)
+			}
+			sb.WriteString(rest)
+			if !q.scanner.Scan() {
+				if err := q.scanner.Err(); err != nil {
+					return "", "", false, err
+				}
+				if q.Quotes == "strict" {
+					return "", "", false, q.error("", csv.ErrQuote)
+				}
+				return sb.String(), "", false, nil
+			}
+			sb.WriteByte('\n')
+			q.line = q.scanner.Text()
+			rest = q.line
+			continue
+		}
+
+		sb.WriteString(rest[:i])
+		rest = rest[i+1:]
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			sb.WriteByte('"')
+			rest = rest[1:]
+		case rest == "":
+			return sb.String(), "", false, nil
+		case strings.HasPrefix(rest, q.Delim):
+			return sb.String(), rest[len(q.Delim):], true, nil
+		case q.Quotes == "strict":
+			return "", "", false, q.error(rest, csv.ErrQuote)
+		default:
+			sb.WriteByte('"')
+		}
+	}
+}
+
+// error returns a csv.ParseError at the start of rest, which is the end of the current line.
+func (q *quotedReader) error(rest string, err error) error {
+	return &csv.ParseError{
+		StartLine: q.start.Line,
+		Line:      q.scanPosition.Position().Line,
+		Column:    len(q.line) - len(rest) + 1,
+		Err:       err,
+	}
+}
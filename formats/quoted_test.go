@@ -0,0 +1,42 @@
+package formats_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCSVQuotes(t *testing.T) {
+	input := "id||name||size\n1||\"12\" pipe\"||5\" nail\n2|| \"a||b\"||\"open\n3||\"multi\nline\"||x\n"
+	for _, tc := range []struct {
+		spec map[string]string
+		want []string
+	}{
+		{map[string]string{"quotes": "relaxed", "trim_leading_space": "true"},
+			[]string{`12" pipe/5" nail`, `a||b/"open`, `"multi/`, "x/"}},
+		// an unterminated quote continues to the next closing quote
+		{map[string]string{"quotes": "lazy"},
+			[]string{`12" pipe/5" nail`, ` "a/b"`}},
+	} {
+		tc.spec["type"], tc.spec["fields"], tc.spec["header"], tc.spec["num_fields"] = "csv", "||", "true", "-1"
+		var got []string
+		for _, rec := range readAllFields(t, tc.spec, input) {
+			got = append(got, rec["name"]+"/"+rec["size"])
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: unexpected records: %q", tc.spec["quotes"], got)
+		}
+	}
+
+	recs := readAllFields(t, map[string]string{"type": "csv", "fields": "§", "quotes": "lazy"},
+		"a§b\"c§\"d\"\n")
+	if len(recs) != 1 || recs[0][1] != `b"c` || recs[0][2] != "d" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	df := openFormat(t, map[string]string{"type": "csv", "fields": "||"}, "1||2\"3\n")
+	if _, err := df.NextRecordFields(); !errors.Is(err, csv.ErrBareQuote) {
+		t.Errorf("expected a bare quote error, got %v", err)
+	}
+}
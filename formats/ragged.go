@@ -0,0 +1,141 @@
+package formats
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// RaggedFormat is implemented by DataFormats created with the "fixed_fields" option.
+type RaggedFormat interface {
+	DataFormat
+
+	// Ragged returns the number of records read which did not have exactly the declared
+	// fields, including any which were returned as errors.
+	Ragged() int
+}
+
+////////
+
+// raggedFormat wraps a DataFormat to return records with a fixed set of fields. With the "fix"
+// policy, missing fields are added with empty values and undeclared fields are removed, and
+// with the "error" policy a ragged record is an error, which can be handled by the "on_error"
+// option. Either way, ragged records are counted.
+type raggedFormat struct {
+	wrappedFormat
+	Name   string
+	Keys   []interface{}
+	Policy string
+
+	ragged int
+	fixed  Record // reused by NextFieldBytes
+}
+
+// newRaggedFormat wraps df if spec has the "fixed_fields" option, which is either a number of
+// fields (keyed from 0) or a list of field keys.
+func newRaggedFormat(df DataFormat, spec map[string]string) (DataFormat, error) {
+	v := spec["fixed_fields"]
+	if v == "" {
+		return df, nil
+	}
+	f := &raggedFormat{wrappedFormat: wrappedFormat{DataFormat: df}, Name: spec["type"], Policy: "fix"}
+	if p, found := spec["ragged"]; found {
+		if p != "fix" && p != "error" {
+			return nil, fmt.Errorf("ragged option must be 'fix' or 'error', not '%s'", p)
+		}
+		f.Policy = p
+	}
+
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("fixed_fields option must be a positive number or a list of fields, not '%s'", v)
+		}
+		for i := 0; i < n; i++ {
+			f.Keys = append(f.Keys, i)
+		}
+		return f, nil
+	}
+	f.Keys = parseFieldKeys(v)
+	return f, nil
+}
+
+func (f *raggedFormat) Ragged() int {
+	return f.ragged
+}
+
+func (f *raggedFormat) GetFields(record string) (map[interface{}]string, error) {
+	fields, err := f.DataFormat.GetFields(record)
+	if err != nil {
+		return nil, err
+	}
+	return f.fix(fields)
+}
+
+func (f *raggedFormat) NextRecordFields() (map[interface{}]string, error) {
+	fields, err := f.DataFormat.NextRecordFields()
+	if err != nil {
+		return nil, err
+	}
+	return f.fix(fields)
+}
+
+// fix returns the declared fields of a record, or an error for a ragged record with the
+// "error" policy.
+func (f *raggedFormat) fix(fields map[interface{}]string) (map[interface{}]string, error) {
+	ret := make(map[interface{}]string, len(f.Keys))
+	missing := 0
+	for _, k := range f.Keys {
+		v, found := fields[k]
+		if !found {
+			missing++
+		}
+		ret[k] = v
+	}
+	if err := f.check(missing, len(fields)-(len(f.Keys)-missing)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// check counts a record with missing or extra fields as ragged, and returns an error for it
+// with the "error" policy.
+func (f *raggedFormat) check(missing, extra int) error {
+	if missing == 0 && extra == 0 {
+		return nil
+	}
+	f.ragged++
+	metrics.Add(metrics.RecordsRagged, 1, "format", f.Name)
+	if f.Policy == "error" {
+		return &RecordError{Err: fmt.Errorf("record has %d missing and %d extra fields", missing, extra)}
+	}
+	return nil
+}
+
+func (f *raggedFormat) NextRecordInto(rec *Record) error {
+	if err := f.wrappedFormat.NextRecordInto(&f.rec); err != nil {
+		return err
+	}
+	rec.Reset()
+	missing := 0
+	for _, k := range f.Keys {
+		v, found := f.rec.Get(k)
+		if !found {
+			missing++
+		}
+		rec.Keys = append(rec.Keys, k)
+		rec.Values = append(rec.Values, v)
+	}
+	return f.check(missing, f.rec.Len()-(len(f.Keys)-missing))
+}
+
+func (f *raggedFormat) NextFieldBytes() ([][]byte, error) {
+	if err := f.NextRecordInto(&f.fixed); err != nil {
+		return nil, err
+	}
+	return f.fieldBytes(&f.fixed), nil
+}
+
+func (f *raggedFormat) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,41 @@
+package formats_test
+
+import (
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestFixedFields(t *testing.T) {
+	input := "a\tb\tc\nd\te\nf\tg\th\ti\n"
+	spec := map[string]string{"type": "tab-delimited", "fixed_fields": "3"}
+	df := openFormat(t, spec, input)
+	if df.HasVariableFields() {
+		t.Error("fixed fields should not be variable")
+	}
+	var recs []map[interface{}]string
+	for {
+		rec, err := df.NextRecordFields()
+		if err != nil {
+			break
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 || len(recs[1]) != 3 || recs[1][2] != "" || len(recs[2]) != 3 || recs[2][2] != "h" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+	if n := df.(formats.RaggedFormat).Ragged(); n != 2 {
+		t.Errorf("expected 2 ragged records, got %d", n)
+	}
+
+	spec["ragged"], spec["on_error"] = "error", "collect"
+	recs = readAllFields(t, spec, input)
+	if len(recs) != 1 || recs[0][0] != "a" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "json", "fixed_fields": "id,name"}, `{"id":"1","extra":"x"}`)
+	if len(recs) != 1 || len(recs[0]) != 2 || recs[0]["id"] != "1" || recs[0]["name"] != "" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
@@ -0,0 +1,172 @@
+package formats
+
+import (
+	"sync"
+)
+
+// Record is an ordered set of fields, which can be accessed by position or by key. Unlike the
+// map[interface{}]string returned by NextRecordFields, a Record can be reused for every record
+// in a file, so that reading does not allocate. Keys are ints (0-based column indexes) or
+// strings, as for the map API.
+type Record struct {
+	Keys   []interface{}
+	Values []string
+}
+
+// Len returns the number of fields in the record.
+func (r *Record) Len() int {
+	return len(r.Values)
+}
+
+// Get returns the value of the field with the given key, and whether it was found.
+func (r *Record) Get(key interface{}) (string, bool) {
+	for i, k := range r.Keys {
+		if k == key {
+			return r.Values[i], true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the field with the given key, adding it to the end of the record if
+// it is not already present.
+func (r *Record) Set(key interface{}, value string) {
+	for i, k := range r.Keys {
+		if k == key {
+			r.Values[i] = value
+			return
+		}
+	}
+	r.Keys = append(r.Keys, key)
+	r.Values = append(r.Values, value)
+}
+
+// Reset removes all fields, keeping the allocated space for reuse.
+func (r *Record) Reset() {
+	r.Keys = r.Keys[:0]
+	r.Values = r.Values[:0]
+}
+
+// Clone returns a copy of the record which does not share any memory with it.
+func (r *Record) Clone() *Record {
+	c := &Record{Keys: make([]interface{}, len(r.Keys)), Values: make([]string, len(r.Values))}
+	copy(c.Keys, r.Keys)
+	copy(c.Values, r.Values)
+	return c
+}
+
+// Map returns the fields of the record as a map, as used by NextRecordFields.
+func (r *Record) Map() map[interface{}]string {
+	ret := make(map[interface{}]string, len(r.Values))
+	for i, k := range r.Keys {
+		ret[k] = r.Values[i]
+	}
+	return ret
+}
+
+// SetMap replaces the fields of the record with those of a map. Fields are ordered with
+// integer keys first, followed by names in sorted order.
+func (r *Record) SetMap(fields map[interface{}]string) {
+	r.Reset()
+	for _, k := range sortedKeys(fields) {
+		r.Keys = append(r.Keys, k)
+		r.Values = append(r.Values, fields[k])
+	}
+}
+
+var recordPool = sync.Pool{New: func() interface{} { return &Record{} }}
+
+// GetRecord returns an empty Record from a pool. Call PutRecord when it is no longer needed.
+func GetRecord() *Record {
+	return recordPool.Get().(*Record)
+}
+
+// PutRecord returns a Record to the pool used by GetRecord. The record must not be used again.
+func PutRecord(r *Record) {
+	r.Reset()
+	recordPool.Put(r)
+}
+
+// RecordFormat is implemented by DataFormats which can read records directly into a Record.
+type RecordFormat interface {
+	DataFormat
+
+	// NextRecordInto replaces the fields of rec with those of the next record, or returns
+	// io.EOF at the end of input. This method requires a prior call to Open()
+	NextRecordInto(rec *Record) error
+}
+
+// ReadRecord reads the next record from df into rec, using NextRecordInto if df is a
+// RecordFormat, and NextRecordFields otherwise.
+func ReadRecord(df DataFormat, rec *Record) error {
+	if rf, ok := df.(RecordFormat); ok {
+		return rf.NextRecordInto(rec)
+	}
+	fields, err := df.NextRecordFields()
+	if err != nil {
+		return err
+	}
+	rec.SetMap(fields)
+	return nil
+}
+
+////////
+
+// wrappedFormat is embedded by the DataFormats which wrap another to implement an option (e.g.
+// "charset" or "on_error"), and forwards the optional interfaces of the wrapped DataFormat.
+// Wrappers which change the fields of a record must override NextRecordInto and
+// NextFieldBytes.
+type wrappedFormat struct {
+	DataFormat
+
+	rec   Record   // reused by NextFieldBytes when the DataFormat is not a FieldBytesFormat
+	split [][]byte // reused by fieldBytes
+}
+
+// Position returns the position of the most recent record, if the DataFormat is a Positioner.
+func (w *wrappedFormat) Position() Position {
+	if p, ok := w.DataFormat.(Positioner); ok {
+		return p.Position()
+	}
+	return Position{}
+}
+
+// NextRecordInto reads the next record using NextRecordInto if the DataFormat is a
+// RecordFormat. Otherwise the record is parsed with GetFields, and a parsing error is returned
+// as a RecordError, since the input can still be read.
+func (w *wrappedFormat) NextRecordInto(rec *Record) error {
+	if rf, ok := w.DataFormat.(RecordFormat); ok {
+		return rf.NextRecordInto(rec)
+	}
+	s, err := w.DataFormat.NextRecord()
+	if err != nil {
+		return err
+	}
+	fields, err := w.DataFormat.GetFields(s)
+	if err != nil {
+		return &RecordError{Record: s, Err: err}
+	}
+	rec.SetMap(fields)
+	return nil
+}
+
+// NextFieldBytes returns the fields of the next record without allocating if the DataFormat
+// is a FieldBytesFormat, or copies them from a Record otherwise.
+func (w *wrappedFormat) NextFieldBytes() ([][]byte, error) {
+	if fb, ok := w.DataFormat.(FieldBytesFormat); ok {
+		return fb.NextFieldBytes()
+	}
+	if err := w.NextRecordInto(&w.rec); err != nil {
+		return nil, err
+	}
+	return w.fieldBytes(&w.rec), nil
+}
+
+// fieldBytes copies the values of rec for NextFieldBytes.
+func (w *wrappedFormat) fieldBytes(rec *Record) [][]byte {
+	w.split = w.split[:0]
+	for _, v := range rec.Values {
+		w.split = append(w.split, []byte(v))
+	}
+	return w.split
+}
@@ -0,0 +1,126 @@
+package formats_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestReadRecord(t *testing.T) {
+	for _, typ := range []string{"tab-delimited", "simple-delimited", "csv", "regex"} {
+		df := openFormat(t, map[string]string{"type": typ, "header": "true",
+			"fields": "\t", "num_fields": "-1", "pattern": "^(?P<id>[^\t]*)\t(?P<name>[^\t]*)(?:\t(.*))?$"}, "id\tname\n1\ta\n2\tb\textra\n")
+		if typ == "regex" {
+			df.NextRecord() // the header row
+		}
+
+		rec := formats.GetRecord()
+		var got []string
+		for {
+			if err := formats.ReadRecord(df, rec); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			id, _ := rec.Get("id")
+			name, _ := rec.Get("name")
+			got = append(got, name+"/"+id)
+		}
+		if _, ok := df.(formats.RecordFormat); ok && (rec.Len() != 3 || rec.Keys[2] != 2 || rec.Values[0] != "2") {
+			t.Errorf("%s: unexpected record: %v", typ, rec)
+		}
+		formats.PutRecord(rec)
+		if len(got) != 2 || got[0] != "a/1" || got[1] != "b/2" {
+			t.Errorf("%s: unexpected records: %v", typ, got)
+		}
+	}
+}
+
+func TestWrappedFormats(t *testing.T) {
+	input := "1\ta\n2\n3\tc\n"
+	for _, opts := range []map[string]string{
+		{"charset": "latin1"},
+		{"on_error": "skip"},
+		{"fixed_fields": "2", "ragged": "error", "on_error": "skip"},
+		{"fixed_fields": "0,1", "types": "0:int", "on_error": "collect"},
+	} {
+		spec := map[string]string{"type": "tab-delimited"}
+		for k, v := range opts {
+			spec[k] = v
+		}
+		df := openFormat(t, spec, input)
+		if _, ok := df.(formats.Positioner); !ok {
+			t.Errorf("%v: not a Positioner", opts)
+		}
+		if _, ok := df.(formats.RecordFormat); !ok {
+			t.Errorf("%v: not a RecordFormat", opts)
+		}
+
+		rec := formats.GetRecord()
+		var got []string
+		for {
+			if err := formats.ReadRecord(df, rec); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := rec.Get(1)
+			got = append(got, b)
+		}
+		formats.PutRecord(rec)
+		if p := df.(formats.Positioner).Position(); p.Line != 3 {
+			t.Errorf("%v: position of the last record is %+v", opts, p)
+		}
+		want := "a,,c"
+		if opts["ragged"] == "error" {
+			want = "a,c"
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%v: unexpected records: %q", opts, got)
+		}
+
+		df = openFormat(t, spec, input)
+		fb, ok := df.(formats.FieldBytesFormat)
+		if !ok {
+			t.Errorf("%v: not a FieldBytesFormat", opts)
+			continue
+		}
+		got = got[:0]
+		for {
+			fields, err := fb.NextFieldBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(bytes.Join(fields, []byte("|"))))
+		}
+		want = "1|a,2,3|c"
+		switch {
+		case opts["ragged"] == "error":
+			want = "1|a,3|c"
+		case opts["fixed_fields"] != "":
+			want = "1|a,2|,3|c"
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%v: unexpected field bytes: %q", opts, got)
+		}
+	}
+}
+
+func TestWrappedFieldBytesAllocs(t *testing.T) {
+	df := openFormat(t, map[string]string{"type": "tab-delimited", "on_error": "skip"}, strings.Repeat("1\ta\tb\n", 1000))
+	fb := df.(formats.FieldBytesFormat)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := fb.NextFieldBytes(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("NextFieldBytes allocated %v times per record", allocs)
+	}
+}
@@ -0,0 +1,126 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// compilePattern compiles the "pattern" option of the regex-based formats, which must have at
+// least one capture group.
+func compilePattern(format, pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s format pattern is invalid: %s", format, err)
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("%s format pattern must have at least one capture group", format)
+	}
+	return re, nil
+}
+
+// patternFields returns the capture groups of re in s, keyed by group name, or by group number
+// (starting at 1) for unnamed groups. It returns nil if s does not match.
+func patternFields(re *regexp.Regexp, s string) map[interface{}]string {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	ret := make(map[interface{}]string, len(m)-1)
+	for i, name := range re.SubexpNames() {
+		if i == 0 {
+			continue
+		}
+		if name != "" {
+			ret[name] = m[i]
+		} else {
+			ret[i] = m[i]
+		}
+	}
+	return ret
+}
+
+// regexLines extracts fields from each line using the capture groups of a regular expression,
+// e.g. `^(?P<date>\S+) (?P<level>[A-Z]+) (?P<message>.*)$`. Lines which do not match are
+// skipped unless Unmatched is "error".
+type regexLines struct {
+	Pattern   *regexp.Regexp
+	Unmatched string
+
+	scanner *bufio.Scanner
+
+	scanPosition
+	scanLimits
+}
+
+func (f *regexLines) Init(spec map[string]string) error {
+	v, found := spec["pattern"]
+	if !found || v == "" {
+		return fmt.Errorf("regex format requires a pattern")
+	}
+	re, err := compilePattern("regex", v)
+	if err != nil {
+		return err
+	}
+	f.Pattern = re
+
+	f.Unmatched = "skip"
+	if v, found := spec["unmatched"]; found {
+		if v != "skip" && v != "error" {
+			return fmt.Errorf("regex format unmatched must be 'skip' or 'error', not '%s'", v)
+		}
+		f.Unmatched = v
+	}
+	return f.scanLimits.init(spec)
+}
+
+func (f *regexLines) Open(r io.Reader) error {
+	if f.Pattern == nil {
+		return fmt.Errorf("regex format requires a pattern")
+	}
+	f.scanner = f.newScanner(r)
+	f.scanner.Split(f.track(bufio.ScanLines))
+	return nil
+}
+
+func (f *regexLines) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		line := strings.TrimRight(f.scanner.Text(), "\r")
+		if !f.Pattern.MatchString(line) {
+			if f.Unmatched == "error" {
+				return "", &RecordError{Record: line, Err: fmt.Errorf("regex format: line does not match pattern: '%s'", line)}
+			}
+			continue
+		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "regex")
+		return line, nil
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (f *regexLines) GetFields(record string) (map[interface{}]string, error) {
+	ret := patternFields(f.Pattern, strings.TrimRight(record, "\r\n"))
+	if ret == nil {
+		return nil, fmt.Errorf("regex format: record does not match pattern: '%s'", record)
+	}
+	return ret, nil
+}
+
+func (f *regexLines) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *regexLines) HasVariableFields() bool {
+	return false
+}
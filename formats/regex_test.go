@@ -0,0 +1,34 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestRegexFormat(t *testing.T) {
+	if _, err := formats.GetDataFormat(map[string]string{"type": "regex", "pattern": "no groups"}); err == nil {
+		t.Error("expected an error for a pattern without capture groups")
+	}
+	spec := map[string]string{"type": "regex", "pattern": `^(?P<date>\S+) (?P<level>[A-Z]+) (.*)$`}
+	recs := readAllFields(t, spec, "2024-01-02 INFO started\n# not a log line\n2024-01-03 WARN disk full\n")
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %v", recs)
+	}
+	if recs[1]["date"] != "2024-01-03" || recs[1]["level"] != "WARN" || recs[1][3] != "disk full" {
+		t.Errorf("unexpected fields: %v", recs[1])
+	}
+
+	spec["unmatched"] = "error"
+	df := openFormat(t, spec, "# not a log line\n")
+	if _, err := df.NextRecord(); err == nil || err == io.EOF {
+		t.Errorf("expected an error for an unmatched line, got %v", err)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "multiline", "record_start": `^\d`, "pattern": `(?s)^(?P<date>\S+) (?P<message>.*)$`},
+		"2024-01-02 request failed\n  at main.go:42\n2024-01-03 ok\n")
+	if len(recs) != 2 || recs[0]["message"] != "request failed\n  at main.go:42" {
+		t.Errorf("unexpected multiline fields: %v", recs)
+	}
+}
@@ -0,0 +1,70 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The default buffer sizes for line-based formats. Records may be up to MaxRecordBytes long,
+// and the buffer grows as needed from BufferSize.
+const (
+	defaultBufferSize     = 64 << 10
+	defaultMaxRecordBytes = 64 << 20
+)
+
+// scanLimits holds the "buffer_size" and "max_record_bytes" options of the line-based formats.
+type scanLimits struct {
+	BufferSize     int
+	MaxRecordBytes int
+}
+
+func (l *scanLimits) init(spec map[string]string) error {
+	l.BufferSize, l.MaxRecordBytes = defaultBufferSize, defaultMaxRecordBytes
+	for _, opt := range []struct {
+		name string
+		val  *int
+	}{{"buffer_size", &l.BufferSize}, {"max_record_bytes", &l.MaxRecordBytes}} {
+		v, found := spec[opt.name]
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s option must be a positive number of bytes, not '%s'", opt.name, v)
+		}
+		*opt.val = n
+	}
+	if l.BufferSize > l.MaxRecordBytes {
+		l.BufferSize = l.MaxRecordBytes
+	}
+	return nil
+}
+
+// limits returns the buffer size and maximum record length, using the defaults if Init wasn't
+// called.
+func (l *scanLimits) limits() (int, int) {
+	if l.MaxRecordBytes == 0 {
+		return defaultBufferSize, defaultMaxRecordBytes
+	}
+	return l.BufferSize, l.MaxRecordBytes
+}
+
+// newScanner returns a bufio.Scanner for r with the configured buffer size and maximum record
+// length.
+func (l *scanLimits) newScanner(r io.Reader) *bufio.Scanner {
+	size, max := l.limits()
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, size), max)
+	return s
+}
+
+// scanError returns err with a clearer message if the record was too long.
+func (l *scanLimits) scanError(err error) error {
+	if err == bufio.ErrTooLong {
+		_, max := l.limits()
+		return fmt.Errorf("record is longer than %d bytes (see the max_record_bytes option)", max)
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package formats_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRecordLimits(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	input := "1\t" + long + "\n2\tb\n"
+	for _, spec := range []map[string]string{
+		{"type": "simple-delimited"},
+		{"type": "tab-delimited", "buffer_size": "1024"},
+		{"type": "csv", "fields": "\t"},
+		{"type": "fixed", "offsets": "0,2"},
+		{"type": "regex", "pattern": "^(.)\t(.*)$"},
+	} {
+		recs := readAllFields(t, spec, input)
+		if len(recs) != 2 || (len(recs[0][1]) != len(long) && len(recs[0][2]) != len(long)) {
+			t.Errorf("%s: unexpected records: %d", spec["type"], len(recs))
+		}
+
+		spec["max_record_bytes"] = "1000"
+		df := openFormat(t, spec, input)
+		if _, err := df.NextRecordFields(); err == nil || err == io.EOF {
+			t.Errorf("%s: expected an error for a long record, got %v", spec["type"], err)
+		}
+	}
+}
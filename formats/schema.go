@@ -0,0 +1,346 @@
+package formats
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType is the type of a field's values. Field values are always strings, but a typed
+// value is normalized into a canonical representation for its type.
+type FieldType string
+
+// The supported field types, ordered from the most specific to the least.
+const (
+	TypeInt    FieldType = "int"    // base 10 integers, e.g. "-42"
+	TypeFloat  FieldType = "float"  // floating-point numbers, e.g. "1.5e-3"
+	TypeBool   FieldType = "bool"   // "true" or "false"
+	TypeDate   FieldType = "date"   // "2006-01-02", or RFC 3339 if a time is included
+	TypeString FieldType = "string" // any value
+)
+
+// dateLayouts are the layouts accepted for TypeDate values. The layouts with a time component
+// are normalized to RFC 3339.
+var dateLayouts = []struct {
+	layout  string
+	hasTime bool
+}{
+	{"2006-01-02", false},
+	{time.RFC3339Nano, true},
+	{"2006-01-02T15:04:05", true},
+	{"2006-01-02 15:04:05", true},
+	{"2006/01/02", false},
+	{"02-Jan-2006", false},
+	{"Jan 2, 2006", false},
+}
+
+// ParseFieldType returns the FieldType with the given name. The empty name is TypeString.
+func ParseFieldType(name string) (FieldType, error) {
+	switch t := FieldType(name); t {
+	case "":
+		return TypeString, nil
+	case TypeInt, TypeFloat, TypeBool, TypeDate, TypeString:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown type '%s'", name)
+}
+
+// Normalize checks that value is valid for the type and returns its canonical form.
+func (t FieldType) Normalize(value string) (string, error) {
+	switch t {
+	case "", TypeString:
+		return value, nil
+	case TypeInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("not an integer")
+		}
+		return strconv.FormatInt(i, 10), nil
+	case TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("not a number")
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case TypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("not a boolean")
+		}
+		return strconv.FormatBool(b), nil
+	case TypeDate:
+		for _, dl := range dateLayouts {
+			d, err := time.Parse(dl.layout, value)
+			if err != nil {
+				continue
+			}
+			if dl.hasTime {
+				return d.Format(time.RFC3339Nano), nil
+			}
+			return d.Format("2006-01-02"), nil
+		}
+		return "", fmt.Errorf("not a date")
+	}
+	return "", fmt.Errorf("unknown type '%s'", t)
+}
+
+// inferType returns the most specific type for value. Booleans are only inferred from the
+// words "true" and "false", so that columns of 0 and 1 are integers.
+func inferType(value string) FieldType {
+	for _, t := range []FieldType{TypeInt, TypeFloat, TypeDate} {
+		if _, err := t.Normalize(value); err == nil {
+			return t
+		}
+	}
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return TypeBool
+	}
+	return TypeString
+}
+
+// widenType returns a type which can represent the values of both a and b.
+func widenType(a, b FieldType) FieldType {
+	switch {
+	case a == b:
+		return a
+	case (a == TypeInt && b == TypeFloat) || (a == TypeFloat && b == TypeInt):
+		return TypeFloat
+	}
+	return TypeString
+}
+
+////////
+
+// SchemaField is the key and type of a field in a Schema.
+type SchemaField struct {
+	Key  interface{}
+	Type FieldType
+}
+
+// Schema describes the type of each field in a set of records. Fields are ordered with
+// integer keys first, followed by names in sorted order.
+type Schema struct {
+	Fields []SchemaField
+}
+
+// Type returns the type of the field with the given key, or TypeString if it is not in the
+// schema.
+func (s *Schema) Type(key interface{}) FieldType {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f.Type
+		}
+	}
+	return TypeString
+}
+
+// InferSchema returns the narrowest types which represent every non-empty value of the given
+// records. Fields with no non-empty values are strings.
+func InferSchema(records []map[interface{}]string) *Schema {
+	types := make(map[interface{}]FieldType)
+	all := make(map[interface{}]string)
+	for _, rec := range records {
+		for k, v := range rec {
+			all[k] = ""
+			if v == "" {
+				continue
+			}
+			t := inferType(v)
+			if prev, found := types[k]; found {
+				t = widenType(prev, t)
+			}
+			types[k] = t
+		}
+	}
+
+	s := &Schema{}
+	for _, k := range sortedKeys(all) {
+		t, found := types[k]
+		if !found {
+			t = TypeString
+		}
+		s.Fields = append(s.Fields, SchemaField{Key: k, Type: t})
+	}
+	return s
+}
+
+// SchemaFormat is implemented by DataFormats which return typed fields.
+type SchemaFormat interface {
+	DataFormat
+
+	// Schema returns the types of the fields, or nil if no types were requested. When types
+	// are inferred, this method requires a prior call to Open()
+	Schema() *Schema
+}
+
+////////
+
+// typedFormat wraps a DataFormat to normalize field values using declared or inferred types.
+// Types are inferred from the first Infer records, which are read by Open. Empty values are
+// not checked, and fields which are not in the schema are returned unchanged.
+type typedFormat struct {
+	DataFormat
+	Declared map[interface{}]FieldType
+	Infer    int
+
+	schema  *Schema
+	pending []string
+
+	// positions of the pending records, if the DataFormat is a Positioner
+	pendingPos []Position
+	pos        *Position
+}
+
+// newTypedFormat wraps df if spec has the "types" or "infer_types" options.
+func newTypedFormat(df DataFormat, spec map[string]string) (DataFormat, error) {
+	if spec["types"] == "" && spec["infer_types"] == "" {
+		return df, nil
+	}
+	f := &typedFormat{DataFormat: df, Declared: make(map[interface{}]FieldType)}
+	if v := spec["types"]; v != "" {
+		for _, decl := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(decl), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("types option must be a list of name:type, not '%s'", decl)
+			}
+			t, err := ParseFieldType(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			var key interface{} = parts[0]
+			if i, err := strconv.Atoi(parts[0]); err == nil {
+				key = i
+			}
+			f.Declared[key] = t
+		}
+	}
+	if v := spec["infer_types"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("infer_types option must be a number of records, not '%s'", v)
+		}
+		f.Infer = n
+	}
+	f.buildSchema(nil)
+	return f, nil
+}
+
+func (f *typedFormat) Open(r io.Reader) error {
+	if err := f.DataFormat.Open(r); err != nil {
+		return err
+	}
+
+	var samples []map[interface{}]string
+	f.pending, f.pendingPos, f.pos = f.pending[:0], f.pendingPos[:0], nil
+	for len(f.pending) < f.Infer {
+		rec, err := f.DataFormat.NextRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fields, err := f.DataFormat.GetFields(rec)
+		if err != nil {
+			return err
+		}
+		f.pending = append(f.pending, rec)
+		samples = append(samples, fields)
+		if p, ok := f.DataFormat.(Positioner); ok {
+			f.pendingPos = append(f.pendingPos, p.Position())
+		}
+	}
+
+	f.buildSchema(samples)
+	return nil
+}
+
+// buildSchema infers the schema from the sample records, and then applies the declared types.
+func (f *typedFormat) buildSchema(samples []map[interface{}]string) {
+	declared := make(map[interface{}]string, len(f.Declared))
+	for k := range f.Declared {
+		declared[k] = ""
+	}
+	f.schema = InferSchema(append(samples, declared))
+	for i, sf := range f.schema.Fields {
+		if t, found := f.Declared[sf.Key]; found {
+			f.schema.Fields[i].Type = t
+		}
+	}
+}
+
+func (f *typedFormat) Schema() *Schema {
+	return f.schema
+}
+
+func (f *typedFormat) NextRecord() (string, error) {
+	if len(f.pending) > 0 {
+		rec := f.pending[0]
+		f.pending = f.pending[1:]
+		if len(f.pendingPos) > 0 {
+			f.pos = &f.pendingPos[0]
+			f.pendingPos = f.pendingPos[1:]
+		}
+		return rec, nil
+	}
+	f.pos = nil
+	return f.DataFormat.NextRecord()
+}
+
+// Position returns the position of the most recent record, if the DataFormat is a Positioner.
+func (f *typedFormat) Position() Position {
+	if f.pos != nil {
+		return *f.pos
+	}
+	if p, ok := f.DataFormat.(Positioner); ok {
+		return p.Position()
+	}
+	return Position{}
+}
+
+// Ragged returns the number of ragged records, if the DataFormat is a RaggedFormat.
+func (f *typedFormat) Ragged() int {
+	if rf, ok := f.DataFormat.(RaggedFormat); ok {
+		return rf.Ragged()
+	}
+	return 0
+}
+
+func (f *typedFormat) GetFields(record string) (map[interface{}]string, error) {
+	fields, err := f.DataFormat.GetFields(record)
+	if err != nil {
+		return nil, err
+	}
+	return f.normalize(fields)
+}
+
+func (f *typedFormat) NextRecordFields() (map[interface{}]string, error) {
+	if len(f.pending) > 0 {
+		rec, _ := f.NextRecord()
+		return f.GetFields(rec)
+	}
+	f.pos = nil
+	fields, err := f.DataFormat.NextRecordFields()
+	if err != nil {
+		return nil, err
+	}
+	return f.normalize(fields)
+}
+
+// normalize converts the values of fields to the canonical form of their types.
+func (f *typedFormat) normalize(fields map[interface{}]string) (map[interface{}]string, error) {
+	for _, sf := range f.schema.Fields {
+		v, found := fields[sf.Key]
+		if !found || v == "" {
+			continue
+		}
+		nv, err := sf.Type.Normalize(v)
+		if err != nil {
+			return nil, fmt.Errorf("field '%v': %s (value '%s')", sf.Key, err, v)
+		}
+		fields[sf.Key] = nv
+	}
+	return fields, nil
+}
@@ -0,0 +1,41 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestFieldTypes(t *testing.T) {
+	input := "id,score,ok,added,name\n007,1,true,2020-01-02,x\n8,2.5,false,2021-03-04 05:06:07,y\n9,,true,,z\n"
+	df := openFormat(t, map[string]string{"type": "csv", "header": "true",
+		"infer_types": "2", "types": "name:string,extra:int"}, input)
+
+	schema := df.(formats.SchemaFormat).Schema()
+	want := map[interface{}]formats.FieldType{"id": formats.TypeInt, "score": formats.TypeFloat,
+		"ok": formats.TypeBool, "added": formats.TypeDate, "name": formats.TypeString, "extra": formats.TypeInt}
+	if len(schema.Fields) != len(want) {
+		t.Errorf("unexpected schema: %v", schema.Fields)
+	}
+	for k, typ := range want {
+		if schema.Type(k) != typ {
+			t.Errorf("expected %v to be %s, found %s", k, typ, schema.Type(k))
+		}
+	}
+
+	var recs []map[interface{}]string
+	for {
+		rec, err := df.NextRecordFields()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 || recs[0]["id"] != "7" || recs[1]["added"] != "2021-03-04T05:06:07Z" || recs[2]["score"] != "" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
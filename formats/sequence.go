@@ -0,0 +1,217 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// sequenceReader returns a line reader for sequence files, which are often gzip compressed
+// even when the file name does not say so.
+func sequenceReader(r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReaderSize(gz, 1<<16)
+	}
+	return br, nil
+}
+
+// readLine returns the next line without its line ending, or io.EOF.
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// splitHeader splits a sequence header line (without its leading '>' or '@') into an id and
+// a description.
+func splitHeader(header string, fields map[interface{}]string) {
+	header = strings.TrimSpace(header)
+	fields["id"], fields["description"] = header, ""
+	if i := strings.IndexAny(header, " \t"); i != -1 {
+		fields["id"], fields["description"] = header[:i], strings.TrimSpace(header[i+1:])
+	}
+}
+
+// fastaSequences reads each sequence in a FASTA file as a record, with the fields "id",
+// "description" and "sequence". Wrapped sequence lines are joined, and records are returned
+// as a header line followed by the unwrapped sequence.
+type fastaSequences struct {
+	reader *bufio.Reader
+	header string
+}
+
+func (f *fastaSequences) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *fastaSequences) Open(r io.Reader) error {
+	var err error
+	f.header = ""
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+func (f *fastaSequences) NextRecord() (string, error) {
+	for f.header == "" {
+		line, err := readLine(f.reader)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, ">") {
+			f.header = line
+		} else if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, ";") {
+			return "", fmt.Errorf("fasta format: expected '>' at start of record, found '%s'", line)
+		}
+	}
+
+	var seq strings.Builder
+	header := f.header
+	f.header = ""
+	for {
+		line, err := readLine(f.reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, ">") {
+			f.header = line
+			break
+		}
+		if strings.HasPrefix(line, ";") {
+			continue
+		}
+		seq.WriteString(strings.TrimSpace(line))
+	}
+
+	metrics.Add(metrics.RecordsParsed, 1, "format", "fasta")
+	return header + "\n" + seq.String(), nil
+}
+
+func (f *fastaSequences) GetFields(record string) (map[interface{}]string, error) {
+	if !strings.HasPrefix(record, ">") {
+		return nil, fmt.Errorf("fasta format: expected '>' at start of record")
+	}
+	lines := strings.SplitN(strings.TrimRight(record, "\r\n"), "\n", 2)
+	ret := make(map[interface{}]string, 3)
+	splitHeader(lines[0][1:], ret)
+	ret["sequence"] = ""
+	if len(lines) == 2 {
+		ret["sequence"] = strings.Replace(strings.Replace(lines[1], "\n", "", -1), "\r", "", -1)
+	}
+	return ret, nil
+}
+
+func (f *fastaSequences) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *fastaSequences) HasVariableFields() bool {
+	return false
+}
+
+////////
+
+// fastqSequences reads each read in a FASTQ file as a record, with the fields "id",
+// "description", "sequence" and "quality". Wrapped sequence and quality lines are joined, and
+// records are returned as the four standard lines.
+type fastqSequences struct {
+	reader *bufio.Reader
+}
+
+func (f *fastqSequences) Init(spec map[string]string) error {
+	return nil
+}
+
+func (f *fastqSequences) Open(r io.Reader) error {
+	var err error
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+func (f *fastqSequences) NextRecord() (string, error) {
+	header, err := readLine(f.reader)
+	for err == nil && strings.TrimSpace(header) == "" {
+		header, err = readLine(f.reader)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(header, "@") {
+		return "", fmt.Errorf("fastq format: expected '@' at start of record, found '%s'", header)
+	}
+
+	var seq, qual bytes.Buffer
+	for {
+		line, err := readLine(f.reader)
+		if err != nil {
+			return "", f.truncated(header, err)
+		}
+		if strings.HasPrefix(line, "+") {
+			break
+		}
+		seq.WriteString(strings.TrimSpace(line))
+	}
+	// quality lines may begin with '@', so they are read until they match the sequence length
+	for qual.Len() < seq.Len() {
+		line, err := readLine(f.reader)
+		if err != nil {
+			return "", f.truncated(header, err)
+		}
+		qual.WriteString(strings.TrimSpace(line))
+	}
+	if qual.Len() != seq.Len() {
+		return "", fmt.Errorf("fastq format: quality length does not match sequence length for '%s'", header)
+	}
+
+	metrics.Add(metrics.RecordsParsed, 1, "format", "fastq")
+	return header + "\n" + seq.String() + "\n+\n" + qual.String(), nil
+}
+
+func (f *fastqSequences) truncated(header string, err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("fastq format: record '%s' is truncated", header)
+	}
+	return err
+}
+
+func (f *fastqSequences) GetFields(record string) (map[interface{}]string, error) {
+	lines := strings.Split(strings.TrimRight(record, "\r\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "@") || !strings.HasPrefix(lines[2], "+") {
+		return nil, fmt.Errorf("fastq format: expected a 4-line record")
+	}
+	ret := make(map[interface{}]string, 4)
+	splitHeader(lines[0][1:], ret)
+	ret["sequence"] = strings.TrimSpace(lines[1])
+	ret["quality"] = strings.TrimSpace(lines[3])
+	return ret, nil
+}
+
+func (f *fastqSequences) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *fastqSequences) HasVariableFields() bool {
+	return false
+}
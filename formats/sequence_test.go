@@ -0,0 +1,37 @@
+package formats_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestSequenceFormats(t *testing.T) {
+	recs := readAllFields(t, map[string]string{"type": "fasta"}, ">sp|P04637|P53_HUMAN Cellular tumor antigen p53\nMEEPQSDPSV\r\nEPPLSQETFS\n\n>empty\n>seq2\nACGT\n")
+	if len(recs) != 3 {
+		t.Fatalf("expected 3 sequences, got %v", recs)
+	}
+	if recs[0]["id"] != "sp|P04637|P53_HUMAN" || recs[0]["description"] != "Cellular tumor antigen p53" || recs[0]["sequence"] != "MEEPQSDPSVEPPLSQETFS" {
+		t.Errorf("unexpected fields: %v", recs[0])
+	}
+	if recs[1]["sequence"] != "" || recs[2]["sequence"] != "ACGT" {
+		t.Errorf("unexpected sequences: %v %v", recs[1], recs[2])
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte("@read1 1:N:0\nACGT\nAC\n+\n@@II\nI#\n@read2\nGG\n+read2\nII\n"))
+	gz.Close()
+	recs = readAllFields(t, map[string]string{"type": "fastq"}, buf.String())
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 reads, got %v", recs)
+	}
+	if recs[0]["id"] != "read1" || recs[0]["sequence"] != "ACGTAC" || recs[0]["quality"] != "@@III#" || recs[1]["quality"] != "II" {
+		t.Errorf("unexpected fields: %v", recs)
+	}
+
+	df := openFormat(t, map[string]string{"type": "fastq"}, "@read1\nACGT\n+\nII\n")
+	if _, err := df.NextRecord(); err == nil {
+		t.Error("expected an error for a truncated read")
+	}
+}
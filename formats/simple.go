@@ -2,23 +2,45 @@ package formats
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"github.com/pbnjay/anydata/metrics"
 )
 
 type simpleDelimited struct {
 	FieldDelim  string
 	RecordDelim string
+	Comment     string
+	SkipFooter  int
 	rdLen       int
 	reader      io.Reader
 	scanner     *bufio.Scanner
+
+	// records are read SkipFooter ahead, so that the footer is never returned
+	ahead []delimitedLine
+	pos   Position
+
+	headerRow
+	scanPosition
+	scanLimits
+}
+
+// delimitedLine is a record which has been read ahead, and its position.
+type delimitedLine struct {
+	text string
+	pos  Position
 }
 
 func (f *simpleDelimited) Init(spec map[string]string) error {
+	if err := f.scanLimits.init(spec); err != nil {
+		return err
+	}
 	// defaults
 	f.FieldDelim = "\t"
 	f.RecordDelim = "\n"
@@ -31,9 +53,18 @@ func (f *simpleDelimited) Init(spec map[string]string) error {
 			f.RecordDelim = rd
 		}
 	}
+	f.Comment = spec["comments"]
+	f.SkipFooter = 0
+	if v, found := spec["skip_footer"]; found {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("skip_footer option must be a non-negative integer, not '%s'", v)
+		}
+		f.SkipFooter = n
+	}
 
 	f.rdLen = len([]byte(f.RecordDelim))
-	return nil
+	return f.headerRow.init(spec)
 }
 
 func (f *simpleDelimited) Open(r io.Reader) error {
@@ -45,7 +76,7 @@ func (f *simpleDelimited) Open(r io.Reader) error {
 	}
 
 	f.reader = r
-	f.scanner = bufio.NewScanner(r)
+	f.scanner = f.newScanner(r)
 
 	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -63,31 +94,67 @@ func (f *simpleDelimited) Open(r io.Reader) error {
 		// request more data
 		return 0, nil, nil
 	}
-	f.scanner.Split(split)
-	return nil
+	f.scanner.Split(f.track(split))
+
+	for i := 0; i < f.SkipLines; i++ {
+		if !f.scanner.Scan() {
+			return f.scanError(f.scanner.Err())
+		}
+	}
+	f.ahead, f.pos = f.ahead[:0], Position{}
+	var names []string
+	if f.Header {
+		if line, ok := f.nextLine(); ok {
+			names = strings.Split(line, f.FieldDelim)
+		}
+	}
+	if err := f.setNames(names); err != nil {
+		return err
+	}
+	return f.scanError(f.scanner.Err())
+}
+
+// nextLine returns the next line which is not empty or a comment.
+func (f *simpleDelimited) nextLine() (string, bool) {
+	for f.scanner.Scan() {
+		line := f.scanner.Text()
+		if line == "" || (f.Comment != "" && strings.HasPrefix(line, f.Comment)) {
+			continue
+		}
+		return line, true
+	}
+	return "", false
 }
 
 func (f *simpleDelimited) NextRecord() (string, error) {
-	line := ""
-	for line == "" {
-		if !f.scanner.Scan() {
+	for len(f.ahead) <= f.SkipFooter {
+		line, ok := f.nextLine()
+		if !ok {
+			if err := f.scanError(f.scanner.Err()); err != nil {
+				return "", err
+			}
 			return "", io.EOF
 		}
-		line = f.scanner.Text()
+		f.ahead = append(f.ahead, delimitedLine{line, f.scanPosition.Position()})
 	}
+	line := f.ahead[0]
+	f.ahead = append(f.ahead[:0], f.ahead[1:]...)
+	f.pos = line.pos
 
-	return line, nil
+	metrics.Add(metrics.RecordsParsed, 1, "format", "simple-delimited")
+	return line.text, nil
+}
+
+// Position returns the position of the most recent record.
+func (f *simpleDelimited) Position() Position {
+	return f.pos
 }
 
 func (f *simpleDelimited) GetFields(record string) (map[interface{}]string, error) {
 	if strings.HasSuffix(record, f.RecordDelim) {
 		record = strings.TrimSuffix(record, f.RecordDelim)
 	}
-	ret := make(map[interface{}]string)
-	for i, v := range strings.Split(record, f.FieldDelim) {
-		ret[i] = v
-	}
-	return ret, nil
+	return f.fields(strings.SplitN(record, f.FieldDelim, f.splitN())), nil
 }
 
 func (f *simpleDelimited) NextRecordFields() (map[interface{}]string, error) {
@@ -98,6 +165,15 @@ func (f *simpleDelimited) NextRecordFields() (map[interface{}]string, error) {
 	return f.GetFields(s)
 }
 
+func (f *simpleDelimited) NextRecordInto(rec *Record) error {
+	s, e := f.NextRecord()
+	if e != nil {
+		return e
+	}
+	f.record(strings.SplitN(strings.TrimSuffix(s, f.RecordDelim), f.FieldDelim, f.splitN()), rec)
+	return nil
+}
+
 func (f *simpleDelimited) HasVariableFields() bool {
 	return false
 }
@@ -107,26 +183,52 @@ func (f *simpleDelimited) HasVariableFields() bool {
 ////////
 
 type commaSeparated struct {
-	FieldDelim string
-	Comment    string
-	NumFields  int
-	reader     io.Reader
-	csvReader  *csv.Reader
+	FieldDelim       string
+	Comment          string
+	NumFields        int
+	Quotes           string
+	TrimLeadingSpace bool
+	reader           io.Reader
+	csvReader        *csv.Reader
+	quoted           *quotedReader // used instead of csvReader when it cannot parse the format
+
+	headerRow
+
+	// most recent NextRecord results
+	lastRecord string
+	lastFields []string
+
+	// position of the most recent record, and of the csv.Reader's input
+	pos     Position
+	skipped Position
+
+	scanLimits
 }
 
 func (f *commaSeparated) Init(spec map[string]string) error {
+	if err := f.scanLimits.init(spec); err != nil {
+		return err
+	}
 	if v, found := spec["fields"]; found {
-		if len(v) > 1 {
-			return fmt.Errorf("field delimiter for csv format can only be one character long")
+		if v == "" || strings.ContainsAny(v, "\"\r\n") || !utf8.ValidString(v) {
+			return fmt.Errorf("field delimiter for csv format cannot be empty, or contain quotes or newlines")
 		}
 		f.FieldDelim = v
 	}
 	if v, found := spec["comments"]; found {
-		if len(v) > 1 {
+		if utf8.RuneCountInString(v) > 1 {
 			return fmt.Errorf("comment delimiter for csv format can only be one character long")
 		}
 		f.Comment = v
 	}
+	f.Quotes = "strict"
+	if v, found := spec["quotes"]; found {
+		if v != "strict" && v != "lazy" && v != "relaxed" {
+			return fmt.Errorf("csv format quotes must be 'strict', 'lazy' or 'relaxed', not '%s'", v)
+		}
+		f.Quotes = v
+	}
+	f.TrimLeadingSpace = spec["trim_leading_space"] == "true"
 	if v, found := spec["num_fields"]; found {
 		_, err := fmt.Sscanf(v, "%d", &f.NumFields)
 		if err != nil {
@@ -134,75 +236,169 @@ func (f *commaSeparated) Init(spec map[string]string) error {
 		}
 	}
 
-	return nil
+	return f.headerRow.init(spec)
 }
 
 func (f *commaSeparated) Open(r io.Reader) error {
 	f.reader = r
-	f.csvReader = csv.NewReader(r)
+	f.pos, f.skipped = Position{}, Position{}
+	size, _ := f.limits()
+	br := bufio.NewReaderSize(r, size)
+	if f.SkipLines > 0 {
+		// skipped lines need not be valid CSV, so they are read before the csv.Reader
+		for i := 0; i < f.SkipLines; i++ {
+			line, err := br.ReadString('\n')
+			f.skipped.Offset += int64(len(line))
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			f.skipped.Line++
+		}
+	}
+	rr := f.newReader(br)
+	f.csvReader, _ = rr.(*csv.Reader)
+	f.quoted, _ = rr.(*quotedReader)
+
+	f.lastRecord, f.lastFields = "", nil
+	var names []string
+	if f.Header {
+		var err error
+		names, err = rr.Read()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return f.setNames(names)
+}
 
-	if f.FieldDelim != "" {
-		f.csvReader.Comma, _ = utf8.DecodeRune([]byte(f.FieldDelim))
+// newReader returns a csv.Reader for r if it supports the format's options, or a quotedReader
+// otherwise.
+func (f *commaSeparated) newReader(r io.Reader) interface{ Read() ([]string, error) } {
+	delim := f.delim()
+	if utf8.RuneCountInString(delim) > 1 || f.Quotes == "relaxed" {
+		q := newQuotedReader(f.newScanner(r))
+		q.Delim, q.Comment, q.Quotes = delim, f.Comment, f.Quotes
+		q.TrimLeadingSpace = f.TrimLeadingSpace
+		q.FieldsPerRecord = f.NumFields
+		return q
 	}
+
+	cr := csv.NewReader(r)
+	cr.Comma, _ = utf8.DecodeRuneInString(delim)
 	if f.Comment != "" {
-		f.csvReader.Comment, _ = utf8.DecodeRune([]byte(f.Comment))
+		cr.Comment, _ = utf8.DecodeRuneInString(f.Comment)
 	}
-	f.csvReader.FieldsPerRecord = f.NumFields
+	cr.FieldsPerRecord = f.NumFields
+	cr.LazyQuotes = f.Quotes == "lazy"
+	cr.TrimLeadingSpace = f.TrimLeadingSpace
+	return cr
+}
 
-	return nil
+func (f *commaSeparated) delim() string {
+	if f.FieldDelim != "" {
+		return f.FieldDelim
+	}
+	return ","
 }
 
-// horribly inefficient, don't call this much!
-func (f *commaSeparated) NextRecord() (string, error) {
+// joinRecord re-encodes a parsed record, quoting only the fields that require it.
+func (f *commaSeparated) joinRecord(rec []string) string {
+	delim := f.delim()
+	var sb strings.Builder
+	for i, v := range rec {
+		if i > 0 {
+			sb.WriteString(delim)
+		}
+		if v == "" || (!strings.Contains(v, delim) && !strings.ContainsAny(v, "\"\r\n") && !unicode.IsSpace(rune(v[0]))) {
+			sb.WriteString(v)
+			continue
+		}
+		sb.WriteByte('"')
+		sb.WriteString(strings.Replace(v, `"`, `""`, -1))
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+// read reads the next record from the csv.Reader, and updates its position. The offset is
+// the end of the previous record, so it includes any blank or comment lines which were skipped.
+func (f *commaSeparated) read() ([]string, error) {
+	if f.quoted != nil {
+		rec, err := f.quoted.Read()
+		if err != nil {
+			return nil, f.scanError(err)
+		}
+		p := f.quoted.Position()
+		f.pos = Position{Line: f.skipped.Line + p.Line, Offset: f.skipped.Offset + p.Offset}
+		return rec, nil
+	}
+
+	offset := f.csvReader.InputOffset()
 	rec, err := f.csvReader.Read()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	buf := bytes.NewBuffer(nil)
-	w := csv.NewWriter(buf)
-	if f.FieldDelim != "" {
-		w.Comma, _ = utf8.DecodeRune([]byte(f.FieldDelim))
+	if _, max := f.limits(); f.csvReader.InputOffset()-offset > int64(max) {
+		return nil, f.scanError(bufio.ErrTooLong)
 	}
-	err = w.Write(rec)
+	line, _ := f.csvReader.FieldPos(0)
+	f.pos = Position{Line: f.skipped.Line + line, Offset: f.skipped.Offset + offset}
+	return rec, nil
+}
+
+// Position returns the position of the most recent record.
+func (f *commaSeparated) Position() Position {
+	return f.pos
+}
+
+// NextRecord re-encodes the parsed record so that it can be split again by GetFields. The last
+// record returned is remembered so that a following GetFields call does not need to re-parse.
+func (f *commaSeparated) NextRecord() (string, error) {
+	rec, err := f.read()
 	if err != nil {
 		return "", err
 	}
-	w.Flush()
 
-	return buf.String(), nil
+	metrics.Add(metrics.RecordsParsed, 1, "format", "csv")
+
+	f.lastRecord = f.joinRecord(rec)
+	f.lastFields = rec
+	return f.lastRecord, nil
 }
 
-// horribly inefficient, don't call this much!
 func (f *commaSeparated) GetFields(record string) (map[interface{}]string, error) {
-	buf := bytes.NewBufferString(record)
-	r := csv.NewReader(buf)
-	if f.FieldDelim != "" {
-		r.Comma, _ = utf8.DecodeRune([]byte(f.FieldDelim))
-	}
-	r.FieldsPerRecord = f.NumFields
-	rec, err := r.Read()
-	if err != nil {
-		return nil, err
+	rec := f.lastFields
+	if rec == nil || record != f.lastRecord {
+		var err error
+		rec, err = f.newReader(strings.NewReader(record)).Read()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	ret := make(map[interface{}]string)
-	for i, v := range rec {
-		ret[i] = v
-	}
-	return ret, nil
+	return f.fields(rec), nil
 }
 
 func (f *commaSeparated) NextRecordFields() (map[interface{}]string, error) {
-	rec, err := f.csvReader.Read()
+	rec, err := f.read()
 	if err != nil {
 		return nil, err
 	}
-	ret := make(map[interface{}]string)
-	for i, v := range rec {
-		ret[i] = v
+	metrics.Add(metrics.RecordsParsed, 1, "format", "csv")
+	return f.fields(rec), nil
+}
+
+func (f *commaSeparated) NextRecordInto(rec *Record) error {
+	values, err := f.read()
+	if err != nil {
+		return err
 	}
-	return ret, nil
+	metrics.Add(metrics.RecordsParsed, 1, "format", "csv")
+	f.record(values, rec)
+	return nil
 }
 
 func (f *commaSeparated) HasVariableFields() bool {
@@ -211,34 +407,158 @@ func (f *commaSeparated) HasVariableFields() bool {
 
 /////////
 
+// fixedColumn is a field of the fixed-width format, from byte Start up to (but not including)
+// End. An End of -1 extends to the end of the line.
+type fixedColumn struct {
+	Key   interface{}
+	Start int
+	End   int
+	Trim  bool
+}
+
 type fixedWidth struct {
-	Offsets []int
-	reader  io.Reader
-	scanner *bufio.Scanner
+	Columns    []fixedColumn
+	ShortLines string
+	reader     io.Reader
+	scanner    *bufio.Scanner
+
+	scanPosition
+	scanLimits
 }
 
 func (f *fixedWidth) Init(spec map[string]string) error {
-	f.Offsets = nil
+	if err := f.scanLimits.init(spec); err != nil {
+		return err
+	}
+	f.Columns = nil
+	f.ShortLines = "pad"
 
-	if spec != nil {
-		if offs, found := spec["offsets"]; found {
-			for _, off := range strings.Split(offs, ",") {
-				var n int
-				_, err := fmt.Sscanf(off, "%d", &n)
-				if err != nil {
-					return err
+	if spec == nil {
+		return nil
+	}
+	var names []string
+	if v, found := spec["names"]; found {
+		names = strings.Split(v, ",")
+	}
+	nameOf := func(i int) interface{} {
+		if i < len(names) && strings.TrimSpace(names[i]) != "" {
+			return strings.TrimSpace(names[i])
+		}
+		return i
+	}
+
+	if offs, found := spec["offsets"]; found {
+		var starts []int
+		for _, off := range strings.Split(offs, ",") {
+			var n int
+			_, err := fmt.Sscanf(off, "%d", &n)
+			if err != nil || n < 0 || (len(starts) > 0 && n <= starts[len(starts)-1]) {
+				return fmt.Errorf("fixed format offsets must be increasing non-negative integers, not '%s'", off)
+			}
+			starts = append(starts, n)
+		}
+		for i, n := range starts {
+			end := -1
+			if i+1 < len(starts) {
+				end = starts[i+1]
+			}
+			f.Columns = append(f.Columns, fixedColumn{Key: nameOf(i), Start: n, End: end})
+		}
+	} else if widths, found := spec["widths"]; found {
+		pos := 0
+		for i, w := range strings.Split(widths, ",") {
+			var n int
+			_, err := fmt.Sscanf(w, "%d", &n)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("fixed format widths must be positive integers, not '%s'", w)
+			}
+			f.Columns = append(f.Columns, fixedColumn{Key: nameOf(i), Start: pos, End: pos + n})
+			pos += n
+		}
+	} else if cols, found := spec["columns"]; found {
+		// e.g. "id:0-8,name:8-40,notes:40-"
+		for _, col := range strings.Split(cols, ",") {
+			parts := strings.SplitN(col, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("fixed format columns must be name:start-end, not '%s'", col)
+			}
+			c := fixedColumn{Key: strings.TrimSpace(parts[0]), End: -1}
+			rng := strings.SplitN(parts[1], "-", 2)
+			_, err := fmt.Sscanf(rng[0], "%d", &c.Start)
+			if err == nil && len(rng) == 2 && strings.TrimSpace(rng[1]) != "" {
+				_, err = fmt.Sscanf(rng[1], "%d", &c.End)
+			}
+			if err != nil || c.Start < 0 || (c.End != -1 && c.End <= c.Start) {
+				return fmt.Errorf("fixed format columns must be name:start-end, not '%s'", col)
+			}
+			f.Columns = append(f.Columns, c)
+		}
+	}
+	if v, found := spec["columns"]; found && (spec["offsets"] != "" || spec["widths"] != "") {
+		// select some of the columns given by "offsets" or "widths", e.g. "id,2"
+		var selected []fixedColumn
+		for _, k := range parseFieldKeys(v) {
+			n := len(selected)
+			for _, c := range f.Columns {
+				if c.Key == k {
+					selected = append(selected, c)
 				}
-				f.Offsets = append(f.Offsets, n)
+			}
+			if len(selected) == n {
+				return fmt.Errorf("fixed format column '%v' is not defined", k)
 			}
 		}
+		f.Columns = selected
 	}
 
+	if v, found := spec["trim"]; found {
+		switch v {
+		case "true", "all":
+			for i := range f.Columns {
+				f.Columns[i].Trim = true
+			}
+		case "false", "":
+		default:
+			for _, name := range strings.Split(v, ",") {
+				name = strings.TrimSpace(name)
+				for i, c := range f.Columns {
+					if fmt.Sprint(c.Key) == name {
+						f.Columns[i].Trim = true
+					}
+				}
+			}
+		}
+	}
+
+	if v, found := spec["short_lines"]; found {
+		if v != "pad" && v != "skip" && v != "error" {
+			return fmt.Errorf("fixed format short_lines must be 'pad', 'skip' or 'error', not '%s'", v)
+		}
+		f.ShortLines = v
+	}
 	return nil
 }
 
+// selectsColumns marks the fixed-width format as handling the "columns" option, which either
+// gives the positions of its columns or selects some of them.
+func (f *fixedWidth) selectsColumns() {}
+
+// minLength returns the length of the shortest line which contains every column.
+func (f *fixedWidth) minLength() int {
+	n := 0
+	for _, c := range f.Columns {
+		if c.End > n {
+			n = c.End
+		} else if c.End == -1 && c.Start > n {
+			n = c.Start
+		}
+	}
+	return n
+}
+
 func (f *fixedWidth) Open(r io.Reader) error {
 	f.reader = r
-	f.scanner = bufio.NewScanner(r)
+	f.scanner = f.newScanner(r)
 
 	split := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -256,7 +576,7 @@ func (f *fixedWidth) Open(r io.Reader) error {
 		// request more data
 		return 0, nil, nil
 	}
-	f.scanner.Split(split)
+	f.scanner.Split(f.track(split))
 	return nil
 }
 
@@ -264,22 +584,42 @@ func (f *fixedWidth) NextRecord() (string, error) {
 	line := ""
 	for line == "" {
 		if !f.scanner.Scan() {
+			if err := f.scanError(f.scanner.Err()); err != nil {
+				return "", err
+			}
 			return "", io.EOF
 		}
 		line = f.scanner.Text()
+		if f.ShortLines == "skip" && len(line) < f.minLength() {
+			line = ""
+		}
 	}
 
+	metrics.Add(metrics.RecordsParsed, 1, "format", "fixed")
 	return line, nil
 }
 
+// GetFields slices the columns from a record. Columns which extend past the end of a short
+// record are truncated or empty, unless ShortLines is "error".
 func (f *fixedWidth) GetFields(record string) (map[interface{}]string, error) {
-	ret := make(map[interface{}]string)
-	for i, v := range f.Offsets {
-		if i == len(f.Offsets)-1 {
-			ret[i] = strings.TrimSuffix(record[v:], "\n")
-		} else {
-			ret[i] = record[v:f.Offsets[i+1]]
+	record = strings.TrimSuffix(record, "\n")
+	if f.ShortLines == "error" && len(record) < f.minLength() {
+		return nil, fmt.Errorf("fixed format: line is %d characters, expected at least %d", len(record), f.minLength())
+	}
+	ret := make(map[interface{}]string, len(f.Columns))
+	for _, c := range f.Columns {
+		v := ""
+		if c.Start < len(record) {
+			if c.End == -1 || c.End > len(record) {
+				v = record[c.Start:]
+			} else {
+				v = record[c.Start:c.End]
+			}
+		}
+		if c.Trim {
+			v = strings.TrimSpace(v)
 		}
+		ret[c.Key] = v
 	}
 	return ret, nil
 }
@@ -0,0 +1,70 @@
+package formats_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestFixedWidth(t *testing.T) {
+	input := "ENSG01  TP53      tumor protein\nENSG02  BRCA1\nshort\n"
+	recs := readAllFields(t, map[string]string{"type": "fixed", "widths": "8,10,20", "names": "id,symbol,description", "trim": "true"}, input)
+	if len(recs) != 3 || recs[0]["symbol"] != "TP53" || recs[0]["description"] != "tumor protein" || recs[1]["description"] != "" || recs[2]["id"] != "short" {
+		t.Errorf("unexpected padded fields: %v", recs)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "fixed", "columns": "id:0-8,rest:8-", "trim": "id", "short_lines": "skip"}, input)
+	if len(recs) != 2 || recs[0]["id"] != "ENSG01" || recs[1]["rest"] != "BRCA1" || recs[0]["rest"] != "TP53      tumor protein" {
+		t.Errorf("unexpected fields: %v", recs)
+	}
+
+	df := openFormat(t, map[string]string{"type": "fixed", "offsets": "0,8,18", "short_lines": "error"}, "short\n")
+	if _, err := df.NextRecordFields(); err == nil || err == io.EOF {
+		t.Errorf("expected an error for a short line, got %v", err)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "fixed", "widths": "8,10,20", "names": "id,,description", "columns": "description,1"}, input)
+	if len(recs) != 3 || len(recs[0]) != 2 || recs[0][1] != "TP53      " || recs[0]["description"] != "tumor protein" {
+		t.Errorf("unexpected selected fields: %v", recs)
+	}
+	if _, err := formats.GetDataFormat(map[string]string{"type": "fixed", "widths": "8,10", "columns": "symbol"}); err == nil {
+		t.Error("expected an error for a column which is not defined")
+	}
+
+	for _, offs := range []string{"10,5", "-3,2", "0,4,4"} {
+		if _, err := formats.GetDataFormat(map[string]string{"type": "fixed", "offsets": offs}); err == nil {
+			t.Errorf("expected an error for offsets '%s'", offs)
+		}
+	}
+}
+
+func TestDelimitedCommentsAndFooter(t *testing.T) {
+	input := "Release 12\n# generated 2020-01-01\nid\tname\n1\ta\n# note\n\n2\tb\nTotal: 2\t\n"
+	recs := readAllFields(t, map[string]string{"type": "tab-delimited", "skip_lines": "1",
+		"comments": "#", "header": "true", "skip_footer": "1"}, input)
+	if len(recs) != 2 || recs[0]["name"] != "a" || recs[1]["id"] != "2" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	df := openFormat(t, map[string]string{"type": "csv"}, "a,\"b, \"\"c\"\"\",d\n")
+
+	rec, err := df.NextRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec != `a,"b, ""c""",d` {
+		t.Errorf("unexpected record encoding: %s", rec)
+	}
+
+	// use a copy of the string so the cached fields aren't used
+	fields, err := df.GetFields(string([]byte(rec)) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 3 || fields[1] != `b, "c"` {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+}
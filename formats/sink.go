@@ -0,0 +1,340 @@
+package formats
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// DataSink writes records in a specific format. It is the output counterpart to DataFormat.
+type DataSink interface {
+	// Init initializes this instance with attributes from the provided spec. Calling this
+	// method is optional.
+	Init(spec map[string]string) error
+
+	// Open prepares to write new records to the specified io.Writer.
+	Open(w io.Writer) error
+
+	// WriteRecord writes a record's fields. This method requires a prior call to Open()
+	WriteRecord(fields map[interface{}]string) error
+
+	// Close flushes any buffered records. It does not close the io.Writer given to Open.
+	Close() error
+}
+
+// DataSinkGetter returns an instance of a DataSink
+type DataSinkGetter func() DataSink
+
+// GetDataSink uses spec["type"] to search the registered DataSinks. If a match is found,
+// (DataSink).Init(spec) is called to initialize it before returning.
+func (r *Registry) GetDataSink(spec map[string]string) (DataSink, error) {
+	r.mu.RLock()
+	dsg, found := r.sinks[spec["type"]]
+	r.mu.RUnlock()
+
+	if found {
+		ds := dsg()
+		if err := ds.Init(spec); err != nil {
+			return nil, err
+		}
+		return ds, nil
+	}
+	return nil, fmt.Errorf("no sink matches type '%s'", spec["type"])
+}
+
+// RegisterSink adds the named DataSink to the search list for r.GetDataSink
+func (r *Registry) RegisterSink(name string, dsg DataSinkGetter) {
+	r.mu.Lock()
+	r.sinks[name] = dsg
+	r.mu.Unlock()
+}
+
+// GetDataSink uses spec["type"] to search the DefaultRegistry for a DataSink.
+func GetDataSink(spec map[string]string) (DataSink, error) {
+	return DefaultRegistry.GetDataSink(spec)
+}
+
+// RegisterSink adds the named DataSink to the DefaultRegistry search list for GetDataSink
+func RegisterSink(name string, dsg DataSinkGetter) {
+	DefaultRegistry.RegisterSink(name, dsg)
+}
+
+// sinkColumns holds the "columns" option of the tabular sinks. If no columns are given, they
+// are taken from the first record: integer keys in order, followed by names in sorted order.
+type sinkColumns struct {
+	Columns []interface{}
+}
+
+func (c *sinkColumns) init(spec map[string]string) {
+	c.Columns = nil
+	if v, found := spec["columns"]; found && v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if i, err := strconv.Atoi(name); err == nil {
+				c.Columns = append(c.Columns, i)
+			} else {
+				c.Columns = append(c.Columns, name)
+			}
+		}
+	}
+}
+
+func (c *sinkColumns) columns(fields map[interface{}]string) []interface{} {
+	if c.Columns == nil {
+		c.Columns = sortedKeys(fields)
+	}
+	return c.Columns
+}
+
+// sortedKeys returns the keys of a record, with integer keys first.
+func sortedKeys(fields map[interface{}]string) []interface{} {
+	keys := make([]interface{}, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, iok := keys[i].(int)
+		kj, jok := keys[j].(int)
+		switch {
+		case iok && jok:
+			return ki < kj
+		case iok != jok:
+			return iok
+		}
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
+
+// csvSink writes records as CSV (or any other single-character delimiter, such as tabs).
+// Fields which are not in the columns are not written.
+type csvSink struct {
+	FieldDelim string
+	Header     bool
+	sinkColumns
+
+	writer  *csv.Writer
+	started bool
+}
+
+func (s *csvSink) Init(spec map[string]string) error {
+	if s.FieldDelim == "" {
+		s.FieldDelim = ","
+	}
+	if v, found := spec["fields"]; found {
+		if utf8.RuneCountInString(v) != 1 {
+			return fmt.Errorf("field delimiter for csv sink must be one character long")
+		}
+		s.FieldDelim = v
+	}
+	s.Header = false
+	if v, found := spec["header"]; found {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("header option must be true or false, not '%s'", v)
+		}
+		s.Header = b
+	}
+	s.sinkColumns.init(spec)
+	return nil
+}
+
+func (s *csvSink) Open(w io.Writer) error {
+	s.writer = csv.NewWriter(w)
+	if s.FieldDelim != "" {
+		s.writer.Comma, _ = utf8.DecodeRuneInString(s.FieldDelim)
+	}
+	s.started = false
+	return nil
+}
+
+func (s *csvSink) WriteRecord(fields map[interface{}]string) error {
+	cols := s.columns(fields)
+	if !s.started {
+		s.started = true
+		if s.Header {
+			names := make([]string, len(cols))
+			for i, c := range cols {
+				names[i] = fmt.Sprint(c)
+			}
+			if err := s.writer.Write(names); err != nil {
+				return err
+			}
+		}
+	}
+	row := make([]string, len(cols))
+	for i, c := range cols {
+		row[i] = fields[c]
+	}
+	metrics.Add(metrics.RecordsWritten, 1, "sink", "csv")
+	return s.writer.Write(row)
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+////////
+
+// jsonLinesSink writes each record as a JSON object on its own line.
+type jsonLinesSink struct {
+	writer *bufio.Writer
+	enc    *json.Encoder
+}
+
+func (s *jsonLinesSink) Init(spec map[string]string) error {
+	return nil
+}
+
+func (s *jsonLinesSink) Open(w io.Writer) error {
+	s.writer = bufio.NewWriter(w)
+	s.enc = json.NewEncoder(s.writer)
+	s.enc.SetEscapeHTML(false)
+	return nil
+}
+
+func (s *jsonLinesSink) WriteRecord(fields map[interface{}]string) error {
+	obj := make(map[string]string, len(fields))
+	for k, v := range fields {
+		obj[fmt.Sprint(k)] = v
+	}
+	metrics.Add(metrics.RecordsWritten, 1, "sink", "jsonl")
+	return s.enc.Encode(obj)
+}
+
+func (s *jsonLinesSink) Close() error {
+	return s.writer.Flush()
+}
+
+////////
+
+// sqliteSink inserts records into a SQLite table, which is created if it does not exist. The
+// database/sql driver is not included, so the program must import one (e.g.
+// "github.com/mattn/go-sqlite3"). Records are written in transactions of BatchSize rows. The
+// io.Writer given to Open is not used.
+type sqliteSink struct {
+	Driver    string
+	Database  string
+	Table     string
+	BatchSize int
+	sinkColumns
+
+	db      *sql.DB
+	tx      *sql.Tx
+	stmt    *sql.Stmt
+	pending int
+}
+
+func (s *sqliteSink) Init(spec map[string]string) error {
+	s.Driver = "sqlite3"
+	if v, found := spec["driver"]; found {
+		s.Driver = v
+	}
+	s.Database = spec["database"]
+	s.Table = spec["table"]
+	if s.Database == "" || s.Table == "" {
+		return fmt.Errorf("sqlite sink requires database and table options")
+	}
+	s.BatchSize = 10000
+	if v, found := spec["batch_size"]; found {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("sqlite sink batch_size must be a positive integer, not '%s'", v)
+		}
+		s.BatchSize = n
+	}
+	s.sinkColumns.init(spec)
+	return nil
+}
+
+func (s *sqliteSink) Open(w io.Writer) error {
+	var err error
+	s.db, err = sql.Open(s.Driver, s.Database)
+	return err
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// begin creates the table if needed and starts a new transaction.
+func (s *sqliteSink) begin(cols []interface{}) error {
+	names := make([]string, len(cols))
+	params := make([]string, len(cols))
+	for i, c := range cols {
+		name := fmt.Sprint(c)
+		if _, ok := c.(int); ok {
+			name = "field" + name
+		}
+		names[i] = quoteIdent(name)
+		params[i] = "?"
+	}
+	if s.stmt == nil {
+		create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s TEXT)", quoteIdent(s.Table), strings.Join(names, " TEXT, "))
+		if _, err := s.db.Exec(create); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	s.tx, err = s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(s.Table), strings.Join(names, ", "), strings.Join(params, ", "))
+	s.stmt, err = s.tx.Prepare(insert)
+	return err
+}
+
+// commit commits the current transaction, if any.
+func (s *sqliteSink) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	s.stmt.Close()
+	err := s.tx.Commit()
+	s.tx, s.pending = nil, 0
+	return err
+}
+
+func (s *sqliteSink) WriteRecord(fields map[interface{}]string) error {
+	cols := s.columns(fields)
+	if s.tx == nil {
+		if err := s.begin(cols); err != nil {
+			return err
+		}
+	}
+	args := make([]interface{}, len(cols))
+	for i, c := range cols {
+		args[i] = fields[c]
+	}
+	if _, err := s.stmt.Exec(args...); err != nil {
+		return err
+	}
+	metrics.Add(metrics.RecordsWritten, 1, "sink", "sqlite")
+
+	s.pending++
+	if s.pending >= s.BatchSize {
+		return s.commit()
+	}
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	err := s.commit()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// sqlRows parses the query results streamed by anydata's database fetchers: a JSON array of
+// column names on the first line, followed by one JSON array of values per row.
+type sqlRows struct {
+	ByIndex bool
+	Null    string
+	columns []string
+	scanner *bufio.Scanner
+
+	scanLimits
+}
+
+func (f *sqlRows) Init(spec map[string]string) error {
+	f.ByIndex = false
+	f.Null = ""
+	if v, found := spec["keys"]; found {
+		switch v {
+		case "names":
+		case "index":
+			f.ByIndex = true
+		default:
+			return fmt.Errorf("sql format keys must be 'names' or 'index', not '%s'", v)
+		}
+	}
+	if v, found := spec["null"]; found {
+		f.Null = v
+	}
+	return f.scanLimits.init(spec)
+}
+
+func (f *sqlRows) Open(r io.Reader) error {
+	f.scanner = f.newScanner(r)
+	f.columns = nil
+	if !f.scanner.Scan() {
+		if err := f.scanError(f.scanner.Err()); err != nil {
+			return err
+		}
+		return fmt.Errorf("sql format: missing column names")
+	}
+	if err := json.Unmarshal(f.scanner.Bytes(), &f.columns); err != nil {
+		return fmt.Errorf("sql format: invalid column names: %s", err.Error())
+	}
+	return nil
+}
+
+func (f *sqlRows) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		if line := f.scanner.Text(); line != "" {
+			metrics.Add(metrics.RecordsParsed, 1, "format", "sql")
+			return line, nil
+		}
+	}
+	if err := f.scanError(f.scanner.Err()); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// GetFields keys each value by its column name, or by its 0-based position if the "keys"
+// option is "index" or the column names are not known (i.e. Open was not called).
+func (f *sqlRows) GetFields(record string) (map[interface{}]string, error) {
+	var row []*string
+	if err := json.Unmarshal([]byte(record), &row); err != nil {
+		return nil, err
+	}
+	if f.columns != nil && len(row) != len(f.columns) {
+		return nil, fmt.Errorf("sql format: record has %d values for %d columns", len(row), len(f.columns))
+	}
+
+	ret := make(map[interface{}]string, len(row))
+	for i, v := range row {
+		s := f.Null
+		if v != nil {
+			s = *v
+		}
+		if f.ByIndex || f.columns == nil {
+			ret[i] = s
+		} else {
+			ret[f.columns[i]] = s
+		}
+	}
+	return ret, nil
+}
+
+func (f *sqlRows) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *sqlRows) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,366 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+var (
+	// pdbAtomColumns are the columns of ATOM and HETATM records in the PDB format.
+	pdbAtomColumns = []fixedColumn{
+		{"serial", 6, 11, true}, {"name", 12, 16, true}, {"altLoc", 16, 17, true},
+		{"resName", 17, 20, true}, {"chainID", 21, 22, true}, {"resSeq", 22, 26, true},
+		{"iCode", 26, 27, true}, {"x", 30, 38, true}, {"y", 38, 46, true}, {"z", 46, 54, true},
+		{"occupancy", 54, 60, true}, {"tempFactor", 60, 66, true}, {"element", 76, 78, true},
+		{"charge", 78, 80, true},
+	}
+	// pdbHeaderColumns are the columns of the HEADER record in the PDB format.
+	pdbHeaderColumns = []fixedColumn{
+		{"classification", 10, 50, true}, {"depDate", 50, 59, true}, {"idCode", 62, 66, true},
+	}
+)
+
+// pdbRecords reads the lines of a PDB file (which may be gzip compressed) with the selected
+// record types. The record type is in the "record" field. ATOM, HETATM and HEADER records
+// are split into their columns, keyed by the names used in the PDB specification (e.g.
+// "resName" and "x"), and the text after the record type is in the "text" field for the
+// other record types.
+type pdbRecords struct {
+	Records map[string]bool
+
+	reader *bufio.Reader
+}
+
+func (f *pdbRecords) Init(spec map[string]string) error {
+	f.Records = map[string]bool{"ATOM": true, "HETATM": true}
+	if v, found := spec["records"]; found {
+		f.Records = make(map[string]bool)
+		for _, s := range strings.Split(v, ",") {
+			f.Records[strings.ToUpper(strings.TrimSpace(s))] = true
+		}
+	}
+	return nil
+}
+
+func (f *pdbRecords) Open(r io.Reader) error {
+	if f.Records == nil {
+		f.Init(nil)
+	}
+	var err error
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+func (f *pdbRecords) NextRecord() (string, error) {
+	for {
+		line, err := readLine(f.reader)
+		if err != nil {
+			return "", err
+		}
+		if f.Records[pdbRecordType(line)] {
+			metrics.Add(metrics.RecordsParsed, 1, "format", "pdb")
+			return line, nil
+		}
+	}
+}
+
+// pdbRecordType returns the record type of a line, from its first six columns.
+func pdbRecordType(line string) string {
+	if len(line) > 6 {
+		line = line[:6]
+	}
+	return strings.TrimSpace(line)
+}
+
+func (f *pdbRecords) GetFields(record string) (map[interface{}]string, error) {
+	typ := pdbRecordType(record)
+	var columns []fixedColumn
+	switch typ {
+	case "ATOM", "HETATM":
+		columns = pdbAtomColumns
+	case "HEADER":
+		columns = pdbHeaderColumns
+	default:
+		columns = []fixedColumn{{"text", 6, -1, true}}
+	}
+
+	fixed := fixedWidth{Columns: columns}
+	ret, err := fixed.GetFields(record)
+	if err != nil {
+		return nil, err
+	}
+	ret["record"] = typ
+	return ret, nil
+}
+
+func (f *pdbRecords) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+func (f *pdbRecords) HasVariableFields() bool {
+	for typ := range f.Records {
+		if typ != "ATOM" && typ != "HETATM" {
+			return true
+		}
+	}
+	return false
+}
+
+////////
+
+// cifToken is a token of a CIF file. Values which were quoted or text fields can not be
+// reserved words or data names.
+type cifToken struct {
+	Text   string
+	Quoted bool
+}
+
+// isName returns true for data names, e.g. "_atom_site.Cartn_x".
+func (t cifToken) isName() bool {
+	return !t.Quoted && strings.HasPrefix(t.Text, "_")
+}
+
+// isKeyword returns true for data names and reserved words, which end a loop.
+func (t cifToken) isKeyword() bool {
+	if t.Quoted {
+		return false
+	}
+	s := strings.ToLower(t.Text)
+	return strings.HasPrefix(s, "_") || strings.HasPrefix(s, "loop_") || strings.HasPrefix(s, "data_") ||
+		strings.HasPrefix(s, "save_") || s == "global_" || s == "stop_"
+}
+
+// value returns the text of a value, which is empty for the unknown ("?") and inapplicable
+// (".") values.
+func (t cifToken) value() string {
+	if !t.Quoted && (t.Text == "?" || t.Text == ".") {
+		return ""
+	}
+	return t.Text
+}
+
+// cifCategory splits a data name into its category and item, e.g. "_atom_site" and "Cartn_x".
+func cifCategory(name string) (string, string) {
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// mmcifTables reads the rows of a category table from an mmCIF file (which may be gzip
+// compressed), such as "_atom_site" or "_entity". Fields are keyed by item name without the
+// category (e.g. "Cartn_x"), and unknown or inapplicable values are empty. A category which
+// is not in a loop is a single record. Records are encoded as a JSON object of the fields.
+type mmcifTables struct {
+	Category string
+
+	reader *bufio.Reader
+	line   string
+	items  []string // the items of the current loop, if it is for the Category
+	peeked *cifToken
+}
+
+func (f *mmcifTables) Init(spec map[string]string) error {
+	f.Category = "_atom_site"
+	if v, found := spec["category"]; found {
+		if strings.TrimPrefix(v, "_") == "" || strings.ContainsAny(v, ". \t") {
+			return fmt.Errorf("mmcif format: invalid category '%s'", v)
+		}
+		f.Category = "_" + strings.TrimPrefix(v, "_")
+	}
+	return nil
+}
+
+func (f *mmcifTables) Open(r io.Reader) error {
+	if f.Category == "" {
+		f.Category = "_atom_site"
+	}
+	var err error
+	f.line, f.items, f.peeked = "", nil, nil
+	f.reader, err = sequenceReader(r)
+	return err
+}
+
+// next returns the next token, or io.EOF.
+func (f *mmcifTables) next() (cifToken, error) {
+	if f.peeked != nil {
+		t := *f.peeked
+		f.peeked = nil
+		return t, nil
+	}
+	for {
+		f.line = strings.TrimLeft(f.line, " \t")
+		if f.line != "" && f.line[0] != '#' {
+			break
+		}
+		line, err := readLine(f.reader)
+		if err != nil {
+			return cifToken{}, err
+		}
+		if strings.HasPrefix(line, ";") {
+			return f.textField(line[1:])
+		}
+		f.line = line
+	}
+
+	if q := f.line[0]; q == '\'' || q == '"' {
+		// a quote only ends the value if it is followed by whitespace
+		for i := 1; i < len(f.line); i++ {
+			if f.line[i] == q && (i+1 == len(f.line) || f.line[i+1] == ' ' || f.line[i+1] == '\t') {
+				t := cifToken{Text: f.line[1:i], Quoted: true}
+				f.line = f.line[i+1:]
+				return t, nil
+			}
+		}
+		return cifToken{}, fmt.Errorf("mmcif format: unterminated quoted value")
+	}
+	i := strings.IndexAny(f.line, " \t")
+	if i == -1 {
+		i = len(f.line)
+	}
+	t := cifToken{Text: f.line[:i]}
+	f.line = f.line[i:]
+	return t, nil
+}
+
+// textField reads a text field, which continues until a line beginning with a semicolon.
+func (f *mmcifTables) textField(first string) (cifToken, error) {
+	lines := []string{first}
+	for {
+		line, err := readLine(f.reader)
+		if err == io.EOF {
+			return cifToken{}, fmt.Errorf("mmcif format: unterminated text field")
+		}
+		if err != nil {
+			return cifToken{}, err
+		}
+		if strings.HasPrefix(line, ";") {
+			f.line = line[1:]
+			break
+		}
+		lines = append(lines, line)
+	}
+	return cifToken{Text: strings.TrimSpace(strings.Join(lines, "\n")), Quoted: true}, nil
+}
+
+// matches returns true if the data name is an item of the Category.
+func (f *mmcifTables) matches(name string) bool {
+	category, _ := cifCategory(name)
+	return strings.EqualFold(category, f.Category)
+}
+
+func (f *mmcifTables) NextRecord() (string, error) {
+	for {
+		if f.items != nil {
+			t, err := f.next()
+			if err != nil {
+				return "", err
+			}
+			if t.isKeyword() {
+				f.items, f.peeked = nil, &t
+				continue
+			}
+			rec := map[string]string{f.items[0]: t.value()}
+			for _, item := range f.items[1:] {
+				t, err = f.next()
+				if err == io.EOF || (err == nil && t.isKeyword()) {
+					return "", fmt.Errorf("mmcif format: incomplete row in the %s loop", f.Category)
+				}
+				if err != nil {
+					return "", err
+				}
+				rec[item] = t.value()
+			}
+			return f.encode(rec)
+		}
+
+		t, err := f.next()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case !t.Quoted && strings.EqualFold(t.Text, "loop_"):
+			var names []string
+			for {
+				if t, err = f.next(); err != nil && err != io.EOF {
+					return "", err
+				}
+				if err == io.EOF || !t.isName() {
+					if err == nil {
+						f.peeked = &t
+					}
+					break
+				}
+				names = append(names, t.Text)
+			}
+			if len(names) > 0 && f.matches(names[0]) {
+				f.items = make([]string, len(names))
+				for i, name := range names {
+					_, f.items[i] = cifCategory(name)
+				}
+			}
+
+		case t.isName() && f.matches(t.Text):
+			// the items of a category which is not in a loop
+			rec := make(map[string]string)
+			for t.isName() && f.matches(t.Text) {
+				_, item := cifCategory(t.Text)
+				v, err := f.next()
+				if err != nil {
+					return "", fmt.Errorf("mmcif format: missing value for %s", t.Text)
+				}
+				rec[item] = v.value()
+
+				if t, err = f.next(); err == io.EOF {
+					break
+				} else if err != nil {
+					return "", err
+				}
+				if !t.isName() || !f.matches(t.Text) {
+					f.peeked = &t
+				}
+			}
+			return f.encode(rec)
+
+		case t.isName():
+			// skip the value of an item in another category
+			if _, err = f.next(); err != nil {
+				return "", err
+			}
+		}
+	}
+}
+
+func (f *mmcifTables) encode(rec map[string]string) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "mmcif")
+	return string(data), nil
+}
+
+func (f *mmcifTables) GetFields(record string) (map[interface{}]string, error) {
+	return sectionFields(record)
+}
+
+func (f *mmcifTables) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(s)
+}
+
+func (f *mmcifTables) HasVariableFields() bool {
+	return false
+}
@@ -0,0 +1,57 @@
+package formats_test
+
+import "testing"
+
+func TestStructureFormats(t *testing.T) {
+	pdb := `HEADER    HYDROLASE                               01-JAN-00   1ABC              
+ATOM      1  N   MET A   1      38.198  19.582  28.998  1.00 45.29           N  
+HETATM  500 ZN    ZN A 201      10.000  -2.500   3.250  1.00 20.00          ZN2+
+END
+`
+	recs := readAllFields(t, map[string]string{"type": "pdb"}, pdb)
+	if len(recs) != 2 {
+		t.Fatalf("unexpected records: %v", recs)
+	}
+	if r := recs[0]; r["record"] != "ATOM" || r["name"] != "N" || r["resName"] != "MET" || r["chainID"] != "A" ||
+		r["x"] != "38.198" || r["tempFactor"] != "45.29" || r["element"] != "N" {
+		t.Errorf("unexpected atom: %v", r)
+	}
+	if r := recs[1]; r["record"] != "HETATM" || r["serial"] != "500" || r["z"] != "3.250" || r["charge"] != "2+" {
+		t.Errorf("unexpected hetatm: %v", r)
+	}
+	if recs = readAllFields(t, map[string]string{"type": "pdb", "records": "HEADER,END"}, pdb); len(recs) != 2 ||
+		recs[0]["idCode"] != "1ABC" || recs[0]["classification"] != "HYDROLASE" || recs[1]["text"] != "" {
+		t.Errorf("unexpected header: %v", recs)
+	}
+
+	cif := `data_1ABC
+#
+_entry.id 1ABC
+_struct.title
+;An example
+structure
+;
+_struct.pdbx_descriptor 'Zinc hydrolase'
+#
+loop_
+_atom_site.group_PDB
+_atom_site.id
+_atom_site.label_atom_id
+_atom_site.Cartn_x
+_atom_site.pdbx_PDB_ins_code
+ATOM   1   N   38.198 ?
+HETATM 500 "O5'" 10.000 .
+#
+`
+	recs = readAllFields(t, map[string]string{"type": "mmcif"}, cif)
+	if len(recs) != 2 {
+		t.Fatalf("unexpected rows: %v", recs)
+	}
+	if r := recs[1]; r["group_PDB"] != "HETATM" || r["label_atom_id"] != "O5'" || r["Cartn_x"] != "10.000" || r["pdbx_PDB_ins_code"] != "" {
+		t.Errorf("unexpected row: %v", r)
+	}
+	recs = readAllFields(t, map[string]string{"type": "mmcif", "category": "struct"}, cif)
+	if len(recs) != 1 || recs[0]["title"] != "An example\nstructure" || recs[0]["pdbx_descriptor"] != "Zinc hydrolase" {
+		t.Errorf("unexpected struct: %v", recs)
+	}
+}
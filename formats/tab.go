@@ -0,0 +1,305 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// FieldBytesFormat is implemented by DataFormats which can return the fields of each record
+// as byte slices without allocating. The slices (and their contents) are only valid until the
+// next call, so values which are kept must be copied. The "charset" and "on_error" options
+// keep this interface, but only formats which parse bytes directly (e.g. "tab-delimited")
+// avoid allocating, and the "fixed_fields" option copies the fields. Formats created with the
+// "types" option do not implement FieldBytesFormat, unless "on_error" is also given.
+type FieldBytesFormat interface {
+	DataFormat
+
+	// NextFieldBytes returns the fields of the next record, in column order (or the order of
+	// the "columns" option, with nil for missing columns), or io.EOF at the end of input.
+	// This method requires a prior call to Open()
+	NextFieldBytes() ([][]byte, error)
+}
+
+// tabDelimited is an optimized parser for tab-delimited files. Lines are read directly from
+// the bufio.Reader's buffer and split in place, so NextFieldBytes does not allocate, and
+// NextRecordFields only allocates the line and the returned map.
+type tabDelimited struct {
+	FieldDelim byte
+	Comment    string
+	SkipFooter int
+
+	reader  *bufio.Reader
+	comment []byte
+	buf     []byte   // lines which do not fit in the reader's buffer
+	split   [][]byte // reused by NextFieldBytes
+	values  []string // reused by NextRecordFields
+	keys    []interface{}
+
+	// records are copied and read SkipFooter ahead, so that the footer is never returned
+	ahead  []delimitedLine
+	pos    Position // of the most recent record
+	next   Position // of the next line
+	parsed int      // records not yet reported to metrics
+
+	headerRow
+	scanLimits
+}
+
+func (f *tabDelimited) Init(spec map[string]string) error {
+	if err := f.scanLimits.init(spec); err != nil {
+		return err
+	}
+	f.FieldDelim = '\t'
+	if v, found := spec["fields"]; found {
+		if len(v) != 1 {
+			return fmt.Errorf("field delimiter for tab-delimited format must be a single byte (use simple-delimited)")
+		}
+		f.FieldDelim = v[0]
+	}
+	if v, found := spec["records"]; found && v != "\n" {
+		return fmt.Errorf("tab-delimited format only supports newline record delimiters (use simple-delimited)")
+	}
+	f.Comment = spec["comments"]
+	f.SkipFooter = 0
+	if v, found := spec["skip_footer"]; found {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("skip_footer option must be a non-negative integer, not '%s'", v)
+		}
+		f.SkipFooter = n
+	}
+	return f.headerRow.init(spec)
+}
+
+func (f *tabDelimited) Open(r io.Reader) error {
+	if f.FieldDelim == 0 {
+		f.FieldDelim = '\t'
+	}
+	size, _ := f.limits()
+	f.reader = bufio.NewReaderSize(r, size)
+	f.comment = []byte(f.Comment)
+	f.ahead = f.ahead[:0]
+	f.pos, f.next = Position{}, Position{Line: 1}
+	f.keys = nil
+	if err := f.setNames(nil); err != nil {
+		return err
+	}
+
+	for i := 0; i < f.SkipLines; i++ {
+		if _, err := f.readLine(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	if f.Header {
+		line, err := f.nextLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return f.setNames(strings.Split(string(line), string(f.FieldDelim)))
+	}
+	return nil
+}
+
+// readLine returns the next line without its newline. The line is only valid until the next
+// call.
+func (f *tabDelimited) readLine() ([]byte, error) {
+	_, max := f.limits()
+	line, err := f.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		f.buf = append(f.buf[:0], line...)
+		for err == bufio.ErrBufferFull && len(f.buf) <= max {
+			line, err = f.reader.ReadSlice('\n')
+			f.buf = append(f.buf, line...)
+		}
+		line = f.buf
+	}
+	if len(line) > max {
+		return nil, f.scanError(bufio.ErrTooLong)
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+
+	f.pos = f.next
+	f.next.Offset += int64(len(line))
+	if line[len(line)-1] == '\n' {
+		f.next.Line++
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// nextLine returns the next line which is not empty or a comment.
+func (f *tabDelimited) nextLine() ([]byte, error) {
+	for {
+		line, err := f.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || (len(f.comment) > 0 && bytes.HasPrefix(line, f.comment)) {
+			continue
+		}
+		return line, nil
+	}
+}
+
+// nextRecord returns the next record, which is only valid until the next call.
+func (f *tabDelimited) nextRecord() ([]byte, error) {
+	if f.SkipFooter == 0 {
+		line, err := f.nextLine()
+		if err != nil {
+			f.reportParsed()
+			return nil, err
+		}
+		f.countParsed()
+		return line, nil
+	}
+
+	for len(f.ahead) <= f.SkipFooter {
+		line, err := f.nextLine()
+		if err != nil {
+			f.reportParsed()
+			return nil, err
+		}
+		f.ahead = append(f.ahead, delimitedLine{string(line), f.pos})
+	}
+	line := f.ahead[0]
+	f.ahead = append(f.ahead[:0], f.ahead[1:]...)
+	f.pos = line.pos
+
+	f.countParsed()
+	return []byte(line.text), nil
+}
+
+// countParsed counts a parsed record. Metrics are reported in batches, because the call
+// would otherwise allocate for every record.
+func (f *tabDelimited) countParsed() {
+	f.parsed++
+	if f.parsed == 1024 {
+		f.reportParsed()
+	}
+}
+
+// reportParsed reports the records which have been counted.
+func (f *tabDelimited) reportParsed() {
+	if f.parsed > 0 {
+		metrics.Add(metrics.RecordsParsed, float64(f.parsed), "format", "tab-delimited")
+		f.parsed = 0
+	}
+}
+
+// Position returns the position of the most recent record.
+func (f *tabDelimited) Position() Position {
+	return f.pos
+}
+
+func (f *tabDelimited) NextFieldBytes() ([][]byte, error) {
+	line, err := f.nextRecord()
+	if err != nil {
+		return nil, err
+	}
+	n := f.splitN()
+	f.split = f.split[:0]
+	for {
+		i := bytes.IndexByte(line, f.FieldDelim)
+		if i == -1 || len(f.split)+1 == n {
+			f.split = append(f.split, line)
+			break
+		}
+		f.split = append(f.split, line[:i])
+		line = line[i+1:]
+	}
+	if f.Columns == nil {
+		return f.split, nil
+	}
+
+	// the selected columns are moved to the end of split, which is reused
+	all := len(f.split)
+	for _, i := range f.selected {
+		var v []byte
+		if i < all {
+			v = f.split[i]
+		}
+		f.split = append(f.split, v)
+	}
+	return f.split[all:], nil
+}
+
+func (f *tabDelimited) NextRecord() (string, error) {
+	line, err := f.nextRecord()
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+func (f *tabDelimited) GetFields(record string) (map[interface{}]string, error) {
+	return f.fields(strings.SplitN(strings.TrimSuffix(record, "\n"), string(f.FieldDelim), f.splitN())), nil
+}
+
+func (f *tabDelimited) NextRecordFields() (map[interface{}]string, error) {
+	s, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.fields(f.splitValues(s)), nil
+}
+
+// splitValues splits a record into f.values, which share the memory of s, stopping after the
+// last selected column.
+func (f *tabDelimited) splitValues(s string) []string {
+	n := f.splitN()
+	f.values = f.values[:0]
+	for {
+		i := strings.IndexByte(s, f.FieldDelim)
+		if i == -1 || len(f.values)+1 == n {
+			f.values = append(f.values, s)
+			return f.values
+		}
+		f.values = append(f.values, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func (f *tabDelimited) NextRecordInto(rec *Record) error {
+	s, err := f.NextRecord()
+	if err != nil {
+		return err
+	}
+	if f.Columns != nil {
+		f.record(f.splitValues(s), rec)
+		return nil
+	}
+	rec.Reset()
+	for i := 0; ; i++ {
+		if i == len(f.keys) {
+			// keys are cached, since boxing an int key may allocate
+			f.keys = append(f.keys, f.key(i))
+		}
+		rec.Keys = append(rec.Keys, f.keys[i])
+
+		j := strings.IndexByte(s, f.FieldDelim)
+		if j == -1 {
+			rec.Values = append(rec.Values, s)
+			return nil
+		}
+		rec.Values = append(rec.Values, s[:j])
+		s = s[j+1:]
+	}
+}
+
+func (f *tabDelimited) HasVariableFields() bool {
+	return false
+}
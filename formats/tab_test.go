@@ -0,0 +1,31 @@
+package formats_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestTabDelimitedFieldBytes(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+	df := openFormat(t, map[string]string{"type": "tab-delimited", "header": "true"}, "a\tb\n1\t"+long+"\n\n2\t\n3")
+	fb := df.(formats.FieldBytesFormat)
+
+	var got []string
+	for {
+		fields, err := fb.NextFieldBytes()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(bytes.Join(fields, []byte("|"))))
+	}
+	if len(got) != 3 || got[0] != "1|"+long || got[1] != "2|" || got[2] != "3" {
+		t.Errorf("unexpected records: %d", len(got))
+	}
+}
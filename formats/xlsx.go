@@ -0,0 +1,466 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// xlsxSheet reads the rows of one worksheet in an Excel workbook. A workbook is a zip archive,
+// so Open reads the entire input into memory, but the rows of the worksheet are parsed as they
+// are requested. Records are encoded as a JSON array of cell values, one per column.
+type xlsxSheet struct {
+	Sheet string
+	Cells string
+	headerRow
+
+	sharedStrings []string
+	dateStyles    []bool
+	date1904      bool
+
+	decoder *xml.Decoder
+	rowNum  int
+	pending []string
+}
+
+func (f *xlsxSheet) Init(spec map[string]string) error {
+	f.Sheet = spec["sheet"]
+	f.Cells = "typed"
+	if v, found := spec["cells"]; found {
+		if v != "typed" && v != "raw" {
+			return fmt.Errorf("xlsx format cells must be 'typed' or 'raw', not '%s'", v)
+		}
+		f.Cells = v
+	}
+	return f.headerRow.init(spec)
+}
+
+func (f *xlsxSheet) Open(r io.Reader) error {
+	f.decoder = nil
+	f.rowNum = 0
+	f.pending = nil
+	if err := f.setNames(nil); err != nil {
+		return err
+	}
+	if f.Cells == "" {
+		f.Cells = "typed"
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, []byte{0xD0, 0xCF, 0x11, 0xE0}) {
+		return fmt.Errorf("xlsx format does not support legacy .xls workbooks, save it as .xlsx")
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("xlsx format: not an Excel workbook: %s", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, zf := range zr.File {
+		files[zf.Name] = zf
+	}
+
+	sheetFile, err := f.findSheet(files)
+	if err != nil {
+		return err
+	}
+	if f.sharedStrings, err = readSharedStrings(files["xl/sharedStrings.xml"]); err != nil {
+		return err
+	}
+	if f.dateStyles, err = readDateStyles(files["xl/styles.xml"]); err != nil {
+		return err
+	}
+	rc, err := sheetFile.Open()
+	if err != nil {
+		return err
+	}
+	f.decoder = xml.NewDecoder(rc)
+
+	// skip_lines counts spreadsheet rows, including any which are empty
+	for {
+		row, err := f.nextRow()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f.rowNum > f.SkipLines {
+			if f.Header {
+				return f.setNames(row)
+			}
+			f.pending = row
+			return nil
+		}
+	}
+}
+
+// findSheet returns the worksheet selected by f.Sheet (a name or 1-based index), or the first
+// worksheet in the workbook.
+func (f *xlsxSheet) findSheet(files map[string]*zip.File) (*zip.File, error) {
+	var workbook struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := unmarshalZipFile(files["xl/workbook.xml"], &workbook); err != nil {
+		return nil, err
+	}
+	if err := unmarshalZipFile(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return nil, err
+	}
+	f.date1904 = workbook.Properties.Date1904 == "1" || workbook.Properties.Date1904 == "true"
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("xlsx format: workbook has no sheets")
+	}
+
+	idx := -1
+	if f.Sheet == "" {
+		idx = 0
+	}
+	for i, s := range workbook.Sheets {
+		if idx == -1 && s.Name == f.Sheet {
+			idx = i
+		}
+	}
+	if n, err := strconv.Atoi(f.Sheet); idx == -1 && err == nil && n > 0 && n <= len(workbook.Sheets) {
+		idx = n - 1
+	}
+	if idx == -1 {
+		return nil, fmt.Errorf("xlsx format: workbook has no sheet '%s'", f.Sheet)
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[idx].ID {
+			continue
+		}
+		name := path.Join("xl", rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			name = strings.TrimPrefix(rel.Target, "/")
+		}
+		if zf, found := files[name]; found {
+			return zf, nil
+		}
+	}
+	return nil, fmt.Errorf("xlsx format: worksheet '%s' is missing", workbook.Sheets[idx].Name)
+}
+
+// nextRow returns the cell values of the next row in the worksheet, or io.EOF at the end.
+func (f *xlsxSheet) nextRow() ([]string, error) {
+	var row []string
+	var value bytes.Buffer
+	var cellType string
+	var style, col int
+	inCell, inValue := false, false
+	for {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				f.rowNum++
+				for _, a := range t.Attr {
+					if a.Name.Local == "r" {
+						if n, err := strconv.Atoi(a.Value); err == nil {
+							f.rowNum = n
+						}
+					}
+				}
+			case "c":
+				inCell = true
+				cellType, style, col = "n", 0, len(row)
+				value.Reset()
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "r":
+						if n := columnIndex(a.Value); n >= 0 {
+							col = n
+						}
+					case "t":
+						cellType = a.Value
+					case "s":
+						style, _ = strconv.Atoi(a.Value)
+					}
+				}
+			case "v", "t":
+				inValue = inCell
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				inCell = false
+				for len(row) <= col {
+					row = append(row, "")
+				}
+				row[col] = f.cellValue(cellType, style, value.String())
+			case "row":
+				return row, nil
+			}
+		}
+	}
+}
+
+// cellValue converts a stored cell value according to its type and style.
+func (f *xlsxSheet) cellValue(cellType string, style int, v string) string {
+	switch cellType {
+	case "s":
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 && i < len(f.sharedStrings) {
+			return f.sharedStrings[i]
+		}
+		return v
+	case "b":
+		if f.Cells == "typed" {
+			return strconv.FormatBool(v == "1")
+		}
+		return v
+	case "n":
+		if f.Cells != "typed" || v == "" {
+			return v
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return v
+		}
+		if style < len(f.dateStyles) && f.dateStyles[style] {
+			return excelDate(n, f.date1904)
+		}
+		// remove binary floating-point noise, as Excel displays at most 15 significant digits
+		n, _ = strconv.ParseFloat(strconv.FormatFloat(n, 'g', 15, 64), 64)
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	// "str" (formula results), "inlineStr", "e" (errors) and "d" (ISO 8601 dates)
+	return v
+}
+
+// next returns the next non-empty row.
+func (f *xlsxSheet) next() ([]string, error) {
+	if f.decoder == nil {
+		return nil, io.EOF
+	}
+	if f.pending != nil {
+		row := f.pending
+		f.pending = nil
+		if len(row) > 0 {
+			return row, nil
+		}
+	}
+	for {
+		row, err := f.nextRow()
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 0 {
+			return row, nil
+		}
+	}
+}
+
+func (f *xlsxSheet) NextRecord() (string, error) {
+	row, err := f.next()
+	if err != nil {
+		return "", err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "xlsx")
+	data, err := json.Marshal(row)
+	return string(data), err
+}
+
+func (f *xlsxSheet) GetFields(record string) (map[interface{}]string, error) {
+	var row []string
+	if err := json.Unmarshal([]byte(record), &row); err != nil {
+		return nil, err
+	}
+	return f.fields(row), nil
+}
+
+func (f *xlsxSheet) NextRecordFields() (map[interface{}]string, error) {
+	row, err := f.next()
+	if err != nil {
+		return nil, err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "xlsx")
+	return f.fields(row), nil
+}
+
+func (f *xlsxSheet) HasVariableFields() bool {
+	return false
+}
+
+// unmarshalZipFile decodes the XML document in zf into v.
+func unmarshalZipFile(zf *zip.File, v interface{}) error {
+	if zf == nil {
+		return fmt.Errorf("xlsx format: not an Excel workbook")
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// readSharedStrings returns the shared string table of a workbook, which may be absent.
+func readSharedStrings(zf *zip.File) ([]string, error) {
+	if zf == nil {
+		return nil, nil
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var ret []string
+	var sb strings.Builder
+	inText, phonetic := false, 0
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "rPh":
+				// phonetic hints are not part of the text
+				phonetic++
+			case "t":
+				inText = phonetic == 0
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				ret = append(ret, sb.String())
+			case "rPh":
+				phonetic--
+			case "t":
+				inText = false
+			}
+		}
+	}
+}
+
+// readDateStyles returns, for each cell style index, whether the style formats dates or times.
+func readDateStyles(zf *zip.File) ([]bool, error) {
+	if zf == nil {
+		return nil, nil
+	}
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := unmarshalZipFile(zf, &styles); err != nil {
+		return nil, err
+	}
+	codes := make(map[int]string)
+	for _, nf := range styles.NumFmts {
+		codes[nf.ID] = nf.Code
+	}
+	ret := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		ret[i] = isDateFormat(xf.NumFmtID, codes[xf.NumFmtID])
+	}
+	return ret, nil
+}
+
+// isDateFormat returns true for the built-in date and time number formats, and for custom
+// formats which use date or time codes outside of quoted text and [bracketed] sections.
+func isDateFormat(id int, code string) bool {
+	if (id >= 14 && id <= 22) || (id >= 45 && id <= 47) {
+		return true
+	}
+	quoted, bracketed := false, false
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			bracketed = true
+		case c == ']':
+			bracketed = false
+		case bracketed:
+		case c == '\\':
+			i++
+		case strings.IndexByte("dmyhsDMYHS", c) != -1:
+			return true
+		}
+	}
+	return false
+}
+
+// excelDate converts a serial date number into "2006-01-02", "15:04:05" or
+// "2006-01-02 15:04:05" form.
+func excelDate(serial float64, date1904 bool) string {
+	// using Dec 30 accounts for the nonexistent Feb 29, 1900 in the 1900 date system
+	base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		base = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	secs := math.Round((serial - days) * 86400)
+	t := base.AddDate(0, 0, int(days)).Add(time.Duration(secs) * time.Second)
+	switch {
+	case days == 0 && !date1904:
+		return t.Format("15:04:05")
+	case secs == 0:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// columnIndex returns the 0-based column of a cell reference like "AB12", or -1 if it has none.
+func columnIndex(ref string) int {
+	n := 0
+	for i := 0; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		n = n*26 + int(ref[i]-'A') + 1
+	}
+	return n - 1
+}
@@ -0,0 +1,65 @@
+package formats_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+func TestXLSX(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+			xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Notes" sheetId="1" r:id="rId1"/><sheet name="Genes" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":     `<sst><si><t>gene_id</t></si><si><t>added</t></si><si><r><t>TP</t></r><r><t>53</t></r></si></sst>`,
+		"xl/styles.xml":            `<styleSheet><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>hi</t></is></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData>
+			<row r="1"><c r="A1" t="inlineStr"><is><t>Exported gene list</t></is></c></row>
+			<row r="3"><c r="A3" t="s"><v>0</v></c><c r="B3" t="s"><v>1</v></c><c r="D3" t="inlineStr"><is><t>score</t></is></c></row>
+			<row r="4"><c r="A4" t="s"><v>2</v></c><c r="B4" s="1"><v>45292</v></c><c r="C4" t="b"><v>1</v></c><c r="D4"><v>0.30000000000000004</v></c></row>
+			</sheetData></worksheet>`,
+	}
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	df, err := formats.GetDataFormat(map[string]string{"type": "xlsx", "sheet": "Genes", "header": "true", "skip_lines": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = df.Open(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := df.NextRecordFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec["gene_id"] != "TP53" || rec["added"] != "2024-01-01" || rec[2] != "true" || rec["score"] != "0.3" {
+		t.Errorf("unexpected fields: %v", rec)
+	}
+	if _, err = df.NextRecord(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	df, _ = formats.GetDataFormat(map[string]string{"type": "xlsx", "sheet": "2", "cells": "raw"})
+	df.Open(bytes.NewReader(buf.Bytes()))
+	df.NextRecord()
+	df.NextRecord()
+	line, err := df.NextRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != `["TP53","45292","1","0.30000000000000004"]` {
+		t.Errorf("unexpected raw record: %s", line)
+	}
+}
@@ -1,194 +1,293 @@
 package formats
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
-	"unicode"
+
+	"github.com/pbnjay/anydata/metrics"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
+// genericXMLFormat reads the elements named by the "records" option, keying the text of their
+// descendants by path (e.g. "Article>Title"). Attributes may also be returned (keyed as
+// "Article>Title@lang"), and repeated elements are either joined into a single field or
+// indexed by position (e.g. "Article>Author[0]>Name"). Record names may have a namespace
+// prefix (e.g. "ns:Record"), which is resolved using the "namespaces" option or the
+// declarations in the document.
 type genericXMLFormat struct {
-	descOffset int
-	descent    []string
-	records    map[string]bool
-	reader     io.Reader
-	decoder    *xml.Decoder
+	Attributes bool
+	Repeated   string
+	Join       string
+	Namespaces map[string]string
+
+	records   map[string]bool
+	qualified []xml.Name // prefixed records, with the prefix in Space
+	scopes    []map[string]string
+	utf16     bool
+	decoded   bool // the input is decoded by the "charset" option
+	reader    io.Reader
+	decoder   *xml.Decoder
+}
+
+// xmlNode is an element within a record.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	text     []string
+	children []*xmlNode
 }
 
 func (f *genericXMLFormat) Init(spec map[string]string) error {
 	recs := strings.Split(spec["records"], ",")
+	f.decoded = spec["charset"] != ""
 	f.records = make(map[string]bool)
+	f.qualified = nil
 	for _, r := range recs {
+		if i := strings.IndexByte(r, ':'); i != -1 {
+			f.qualified = append(f.qualified, xml.Name{Space: r[:i], Local: r[i+1:]})
+			continue
+		}
 		f.records[r] = true
 	}
+
+	f.Namespaces = make(map[string]string)
+	if v, found := spec["namespaces"]; found && v != "" {
+		for _, ns := range strings.Split(v, ",") {
+			parts := strings.SplitN(ns, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("namespaces option must be a list of prefix=URI, not '%s'", ns)
+			}
+			f.Namespaces[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	f.Attributes = false
+	if v, found := spec["attributes"]; found {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("attributes option must be true or false, not '%s'", v)
+		}
+		f.Attributes = b
+	}
+	f.Repeated = "join"
+	if v, found := spec["repeated"]; found {
+		if v != "join" && v != "index" {
+			return fmt.Errorf("repeated option must be 'join' or 'index', not '%s'", v)
+		}
+		f.Repeated = v
+	}
+	f.Join = "\t"
+	if v, found := spec["join"]; found {
+		f.Join = v
+	}
 	return nil
 }
 
 func (f *genericXMLFormat) Open(r io.Reader) error {
-	f.reader = r
-	f.decoder = xml.NewDecoder(r)
-	f.decoder.CharsetReader = charsetReader
+	// encoding/xml only reads ASCII-compatible encodings, so UTF-16 must be decoded first
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(2)
+	f.utf16 = bytes.Equal(bom, []byte{0xFE, 0xFF}) || bytes.Equal(bom, []byte{0xFF, 0xFE})
+	f.reader = br
+	if f.utf16 {
+		f.reader = transform.NewReader(br, unicode.BOMOverride(transform.Nop))
+	}
+
+	f.scopes = f.scopes[:0]
+	f.decoder = xml.NewDecoder(f.reader)
+	f.decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if f.decoded || (f.utf16 && strings.HasPrefix(strings.ToLower(charset), "utf-16")) {
+			return input, nil
+		}
+		return charsetReader(charset, input)
+	}
 	return nil
 }
 
-func (f *genericXMLFormat) xtractRecord() (map[string][]string, error) {
-	var tok xml.Token
-	var err error
-	recData := make(map[string][]string)
-	completeRecord := false
-	parsingRecord := false
+// isRecord returns true if the element is one of the records to enumerate.
+func (f *genericXMLFormat) isRecord(name xml.Name) bool {
+	if f.records[name.Local] {
+		return true
+	}
+	for _, q := range f.qualified {
+		if q.Local == name.Local && f.namespaceURI(q.Space) == name.Space {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceURI returns the URI for a namespace prefix, using the namespaces option before the
+// declarations in scope.
+func (f *genericXMLFormat) namespaceURI(prefix string) string {
+	if uri, found := f.Namespaces[prefix]; found {
+		return uri
+	}
+	for i := len(f.scopes) - 1; i >= 0; i-- {
+		if uri, found := f.scopes[i][prefix]; found {
+			return uri
+		}
+	}
+	return ""
+}
+
+// xtractRecord reads the next record element, and returns its fields.
+func (f *genericXMLFormat) xtractRecord() (map[string]string, error) {
+	var stack []*xmlNode
 
 	// read until we get an expected record
-	for tok, err = f.decoder.Token(); err == nil && !completeRecord; tok, err = f.decoder.Token() {
+	for {
+		tok, err := f.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
 		switch tval := tok.(type) {
 		case xml.StartElement:
-			f.descent = append(f.descent, tval.Name.Local)
-			if f.records[tval.Name.Local] {
-				parsingRecord = true
-				f.descOffset = len(f.descent) - 1
+			var scope map[string]string
+			var attrs []xml.Attr
+			for _, a := range tval.Attr {
+				if a.Name.Space == "xmlns" {
+					if scope == nil {
+						scope = make(map[string]string)
+					}
+					scope[a.Name.Local] = a.Value
+				} else if a.Name.Space != "" || a.Name.Local != "xmlns" {
+					attrs = append(attrs, a)
+				}
 			}
-		case xml.CharData:
-			if strings.TrimSpace(string(tval)) == "" {
+			f.scopes = append(f.scopes, scope)
+
+			if len(stack) == 0 && !f.isRecord(tval.Name) {
 				continue
 			}
-			if parsingRecord {
-				xPath := strings.Join(f.descent[f.descOffset:], ">")
-				recData[xPath] = append(recData[xPath], string(tval))
+			node := &xmlNode{name: tval.Name.Local, attrs: attrs}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.CharData:
+			if len(stack) == 0 || strings.TrimSpace(string(tval)) == "" {
+				continue
 			}
+			node := stack[len(stack)-1]
+			node.text = append(node.text, string(tval))
 		case xml.EndElement:
-			i := len(f.descent) - 1
-			if parsingRecord && f.records[f.descent[i]] && len(recData) > 0 {
-				completeRecord = true
+			f.scopes = f.scopes[:len(f.scopes)-1]
+			if len(stack) == 0 {
+				continue
 			}
-			if f.descent[i] == tval.Name.Local {
-				f.descent = f.descent[:i]
+			if len(stack) == 1 {
+				vals := make(map[string][]string)
+				f.addFields(stack[0], stack[0].name, vals)
+				ret := make(map[string]string, len(vals))
+				for key, val := range vals {
+					ret[key] = strings.Join(val, f.Join)
+				}
+				return ret, nil
 			}
+			stack = stack[:len(stack)-1]
 		}
 	}
-	return recData, err
 }
 
-func (f *genericXMLFormat) NextRecord() (string, error) {
-	var rec map[string][]string
-	var err error
-	ret := []string{}
-	for rec, err = f.xtractRecord(); err == nil && len(ret) == 0; rec, err = f.xtractRecord() {
-		for key, val := range rec {
-			ret = append(ret, key+" - "+strings.Join(val, "\t"))
+// addFields adds the text and attributes of node and its descendants to vals.
+func (f *genericXMLFormat) addFields(node *xmlNode, path string, vals map[string][]string) {
+	if f.Attributes {
+		for _, a := range node.attrs {
+			key := path + "@" + a.Name.Local
+			vals[key] = append(vals[key], a.Value)
 		}
 	}
+	if len(node.text) > 0 {
+		vals[path] = append(vals[path], node.text...)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range node.children {
+		counts[c.name]++
+	}
+	seen := make(map[string]int)
+	for _, c := range node.children {
+		cpath := path + ">" + c.name
+		if f.Repeated == "index" && counts[c.name] > 1 {
+			cpath += "[" + strconv.Itoa(seen[c.name]) + "]"
+			seen[c.name]++
+		}
+		f.addFields(c, cpath, vals)
+	}
+}
+
+func (f *genericXMLFormat) NextRecord() (string, error) {
+	rec, err := f.NextRecordFields()
 	if err != nil {
 		return "", err
 	}
+	ret := make([]string, 0, len(rec))
+	for key, val := range rec {
+		ret = append(ret, key.(string)+" - "+val)
+	}
+	sort.Strings(ret)
 	return strings.Join(ret, "\n"), nil
 }
 
 func (f *genericXMLFormat) GetFields(record string) (map[interface{}]string, error) {
 	ret := make(map[interface{}]string)
+	last := ""
 	for _, line := range strings.Split(record, "\n") {
 		parts := strings.SplitN(line, " - ", 2)
-		ret[parts[0]] = parts[1]
-	}
-	return ret, nil
-}
-
-func (f *genericXMLFormat) NextRecordFields() (map[interface{}]string, error) {
-	var rec map[string][]string
-	var err error
-	ret := make(map[interface{}]string)
-	for rec, err = f.xtractRecord(); err == nil && len(ret) == 0; rec, err = f.xtractRecord() {
-		for key, val := range rec {
-			ret[key] = strings.Join(val, "\t")
+		if len(parts) != 2 {
+			if last != "" {
+				// a value which contains a newline
+				ret[last] += "\n" + line
+			}
+			continue
 		}
-	}
-	if err != nil {
-		return nil, err
+		last = parts[0]
+		ret[last] = parts[1]
 	}
 	return ret, nil
 }
 
-func (f *genericXMLFormat) HasVariableFields() bool {
-	return true
-}
-
-type charsetISO88591er struct {
-	r   io.ByteReader
-	buf *bytes.Buffer
-}
-
-func newCharsetISO88591(r io.Reader) *charsetISO88591er {
-	buf := bytes.NewBuffer(make([]byte, 0, unicode.MaxRune))
-	return &charsetISO88591er{r.(io.ByteReader), buf}
-}
-
-func (cs *charsetISO88591er) ReadByte() (b byte, err error) {
-	// http://unicode.org/Public/MAPPINGS/ISO8859/8859-1.TXT
-	// Date: 1999 July 27; Last modified: 27-Feb-2001 05:08
-	if cs.buf.Len() <= 0 {
-		r, err := cs.r.ReadByte()
+func (f *genericXMLFormat) NextRecordFields() (map[interface{}]string, error) {
+	for {
+		rec, err := f.xtractRecord()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		if r < unicode.MaxASCII {
-			return r, nil
+		if len(rec) == 0 {
+			continue
 		}
-		cs.buf.WriteRune(rune(r))
-	}
-	return cs.buf.ReadByte()
-}
-
-func (cs *charsetISO88591er) Read(p []byte) (int, error) {
-	// Use ReadByte method.
-	return 0, fmt.Errorf("not implemented")
-}
-
-func isCharset(charset string, names []string) bool {
-	charset = strings.ToLower(charset)
-	for _, n := range names {
-		if charset == strings.ToLower(n) {
-			return true
+		ret := make(map[interface{}]string, len(rec))
+		for key, val := range rec {
+			ret[key] = val
 		}
+		metrics.Add(metrics.RecordsParsed, 1, "format", "xml")
+		return ret, nil
 	}
-	return false
 }
 
-func isCharsetISO88591(charset string) bool {
-	// http://www.iana.org/assignments/character-sets
-	// (last updated 2010-11-04)
-	names := []string{
-		// Name
-		"ISO_8859-1:1987",
-		// Alias (preferred MIME name)
-		"ISO-8859-1",
-		// Aliases
-		"iso-ir-100",
-		"ISO_8859-1",
-		"latin1",
-		"l1",
-		"IBM819",
-		"CP819",
-		"csISOLatin1",
-	}
-	return isCharset(charset, names)
-}
-
-func isCharsetUTF8(charset string) bool {
-	names := []string{
-		"UTF-8",
-		// Default
-		"",
-	}
-	return isCharset(charset, names)
+func (f *genericXMLFormat) HasVariableFields() bool {
+	return true
 }
 
+// charsetReader decodes the IANA-registered charsets, such as "ISO-8859-1", "ISO-8859-15",
+// "Windows-1252" and "UTF-16", to UTF-8.
 func charsetReader(charset string, input io.Reader) (io.Reader, error) {
-	if isCharsetUTF8(charset) {
+	if charset == "" || strings.EqualFold(charset, "UTF-8") {
 		return input, nil
 	}
-	if isCharsetISO88591(charset) {
-		return newCharsetISO88591(input), nil
+	enc, err := ianaindex.IANA.Encoding(charset)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unexpected charset: %s", charset)
 	}
-	return nil, fmt.Errorf("unexpected charset: " + charset)
+	return transform.NewReader(input, enc.NewDecoder()), nil
 }
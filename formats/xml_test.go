@@ -0,0 +1,55 @@
+package formats_test
+
+import "testing"
+
+func TestXMLAttributes(t *testing.T) {
+	input := `<Set><Article id="a1"><Title lang="en">First</Title>
+		<Author><Name>X</Name></Author><Author><Name>Y</Name></Author></Article>
+		<Article id="a2"><Title>Second</Title><Author><Name>Z</Name></Author></Article></Set>`
+
+	recs := readAllFields(t, map[string]string{"type": "xml", "records": "Article", "join": "|"}, input)
+	if len(recs) != 2 || recs[0]["Article>Author>Name"] != "X|Y" || recs[0]["Article@id"] != "" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	recs = readAllFields(t, map[string]string{"type": "xml", "records": "Article",
+		"attributes": "true", "repeated": "index"}, input)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, found %d", len(recs))
+	}
+	if recs[0]["Article@id"] != "a1" || recs[0]["Article>Title@lang"] != "en" ||
+		recs[0]["Article>Author[0]>Name"] != "X" || recs[0]["Article>Author[1]>Name"] != "Y" {
+		t.Errorf("unexpected fields: %v", recs[0])
+	}
+	if recs[1]["Article>Author>Name"] != "Z" {
+		t.Errorf("unexpected fields: %v", recs[1])
+	}
+}
+
+func TestXMLNamespacesAndCharsets(t *testing.T) {
+	input := `<feed xmlns:a="urn:a" xmlns:b="urn:b"><a:entry><a:id>1</a:id></a:entry>
+		<b:entry><b:id>2</b:id></b:entry></feed>`
+	recs := readAllFields(t, map[string]string{"type": "xml", "records": "b:entry"}, input)
+	if len(recs) != 1 || recs[0]["entry>id"] != "2" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+	recs = readAllFields(t, map[string]string{"type": "xml", "records": "x:entry", "namespaces": "x=urn:a"}, input)
+	if len(recs) != 1 || recs[0]["entry>id"] != "1" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	latin := "<?xml version=\"1.0\" encoding=\"windows-1252\"?><r><v>caf\xe9 \x80</v></r>"
+	recs = readAllFields(t, map[string]string{"type": "xml", "records": "r"}, latin)
+	if len(recs) != 1 || recs[0]["r>v"] != "café €" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+
+	utf16 := []byte{0xFF, 0xFE}
+	for _, c := range `<?xml version="1.0" encoding="UTF-16"?><r><v>ü</v></r>` {
+		utf16 = append(utf16, byte(c), byte(c>>8))
+	}
+	recs = readAllFields(t, map[string]string{"type": "xml", "records": "r"}, string(utf16))
+	if len(recs) != 1 || recs[0]["r>v"] != "ü" {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
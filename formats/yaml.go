@@ -0,0 +1,167 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pbnjay/anydata/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlDocuments reads each document in a (multi-document) YAML stream as a record, or each
+// item if a document is a list. Records are encoded as compact JSON, so fields are flattened in
+// the same way as the "json" format.
+type yamlDocuments struct {
+	Null string
+
+	decoder *yaml.Decoder
+	items   []interface{}
+}
+
+func (f *yamlDocuments) Init(spec map[string]string) error {
+	f.Null = ""
+	if v, found := spec["null"]; found {
+		f.Null = v
+	}
+	return nil
+}
+
+func (f *yamlDocuments) Open(r io.Reader) error {
+	f.decoder = yaml.NewDecoder(r)
+	f.items = nil
+	return nil
+}
+
+func (f *yamlDocuments) NextRecord() (string, error) {
+	for len(f.items) == 0 {
+		var doc yaml.Node
+		if err := f.decoder.Decode(&doc); err != nil {
+			return "", err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		v, err := yamlValue(doc.Content[0])
+		if err != nil {
+			return "", err
+		}
+		if list, ok := v.([]interface{}); ok {
+			f.items = list
+		} else {
+			f.items = []interface{}{v}
+		}
+	}
+	v := f.items[0]
+	f.items = f.items[1:]
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	metrics.Add(metrics.RecordsParsed, 1, "format", "yaml")
+	return string(data), nil
+}
+
+func (f *yamlDocuments) GetFields(record string) (map[interface{}]string, error) {
+	return (&jsonRecords{Null: f.Null}).GetFields(record)
+}
+
+func (f *yamlDocuments) NextRecordFields() (map[interface{}]string, error) {
+	s, e := f.NextRecord()
+	if e != nil {
+		return nil, e
+	}
+	return f.GetFields(s)
+}
+
+func (f *yamlDocuments) HasVariableFields() bool {
+	return true
+}
+
+// yamlValue converts a YAML node into values which can be encoded as JSON. Numbers keep their
+// original text where it is valid JSON, anchors are resolved, and "<<" merge keys are applied.
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(n.Content[0])
+
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+
+	case yaml.SequenceNode:
+		ret := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+
+	case yaml.MappingNode:
+		ret := make(map[string]interface{}, len(n.Content)/2)
+		var merges []map[string]interface{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, err := yamlValue(n.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if n.Content[i].Tag == "!!merge" {
+				switch m := v.(type) {
+				case map[string]interface{}:
+					merges = append(merges, m)
+				case []interface{}:
+					for _, x := range m {
+						if mm, ok := x.(map[string]interface{}); ok {
+							merges = append(merges, mm)
+						}
+					}
+				}
+				continue
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			ret[key] = v
+		}
+		for _, m := range merges {
+			for k, v := range m {
+				if _, found := ret[k]; !found {
+					ret[k] = v
+				}
+			}
+		}
+		return ret, nil
+	}
+
+	switch n.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := n.Decode(&b)
+		return b, err
+	case "!!int", "!!float":
+		if json.Valid([]byte(n.Value)) {
+			return json.Number(n.Value), nil
+		}
+		// e.g. hex integers or .inf, which JSON cannot represent
+		var v interface{}
+		if err := n.Decode(&v); err == nil {
+			if _, err = json.Marshal(v); err == nil {
+				return v, nil
+			}
+		}
+	}
+	return n.Value, nil
+}
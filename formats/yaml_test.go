@@ -0,0 +1,36 @@
+package formats_test
+
+import "testing"
+
+func TestYAMLDocuments(t *testing.T) {
+	input := `defaults: &defaults
+  replicas: 1
+  version: 1.10
+---
+kind: Deployment
+metadata: {name: web, labels: {app: web}}
+spec:
+  <<: *defaults
+  replicas: 3
+  ports: [80, 443]
+---
+- name: a
+  enabled: true
+- name: b
+  owner: ~
+`
+	recs := readAllFields(t, map[string]string{"type": "yaml", "null": "NULL"}, input)
+	if len(recs) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(recs))
+	}
+	if recs[0]["defaults.version"] != "1.10" {
+		t.Errorf("unexpected fields: %v", recs[0])
+	}
+	if recs[1]["metadata.labels.app"] != "web" || recs[1]["spec.replicas"] != "3" ||
+		recs[1]["spec.version"] != "1.10" || recs[1]["spec.ports"] != "[80,443]" {
+		t.Errorf("unexpected fields: %v", recs[1])
+	}
+	if recs[2]["enabled"] != "true" || recs[3]["owner"] != "NULL" {
+		t.Errorf("unexpected list items: %v %v", recs[2], recs[3])
+	}
+}
@@ -0,0 +1,246 @@
+package anydata
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS is a read-only io/fs.FS over a set of resource strings, so that code written against
+// fs.FS (template loading, http.FileServer, etc.) can transparently read remote, cached,
+// decompressed and extracted resources. Resources are only fetched when opened.
+//
+// Directories are implied by the slash-separated names of the resources they contain.
+type FS struct {
+	// Registry used to resolve resources (nil uses DefaultRegistry).
+	Registry *Registry
+
+	files map[string]string
+}
+
+// NewFS creates an FS containing each of the given resources. Each resource is named using its
+// archive member path if it has a fragment, or the base name of its path otherwise, e.g.:
+//
+//    ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz#names.dmp  =>  names.dmp
+//    https://example.com/data/genes.tsv.gz                         =>  genes.tsv.gz
+//
+// Use Add to choose names explicitly.
+func NewFS(resources ...string) (*FS, error) {
+	fsys := &FS{files: make(map[string]string)}
+	for _, res := range resources {
+		name := ""
+		if furl, err := url.Parse(res); err == nil {
+			if furl.Fragment != "" {
+				name = furl.Fragment
+			} else {
+				name = path.Base(furl.Path)
+			}
+		} else if parts := strings.SplitN(res, "#", 2); len(parts) == 2 {
+			name = parts[1]
+		} else {
+			name = path.Base(res)
+		}
+
+		if err := fsys.Add(strings.TrimLeft(name, "/"), res); err != nil {
+			return nil, err
+		}
+	}
+	return fsys, nil
+}
+
+// Add makes resource available under name, which must be a valid fs path.
+func (fsys *FS) Add(name, resource string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "add", Path: name, Err: fs.ErrInvalid}
+	}
+	if fsys.files == nil {
+		fsys.files = make(map[string]string)
+	}
+	if _, found := fsys.files[name]; found {
+		return &fs.PathError{Op: "add", Path: name, Err: fs.ErrExist}
+	}
+	fsys.files[name] = resource
+	return nil
+}
+
+// Open implements fs.FS.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if res, found := fsys.files[name]; found {
+		reg := fsys.Registry
+		if reg == nil {
+			reg = DefaultRegistry
+		}
+		ftch, err := reg.GetFetcher(res)
+		if err == nil {
+			err = ftch.Fetch(res)
+		}
+		var r io.Reader
+		if err == nil {
+			r, err = ftch.GetReader()
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &fsFile{name: path.Base(name), ftch: ftch, r: r}, nil
+	}
+
+	entries := fsys.readDir(name)
+	if entries == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fsDir{name: path.Base(name), entries: entries}, nil
+}
+
+// readDir returns the sorted entries within directory name, or nil if it does not exist.
+func (fsys *FS) readDir(name string) []fs.DirEntry {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for fn := range fsys.files {
+		if !strings.HasPrefix(fn, prefix) {
+			continue
+		}
+		rest := fn[len(prefix):]
+		isDir := false
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i]
+			isDir = true
+		}
+		if seen[rest] {
+			continue
+		}
+		seen[rest] = true
+		entries = append(entries, fs.FileInfoToDirEntry(&fsInfo{name: rest, dir: isDir, size: -1}))
+	}
+	if entries == nil && name == "." {
+		return []fs.DirEntry{}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+type fsInfo struct {
+	name string
+	dir  bool
+	size int64
+}
+
+func (fi *fsInfo) Name() string { return fi.name }
+
+// Size returns the size of the file, or 0 if it is not yet known.
+func (fi *fsInfo) Size() int64 {
+	if fi.size < 0 {
+		return 0
+	}
+	return fi.size
+}
+
+func (fi *fsInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fsInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fsInfo) IsDir() bool        { return fi.dir }
+func (fi *fsInfo) Sys() interface{}   { return nil }
+
+// fsFile is an opened resource. Seek is supported directly if the underlying reader allows it,
+// otherwise the resource is re-read and spooled to a temporary file on the first Seek.
+//
+// Because the size of a resource is generally not known until it has been completely
+// decompressed or extracted, Stat always reports a size of 0.
+type fsFile struct {
+	name string
+	ftch Fetcher
+	r    io.Reader
+	pos  int64
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return &fsInfo{name: f.name, size: -1}, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if _, ok := f.r.(io.Seeker); !ok {
+		r, err := f.ftch.GetReader()
+		if err != nil {
+			return 0, err
+		}
+		ra, size, err := readerAt(r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.Close()
+
+		sr := io.NewSectionReader(ra, 0, size)
+		sr.Seek(f.pos, io.SeekStart)
+		f.r = sr
+	}
+	return f.r.(io.Seeker).Seek(offset, whence)
+}
+
+func (f *fsFile) Close() error {
+	if c, ok := f.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+type fsDir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return &fsInfo{name: d.name, dir: true}, nil
+}
+
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,42 @@
+package anydata_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	plain := filepath.Join(dir, "plain.txt")
+	ioutil.WriteFile(plain, []byte("hello world\n"), 0666)
+
+	gzName := filepath.Join(dir, "data.txt.gz")
+	f, _ := os.Create(gzName)
+	gw := gzip.NewWriter(f)
+	gw.Write([]byte("compressed contents\n"))
+	gw.Close()
+	f.Close()
+
+	fsys, err := anydata.NewFS(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fsys.Add("sub/data.txt", gzName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fstest.TestFS(fsys, "plain.txt", "sub/data.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
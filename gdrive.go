@@ -0,0 +1,175 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// A Google Drive fetcher for publicly shared files. Detects drive://<file-id> resources, as well
+// as the usual drive.google.com sharing links:
+//
+//    drive://1AbCdEfGhIjKlMnOpQrStUvWxYz/names.tar.gz#names.dmp
+//    https://drive.google.com/file/d/1AbCdEfGhIjKlMnOpQrStUvWxYz/view?usp=sharing
+//    https://drive.google.com/open?id=1AbCdEfGhIjKlMnOpQrStUvWxYz
+//    https://drive.google.com/uc?export=download&id=1AbCdEfGhIjKlMnOpQrStUvWxYz
+//
+// The optional filename after the file ID of a drive:// resource is only used to select
+// wrappers (so that the file is decompressed and/or extracted as expected).
+//
+// Large files are not virus-scanned by Google, so Drive returns a confirmation page instead of
+// the file. This is handled automatically. Downloads are stored in the cache in the same way as
+// the HTTP fetcher, and support the same resume and revalidation features.
+type driveFetcher struct {
+	httpFetcher
+}
+
+var (
+	driveLinkPattern  = regexp.MustCompile(`^https?://(drive|docs)\.google\.com/file/d/([^/?#]+)`)
+	driveFormPattern  = regexp.MustCompile(`(?s)<form[^>]*id="download-form"[^>]*action="([^"]+)"(.*?)</form>`)
+	driveInputPattern = regexp.MustCompile(`<input[^>]*type="hidden"[^>]*name="([^"]+)"[^>]*value="([^"]*)"`)
+	driveTokenPattern = regexp.MustCompile(`confirm=([0-9A-Za-z_-]+)`)
+
+	// driveDownloadURL is the download link for a file ID (tests replace it with a local server)
+	driveDownloadURL = "https://drive.google.com/uc?export=download&id="
+)
+
+// driveFileID returns the Google Drive file ID referenced by resource, or "" if resource is not
+// a Google Drive link.
+func driveFileID(resource string) string {
+	if strings.HasPrefix(resource, "drive://") {
+		furl, err := url.Parse(resource)
+		if err != nil {
+			return ""
+		}
+		return furl.Host
+	}
+	if m := driveLinkPattern.FindStringSubmatch(resource); m != nil {
+		return m[2]
+	}
+	if strings.HasPrefix(resource, "https://drive.google.com/") ||
+		strings.HasPrefix(resource, "https://docs.google.com/") {
+		furl, err := url.Parse(resource)
+		if err != nil {
+			return ""
+		}
+		if furl.Path == "/open" || furl.Path == "/uc" {
+			return furl.Query().Get("id")
+		}
+	}
+	return ""
+}
+
+func (n *driveFetcher) String() string {
+	return "Google Drive Download"
+}
+
+func (n *driveFetcher) Detect(resource string) bool {
+	return driveFileID(resource) != ""
+}
+
+func (n *driveFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *driveFetcher) FetchContext(ctx context.Context, resource string) error {
+	if n.cache().cachedFilePath(ctx, resource) != "" {
+		return n.httpFetcher.FetchContext(ctx, resource)
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "drive")
+
+	if err := n.setDownloadURL(resource); err != nil {
+		return err
+	}
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		// small files are returned directly
+		n.localPath = ""
+		return n.startDownload(ctx, resp)
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	n.url, err = driveConfirmURL(n.url, string(page))
+	if err != nil {
+		return fmt.Errorf("google drive fetch of '%s' failed: %s", resource, err.Error())
+	}
+	return n.httpFetcher.FetchContext(ctx, resource)
+}
+
+// setDownloadURL prepares the fetcher to request the download link for resource.
+func (n *driveFetcher) setDownloadURL(resource string) error {
+	id := driveFileID(resource)
+	if id == "" {
+		return fmt.Errorf("'%s' is not a Google Drive link", resource)
+	}
+
+	// the confirmation token may be tied to a cookie
+	jar := n.opts.Jar
+	if jar == nil {
+		var err error
+		if jar, err = cookiejar.New(nil); err != nil {
+			return err
+		}
+	}
+	n.client = &http.Client{Jar: jar, Transport: httpTransport(&n.opts)}
+	n.resource = resource
+	n.url = driveDownloadURL + url.QueryEscape(id)
+	return nil
+}
+
+// Stat makes a HEAD request for the download link. The size of large files is not known (-1),
+// since Drive returns a confirmation page for them instead.
+func (n *driveFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if err := n.setDownloadURL(resource); err != nil {
+		return ResourceInfo{}, err
+	}
+	info, err := n.httpFetcher.Stat(ctx, resource)
+	if err == nil && strings.HasPrefix(info.ContentType, "text/html") {
+		info = ResourceInfo{Size: -1}
+	}
+	return info, err
+}
+
+// Expand returns pattern as-is, since Drive files can't be listed without the Drive API.
+func (n *driveFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	return []string{pattern}, nil
+}
+
+// driveConfirmURL extracts the download URL from a Google Drive confirmation page.
+func driveConfirmURL(pageURL, page string) (string, error) {
+	if m := driveFormPattern.FindStringSubmatch(page); m != nil {
+		furl, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			return "", err
+		}
+		q := furl.Query()
+		for _, in := range driveInputPattern.FindAllStringSubmatch(m[2], -1) {
+			q.Set(html.UnescapeString(in[1]), html.UnescapeString(in[2]))
+		}
+		furl.RawQuery = q.Encode()
+		return furl.String(), nil
+	}
+
+	// older confirmation pages include a token in the download link
+	if m := driveTokenPattern.FindStringSubmatch(page); m != nil {
+		return pageURL + "&confirm=" + m[1], nil
+	}
+	return "", fmt.Errorf("file is not publicly shared or does not exist")
+}
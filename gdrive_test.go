@@ -0,0 +1,113 @@
+package anydata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDriveFileID(t *testing.T) {
+	const id = "1AbCdEfGhIjKlMnOpQrStUvWxYz"
+	for resource, want := range map[string]string{
+		"drive://" + id + "/names.tar.gz#names.dmp":                   id,
+		"https://drive.google.com/file/d/" + id + "/view?usp=sharing": id,
+		"https://docs.google.com/file/d/" + id:                        id,
+		"https://drive.google.com/open?id=" + id:                      id,
+		"https://drive.google.com/uc?export=download&id=" + id:        id,
+		"https://drive.google.com/drive/folders/" + id:                "",
+		"https://example.com/file/d/" + id:                            "",
+		"http://drive.google.com.example.com/open?id=" + id:           "",
+	} {
+		if got := driveFileID(resource); got != want {
+			t.Errorf("driveFileID(%s) = '%s', expected '%s'", resource, got, want)
+		}
+	}
+}
+
+func TestDriveConfirmURL(t *testing.T) {
+	page := `<html><form id="download-form" action="https://drive.usercontent.google.com/download" method="get">
+		<input type="submit" value="Download anyway"/>
+		<input type="hidden" name="id" value="abc"><input type="hidden" name="confirm" value="t">
+		<input type="hidden" name="uuid" value="x&amp;y"></form></html>`
+	u, err := driveConfirmURL("https://drive.google.com/uc?export=download&id=abc", page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://drive.usercontent.google.com/download?confirm=t&id=abc&uuid=x%26y" {
+		t.Errorf("unexpected confirm url: %s", u)
+	}
+
+	page = `<a id="uc-download-link" href="/uc?export=download&amp;confirm=Xy_9&amp;id=abc">Download anyway</a>`
+	u, err = driveConfirmURL("https://drive.google.com/uc?export=download&id=abc", page)
+	if err != nil || u != "https://drive.google.com/uc?export=download&id=abc&confirm=Xy_9" {
+		t.Errorf("unexpected confirm url: %s (%v)", u, err)
+	}
+
+	if _, err = driveConfirmURL("https://drive.google.com/uc?export=download&id=abc", "<html>Sign in</html>"); err == nil {
+		t.Error("expected an error for a page without a download link")
+	}
+}
+
+func TestDriveFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	InitCache(dir, 1)
+
+	content := strings.Repeat("0123456789abcdef", 1024)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/uc" && r.URL.Query().Get("id") == "small":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, content)
+		case r.URL.Path == "/uc":
+			// large files need confirmation, with a cookie
+			http.SetCookie(w, &http.Cookie{Name: "download_warning", Value: "1"})
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, `<form id="download-form" action="%s/download" method="get">
+				<input type="hidden" name="id" value="%s"><input type="hidden" name="confirm" value="t"></form>`,
+				srv.URL, r.URL.Query().Get("id"))
+		case r.URL.Path == "/download" && r.URL.Query().Get("confirm") == "t":
+			if c, err := r.Cookie("download_warning"); err != nil || c.Value != "1" {
+				http.Error(w, "missing cookie", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(u string) { driveDownloadURL = u }(driveDownloadURL)
+	driveDownloadURL = srv.URL + "/uc?export=download&id="
+
+	for _, resource := range []string{"drive://small/data.txt", "https://drive.google.com/file/d/large/view"} {
+		f, err := GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(f) != "Google Drive Download" {
+			t.Fatalf("unexpected fetcher for '%s': %s", resource, f)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != content {
+			t.Errorf("%s: read %d bytes: %v", resource, len(data), err)
+		}
+	}
+}
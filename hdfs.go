@@ -0,0 +1,213 @@
+package anydata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HDFSAuthenticator adds authentication to WebHDFS requests, for example a Kerberos SPNEGO
+// Authorization header (see the kerberos sub-package).
+type HDFSAuthenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+var (
+	hdfsMu   sync.Mutex
+	hdfsAuth HDFSAuthenticator
+
+	// HDFSWebPort is the NameNode HTTP port used for hdfs:// resources, since the port in
+	// those URLs is usually the (unsupported) RPC port.
+	HDFSWebPort = "9870"
+)
+
+// SetHDFSAuthenticator sets the HDFSAuthenticator used for all WebHDFS requests. If nil (the
+// default), Hadoop's "simple" authentication is used, with the user name taken from the URL or
+// the HADOOP_USER_NAME environment variable.
+func SetHDFSAuthenticator(a HDFSAuthenticator) {
+	hdfsMu.Lock()
+	hdfsAuth = a
+	hdfsMu.Unlock()
+}
+
+// A Hadoop HDFS fetcher using the WebHDFS REST API, which detects the following URLs:
+//
+//    hdfs://namenode/path/to/file         (uses HDFSWebPort)
+//    webhdfs://namenode:9870/path/to/file
+//    swebhdfs://namenode:9871/path/to/file  (WebHDFS over HTTPS)
+//
+// Any query parameters (such as delegation=<token>) are passed through to the OPEN request.
+// Downloads are stored in the cache in the same way as the HTTP fetcher, and support the same
+// resume and revalidation features.
+type hdfsFetcher struct {
+	httpFetcher
+}
+
+func (n *hdfsFetcher) String() string {
+	return "HDFS Download"
+}
+
+func (n *hdfsFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "hdfs://") || strings.HasPrefix(resource, "webhdfs://") ||
+		strings.HasPrefix(resource, "swebhdfs://")
+}
+
+// webhdfsURL returns the WebHDFS URL for operation op on an HDFS resource.
+func webhdfsURL(resource, op string, simpleAuth bool) (string, error) {
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, port := "http", furl.Port()
+	switch furl.Scheme {
+	case "hdfs":
+		port = HDFSWebPort
+	case "webhdfs":
+		if port == "" {
+			port = "9870"
+		}
+	case "swebhdfs":
+		scheme = "https"
+		if port == "" {
+			port = "9871"
+		}
+	default:
+		return "", fmt.Errorf("'%s' is not an HDFS resource", resource)
+	}
+
+	q := furl.Query()
+	q.Set("op", op)
+	if simpleAuth && q.Get("delegation") == "" && q.Get("user.name") == "" {
+		user := os.Getenv("HADOOP_USER_NAME")
+		if furl.User != nil {
+			user = furl.User.Username()
+		}
+		if user != "" {
+			q.Set("user.name", user)
+		}
+	}
+
+	wurl := &url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(furl.Hostname(), port),
+		Path:     "/webhdfs/v1" + furl.Path,
+		RawQuery: q.Encode(),
+	}
+	return wurl.String(), nil
+}
+
+func (n *hdfsFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *hdfsFetcher) FetchContext(ctx context.Context, resource string) error {
+	if err := n.setOperation(resource, "OPEN"); err != nil {
+		return err
+	}
+	return n.httpFetcher.FetchContext(ctx, resource)
+}
+
+// setOperation prepares the fetcher to request the WebHDFS operation op for resource.
+func (n *hdfsFetcher) setOperation(resource, op string) error {
+	hdfsMu.Lock()
+	auth := hdfsAuth
+	hdfsMu.Unlock()
+
+	var err error
+	n.url, err = webhdfsURL(resource, op, auth == nil)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		n.prepare = auth.Authenticate
+	}
+	return nil
+}
+
+// Stat uses the WebHDFS GETFILESTATUS operation.
+func (n *hdfsFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if err := n.setOperation(resource, "GETFILESTATUS"); err != nil {
+		return ResourceInfo{}, err
+	}
+	n.resource = resource
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		FileStatus struct {
+			Length           int64  `json:"length"`
+			ModificationTime int64  `json:"modificationTime"`
+			Type             string `json:"type"`
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return ResourceInfo{}, fmt.Errorf("hdfs stat of '%s' failed: %s", resource, err.Error())
+	}
+	if status.FileStatus.Type != "FILE" {
+		return ResourceInfo{}, fmt.Errorf("hdfs stat of '%s' failed: not a file", resource)
+	}
+	return ResourceInfo{
+		Size:        status.FileStatus.Length,
+		ModTime:     time.Unix(0, status.FileStatus.ModificationTime*int64(time.Millisecond)),
+		ContentType: mime.TypeByExtension(path.Ext(strings.SplitN(resource, "#", 2)[0])),
+	}, nil
+}
+
+// Expand lists the files matching pattern using the WebHDFS LISTSTATUS operation.
+func (n *hdfsFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	return expandURL(pattern, func(dir *url.URL) ([]*url.URL, []*url.URL, error) {
+		return n.listStatus(ctx, dir)
+	})
+}
+
+// listStatus returns the files and subdirectories of an HDFS directory.
+func (n *hdfsFetcher) listStatus(ctx context.Context, dir *url.URL) ([]*url.URL, []*url.URL, error) {
+	if err := n.setOperation(dir.String(), "LISTSTATUS"); err != nil {
+		return nil, nil, err
+	}
+	n.resource = dir.String()
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var listing struct {
+		FileStatuses struct {
+			FileStatus []struct {
+				PathSuffix string `json:"pathSuffix"`
+				Type       string `json:"type"`
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, nil, fmt.Errorf("hdfs listing of '%s' failed: %s", dir, err.Error())
+	}
+
+	var files, dirs []*url.URL
+	for _, st := range listing.FileStatuses.FileStatus {
+		child := *dir
+		child.Path += st.PathSuffix
+		switch st.Type {
+		case "FILE":
+			files = append(files, &child)
+		case "DIRECTORY":
+			child.Path += "/"
+			dirs = append(dirs, &child)
+		}
+	}
+	return files, dirs, nil
+}
@@ -0,0 +1,103 @@
+package anydata_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+// headerAuth is an HDFSAuthenticator which sets a fixed Authorization header.
+type headerAuth string
+
+func (a headerAuth) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", string(a))
+	return nil
+}
+
+func TestHDFSFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+	t.Setenv("HADOOP_USER_NAME", "")
+
+	content := strings.Repeat("id\tname\n", 1000)
+	var mu sync.Mutex
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		queries = append(queries, r.URL.Path+" "+q.Get("op")+" "+q.Get("user.name")+" "+r.Header.Get("Authorization"))
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/datanode/names.tsv":
+			fmt.Fprint(w, content)
+		case r.URL.Path != "/webhdfs/v1/data/names.tsv" && r.URL.Path != "/webhdfs/v1/data/other.tsv":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"RemoteException":{"exception":"FileNotFoundException"}}`)
+		case q.Get("op") == "OPEN":
+			// the NameNode redirects to a DataNode
+			http.Redirect(w, r, "/datanode/names.tsv", http.StatusTemporaryRedirect)
+		case q.Get("op") == "GETFILESTATUS":
+			fmt.Fprintf(w, `{"FileStatus":{"length":%d,"modificationTime":1609459200000,"type":"FILE"}}`, len(content))
+		default:
+			http.Error(w, "unsupported", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	read := func(resource string) (string, error) {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return "", err
+		}
+		if err = f.Fetch(resource); err != nil {
+			return "", err
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	data, err := read("webhdfs://hadoop@" + host + "/data/names.tsv")
+	if err != nil || data != content {
+		t.Errorf("unexpected hdfs read of %d bytes: %v", len(data), err)
+	}
+	if _, err = read("webhdfs://" + host + "/data/missing.tsv"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	anydata.SetHDFSAuthenticator(headerAuth("Negotiate abc"))
+	defer anydata.SetHDFSAuthenticator(nil)
+	info, err := anydata.Stat("webhdfs://" + host + "/data/other.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) || info.ModTime.Unix() != 1609459200 {
+		t.Errorf("unexpected stat: %+v", info)
+	}
+
+	want := []string{
+		"/webhdfs/v1/data/names.tsv OPEN hadoop ",
+		"/datanode/names.tsv   ",
+		"/webhdfs/v1/data/missing.tsv OPEN  ",
+		"/webhdfs/v1/data/other.tsv GETFILESTATUS  Negotiate abc",
+	}
+	if strings.Join(queries, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected requests:\n%s", strings.Join(queries, "\n"))
+	}
+}
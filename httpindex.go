@@ -0,0 +1,75 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// HTTPIndexMaxSize is the largest directory index page (in bytes) read by ExpandResources.
+var HTTPIndexMaxSize int64 = 16 << 20
+
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"'#]+)["'#]`)
+
+// Expand lists the files matching pattern by reading the "Index of" pages generated by web
+// servers such as Apache and nginx for directories without an index file.
+func (n *httpFetcher) Expand(ctx context.Context, pattern string) ([]string, error) {
+	return expandURL(pattern, func(dir *url.URL) ([]*url.URL, []*url.URL, error) {
+		return n.listIndex(ctx, dir)
+	})
+}
+
+// listIndex reads the index page for dir, and returns the files and subdirectories it links to.
+func (n *httpFetcher) listIndex(ctx context.Context, dir *url.URL) ([]*url.URL, []*url.URL, error) {
+	n.resource, n.url = dir.String(), ""
+	resp, err := n.get(ctx, 0, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, HTTPIndexMaxSize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, nil, fmt.Errorf("'%s' is not a directory index page (%s)", dir, ct)
+	}
+	if resp.Request != nil && resp.Request.URL != nil && strings.HasSuffix(resp.Request.URL.Path, "/") {
+		// e.g. a redirect to another host
+		final := *resp.Request.URL
+		final.User = dir.User
+		dir = &final
+	}
+
+	var files, dirs []*url.URL
+	seen := make(map[string]bool)
+	for _, m := range hrefPattern.FindAllStringSubmatch(string(page), -1) {
+		ref, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil || ref.RawQuery != "" {
+			// skip column sorting links (e.g. "?C=N;O=D")
+			continue
+		}
+		child := dir.ResolveReference(ref)
+		if child.Scheme != dir.Scheme || child.Host != dir.Host ||
+			!strings.HasPrefix(child.Path, dir.Path) {
+			continue
+		}
+		name := strings.TrimPrefix(child.Path, dir.Path)
+		if name == "" || strings.Contains(strings.TrimSuffix(name, "/"), "/") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		child.User = dir.User
+		if strings.HasSuffix(name, "/") {
+			dirs = append(dirs, child)
+		} else {
+			files = append(files, child)
+		}
+	}
+	return files, dirs, nil
+}
@@ -0,0 +1,214 @@
+package anydata
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// A Kafka fetcher, which streams the messages of a topic as newline-separated records:
+//
+//    kafka://broker1:9092,broker2:9092/topic?group=my-app&offset=first
+//
+// The reader is unbounded (it waits for new messages) unless one of these URL parameters is
+// given:
+//
+//    limit=N         stop after N messages
+//    idle=DURATION   stop when no message arrives for DURATION (e.g. "30s")
+//
+// Other parameters are "group" (a consumer group ID, whose offsets are committed as messages
+// are read), "partition" (read a single partition without a group), "offset" ("first" or
+// "last", the default, for where to start without a committed offset) and "tls" ("true" to
+// connect using the TLS options). Usernames and passwords from the CredentialProvider are
+// used for SASL/PLAIN authentication.
+//
+// Messages are not cached. A newline is added to any message which does not end with one, so
+// that each message is one record for line-based formats.
+type kafkaFetcher struct {
+	config kafka.ReaderConfig
+	limit  int64
+	idle   time.Duration
+}
+
+func (n *kafkaFetcher) String() string {
+	return "Kafka"
+}
+
+func (n *kafkaFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "kafka://")
+}
+
+// kafkaConfig parses a kafka:// resource.
+func (n *kafkaFetcher) kafkaConfig(resource string) error {
+	rest := strings.TrimPrefix(strings.SplitN(resource, "#", 2)[0], "kafka://")
+	var params string
+	if i := strings.Index(rest, "?"); i != -1 {
+		rest, params = rest[:i], rest[i+1:]
+	}
+	i := strings.Index(rest, "/")
+	if i < 1 || i == len(rest)-1 {
+		return fmt.Errorf("kafka resource '%s' must be kafka://broker/topic", resource)
+	}
+	hosts, topic := rest[:i], strings.Trim(rest[i+1:], "/")
+	q, err := url.ParseQuery(params)
+	if err != nil {
+		return err
+	}
+
+	var brokers []string
+	for _, h := range strings.Split(hosts, ",") {
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			h = net.JoinHostPort(h, "9092")
+		}
+		brokers = append(brokers, h)
+	}
+
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second}
+	if q.Get("tls") == "true" {
+		if dialer.TLS = currentTLSConfig(); dialer.TLS == nil {
+			dialer.TLS = &tls.Config{}
+		}
+	}
+	host, _, _ := net.SplitHostPort(brokers[0])
+	if creds := lookupCredentials("kafka", host); creds != nil && creds.Username != "" {
+		dialer.SASLMechanism = plain.Mechanism{Username: creds.Username, Password: creds.Password}
+	}
+
+	n.config = kafka.ReaderConfig{
+		Brokers:     brokers,
+		Topic:       topic,
+		GroupID:     q.Get("group"),
+		Dialer:      dialer,
+		StartOffset: kafka.LastOffset,
+		MaxWait:     time.Second,
+	}
+	switch q.Get("offset") {
+	case "", "last":
+	case "first":
+		n.config.StartOffset = kafka.FirstOffset
+	default:
+		return fmt.Errorf("kafka offset must be 'first' or 'last', not '%s'", q.Get("offset"))
+	}
+	if p := q.Get("partition"); p != "" {
+		if n.config.GroupID != "" {
+			return fmt.Errorf("kafka resource '%s' cannot use both a group and a partition", resource)
+		}
+		if n.config.Partition, err = strconv.Atoi(p); err != nil {
+			return fmt.Errorf("invalid kafka partition '%s'", p)
+		}
+	}
+
+	n.limit, n.idle = 0, 0
+	if v := q.Get("limit"); v != "" {
+		if n.limit, err = strconv.ParseInt(v, 10, 64); err != nil || n.limit < 1 {
+			return fmt.Errorf("invalid kafka message limit '%s'", v)
+		}
+	}
+	if v := q.Get("idle"); v != "" {
+		if n.idle, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid kafka idle timeout '%s'", v)
+		}
+	}
+	return n.config.Validate()
+}
+
+func (n *kafkaFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+// FetchContext checks that the topic exists. Messages are read by GetReaderContext.
+func (n *kafkaFetcher) FetchContext(ctx context.Context, resource string) error {
+	if err := n.kafkaConfig(resource); err != nil {
+		return err
+	}
+	var err error
+	for _, b := range n.config.Brokers {
+		var conn *kafka.Conn
+		if conn, err = n.config.Dialer.DialContext(ctx, "tcp", b); err != nil {
+			continue
+		}
+		_, err = conn.ReadPartitions(n.config.Topic)
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to read kafka topic '%s': %s", n.config.Topic, err.Error())
+}
+
+// Stat reports an unknown size, since a topic is an unbounded stream.
+func (n *kafkaFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if err := n.kafkaConfig(resource); err != nil {
+		return ResourceInfo{}, err
+	}
+	return ResourceInfo{Size: -1}, nil
+}
+
+func (n *kafkaFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *kafkaFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.config.Topic == "" {
+		return nil, fmt.Errorf("no kafka topic to read (did you call Fetch?)")
+	}
+	return &kafkaReader{
+		ctx:   ctx,
+		r:     kafka.NewReader(n.config),
+		limit: n.limit,
+		idle:  n.idle,
+	}, nil
+}
+
+// kafkaReader reads messages from a topic, one at a time.
+type kafkaReader struct {
+	ctx   context.Context
+	r     *kafka.Reader
+	buf   bytes.Buffer
+	limit int64
+	idle  time.Duration
+	n     int64
+}
+
+func (r *kafkaReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.limit > 0 && r.n >= r.limit {
+			return 0, io.EOF
+		}
+		ctx, cancel := r.ctx, context.CancelFunc(func() {})
+		if r.idle > 0 {
+			ctx, cancel = context.WithTimeout(r.ctx, r.idle)
+		}
+		m, err := r.r.ReadMessage(ctx)
+		cancel()
+		if err != nil {
+			if r.idle > 0 && r.ctx.Err() == nil && ctx.Err() == context.DeadlineExceeded {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		r.n++
+		metrics.Add(metrics.BytesDownloaded, float64(len(m.Value)), "fetcher", "kafka")
+
+		r.buf.Write(m.Value)
+		if len(m.Value) == 0 || m.Value[len(m.Value)-1] != '\n' {
+			r.buf.WriteByte('\n')
+		}
+	}
+	return r.buf.Read(p)
+}
+
+func (r *kafkaReader) Close() error {
+	return r.r.Close()
+}
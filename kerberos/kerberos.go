@@ -0,0 +1,96 @@
+// Package kerberos provides Kerberos (SPNEGO) authentication for anydata's WebHDFS fetcher.
+// Typical use, with a ticket cache created by kinit:
+//
+//    auth, err := kerberos.FromCCache("", "")
+//    if err != nil {
+//        log.Fatal(err)
+//    }
+//    anydata.SetHDFSAuthenticator(auth)
+//
+package kerberos
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// Authenticator implements anydata.HDFSAuthenticator by adding a SPNEGO Authorization header
+// to each request.
+type Authenticator struct {
+	// SPN is the service principal name of the NameNode. If empty, HTTP/<hostname> is used.
+	SPN string
+
+	cl *client.Client
+}
+
+// New returns an Authenticator using an existing Kerberos client.
+func New(cl *client.Client) *Authenticator {
+	return &Authenticator{cl: cl}
+}
+
+// loadConfig loads the krb5.conf file at path, or from KRB5_CONFIG (or /etc/krb5.conf) if
+// path is empty.
+func loadConfig(path string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		path = "/etc/krb5.conf"
+	}
+	return config.Load(path)
+}
+
+// FromCCache returns an Authenticator using the credentials cache at ccachePath, or from
+// KRB5CCNAME (or /tmp/krb5cc_<uid>) if it is empty. krb5confPath is handled similarly.
+func FromCCache(ccachePath, krb5confPath string) (*Authenticator, error) {
+	cfg, err := loadConfig(krb5confPath)
+	if err != nil {
+		return nil, err
+	}
+	if ccachePath == "" {
+		ccachePath = strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+	}
+	if ccachePath == "" {
+		ccachePath = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	cc, err := credentials.LoadCCache(ccachePath)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := client.NewFromCCache(cc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return New(cl), nil
+}
+
+// FromKeytab returns an Authenticator which logs in as username@realm using the keytab file at
+// keytabPath. krb5confPath is handled as in FromCCache.
+func FromKeytab(username, realm, keytabPath, krb5confPath string) (*Authenticator, error) {
+	cfg, err := loadConfig(krb5confPath)
+	if err != nil {
+		return nil, err
+	}
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, err
+	}
+	cl := client.NewWithKeytab(username, realm, kt, cfg)
+	if err = cl.Login(); err != nil {
+		return nil, err
+	}
+	return New(cl), nil
+}
+
+// Authenticate adds a SPNEGO Authorization header to req.
+func (a *Authenticator) Authenticate(req *http.Request) error {
+	return spnego.SetSPNEGOHeader(a.cl, req, a.SPN)
+}
@@ -0,0 +1,51 @@
+package anydata
+
+import (
+	"log"
+	"sync"
+)
+
+// Logger describes the minimal logging method used by anydata to report non-fatal problems
+// (such as cache write failures or stale cache entries). The standard library's *log.Logger
+// satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger sends output to the standard library's default logger.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// discardLogger drops all output.
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = stdLogger{}
+)
+
+// SetLogger replaces the Logger used by fetchers, wrappers, and the cache. By default, output
+// is sent to the standard library's log package. Passing nil silences all output. It is safe
+// to call while fetches are running.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = discardLogger{}
+	}
+	loggerMu.Lock()
+	logger = l
+	loggerMu.Unlock()
+}
+
+// Logf formats and sends a message to the current Logger. It is exported so that sub-packages
+// and custom Fetchers/Wrappers can route their output through the same destination.
+func Logf(format string, v ...interface{}) {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	l.Printf(format, v...)
+}
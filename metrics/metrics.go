@@ -0,0 +1,95 @@
+// Package metrics provides a minimal instrumentation interface used throughout anydata to report
+// counters and observations (fetch durations, bytes downloaded, cache hits, records parsed and
+// records dropped by filters). By default all measurements are discarded; call SetCollector to
+// route them into a monitoring system. An adapter for Prometheus is available in the
+// metrics/prometheus sub-package.
+//
+// Labels are always provided as alternating name/value pairs, and each metric name is always
+// reported with the same set of label names:
+//
+//    FetchDuration   - seconds spent in Fetch            labels: "fetcher"
+//    BytesDownloaded - bytes retrieved from the network  labels: "fetcher"
+//    CacheHits       - cached files used                 (no labels)
+//    CacheMisses     - cache lookups that failed         (no labels)
+//    CacheEvictions  - cached files removed              (no labels)
+//    CacheServed     - bytes read from cached files      (no labels)
+//    RecordsParsed   - records returned by a DataFormat  labels: "format"
+//    RecordsDropped  - records removed by a Filter       labels: "filter"
+//    RecordsWritten  - records written by a DataSink     labels: "sink"
+//    RecordsSkipped  - malformed records skipped         labels: "format"
+//    RecordsRagged   - records without the fixed fields  labels: "format"
+//
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Names of the metrics reported by anydata packages.
+const (
+	FetchDuration   = "anydata_fetch_duration_seconds"
+	BytesDownloaded = "anydata_downloaded_bytes_total"
+	CacheHits       = "anydata_cache_hits_total"
+	CacheMisses     = "anydata_cache_misses_total"
+	CacheEvictions  = "anydata_cache_evictions_total"
+	CacheServed     = "anydata_cache_served_bytes_total"
+	RecordsParsed   = "anydata_records_parsed_total"
+	RecordsDropped  = "anydata_records_dropped_total"
+	RecordsWritten  = "anydata_records_written_total"
+	RecordsSkipped  = "anydata_records_skipped_total"
+	RecordsRagged   = "anydata_records_ragged_total"
+)
+
+// Collector receives measurements. Implementations must be safe for concurrent use.
+type Collector interface {
+	// Add increments the named counter by delta.
+	Add(name string, delta float64, labels ...string)
+
+	// Observe records a single sample for the named histogram.
+	Observe(name string, value float64, labels ...string)
+}
+
+type discard struct{}
+
+func (discard) Add(name string, delta float64, labels ...string)     {}
+func (discard) Observe(name string, value float64, labels ...string) {}
+
+var (
+	mu        sync.RWMutex
+	collector Collector = discard{}
+)
+
+// SetCollector replaces the Collector that receives all anydata measurements. Passing nil
+// restores the default (which discards everything).
+func SetCollector(c Collector) {
+	if c == nil {
+		c = discard{}
+	}
+	mu.Lock()
+	collector = c
+	mu.Unlock()
+}
+
+func current() Collector {
+	mu.RLock()
+	c := collector
+	mu.RUnlock()
+	return c
+}
+
+// Add increments the named counter on the current Collector.
+func Add(name string, delta float64, labels ...string) {
+	current().Add(name, delta, labels...)
+}
+
+// Observe records a sample for the named histogram on the current Collector.
+func Observe(name string, value float64, labels ...string) {
+	current().Observe(name, value, labels...)
+}
+
+// Since observes the number of seconds elapsed since start. It is intended to be deferred:
+//    defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "http")
+func Since(name string, start time.Time, labels ...string) {
+	current().Observe(name, time.Since(start).Seconds(), labels...)
+}
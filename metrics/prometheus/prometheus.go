@@ -0,0 +1,77 @@
+// Package prometheus adapts anydata's metrics to the Prometheus client library. Typical use:
+//
+//    metrics.SetCollector(prometheus.NewCollector(prom.DefaultRegisterer))
+//
+package prometheus
+
+import (
+	"strings"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements metrics.Collector by lazily creating Prometheus counter and histogram
+// vectors the first time each metric name is seen.
+type Collector struct {
+	reg prom.Registerer
+
+	mu         sync.Mutex
+	counters   map[string]*prom.CounterVec
+	histograms map[string]*prom.HistogramVec
+}
+
+// NewCollector returns a Collector that registers new metrics with reg.
+func NewCollector(reg prom.Registerer) *Collector {
+	return &Collector{
+		reg:        reg,
+		counters:   make(map[string]*prom.CounterVec),
+		histograms: make(map[string]*prom.HistogramVec),
+	}
+}
+
+// splitLabels converts alternating name/value pairs into names and values.
+func splitLabels(labels []string) ([]string, []string) {
+	var names, values []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+	return names, values
+}
+
+func helpText(name string) string {
+	return "anydata " + strings.Replace(strings.TrimPrefix(name, "anydata_"), "_", " ", -1)
+}
+
+// Add implements metrics.Collector.
+func (c *Collector) Add(name string, delta float64, labels ...string) {
+	names, values := splitLabels(labels)
+
+	c.mu.Lock()
+	cv, found := c.counters[name]
+	if !found {
+		cv = prom.NewCounterVec(prom.CounterOpts{Name: name, Help: helpText(name)}, names)
+		c.reg.MustRegister(cv)
+		c.counters[name] = cv
+	}
+	c.mu.Unlock()
+
+	cv.WithLabelValues(values...).Add(delta)
+}
+
+// Observe implements metrics.Collector.
+func (c *Collector) Observe(name string, value float64, labels ...string) {
+	names, values := splitLabels(labels)
+
+	c.mu.Lock()
+	hv, found := c.histograms[name]
+	if !found {
+		hv = prom.NewHistogramVec(prom.HistogramOpts{Name: name, Help: helpText(name)}, names)
+		c.reg.MustRegister(hv)
+		c.histograms[name] = hv
+	}
+	c.mu.Unlock()
+
+	hv.WithLabelValues(values...).Observe(value)
+}
@@ -0,0 +1,211 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PreferFastestMirror orders the mirrors of a resource by how quickly they accept a connection,
+// instead of trying them in the order they were registered.
+var PreferFastestMirror = false
+
+type mirrorSet struct {
+	prefix  string
+	mirrors []string
+}
+
+var (
+	mirrorMu   sync.RWMutex
+	mirrorSets []mirrorSet
+)
+
+// RegisterMirrors declares that resources beginning with prefix can also be fetched by replacing
+// prefix with any of mirrors. For example:
+//
+//    anydata.RegisterMirrors("ftp://ftp.ncbi.nih.gov/",
+//        "https://ftp.ncbi.nlm.nih.gov/",
+//        "ftp://ftp.ebi.ac.uk/pub/databases/ncbi/")
+//
+// Fetchers returned by GetFetcher try the resource as given first, then the remaining mirrors
+// in order (see PreferFastestMirror), until one of them succeeds. The cache is keyed by the
+// canonical (prefix) form of the resource, so a file is only downloaded once regardless of
+// which mirror it came from.
+func RegisterMirrors(prefix string, mirrors ...string) {
+	mirrorMu.Lock()
+	mirrorSets = append(mirrorSets, mirrorSet{prefix: prefix, mirrors: mirrors})
+	mirrorMu.Unlock()
+}
+
+// findMirrors returns the mirror set matching resource, and the part of resource which follows
+// the matching prefix (or mirror).
+func findMirrors(resource string) (*mirrorSet, string) {
+	mirrorMu.RLock()
+	defer mirrorMu.RUnlock()
+	for i := range mirrorSets {
+		ms := &mirrorSets[i]
+		if strings.HasPrefix(resource, ms.prefix) {
+			return ms, resource[len(ms.prefix):]
+		}
+		for _, m := range ms.mirrors {
+			if strings.HasPrefix(resource, m) {
+				return ms, resource[len(m):]
+			}
+		}
+	}
+	return nil, ""
+}
+
+// canonicalResource returns the resource on its canonical (registered prefix) host.
+func canonicalResource(resource string) string {
+	if ms, rest := findMirrors(resource); ms != nil {
+		return ms.prefix + rest
+	}
+	return resource
+}
+
+// mirrorCandidates returns every location of resource in the order they should be tried, or
+// nil if it has no mirrors.
+func mirrorCandidates(resource string) []string {
+	ms, rest := findMirrors(resource)
+	if ms == nil {
+		return nil
+	}
+	candidates := []string{resource}
+	for _, m := range append([]string{ms.prefix}, ms.mirrors...) {
+		if m+rest != resource {
+			candidates = append(candidates, m+rest)
+		}
+	}
+
+	if PreferFastestMirror {
+		latency := make([]time.Duration, len(candidates))
+		wg := &sync.WaitGroup{}
+		for i, c := range candidates {
+			wg.Add(1)
+			go func(i int, c string) {
+				latency[i] = connectLatency(c)
+				wg.Done()
+			}(i, c)
+		}
+		wg.Wait()
+		order := make(map[string]time.Duration, len(candidates))
+		for i, c := range candidates {
+			order[c] = latency[i]
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return order[candidates[i]] < order[candidates[j]]
+		})
+	}
+	return candidates
+}
+
+var defaultPorts = map[string]string{
+	"http": "80", "https": "443", "ftp": "21", "ftps": "990", "sftp": "22", "scp": "22", "rsync": "873",
+}
+
+// connectLatency returns the time taken to open a TCP connection to resource's host, or a very
+// long duration if it cannot be reached.
+func connectLatency(resource string) time.Duration {
+	const unreachable = time.Hour
+	furl, err := url.Parse(resource)
+	if err != nil || furl.Hostname() == "" {
+		return unreachable
+	}
+	port := furl.Port()
+	if port == "" {
+		if port = defaultPorts[furl.Scheme]; port == "" {
+			return unreachable
+		}
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(furl.Hostname(), port), 5*time.Second)
+	if err != nil {
+		return unreachable
+	}
+	conn.Close()
+	return time.Since(start)
+}
+
+///////////////////
+
+// A mirror fetcher, which tries each location of a resource until one can be fetched. It is
+// returned by GetFetcher for resources with mirrors (see RegisterMirrors).
+type mirrorFetcher struct {
+	registry   *Registry
+	candidates []string
+	httpOpts   *HTTPOptions
+
+	// the fetcher for the mirror which succeeded
+	f Fetcher
+}
+
+func (n *mirrorFetcher) String() string {
+	if n.f != nil {
+		return fmt.Sprintf("%s", n.f)
+	}
+	return fmt.Sprintf("%s (with %d mirrors)", n.candidates[0], len(n.candidates)-1)
+}
+
+func (n *mirrorFetcher) Detect(resource string) bool {
+	return false
+}
+
+// Unwrap returns the Fetcher for the mirror which was fetched successfully.
+func (n *mirrorFetcher) Unwrap() Fetcher {
+	return n.f
+}
+
+// SetHTTPOptions sets the options used by any HTTP-based mirrors.
+func (n *mirrorFetcher) SetHTTPOptions(opts *HTTPOptions) {
+	n.httpOpts = opts
+}
+
+func (n *mirrorFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	return n.registry.StatContext(ctx, resource)
+}
+
+func (n *mirrorFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *mirrorFetcher) FetchContext(ctx context.Context, resource string) error {
+	var err error
+	for _, c := range n.candidates {
+		var f Fetcher
+		f, err = n.registry.getFetcher(c)
+		if err == nil && n.httpOpts != nil {
+			SetHTTPOptions(f, n.httpOpts)
+		}
+		if err == nil {
+			err = FetchContext(ctx, f, c)
+		}
+		if err == nil {
+			n.f = f
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		Logf("unable to fetch from mirror '%s': %s\n", c, err.Error())
+	}
+	return fmt.Errorf("all mirrors of '%s' failed, last error: %s", resource, err.Error())
+}
+
+func (n *mirrorFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *mirrorFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.f == nil {
+		return nil, fmt.Errorf("reading from mirrors failed (did you call Fetch?)")
+	}
+	return GetReaderContext(ctx, n.f)
+}
@@ -0,0 +1,190 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+var (
+	partsMu  sync.RWMutex
+	partSets = make(map[string][]string)
+)
+
+// RegisterParts declares that resource is split into several files, which are concatenated in
+// the order given to read it. Each part may be a resource string or a pattern to expand (see
+// ExpandResources), so split files can be registered by listing them or with a single glob:
+//
+//    anydata.RegisterParts("ftp://ftp.example.org/dumps/uniref.tar.gz",
+//        "ftp://ftp.example.org/dumps/uniref.tar.gz.a*")
+//    anydata.RegisterParts("/data/reads.fastq",
+//        "/data/reads.part1.fastq.gz", "/data/reads.part2.fastq.gz")
+//
+// Wrappers are applied to each part according to its own name (but not by DetectCompression),
+// and to the concatenation according to the name of resource. So in the first example the joined parts are decompressed
+// and members may be extracted with "uniref.tar.gz#member", while in the second each part is
+// decompressed separately. Parts are fetched (and cached) individually.
+func RegisterParts(resource string, parts ...string) {
+	partsMu.Lock()
+	partSets[resource] = parts
+	partsMu.Unlock()
+}
+
+// findParts returns the parts registered for resource (ignoring any fragment), or nil if it
+// has none.
+func findParts(resource string) []string {
+	resource = strings.SplitN(resource, "#", 2)[0]
+	partsMu.RLock()
+	defer partsMu.RUnlock()
+	return partSets[resource]
+}
+
+///////////////////
+
+// A multi-part fetcher, which reads the concatenation of the parts of a resource. It is used by
+// GetFetcher for resources registered with RegisterParts.
+type partsFetcher struct {
+	registry *Registry
+	parts    []string
+
+	// the fetchers for each expanded part, in order
+	fetchers []Fetcher
+}
+
+func (n *partsFetcher) String() string {
+	return fmt.Sprintf("Multi-part (%d parts)", len(n.parts))
+}
+
+func (n *partsFetcher) Detect(resource string) bool {
+	return false
+}
+
+// expand returns every part resource, in order.
+func (n *partsFetcher) expand(ctx context.Context, resource string) ([]string, error) {
+	var parts []string
+	for _, p := range n.parts {
+		if !hasGlob(p) {
+			parts = append(parts, p)
+			continue
+		}
+		matches, err := n.registry.ExpandResourcesContext(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, matches...)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no parts of '%s' were found", resource)
+	}
+	return parts, nil
+}
+
+func (n *partsFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *partsFetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "parts")
+
+	parts, err := n.expand(ctx, resource)
+	if err != nil {
+		return err
+	}
+	fetchers := make([]Fetcher, len(parts))
+	for i, p := range parts {
+		if fetchers[i], err = n.registry.GetFetcher(p); err != nil {
+			return err
+		}
+		if sw, ok := fetchers[i].(*sniffWrapper); ok {
+			// only the first part of a split file would have a magic number
+			fetchers[i] = sw.wrapped
+		}
+		if err = FetchContext(ctx, fetchers[i], p); err != nil {
+			return fmt.Errorf("fetching part '%s' of '%s' failed: %s", p, resource, err.Error())
+		}
+	}
+	n.fetchers = fetchers
+	return nil
+}
+
+// Stat returns the total size of the parts, or -1 if any of their sizes are unknown.
+func (n *partsFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	parts, err := n.expand(ctx, resource)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	var info ResourceInfo
+	for _, p := range parts {
+		pi, err := n.registry.StatContext(ctx, p)
+		if err != nil {
+			return ResourceInfo{}, err
+		}
+		if pi.Size < 0 || info.Size < 0 {
+			info.Size = -1
+		} else {
+			info.Size += pi.Size
+		}
+		if pi.ModTime.After(info.ModTime) {
+			info.ModTime = pi.ModTime
+		}
+	}
+	return info, nil
+}
+
+func (n *partsFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *partsFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.fetchers == nil {
+		return nil, fmt.Errorf("no parts to read (did you call Fetch?)")
+	}
+	return &partsReader{ctx: ctx, fetchers: n.fetchers}, nil
+}
+
+// partsReader reads each part in turn, only opening a part once the previous one is done.
+type partsReader struct {
+	ctx      context.Context
+	fetchers []Fetcher
+	cur      io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.fetchers) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := GetReaderContext(pr.ctx, pr.fetchers[0])
+			if err != nil {
+				return 0, err
+			}
+			pr.cur, pr.fetchers = rc, pr.fetchers[1:]
+		}
+		n, err := pr.cur.Read(p)
+		if err == io.EOF {
+			pr.cur.Close()
+			pr.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	pr.fetchers = nil
+	if pr.cur != nil {
+		err := pr.cur.Close()
+		pr.cur = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a gzipped tarball split in two
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "names.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("hello\n"))
+	tw.Close()
+	gw.Close()
+	data := buf.Bytes()
+	ioutil.WriteFile(filepath.Join(dir, "dump.tar.gz.aa"), data[:len(data)/2], 0666)
+	ioutil.WriteFile(filepath.Join(dir, "dump.tar.gz.ab"), data[len(data)/2:], 0666)
+	dump := filepath.Join(dir, "dump.tar.gz")
+	anydata.RegisterParts(dump, filepath.Join(dir, "dump.tar.gz.a*"))
+
+	// separately gzipped parts
+	var parts []string
+	for i, line := range []string{"first\n", "second\n"} {
+		name := filepath.Join(dir, fmt.Sprintf("reads.part%d.gz", i+1))
+		f, _ := os.Create(name)
+		gw = gzip.NewWriter(f)
+		gw.Write([]byte(line))
+		gw.Close()
+		f.Close()
+		parts = append(parts, name)
+	}
+	reads := filepath.Join(dir, "reads.txt")
+	anydata.RegisterParts(reads, parts...)
+
+	for resource, want := range map[string]string{
+		dump + "#names.txt": "hello\n",
+		reads:               "first\nsecond\n",
+	} {
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fr.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v)", resource, data, err)
+		}
+	}
+}
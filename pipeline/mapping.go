@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+// FieldMapping maps a single source field into the target schema.
+type FieldMapping struct {
+	// Source is the source field key (integers are converted as for filter fields).
+	Source string `json:"source"`
+
+	// Target is the output field name. If empty, the Source name is used.
+	Target string `json:"target,omitempty"`
+
+	// Type is one of "string" (the default), "int", "float", "bool" or "date" (see
+	// formats.FieldType). Values are validated and normalized into a canonical string
+	// representation for the type.
+	Type string `json:"type,omitempty"`
+
+	// Required fields must be present and non-empty (after applying Default).
+	Required bool `json:"required,omitempty"`
+
+	// Default is used when the source field is missing or empty.
+	Default string `json:"default,omitempty"`
+}
+
+// Mapping reshapes filtered records into a declared target schema. Fields that are not listed
+// are dropped from the output.
+type Mapping []FieldMapping
+
+// MappingError describes a record which could not be mapped into the target schema.
+type MappingError struct {
+	Field string
+	Value string
+	Err   string
+}
+
+func (e *MappingError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("field '%s': %s", e.Field, e.Err)
+	}
+	return fmt.Sprintf("field '%s': %s (value '%s')", e.Field, e.Err, e.Value)
+}
+
+// Check verifies that the mapping itself is well-formed.
+func (m Mapping) Check() error {
+	seen := make(map[string]bool)
+	for _, fm := range m {
+		if fm.Source == "" {
+			return fmt.Errorf("mapping has an empty source field")
+		}
+		target := fm.Target
+		if target == "" {
+			target = fm.Source
+		}
+		if seen[target] {
+			return fmt.Errorf("mapping target '%s' is used more than once", target)
+		}
+		seen[target] = true
+
+		typ, err := formats.ParseFieldType(fm.Type)
+		if err != nil {
+			return &MappingError{Field: target, Err: err.Error()}
+		}
+		if fm.Default != "" {
+			if _, err := typ.Normalize(fm.Default); err != nil {
+				return &MappingError{Field: target, Value: fm.Default, Err: "invalid default, " + err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// Apply maps rec into the target schema.
+func (m Mapping) Apply(rec map[interface{}]string) (map[interface{}]string, error) {
+	ret := make(map[interface{}]string, len(m))
+	for _, fm := range m {
+		target := fm.Target
+		if target == "" {
+			target = fm.Source
+		}
+
+		v := rec[fieldKey(fm.Source)]
+		if v == "" {
+			v = fm.Default
+		}
+		if v == "" {
+			if fm.Required {
+				return nil, &MappingError{Field: target, Err: "required field is missing"}
+			}
+			ret[target] = ""
+			continue
+		}
+
+		nv, err := formats.FieldType(fm.Type).Normalize(v)
+		if err != nil {
+			return nil, &MappingError{Field: target, Value: v, Err: err.Error()}
+		}
+		ret[target] = nv
+	}
+	return ret, nil
+}
@@ -0,0 +1,310 @@
+// Package pipeline ties the anydata fetchers, formats and filters together into a single
+// declarative unit. A Spec names a resource string, a DataFormat specification and an ordered
+// list of filters, and can be stored as JSON alongside other configuration:
+//
+//    {
+//      "resource": "ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz#names.dmp",
+//      "format":   {"type": "simple-delimited", "fields": "\t|\t", "records": "\t|\n"},
+//      "filters":  [{"type": "require", "fields": {"3": "scientific name"}}],
+//      "mapping":  [{"source": "0", "target": "tax_id", "type": "int", "required": true},
+//                   {"source": "1", "target": "name"}],
+//      "output":   {"type": "csv", "header": "true", "columns": "tax_id,name"}
+//    }
+//
+// Filter field keys that look like integers are converted to int, so that they match the
+// positional field indexes produced by the delimited formats.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pbnjay/anydata"
+	"github.com/pbnjay/anydata/filters"
+	"github.com/pbnjay/anydata/formats"
+)
+
+// Spec describes a complete fetch, parse and filter chain.
+type Spec struct {
+	Resource string            `json:"resource"`
+	Format   map[string]string `json:"format"`
+	Filters  []FilterSpec      `json:"filters,omitempty"`
+
+	// Mapping optionally reshapes filtered records into a target schema.
+	Mapping Mapping `json:"mapping,omitempty"`
+
+	// Unique optionally removes duplicate records (after any mapping is applied).
+	Unique *UniqueSpec `json:"unique,omitempty"`
+
+	// ErrorBudget optionally allows bad records to be skipped instead of stopping the run.
+	ErrorBudget *ErrorBudget `json:"error_budget,omitempty"`
+
+	// Output optionally describes a DataSink used by Export to write the filtered records.
+	Output map[string]string `json:"output,omitempty"`
+
+	// Provenance adds the reserved fields "_resource", "_member", "_record", "_line" and
+	// "_offset" to each filtered record, describing where it was read from.
+	Provenance bool `json:"provenance,omitempty"`
+}
+
+// FilterSpec describes a single named filter and the fields used to set it up.
+type FilterSpec struct {
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields"`
+}
+
+// Pipeline is a resolved Spec, ready to Run.
+type Pipeline struct {
+	Spec Spec
+
+	// Quarantine receives bad records skipped under the Spec's ErrorBudget.
+	Quarantine QuarantineSink
+
+	nrecords int
+	nerrors  int
+	lastErr  error
+
+	fetcher anydata.Fetcher
+	reader  io.Reader
+	format  formats.DataFormat
+	filters filters.FilterSet
+	unique  *UniqueRecords
+	sink    formats.DataSink
+}
+
+// fieldKey converts a spec field name into a record key (int if possible, string otherwise).
+func fieldKey(name string) interface{} {
+	if i, err := strconv.Atoi(name); err == nil {
+		return i
+	}
+	return name
+}
+
+// filterFields converts spec field names into record keys.
+func filterFields(fields map[string]string) map[interface{}]string {
+	ret := make(map[interface{}]string, len(fields))
+	for k, v := range fields {
+		ret[fieldKey(k)] = v
+	}
+	return ret
+}
+
+// Registries selects the registries used to resolve a Spec. Nil fields (or a nil *Registries)
+// use the package-level default registries.
+type Registries struct {
+	Fetchers *anydata.Registry
+	Formats  *formats.Registry
+	Filters  *filters.Registry
+}
+
+func (r *Registries) fetchers() *anydata.Registry {
+	if r == nil || r.Fetchers == nil {
+		return anydata.DefaultRegistry
+	}
+	return r.Fetchers
+}
+
+func (r *Registries) formats() *formats.Registry {
+	if r == nil || r.Formats == nil {
+		return formats.DefaultRegistry
+	}
+	return r.Formats
+}
+
+func (r *Registries) filters() *filters.Registry {
+	if r == nil || r.Filters == nil {
+		return filters.DefaultRegistry
+	}
+	return r.Filters
+}
+
+// New resolves the fetcher, format and filters described by spec using the default registries.
+func New(spec Spec) (*Pipeline, error) {
+	return (*Registries)(nil).New(spec)
+}
+
+// New resolves the fetcher, format and filters described by spec.
+func (r *Registries) New(spec Spec) (*Pipeline, error) {
+	var err error
+	p := &Pipeline{Spec: spec}
+	p.filters.Registry = r.filters()
+
+	p.fetcher, err = r.fetchers().GetFetcher(spec.Resource)
+	if err != nil {
+		return nil, err
+	}
+	p.format, err = r.formats().GetDataFormat(spec.Format)
+	if err != nil {
+		return nil, err
+	}
+	for _, fs := range spec.Filters {
+		err = p.filters.Append(fs.Type, filterFields(fs.Fields))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = spec.Mapping.Check(); err != nil {
+		return nil, err
+	}
+	if spec.Unique != nil {
+		p.unique = NewUniqueRecords(*spec.Unique)
+	}
+	if spec.Output != nil {
+		p.sink, err = r.formats().GetDataSink(spec.Output)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Open fetches the resource and prepares the format to read records from it.
+func (p *Pipeline) Open() error {
+	return p.OpenContext(context.Background())
+}
+
+// OpenContext is like Open, but the download (and all later reads) are canceled when ctx is done.
+func (p *Pipeline) OpenContext(ctx context.Context) error {
+	err := anydata.FetchContext(ctx, p.fetcher, p.Spec.Resource)
+	if err != nil {
+		return err
+	}
+	p.reader, err = anydata.GetReaderContext(ctx, p.fetcher)
+	if err != nil {
+		return err
+	}
+	return p.format.Open(p.reader)
+}
+
+// Close releases the resources held by the pipeline.
+func (p *Pipeline) Close() error {
+	if p.unique != nil {
+		p.unique.Close()
+	}
+	if c, ok := p.reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Next returns the next set of filtered records. A single source record may produce zero or
+// more filtered records, so callers should loop until io.EOF is returned. Open must be called
+// first.
+func (p *Pipeline) Next() ([]map[interface{}]string, error) {
+	for {
+		raw, fields, err := p.nextRaw()
+		if err == io.EOF {
+			if err = p.checkBudget(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			// read errors are not bad records, so the ErrorBudget does not cover them
+			return nil, err
+		}
+		p.nrecords++
+		if p.Spec.ErrorBudget != nil {
+			fields, err = p.format.GetFields(raw)
+			if err != nil {
+				if err = p.quarantine(raw, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		recs, err := p.applyFilters(fields)
+		if err != nil {
+			if err = p.quarantine(raw, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(p.Spec.Mapping) > 0 {
+			mapped := recs[:0]
+			for _, rec := range recs {
+				mrec, err := p.Spec.Mapping.Apply(rec)
+				if err != nil {
+					if err = p.quarantine(raw, err); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				mapped = append(mapped, mrec)
+			}
+			recs = mapped
+		}
+		if p.unique != nil && len(recs) > 0 {
+			recs, err = p.unique.Filter(recs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(recs) > 0 {
+			if p.Spec.Provenance {
+				p.addProvenance(recs)
+			}
+			return recs, nil
+		}
+	}
+}
+
+// nextRaw reads the next record. When an ErrorBudget is in use, only the raw record is read, so
+// that it can be quarantined if GetFields fails.
+func (p *Pipeline) nextRaw() (string, map[interface{}]string, error) {
+	if p.Spec.ErrorBudget == nil {
+		fields, err := p.format.NextRecordFields()
+		return "", fields, err
+	}
+	raw, err := p.format.NextRecord()
+	return raw, nil, err
+}
+
+// Run opens the pipeline and calls emit for every filtered record until the input is
+// exhausted or emit returns an error.
+func (p *Pipeline) Run(emit func(map[interface{}]string) error) error {
+	err := p.Open()
+	defer p.Close()
+	if err != nil {
+		return err
+	}
+	for {
+		recs, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err = emit(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Export runs the pipeline, writing every filtered record to w using the DataSink described by
+// the Spec's Output.
+func (p *Pipeline) Export(w io.Writer) error {
+	if p.sink == nil {
+		return fmt.Errorf("pipeline has no output")
+	}
+	if err := p.sink.Open(w); err != nil {
+		return err
+	}
+	err := p.Run(p.sink.WriteRecord)
+	if cerr := p.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// String describes the pipeline's resolved source.
+func (p *Pipeline) String() string {
+	return fmt.Sprintf("%s as %s", p.fetcher, p.Spec.Format["type"])
+}
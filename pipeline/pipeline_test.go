@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func writeTemp(t *testing.T, name, data string) string {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	fn := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(fn, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestValidate(t *testing.T) {
+	fn := writeTemp(t, "genes.tsv", "1\tBRCA1\thuman\n2\tTP53\tmouse\n3\tEGFR\thuman\n4\tMYC\thuman\n")
+
+	spec := Spec{
+		Resource: fn,
+		Format:   map[string]string{"type": "tab-delimited"},
+		Filters:  []FilterSpec{{Type: "require", Fields: map[string]string{"2": "human"}}},
+	}
+	recs, err := Validate(spec, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0][1] != "BRCA1" || recs[1][1] != "EGFR" {
+		t.Errorf("unexpected sample: %v", recs)
+	}
+
+	spec.Format["type"] = "no-such-format"
+	spec.Filters = append(spec.Filters, FilterSpec{Type: "no-such-filter"})
+	_, err = Validate(spec, 0)
+	if verr, ok := err.(ValidationError); !ok || len(verr) != 2 {
+		t.Errorf("expected 2 validation problems, got %v", err)
+	}
+
+	spec.Format["type"] = "tab-delimited"
+	spec.Filters = spec.Filters[:1]
+	for _, output := range []map[string]string{{"type": "no-such-sink"}, {"type": "sqlite"}} {
+		spec.Output = output
+		_, err = Validate(spec, 0)
+		if verr, ok := err.(ValidationError); !ok || len(verr) != 1 || !strings.HasPrefix(verr[0], "output: ") {
+			t.Errorf("expected an output problem for %v, got %v", output, err)
+		}
+	}
+}
+
+func TestMapping(t *testing.T) {
+	m := Mapping{
+		{Source: "0", Target: "id", Type: "int", Required: true},
+		{Source: "1", Target: "score", Type: "float", Default: "0"},
+	}
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := m.Apply(map[interface{}]string{0: "007", 1: "", 2: "dropped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec) != 2 || rec["id"] != "7" || rec["score"] != "0" {
+		t.Errorf("unexpected mapped record: %v", rec)
+	}
+
+	_, err = m.Apply(map[interface{}]string{0: "x"})
+	if merr, ok := err.(*MappingError); !ok || merr.Field != "id" {
+		t.Errorf("expected a MappingError for 'id', got %v", err)
+	}
+}
+
+func TestUniqueRecordsSpill(t *testing.T) {
+	u := NewUniqueRecords(UniqueSpec{Fields: []string{"0"}, MaxKeys: 10})
+	defer u.Close()
+
+	nunique := 0
+	for i := 0; i < 500; i++ {
+		// every key appears twice
+		dupe, err := u.Seen(map[interface{}]string{0: fmt.Sprint(i % 250), 1: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dupe {
+			nunique++
+		}
+	}
+	if nunique != 250 {
+		t.Errorf("expected 250 unique records, got %d", nunique)
+	}
+	if len(u.runs) == 0 || len(u.runs) > maxSpillRuns {
+		t.Errorf("unexpected number of spilled runs: %d", len(u.runs))
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	fn := writeTemp(t, "scores.csv", "1,0.5\n2,oops\n3,0.25\n4,bad\n")
+
+	spec := Spec{
+		Resource:    fn,
+		Format:      map[string]string{"type": "csv"},
+		Mapping:     Mapping{{Source: "0", Target: "id"}, {Source: "1", Target: "score", Type: "float"}},
+		ErrorBudget: &ErrorBudget{MaxErrors: 2},
+	}
+	p, err := New(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	p.Quarantine = NewJSONQuarantine(buf)
+
+	n := 0
+	err = p.Run(func(rec map[interface{}]string) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || strings.Count(buf.String(), "\n") != 2 {
+		t.Errorf("expected 2 good and 2 quarantined records, got %d and:\n%s", n, buf.String())
+	}
+
+	if !strings.Contains(buf.String(), `"record_num":4,"line":4,"offset":20`) {
+		t.Errorf("expected the position of the last bad record in:\n%s", buf.String())
+	}
+
+	spec.ErrorBudget.MaxErrors = 1
+	p, _ = New(spec)
+	err = p.Run(func(rec map[interface{}]string) error { return nil })
+	if _, ok := err.(*BudgetError); !ok {
+		t.Errorf("expected BudgetError, got %v", err)
+	}
+}
+
+func TestErrorBudgetReadError(t *testing.T) {
+	spec := Spec{
+		Resource:    writeTemp(t, "scores.csv", ""),
+		Format:      map[string]string{"type": "csv"},
+		ErrorBudget: &ErrorBudget{MaxPercent: 50},
+	}
+	p, err := New(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	p.Quarantine = NewJSONQuarantine(buf)
+
+	errRead := errors.New("read failed")
+	p.format.Open(io.MultiReader(strings.NewReader("1,0.5\n2,0.25\n"), iotest.ErrReader(errRead)))
+	n := 0
+	for i := 0; i < 10; i++ {
+		recs, err := p.Next()
+		if err != nil {
+			if err != errRead {
+				t.Errorf("expected the read error, got %v", err)
+			}
+			break
+		}
+		n += len(recs)
+	}
+	if n != 2 || buf.Len() != 0 {
+		t.Errorf("expected 2 records and no quarantined records, got %d and:\n%s", n, buf.String())
+	}
+}
+
+func TestExport(t *testing.T) {
+	fn := writeTemp(t, "genes.tsv", "gene_id\tsymbol\nENSG01\tTP53\nENSG02\tBRCA1, \"2\"\n")
+
+	spec := Spec{
+		Resource: fn,
+		Format:   map[string]string{"type": "tab-delimited", "header": "true"},
+		Output:   map[string]string{"type": "csv", "header": "true", "columns": "symbol,gene_id"},
+	}
+	p, err := New(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err = p.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "symbol,gene_id\nTP53,ENSG01\n\"BRCA1, \"\"2\"\"\",ENSG02\n" {
+		t.Errorf("unexpected csv output:\n%s", buf.String())
+	}
+
+	spec.Output = map[string]string{"type": "jsonl"}
+	p, _ = New(spec)
+	buf.Reset()
+	if err = p.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"gene_id":"ENSG01","symbol":"TP53"}`+"\n") || strings.Count(buf.String(), "\n") != 2 {
+		t.Errorf("unexpected jsonl output:\n%s", buf.String())
+	}
+
+	spec.Output = map[string]string{"type": "sqlite"}
+	if _, err = New(spec); err == nil {
+		t.Error("expected an error for a sqlite output without a database")
+	}
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pbnjay/anydata/formats"
+)
+
+// Reserved field names for the provenance of each record, which are added to filtered records
+// when the Spec's Provenance option is set.
+const (
+	ResourceField = "_resource"
+	MemberField   = "_member"
+	RecordField   = "_record"
+	LineField     = "_line"
+	OffsetField   = "_offset"
+)
+
+// Provenance describes where a record was read from.
+type Provenance struct {
+	// Resource is the resource string the record was read from.
+	Resource string `json:"resource"`
+
+	// Member is the archive member (or other part) selected by the resource's "#" fragment.
+	Member string `json:"member,omitempty"`
+
+	// RecordNum is the 1-based index of the record within the resource.
+	RecordNum int `json:"record_num"`
+
+	// Line and Offset give the 1-based line number and 0-based byte offset where the record
+	// begins, for DataFormats which track them (see formats.Positioner). Line is 0 otherwise.
+	Line   int   `json:"line,omitempty"`
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// resourceMember returns the fragment of a resource string, as used to select archive members.
+func resourceMember(resource string) string {
+	if u, err := url.Parse(resource); err == nil {
+		return u.Fragment
+	}
+	if i := strings.Index(resource, "#"); i != -1 {
+		return resource[i+1:]
+	}
+	return ""
+}
+
+// Provenance returns the origin of the most recent source record read by Next.
+func (p *Pipeline) Provenance() Provenance {
+	prov := Provenance{
+		Resource:  p.Spec.Resource,
+		Member:    resourceMember(p.Spec.Resource),
+		RecordNum: p.nrecords,
+	}
+	if pos, ok := p.format.(formats.Positioner); ok {
+		prov.Line, prov.Offset = pos.Position().Line, pos.Position().Offset
+	}
+	return prov
+}
+
+// addProvenance adds the reserved provenance fields to recs.
+func (p *Pipeline) addProvenance(recs []map[interface{}]string) {
+	prov := p.Provenance()
+	for _, rec := range recs {
+		rec[ResourceField] = prov.Resource
+		rec[MemberField] = prov.Member
+		rec[RecordField] = strconv.Itoa(prov.RecordNum)
+		if prov.Line > 0 {
+			rec[LineField] = strconv.Itoa(prov.Line)
+			rec[OffsetField] = strconv.FormatInt(prov.Offset, 10)
+		}
+	}
+}
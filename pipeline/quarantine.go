@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrorBudget allows a pipeline to tolerate a limited number of bad records. Records which fail
+// to parse, filter or map are sent to the pipeline's Quarantine sink (if any) and skipped. The
+// run fails only once the budget is exceeded. A zero value for either limit disables it. Errors
+// reading the input are not bad records, and always stop the run.
+type ErrorBudget struct {
+	// MaxErrors is the maximum number of bad records allowed.
+	MaxErrors int `json:"max_errors,omitempty"`
+
+	// MaxPercent is the maximum percentage (0-100) of bad records allowed. It is checked once
+	// the input is exhausted.
+	MaxPercent float64 `json:"max_percent,omitempty"`
+}
+
+// QuarantinedRecord describes a single bad record.
+type QuarantinedRecord struct {
+	// Resource is the resource string the record was read from.
+	Resource string `json:"resource"`
+
+	// Member is the archive member (or other part) selected by the resource's "#" fragment.
+	Member string `json:"member,omitempty"`
+
+	// RecordNum is the 1-based index of the record within the resource.
+	RecordNum int `json:"record_num"`
+
+	// Line and Offset give the position of the record, if known (see Provenance).
+	Line   int   `json:"line,omitempty"`
+	Offset int64 `json:"offset,omitempty"`
+
+	// Raw is the record as read by the DataFormat (if available).
+	Raw string `json:"raw,omitempty"`
+
+	// Err describes the problem with the record.
+	Err string `json:"error"`
+}
+
+// QuarantineSink receives bad records skipped by a pipeline with an ErrorBudget.
+type QuarantineSink interface {
+	Quarantine(rec QuarantinedRecord) error
+}
+
+// BudgetError is returned when a pipeline exceeds its ErrorBudget.
+type BudgetError struct {
+	Errors  int
+	Records int
+
+	// Last is the most recent record error.
+	Last error
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("error budget exceeded: %d bad records of %d (last error: %s)",
+		e.Errors, e.Records, e.Last)
+}
+
+// jsonQuarantine writes each QuarantinedRecord as a line of JSON.
+type jsonQuarantine struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONQuarantine returns a QuarantineSink that writes bad records to w in JSON Lines format.
+func NewJSONQuarantine(w io.Writer) QuarantineSink {
+	return &jsonQuarantine{enc: json.NewEncoder(w)}
+}
+
+func (q *jsonQuarantine) Quarantine(rec QuarantinedRecord) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.enc.Encode(rec)
+}
+
+// quarantine handles a bad record. If the pipeline has no ErrorBudget or the budget is
+// exceeded, an error is returned to stop processing.
+func (p *Pipeline) quarantine(raw string, err error) error {
+	budget := p.Spec.ErrorBudget
+	if budget == nil {
+		return err
+	}
+	p.nerrors++
+
+	if p.Quarantine != nil {
+		prov := p.Provenance()
+		qerr := p.Quarantine.Quarantine(QuarantinedRecord{
+			Resource:  prov.Resource,
+			Member:    prov.Member,
+			RecordNum: prov.RecordNum,
+			Line:      prov.Line,
+			Offset:    prov.Offset,
+			Raw:       raw,
+			Err:       err.Error(),
+		})
+		if qerr != nil {
+			return qerr
+		}
+	}
+
+	if budget.MaxErrors > 0 && p.nerrors > budget.MaxErrors {
+		return &BudgetError{Errors: p.nerrors, Records: p.nrecords, Last: err}
+	}
+	p.lastErr = err
+	return nil
+}
+
+// checkBudget verifies the percentage budget at the end of input.
+func (p *Pipeline) checkBudget() error {
+	budget := p.Spec.ErrorBudget
+	if budget == nil || budget.MaxPercent <= 0 || p.nrecords == 0 {
+		return nil
+	}
+	if float64(p.nerrors)*100/float64(p.nrecords) > budget.MaxPercent {
+		return &BudgetError{Errors: p.nerrors, Records: p.nrecords, Last: p.lastErr}
+	}
+	return nil
+}
+
+// applyFilters runs the FilterSet, converting any panic from a Filter into an error.
+func (p *Pipeline) applyFilters(fields map[interface{}]string) (recs []map[interface{}]string, err error) {
+	if p.Spec.ErrorBudget != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("filter failed: %v", r)
+			}
+		}()
+	}
+	return p.filters.Apply(fields), nil
+}
@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pbnjay/anydata"
+)
+
+// SchedulerOptions control the shared limits used by a Scheduler.
+type SchedulerOptions struct {
+	// Workers is the maximum number of pipelines run at once (default 4).
+	Workers int
+
+	// MaxPerHost is the maximum number of simultaneous downloads from a single remote host,
+	// which is useful for FTP servers that refuse multiple connections. 0 means no limit.
+	MaxPerHost int
+
+	// Bandwidth is the maximum combined download rate of the Scheduler's pipelines in bytes
+	// per second (see anydata.WithBandwidthLimit). 0 means no limit, other than those set by
+	// anydata.SetBandwidthLimit and anydata.SetHostBandwidthLimit.
+	Bandwidth int64
+
+	// Registries used to resolve each Spec (nil uses the default registries).
+	Registries *Registries
+
+	// Quarantine receives bad records from pipelines with an ErrorBudget.
+	Quarantine QuarantineSink
+}
+
+// Scheduler runs many pipelines concurrently with shared limits. Pipelines that read from the
+// same remote file (e.g. several members of one tarball) are coordinated so that the file is
+// only downloaded once and later pipelines are served from the cache. Each file is downloaded
+// into the cache before its records are read, so the per-host limit only applies to the
+// downloads.
+type Scheduler struct {
+	opts SchedulerOptions
+
+	mu        sync.Mutex
+	hostSlots map[string]chan struct{}
+	fetchLock map[string]*sync.Mutex
+
+	emitMu sync.Mutex
+}
+
+// NewScheduler creates a Scheduler with the given options.
+func NewScheduler(opts SchedulerOptions) *Scheduler {
+	if opts.Workers < 1 {
+		opts.Workers = 4
+	}
+	return &Scheduler{
+		opts:      opts,
+		hostSlots: make(map[string]chan struct{}),
+		fetchLock: make(map[string]*sync.Mutex),
+	}
+}
+
+// hostSlot returns the semaphore for the resource's host, or nil if unlimited or local.
+func (s *Scheduler) hostSlot(resource string) chan struct{} {
+	if s.opts.MaxPerHost < 1 {
+		return nil
+	}
+	furl, err := url.Parse(resource)
+	if err != nil || furl.Host == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, found := s.hostSlots[furl.Host]
+	if !found {
+		slot = make(chan struct{}, s.opts.MaxPerHost)
+		s.hostSlots[furl.Host] = slot
+	}
+	return slot
+}
+
+// resourceLock returns a lock shared by all resources stored under the same cache entry.
+func (s *Scheduler) resourceLock(resource string) *sync.Mutex {
+	key := strings.SplitN(resource, "#", 2)[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, found := s.fetchLock[key]
+	if !found {
+		m = &sync.Mutex{}
+		s.fetchLock[key] = m
+	}
+	return m
+}
+
+// download stores the file for resource in the cache, waiting for the per-host and per-file
+// limits. Pipelines which read the same file wait for a single download, and then read the
+// cached copy concurrently.
+func (s *Scheduler) download(ctx context.Context, resource string) error {
+	lock := s.resourceLock(resource)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if slot := s.hostSlot(resource); slot != nil {
+		select {
+		case slot <- struct{}{}:
+			defer func() { <-slot }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	opts := &anydata.FetchOptions{Workers: 1, Registry: s.opts.Registries.fetchers()}
+	return anydata.PrefetchContext(ctx, []string{resource}, opts)[0]
+}
+
+func (s *Scheduler) run(ctx context.Context, idx int, spec Spec, emit func(int, map[interface{}]string) error) error {
+	p, err := s.opts.Registries.New(spec)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if err = s.download(ctx, spec.Resource); err != nil {
+		return err
+	}
+	p.Quarantine = s.opts.Quarantine
+	if err = p.OpenContext(ctx); err != nil {
+		return err
+	}
+	for {
+		recs, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.emitMu.Lock()
+		for _, rec := range recs {
+			if err = emit(idx, rec); err != nil {
+				break
+			}
+		}
+		s.emitMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run executes every spec, calling emit with the index of the source spec for each filtered
+// record. Calls to emit are serialized, so it does not need to be safe for concurrent use.
+// The returned slice has one entry per spec, which is nil if that pipeline succeeded.
+func (s *Scheduler) Run(specs []Spec, emit func(spec int, rec map[interface{}]string) error) []error {
+	return s.RunContext(context.Background(), specs, emit)
+}
+
+// RunContext is like Run, but the pipelines (including those waiting for a download slot) are
+// canceled when ctx is done.
+func (s *Scheduler) RunContext(ctx context.Context, specs []Spec, emit func(spec int, rec map[interface{}]string) error) []error {
+	if s.opts.Bandwidth > 0 {
+		ctx = anydata.WithBandwidthLimit(ctx, s.opts.Bandwidth)
+	}
+
+	errs := make([]error, len(specs))
+	work := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < s.opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = s.run(ctx, i, specs[i], emit)
+			}
+		}()
+	}
+	for i := range specs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/anydata"
+)
+
+// testServer counts the requests for each path, and the most requests in progress at once.
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+	active   int
+	peak     int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{requests: make(map[string]int)}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		ts.requests[r.URL.Path]++
+		ts.active++
+		if ts.active > ts.peak {
+			ts.peak = ts.active
+		}
+		ts.mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/missing.tsv" {
+			http.NotFound(w, r)
+		} else {
+			fmt.Fprintf(w, "1\t%s\n2\t%s\n3\t%s\n", r.URL.Path, r.URL.Path, r.URL.Path)
+		}
+
+		ts.mu.Lock()
+		ts.active--
+		ts.mu.Unlock()
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// testRegistries returns Registries which fetch into a new cache.
+func testRegistries(t *testing.T) *Registries {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c, err := anydata.NewCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := anydata.NewRegistry()
+	r.RegisterDefaults()
+	r.SetCache(c)
+	return &Registries{Fetchers: r}
+}
+
+func testSpecs(srv *testServer, paths ...string) []Spec {
+	var specs []Spec
+	for _, p := range paths {
+		specs = append(specs, Spec{Resource: srv.URL + p, Format: map[string]string{"type": "tab-delimited"}})
+	}
+	return specs
+}
+
+func TestSchedulerLimits(t *testing.T) {
+	for _, tc := range []struct {
+		opts SchedulerOptions
+		peak int
+	}{
+		{SchedulerOptions{Workers: 2}, 2},
+		{SchedulerOptions{Workers: 4, MaxPerHost: 1}, 1},
+	} {
+		srv := newTestServer(t)
+		tc.opts.Registries = testRegistries(t)
+		specs := testSpecs(srv, "/a.tsv", "/b.tsv", "/c.tsv", "/d.tsv", "/e.tsv", "/f.tsv")
+
+		var n int32
+		errs := NewScheduler(tc.opts).Run(specs, func(spec int, rec map[interface{}]string) error {
+			atomic.AddInt32(&n, 1)
+			return nil
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("%s: %s", specs[i].Resource, err)
+			}
+		}
+		if n != 18 {
+			t.Errorf("expected 18 records, got %d", n)
+		}
+		if srv.peak > tc.peak {
+			t.Errorf("%+v: %d requests at once, expected at most %d", tc.opts, srv.peak, tc.peak)
+		}
+	}
+}
+
+func TestSchedulerSharedFile(t *testing.T) {
+	srv := newTestServer(t)
+	specs := testSpecs(srv, "/a.tsv", "/a.tsv", "/a.tsv", "/b.tsv")
+	s := NewScheduler(SchedulerOptions{Workers: 4, Registries: testRegistries(t)})
+
+	counts := make([]int, len(specs))
+	errs := s.Run(specs, func(spec int, rec map[interface{}]string) error {
+		counts[spec]++
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("%s: %s", specs[i].Resource, err)
+		}
+		if counts[i] != 3 {
+			t.Errorf("%s: expected 3 records, got %d", specs[i].Resource, counts[i])
+		}
+	}
+	if srv.requests["/a.tsv"] != 1 {
+		t.Errorf("expected a single download of the shared file, got %d", srv.requests["/a.tsv"])
+	}
+}
+
+func TestSchedulerErrors(t *testing.T) {
+	srv := newTestServer(t)
+	specs := testSpecs(srv, "/a.tsv", "/missing.tsv", "/b.tsv", "/c.tsv")
+	specs[2].Format = map[string]string{"type": "no-such-format"}
+	s := NewScheduler(SchedulerOptions{Workers: 2, Registries: testRegistries(t)})
+
+	errEmit := errors.New("emit failed")
+	errs := s.Run(specs, func(spec int, rec map[interface{}]string) error {
+		if spec == 3 {
+			return errEmit
+		}
+		return nil
+	})
+	if len(errs) != len(specs) {
+		t.Fatalf("expected %d errors, got %d", len(specs), len(errs))
+	}
+	if errs[0] != nil {
+		t.Errorf("unexpected error for the good pipeline: %s", errs[0])
+	}
+	if errs[1] == nil {
+		t.Error("expected an error for the missing file")
+	}
+	if errs[2] == nil {
+		t.Error("expected an error for the invalid format")
+	}
+	if errs[3] != errEmit {
+		t.Errorf("expected the error from emit, got %v", errs[3])
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	srv := newTestServer(t)
+	specs := testSpecs(srv, "/a.tsv", "/b.tsv")
+	s := NewScheduler(SchedulerOptions{Workers: 2, MaxPerHost: 1, Registries: testRegistries(t)})
+
+	// take the only download slot, so that both pipelines wait for it
+	s.hostSlot(srv.URL) <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := s.RunContext(ctx, specs, func(spec int, rec map[interface{}]string) error {
+		return nil
+	})
+	for i, err := range errs {
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expected the context error, got %v", specs[i].Resource, err)
+		}
+	}
+	if len(srv.requests) != 0 {
+		t.Errorf("expected no downloads, got %v", srv.requests)
+	}
+}
@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// UniqueSpec configures the optional de-duplication stage of a pipeline.
+type UniqueSpec struct {
+	// Fields lists the key fields used to compare records. If empty, all fields are used.
+	Fields []string `json:"fields,omitempty"`
+
+	// MaxKeys is the number of keys held in memory before they are spilled to a temporary
+	// on-disk index (default 1,000,000).
+	MaxKeys int `json:"max_keys,omitempty"`
+}
+
+// keySize is the size of the hashed record keys. 128 bits makes collisions vanishingly unlikely
+// even for billions of records.
+const keySize = 16
+
+type recordKey [keySize]byte
+
+// maxSpillRuns is the number of spilled runs kept before they are merged into one.
+const maxSpillRuns = 8
+
+// UniqueRecords removes duplicate records based on a set of key fields. Keys are hashed and
+// kept in memory until MaxKeys is reached, at which point they are written to a sorted run file
+// on disk and searched there, so uniqueness works on inputs much larger than available RAM.
+type UniqueRecords struct {
+	fields  []string
+	maxKeys int
+
+	mem  map[recordKey]struct{}
+	runs []*os.File
+}
+
+// NewUniqueRecords creates a de-duplication stage.
+func NewUniqueRecords(spec UniqueSpec) *UniqueRecords {
+	if spec.MaxKeys < 1 {
+		spec.MaxKeys = 1000000
+	}
+	return &UniqueRecords{
+		fields:  spec.Fields,
+		maxKeys: spec.MaxKeys,
+		mem:     make(map[recordKey]struct{}),
+	}
+}
+
+// key computes the hashed key for rec.
+func (u *UniqueRecords) key(rec map[interface{}]string) recordKey {
+	h := sha256.New()
+	if len(u.fields) == 0 {
+		parts := make([]string, 0, len(rec))
+		for k, v := range rec {
+			parts = append(parts, fmt.Sprint(k)+"="+v)
+		}
+		sort.Strings(parts)
+		io.WriteString(h, strings.Join(parts, "\x00"))
+	} else {
+		for _, f := range u.fields {
+			v, found := rec[fieldKey(f)]
+			if !found {
+				v = rec[f]
+			}
+			io.WriteString(h, v)
+			h.Write([]byte{0})
+		}
+	}
+
+	var k recordKey
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// Seen returns true if a record with the same key fields was already seen. Otherwise the key is
+// remembered and false is returned.
+func (u *UniqueRecords) Seen(rec map[interface{}]string) (bool, error) {
+	k := u.key(rec)
+	if _, found := u.mem[k]; found {
+		return true, nil
+	}
+	for _, run := range u.runs {
+		found, err := searchRun(run, k)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+
+	u.mem[k] = struct{}{}
+	if len(u.mem) >= u.maxKeys {
+		return false, u.spill()
+	}
+	return false, nil
+}
+
+// Filter returns only the records that have not been seen before.
+func (u *UniqueRecords) Filter(recs []map[interface{}]string) ([]map[interface{}]string, error) {
+	ret := recs[:0]
+	for _, rec := range recs {
+		dupe, err := u.Seen(rec)
+		if err != nil {
+			return nil, err
+		}
+		if !dupe {
+			ret = append(ret, rec)
+		}
+	}
+	return ret, nil
+}
+
+// spill writes the in-memory keys into a new sorted run on disk.
+func (u *UniqueRecords) spill() error {
+	keys := make([]recordKey, 0, len(u.mem))
+	for k := range u.mem {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	f, err := ioutil.TempFile("", "anydata-unique")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err = f.Write(k[:]); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	u.runs = append(u.runs, f)
+	u.mem = make(map[recordKey]struct{})
+
+	if len(u.runs) > maxSpillRuns {
+		return u.merge()
+	}
+	return nil
+}
+
+// merge combines all of the spilled runs into a single sorted run.
+func (u *UniqueRecords) merge() error {
+	out, err := ioutil.TempFile("", "anydata-unique")
+	if err != nil {
+		return err
+	}
+
+	type cursor struct {
+		r   io.Reader
+		cur recordKey
+		ok  bool
+	}
+	advance := func(c *cursor) error {
+		_, err := io.ReadFull(c.r, c.cur[:])
+		if err == io.EOF {
+			c.ok = false
+			return nil
+		}
+		c.ok = err == nil
+		return err
+	}
+
+	var curs []*cursor
+	for _, run := range u.runs {
+		c := &cursor{r: io.NewSectionReader(run, 0, 1<<62)}
+		if err = advance(c); err != nil {
+			out.Close()
+			os.Remove(out.Name())
+			return err
+		}
+		curs = append(curs, c)
+	}
+
+	for {
+		var min *cursor
+		for _, c := range curs {
+			if c.ok && (min == nil || bytes.Compare(c.cur[:], min.cur[:]) < 0) {
+				min = c
+			}
+		}
+		if min == nil {
+			break
+		}
+		if _, err = out.Write(min.cur[:]); err == nil {
+			err = advance(min)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+			return err
+		}
+	}
+
+	u.Close()
+	u.runs = []*os.File{out}
+	return nil
+}
+
+// searchRun performs a binary search for k in a sorted run file.
+func searchRun(run *os.File, k recordKey) (bool, error) {
+	st, err := run.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	var buf recordKey
+	lo, hi := int64(0), st.Size()/keySize
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if _, err = run.ReadAt(buf[:], mid*keySize); err != nil {
+			return false, err
+		}
+		switch bytes.Compare(buf[:], k[:]) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// Close removes any spilled runs from disk.
+func (u *UniqueRecords) Close() error {
+	for _, run := range u.runs {
+		run.Close()
+		os.Remove(run.Name())
+	}
+	u.runs = nil
+	return nil
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ValidationError lists every problem found by Validate.
+type ValidationError []string
+
+func (v ValidationError) Error() string {
+	return "invalid pipeline spec: " + strings.Join(v, "; ")
+}
+
+// Validate checks spec end-to-end without reading any data: the resource must match a fetcher
+// (and any wrappers must resolve), the format specification must parse, every filter name
+// and its parameters must be valid, the mapping must be well-formed, and any output must
+// resolve to a DataSink. All problems found are reported in a ValidationError. Use
+// anydata.Validate to also check that the resource is reachable.
+//
+// If sample is greater than zero, the resource is also fetched and up to sample filtered
+// records are returned, which is useful to confirm field indexes before a long batch run.
+func Validate(spec Spec, sample int) ([]map[interface{}]string, error) {
+	return (*Registries)(nil).Validate(spec, sample)
+}
+
+// Validate is equivalent to the package-level Validate, but resolves spec using r.
+func (r *Registries) Validate(spec Spec, sample int) ([]map[interface{}]string, error) {
+	var problems ValidationError
+
+	if spec.Resource == "" {
+		problems = append(problems, "no resource specified")
+	} else if _, err := r.fetchers().GetFetcher(spec.Resource); err != nil {
+		problems = append(problems, fmt.Sprintf("resource: %s", err))
+	}
+
+	if _, err := r.formats().GetDataFormat(spec.Format); err != nil {
+		problems = append(problems, fmt.Sprintf("format: %s", err))
+	}
+
+	for i, fs := range spec.Filters {
+		if _, err := r.filters().GetFilter(fs.Type, filterFields(fs.Fields)); err != nil {
+			problems = append(problems, fmt.Sprintf("filter %d (%s): %s", i, fs.Type, err))
+		}
+	}
+
+	if err := spec.Mapping.Check(); err != nil {
+		problems = append(problems, fmt.Sprintf("mapping: %s", err))
+	}
+
+	if spec.Output != nil {
+		if _, err := r.formats().GetDataSink(spec.Output); err != nil {
+			problems = append(problems, fmt.Sprintf("output: %s", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	if sample <= 0 {
+		return nil, nil
+	}
+
+	p, err := r.New(spec)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Open()
+	defer p.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []map[interface{}]string
+	for len(ret) < sample {
+		recs, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, recs...)
+	}
+	if len(ret) > sample {
+		ret = ret[:sample]
+	}
+	return ret, nil
+}
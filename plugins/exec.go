@@ -0,0 +1,324 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pbnjay/anydata"
+)
+
+// maxLineSize is the longest JSON line accepted from a plugin process.
+const maxLineSize = 64 * 1024 * 1024
+
+// cmdReader streams the stdout of a running command, and reports a failed exit status (along
+// with any stderr output) as a read error.
+type cmdReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+	done   bool
+}
+
+func startCommand(command []string, stdin io.Reader, env ...string) (*cmdReader, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = stdin
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cr := &cmdReader{cmd: cmd, stderr: &bytes.Buffer{}}
+	cmd.Stderr = cr.stderr
+
+	var err error
+	cr.stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *cmdReader) Read(p []byte) (int, error) {
+	n, err := cr.stdout.Read(p)
+	if err == io.EOF && !cr.done {
+		cr.done = true
+		if werr := cr.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("plugin %s: %s: %s", cr.cmd.Path, werr, strings.TrimSpace(cr.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (cr *cmdReader) Close() error {
+	if !cr.done {
+		cr.done = true
+		cr.cmd.Process.Kill()
+		cr.cmd.Wait()
+	}
+	return nil
+}
+
+// decodeFields parses a JSON object into a record, converting integer-like keys to int.
+func decodeFields(data []byte) (map[interface{}]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	ret := make(map[interface{}]string, len(raw))
+	for k, v := range raw {
+		var key interface{} = k
+		if i, err := strconv.Atoi(k); err == nil {
+			key = i
+		}
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			// non-string values are kept in their JSON representation
+			s = string(v)
+		}
+		ret[key] = s
+	}
+	return ret, nil
+}
+
+// encodeFields converts a record into a JSON object.
+func encodeFields(fields map[interface{}]string) ([]byte, error) {
+	m := make(map[string]string, len(fields))
+	for k, v := range fields {
+		m[fmt.Sprint(k)] = v
+	}
+	return json.Marshal(m)
+}
+
+///////////////////
+
+// An external program fetcher for resources with a given URL scheme.
+type execFetcher struct {
+	scheme   string
+	command  []string
+	resource string
+}
+
+func (n *execFetcher) String() string {
+	return fmt.Sprintf("%s plugin", n.scheme)
+}
+
+func (n *execFetcher) Detect(resource string) bool {
+	furl, err := url.Parse(resource)
+	return err == nil && furl.Scheme == n.scheme
+}
+
+func (n *execFetcher) Fetch(resource string) error {
+	if _, err := exec.LookPath(n.command[0]); err != nil {
+		return err
+	}
+	n.resource = resource
+	return nil
+}
+
+func (n *execFetcher) GetReader() (io.Reader, error) {
+	if n.resource == "" {
+		return nil, fmt.Errorf("reading from %s plugin failed (did you call Fetch?)", n.scheme)
+	}
+	args := append(append([]string{}, n.command...), n.resource)
+	return startCommand(args, nil)
+}
+
+///////////////////
+
+// An external program decompression wrapper for paths with a given suffix.
+type execWrapper struct {
+	suffix  string
+	command []string
+	wrapped anydata.Fetcher
+}
+
+func (n *execWrapper) String() string {
+	return fmt.Sprintf("%s plugin %s", n.suffix, n.wrapped)
+}
+
+func (n *execWrapper) Detect(resource string) bool {
+	return false
+}
+
+func (n *execWrapper) DetectWrap(pathname, partname string) bool {
+	return partname == "" && strings.HasSuffix(pathname, n.suffix)
+}
+
+func (n *execWrapper) Wrap(f anydata.Fetcher, partname string) (anydata.Fetcher, error) {
+	return &execWrapper{suffix: n.suffix, command: n.command, wrapped: f}, nil
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *execWrapper) Unwrap() anydata.Fetcher {
+	return n.wrapped
+}
+
+func (n *execWrapper) Fetch(resource string) error {
+	return n.wrapped.Fetch(resource)
+}
+
+func (n *execWrapper) GetReader() (io.Reader, error) {
+	r, err := n.wrapped.GetReader()
+	if err != nil {
+		return nil, err
+	}
+	return startCommand(n.command, r)
+}
+
+///////////////////
+
+// An external program DataFormat which converts raw input into JSON lines.
+type execFormat struct {
+	command []string
+	spec    []byte
+	proc    *cmdReader
+	scanner *bufio.Scanner
+}
+
+func (f *execFormat) Init(spec map[string]string) error {
+	var err error
+	f.spec, err = json.Marshal(spec)
+	return err
+}
+
+func (f *execFormat) Open(r io.Reader) error {
+	var err error
+	f.proc, err = startCommand(f.command, r, "ANYDATA_SPEC="+string(f.spec))
+	if err != nil {
+		return err
+	}
+	f.scanner = bufio.NewScanner(f.proc)
+	f.scanner.Buffer(nil, maxLineSize)
+	return nil
+}
+
+func (f *execFormat) NextRecord() (string, error) {
+	for f.scanner.Scan() {
+		line := strings.TrimSpace(f.scanner.Text())
+		if line != "" {
+			return line, nil
+		}
+	}
+	if err := f.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+func (f *execFormat) GetFields(record string) (map[interface{}]string, error) {
+	return decodeFields([]byte(record))
+}
+
+func (f *execFormat) NextRecordFields() (map[interface{}]string, error) {
+	rec, err := f.NextRecord()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetFields(rec)
+}
+
+func (f *execFormat) HasVariableFields() bool {
+	return true
+}
+
+///////////////////
+
+// An external program Filter, which exchanges one line of JSON per record.
+type execFilter struct {
+	command []string
+
+	mu      sync.Mutex
+	stdin   io.WriteCloser
+	scanner *bufio.Scanner
+}
+
+// roundTrip sends one line of JSON and returns the response line.
+func (f *execFilter) roundTrip(data []byte) ([]byte, error) {
+	if _, err := f.stdin.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if !f.scanner.Scan() {
+		if err := f.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	return f.scanner.Bytes(), nil
+}
+
+func (f *execFilter) Setup(parts map[interface{}]string) error {
+	cmd := exec.Command(f.command[0], f.command[1:]...)
+	cmd.Stderr = os.Stderr
+	var err error
+	f.stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	f.scanner = bufio.NewScanner(stdout)
+	f.scanner.Buffer(nil, maxLineSize)
+
+	data, err := encodeFields(parts)
+	if err != nil {
+		return err
+	}
+	resp, err := f.roundTrip(data)
+	if err != nil {
+		return fmt.Errorf("plugin filter setup failed: %s", err)
+	}
+	var status struct {
+		Error string `json:"error"`
+	}
+	if err = json.Unmarshal(resp, &status); err != nil {
+		return fmt.Errorf("plugin filter setup failed: %s", err)
+	}
+	if status.Error != "" {
+		return fmt.Errorf("plugin filter setup failed: %s", status.Error)
+	}
+	return nil
+}
+
+func (f *execFilter) Apply(fields map[interface{}]string) []map[interface{}]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := encodeFields(fields)
+	if err == nil {
+		data, err = f.roundTrip(data)
+	}
+	var raw []json.RawMessage
+	if err == nil {
+		err = json.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		anydata.Logf("plugin filter %s: %s\n", f.command[0], err)
+		return nil
+	}
+
+	ret := make([]map[interface{}]string, 0, len(raw))
+	for _, r := range raw {
+		rec, err := decodeFields(r)
+		if err != nil {
+			anydata.Logf("plugin filter %s: %s\n", f.command[0], err)
+			return nil
+		}
+		ret = append(ret, rec)
+	}
+	return ret
+}
@@ -0,0 +1,174 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+	"github.com/pbnjay/anydata/filters"
+	"github.com/pbnjay/anydata/formats"
+)
+
+// testRegistries returns new registries with the default fetchers, and the plugins in entries.
+func testRegistries(t *testing.T, entries ...ManifestEntry) *Registries {
+	r := &Registries{Fetchers: anydata.NewRegistry(), Formats: formats.NewRegistry(), Filters: filters.NewRegistry()}
+	r.Fetchers.RegisterDefaults()
+	for _, m := range entries {
+		if err := m.Register(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func readResource(r *Registries, resource string) (string, error) {
+	f, err := r.Fetchers.GetFetcher(resource)
+	if err != nil {
+		return "", err
+	}
+	if err = f.Fetch(resource); err != nil {
+		return "", err
+	}
+	rd, err := f.GetReader()
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(rd)
+	return string(data), err
+}
+
+func TestDecodeFields(t *testing.T) {
+	fields, err := decodeFields([]byte(`{"0": "a", "name": "b", "-1": "c", "n": 3, "tags": ["x"], "none": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]string{0: "a", "name": "b", -1: "c", "n": "3", "tags": `["x"]`, "none": ""}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if _, err = decodeFields([]byte(`["a"]`)); err == nil {
+		t.Error("expected an error for a JSON array")
+	}
+}
+
+func TestExecFetcher(t *testing.T) {
+	r := testRegistries(t,
+		ManifestEntry{Kind: "fetcher", Name: "vault", Command: []string{"sh", "-c", `printf 'secret for %s' "$0"`}},
+		ManifestEntry{Kind: "fetcher", Name: "broken", Command: []string{"sh", "-c", `echo partial; echo access denied >&2; exit 3`}},
+	)
+	data, err := readResource(r, "vault://db/password")
+	if err != nil || data != "secret for vault://db/password" {
+		t.Errorf("unexpected plugin output %q: %v", data, err)
+	}
+
+	// a failed exit is reported as a read error, with the stderr output
+	data, err = readResource(r, "broken://x")
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: access denied") {
+		t.Errorf("expected the exit status and stderr, got %v", err)
+	}
+	if data != "partial\n" {
+		t.Errorf("unexpected plugin output %q", data)
+	}
+
+	if _, err = readResource(testRegistries(t, ManifestEntry{Kind: "fetcher", Name: "missing",
+		Command: []string{"no-such-plugin-command"}}), "missing://x"); err == nil {
+		t.Error("expected an error for a command which does not exist")
+	}
+}
+
+func TestExecWrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "data.txt.up")
+	if err = ioutil.WriteFile(fn, []byte("id\tname\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := testRegistries(t, ManifestEntry{Kind: "wrapper", Name: ".up", Command: []string{"tr", "a-z", "A-Z"}})
+	data, err := readResource(r, fn)
+	if err != nil || data != "ID\tNAME\n" {
+		t.Errorf("unexpected wrapped output %q: %v", data, err)
+	}
+}
+
+func TestExecFormat(t *testing.T) {
+	// echo the spec, then one record per input line
+	script := `echo "$ANYDATA_SPEC"; echo; while read line; do printf '{"0": "%s", "1": 2}\n' "$line"; done`
+	r := testRegistries(t, ManifestEntry{Kind: "format", Name: "lines", Command: []string{"sh", "-c", script}})
+
+	df, err := r.Formats.GetDataFormat(map[string]string{"type": "lines", "x": "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = df.Open(strings.NewReader("a\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	var recs []map[interface{}]string
+	for {
+		rec, err := df.NextRecordFields()
+		if err != nil {
+			break
+		}
+		recs = append(recs, rec)
+	}
+	want := []map[interface{}]string{{"type": "lines", "x": "y"}, {0: "a", 1: "2"}, {0: "b", 1: "2"}}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
+
+func TestExecFilter(t *testing.T) {
+	// check the setup fields, then return each record twice
+	script := `read setup
+case "$setup" in
+*'"copies":"2"'*) echo '{}';;
+*) echo '{"error": "copies must be 2"}'; exit;;
+esac
+while read rec; do echo "[$rec, $rec]"; done`
+	r := testRegistries(t, ManifestEntry{Kind: "filter", Name: "twice", Command: []string{"sh", "-c", script}})
+
+	if _, err := r.Filters.GetFilter("twice", map[interface{}]string{"copies": "3"}); err == nil ||
+		!strings.Contains(err.Error(), "copies must be 2") {
+		t.Errorf("expected the setup error from the plugin, got %v", err)
+	}
+	f, err := r.Filters.GetFilter("twice", map[interface{}]string{"copies": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := f.Apply(map[interface{}]string{0: "a", "name": "b"})
+	want := []map[interface{}]string{{0: "a", "name": "b"}, {0: "a", "name": "b"}}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("unexpected records: %v", recs)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "plugins.json")
+	ioutil.WriteFile(fn, []byte(`[{"kind": "fetcher", "name": "vault", "command": ["echo"]}]`), 0644)
+
+	r := testRegistries(t)
+	if err = LoadManifest(fn, r); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := readResource(r, "vault://x"); err != nil || data != "vault://x\n" {
+		t.Errorf("unexpected plugin output %q: %v", data, err)
+	}
+
+	for _, m := range []ManifestEntry{{Kind: "fetcher", Name: "x"}, {Kind: "other", Name: "x", Command: []string{"echo"}}} {
+		if err = m.Register(r); err == nil {
+			t.Errorf("expected an error registering %+v", m)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package plugins loads third-party Fetchers, Wrappers, DataFormats and Filters at runtime, so
+// that proprietary sources and transforms can be added without forking or recompiling anydata.
+// Two mechanisms are supported:
+//
+// Go plugins (see the standard library "plugin" package) must export a function named
+// "RegisterAnydata" with the signature:
+//
+//    func RegisterAnydata(f *anydata.Registry, d *formats.Registry, x *filters.Registry) error
+//
+// External programs speak a simple protocol over stdin/stdout, and are described by a JSON
+// manifest loaded with LoadManifest:
+//
+//    [
+//      {"kind": "fetcher", "name": "vault",  "command": ["vault-cat"]},
+//      {"kind": "wrapper", "name": ".lz4",   "command": ["lz4", "-dc"]},
+//      {"kind": "format",  "name": "sas7bdat", "command": ["sas2jsonl"]},
+//      {"kind": "filter",  "name": "geocode", "command": ["python3", "geocode.py"]}
+//    ]
+//
+// The protocol for each kind is:
+//
+//    "fetcher" - handles resources with the URL scheme "name". The command is run with the
+//                resource string as its final argument, and its stdout is the resource content.
+//    "wrapper" - handles resources whose path ends in "name". The wrapped content is written to
+//                the command's stdin, and its stdout is the decompressed content.
+//    "format"  - the raw input is written to the command's stdin, and it must write one JSON
+//                object per line to stdout for each record (field name => string value). The
+//                format spec is provided as JSON in the ANYDATA_SPEC environment variable.
+//    "filter"  - a long-running process. The filter setup fields are written as the first line
+//                of JSON, and the process must respond with {} or {"error": "..."}. Then for each
+//                input record a JSON object is written, and the process must respond with a
+//                JSON array of zero or more output records.
+//
+// Field names that look like integers are converted to int keys, to match the positional
+// field indexes used by the built-in formats.
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"plugin"
+
+	"github.com/pbnjay/anydata"
+	"github.com/pbnjay/anydata/filters"
+	"github.com/pbnjay/anydata/formats"
+)
+
+// Registries identifies where plugins are registered. Nil fields use the package-level
+// default registries.
+type Registries struct {
+	Fetchers *anydata.Registry
+	Formats  *formats.Registry
+	Filters  *filters.Registry
+}
+
+func (r *Registries) defaults() Registries {
+	ret := Registries{anydata.DefaultRegistry, formats.DefaultRegistry, filters.DefaultRegistry}
+	if r != nil {
+		if r.Fetchers != nil {
+			ret.Fetchers = r.Fetchers
+		}
+		if r.Formats != nil {
+			ret.Formats = r.Formats
+		}
+		if r.Filters != nil {
+			ret.Filters = r.Filters
+		}
+	}
+	return ret
+}
+
+// RegisterFunc is the signature of the "RegisterAnydata" symbol exported by Go plugins.
+type RegisterFunc func(*anydata.Registry, *formats.Registry, *filters.Registry) error
+
+// LoadGoPlugin opens the Go plugin at path and calls its RegisterAnydata function.
+func LoadGoPlugin(path string, reg *Registries) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup("RegisterAnydata")
+	if err != nil {
+		return err
+	}
+
+	var fn RegisterFunc
+	switch f := sym.(type) {
+	case func(*anydata.Registry, *formats.Registry, *filters.Registry) error:
+		fn = f
+	case *RegisterFunc:
+		fn = *f
+	default:
+		return fmt.Errorf("plugin '%s': RegisterAnydata has the wrong type (%T)", path, sym)
+	}
+
+	r := reg.defaults()
+	return fn(r.Fetchers, r.Formats, r.Filters)
+}
+
+// ManifestEntry describes a single external program plugin.
+type ManifestEntry struct {
+	// Kind is one of "fetcher", "wrapper", "format", or "filter".
+	Kind string `json:"kind"`
+
+	// Name is the URL scheme (fetcher), path suffix (wrapper), or registered name (format
+	// and filter).
+	Name string `json:"name"`
+
+	// Command is the program and arguments to run.
+	Command []string `json:"command"`
+}
+
+// Register adds the plugin described by m to the registries.
+func (m ManifestEntry) Register(reg *Registries) error {
+	if m.Name == "" || len(m.Command) == 0 {
+		return fmt.Errorf("plugin manifest entries require a name and command")
+	}
+	r := reg.defaults()
+	switch m.Kind {
+	case "fetcher":
+		r.Fetchers.RegisterFetcher(&execFetcher{scheme: m.Name, command: m.Command})
+	case "wrapper":
+		r.Fetchers.RegisterWrapper(&execWrapper{suffix: m.Name, command: m.Command})
+	case "format":
+		r.Formats.RegisterFormat(m.Name, func() formats.DataFormat {
+			return &execFormat{command: m.Command}
+		})
+	case "filter":
+		r.Filters.RegisterFilter(m.Name, func() filters.Filter {
+			return &execFilter{command: m.Command}
+		})
+	default:
+		return fmt.Errorf("unknown plugin kind '%s'", m.Kind)
+	}
+	return nil
+}
+
+// LoadManifest reads a JSON list of ManifestEntry values from filename and registers each.
+func LoadManifest(filename string, reg *Registries) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var entries []ManifestEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("plugin manifest '%s': %s", filename, err)
+	}
+	for _, m := range entries {
+		if err = m.Register(reg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
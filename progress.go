@@ -0,0 +1,153 @@
+package anydata
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress describes the state of a download in progress.
+type Progress struct {
+	// Resource is the resource string being downloaded.
+	Resource string
+
+	// Bytes is the number of bytes downloaded so far (including any resumed partial download).
+	Bytes int64
+
+	// Total is the size of the download in bytes, or -1 if it is not known.
+	Total int64
+
+	// Elapsed is the time since the download started.
+	Elapsed time.Duration
+
+	// Done is true for the final report of a completed (or failed) download.
+	Done bool
+}
+
+// Percent returns the percentage of the download which has completed, or -1 if the total size
+// is not known.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return 100.0 * float64(p.Bytes) / float64(p.Total)
+}
+
+// BytesPerSecond returns the average download throughput.
+func (p Progress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// ProgressReporter receives progress updates from the remote fetchers. Downloads are streamed,
+// so updates are sent as the reader returned by GetReader is consumed. Reports for a single
+// download are sent sequentially, but reports for concurrent downloads may arrive concurrently.
+type ProgressReporter interface {
+	Progress(p Progress)
+}
+
+// ProgressFunc is an adapter to allow the use of ordinary functions as ProgressReporters.
+type ProgressFunc func(p Progress)
+
+// Progress calls f(p).
+func (f ProgressFunc) Progress(p Progress) {
+	f(p)
+}
+
+var (
+	progressMu       sync.Mutex
+	progressReporter ProgressReporter
+
+	// ProgressInterval is the minimum time between progress reports for a download.
+	ProgressInterval = 250 * time.Millisecond
+)
+
+// SetProgressReporter sets the ProgressReporter used by all remote fetchers. Passing nil (the
+// default) disables progress reporting.
+func SetProgressReporter(pr ProgressReporter) {
+	progressMu.Lock()
+	progressReporter = pr
+	progressMu.Unlock()
+}
+
+// progressTracker accumulates the progress of a download, and sends reports to a
+// ProgressReporter. It is safe for concurrent use by the parts of a multi-connection download.
+type progressTracker struct {
+	mu    sync.Mutex
+	pr    ProgressReporter
+	p     Progress
+	start time.Time
+	last  time.Time
+}
+
+// trackProgress returns a progressTracker for resource, or nil if no ProgressReporter is set.
+// offset is the number of bytes already downloaded, and total is the full size in bytes (or -1
+// if unknown).
+func trackProgress(resource string, offset, total int64) *progressTracker {
+	progressMu.Lock()
+	pr := progressReporter
+	progressMu.Unlock()
+	if pr == nil {
+		return nil
+	}
+
+	now := time.Now()
+	return &progressTracker{
+		pr:    pr,
+		p:     Progress{Resource: resource, Bytes: offset, Total: total},
+		start: now,
+		last:  now,
+	}
+}
+
+// add records n more bytes downloaded, and sends a report if it is due. Setting done sends the
+// final report.
+func (t *progressTracker) add(n int64, done bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.p.Done {
+		return
+	}
+	t.p.Bytes += n
+	now := time.Now()
+	if done {
+		t.p.Done = true
+	} else if now.Sub(t.last) < ProgressInterval {
+		return
+	}
+	t.last = now
+	t.p.Elapsed = now.Sub(t.start)
+	t.pr.Progress(t.p)
+}
+
+// progressReader reports the progress of a download as it is read.
+type progressReader struct {
+	r io.ReadCloser
+	t *progressTracker
+}
+
+// reportProgress wraps the download stream r for resource with progress reporting, if a
+// ProgressReporter is set. offset is the number of bytes already downloaded, and total is the
+// full size in bytes (or -1 if unknown).
+func reportProgress(resource string, r io.ReadCloser, offset, total int64) io.ReadCloser {
+	t := trackProgress(resource, offset, total)
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, t: t}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.t.add(int64(n), err != nil)
+	return n, err
+}
+
+func (p *progressReader) Close() error {
+	return p.r.Close()
+}
@@ -0,0 +1,151 @@
+package anydata
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a simple token bucket shared by all readers it wraps.
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  float64 // bytes per second
+	avail float64
+	last  time.Time
+}
+
+// wait blocks until n bytes may be consumed.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.avail += now.Sub(l.last).Seconds() * l.rate
+	}
+	if l.avail > l.rate {
+		// allow at most 1 second of burst
+		l.avail = l.rate
+	}
+	l.last = now
+	l.avail -= float64(n)
+	var delay time.Duration
+	if l.avail < 0 {
+		delay = time.Duration(-l.avail / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type limitedReader struct {
+	r   io.Reader
+	lim *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// don't read more than ~1 second worth of data at a time
+	if max := int(lr.lim.rate); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := lr.r.Read(p)
+	lr.lim.wait(n)
+	return n, err
+}
+
+var (
+	limiterMu        sync.Mutex
+	bandwidthLimiter *rateLimiter
+	hostLimiters     = make(map[string]*rateLimiter)
+)
+
+// newRateLimiter returns a limiter for bytesPerSec, or nil if bytesPerSec <= 0.
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSec), avail: float64(bytesPerSec)}
+}
+
+// SetBandwidthLimit sets the maximum combined download rate (in bytes per second) for all
+// network fetchers in the process. A value <= 0 removes the limit.
+func SetBandwidthLimit(bytesPerSec int64) {
+	limiterMu.Lock()
+	bandwidthLimiter = newRateLimiter(bytesPerSec)
+	limiterMu.Unlock()
+}
+
+// SetHostBandwidthLimit sets the maximum combined download rate (in bytes per second) from
+// host, which may include a port (e.g. "ftp.ncbi.nih.gov" or "data.example.com:8443"). For S3
+// resources the host is the bucket name. Host limits apply in addition to the overall limit set
+// by SetBandwidthLimit. A value <= 0 removes the limit.
+func SetHostBandwidthLimit(host string, bytesPerSec int64) {
+	limiterMu.Lock()
+	if lim := newRateLimiter(bytesPerSec); lim != nil {
+		hostLimiters[host] = lim
+	} else {
+		delete(hostLimiters, host)
+	}
+	limiterMu.Unlock()
+}
+
+type bandwidthKey struct{}
+
+// WithBandwidthLimit returns a context whose fetches share a maximum combined download rate
+// (in bytes per second), in addition to the limits set by SetBandwidthLimit and
+// SetHostBandwidthLimit. Each call creates a separate limit, e.g. for one batch of downloads:
+//
+//    ctx = anydata.WithBandwidthLimit(ctx, 10<<20)
+//
+// A value <= 0 removes a limit set on ctx by an earlier call.
+func WithBandwidthLimit(ctx context.Context, bytesPerSec int64) context.Context {
+	return context.WithValue(ctx, bandwidthKey{}, newRateLimiter(bytesPerSec))
+}
+
+// resourceLimiters returns the limiters that apply to a resource fetched with ctx: the limit
+// from ctx, then the host limit, then the global limit. Every limiter is applied to each read,
+// so the order does not change the effective (lowest) rate.
+func resourceLimiters(ctx context.Context, resource string) []*rateLimiter {
+	var lims []*rateLimiter
+	if lim, _ := ctx.Value(bandwidthKey{}).(*rateLimiter); lim != nil {
+		lims = append(lims, lim)
+	}
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	if len(hostLimiters) > 0 {
+		if furl, err := url.Parse(resource); err == nil {
+			lim, found := hostLimiters[furl.Host]
+			if !found {
+				lim, found = hostLimiters[furl.Hostname()]
+			}
+			if found {
+				lims = append(lims, lim)
+			}
+		}
+	}
+	if bandwidthLimiter != nil {
+		lims = append(lims, bandwidthLimiter)
+	}
+	return lims
+}
+
+// limitReader wraps a network stream for resource with the current bandwidth limits, if any.
+func limitReader(ctx context.Context, resource string, r io.Reader) io.Reader {
+	for _, lim := range resourceLimiters(ctx, resource) {
+		r = &limitedReader{r: r, lim: lim}
+	}
+	return r
+}
+
+// bandwidthLimit returns the lowest bandwidth limit for resource, or 0 if there is none.
+func bandwidthLimit(ctx context.Context, resource string) int64 {
+	var limit int64
+	for _, lim := range resourceLimiters(ctx, resource) {
+		if limit == 0 || int64(lim.rate) < limit {
+			limit = int64(lim.rate)
+		}
+	}
+	return limit
+}
@@ -0,0 +1,191 @@
+package anydata
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of Fetchers and Wrappers used to resolve resource strings. Most programs
+// can use the package-level functions, which operate on DefaultRegistry. Libraries that need a
+// different (or restricted) set of fetchers and wrappers can create their own Registry without
+// affecting other users of the package in the same binary.
+type Registry struct {
+	mu       sync.RWMutex
+	fetchers []Fetcher
+
+	// wrappers wrap fetchers in local extraction code
+	// i.e. unzip and return internal file from remote .zip url
+	wrappers []Wrapper
+
+	// cache stores downloads, or nil to use DefaultCache
+	cache *Cache
+}
+
+// DefaultRegistry is used by the package-level GetFetcher, RegisterFetcher and RegisterWrapper
+// functions. It contains all the built-in Fetchers and Wrappers.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty Registry. Call RegisterDefaults to add the built-in Fetchers and
+// Wrappers.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// RegisterDefaults adds the built-in set of fetchers and wrappers to r.
+func (r *Registry) RegisterDefaults() {
+	r.RegisterFetcher(&stdinFetcher{}) // before local, to claim "-"
+	r.RegisterFetcher(&dataFetcher{})
+	r.RegisterFetcher(&localFetcher{})
+	r.RegisterFetcher(&driveFetcher{}) // before http, to claim drive.google.com links
+	r.RegisterFetcher(&dropboxFetcher{})
+	r.RegisterFetcher(&boxFetcher{})
+	r.RegisterFetcher(&httpFetcher{})
+	r.RegisterFetcher(&ftpFetcher{})
+	r.RegisterFetcher(&s3Fetcher{})
+	r.RegisterFetcher(&sshFetcher{})
+	r.RegisterFetcher(&rsyncFetcher{})
+	r.RegisterFetcher(&hdfsFetcher{})
+	r.RegisterFetcher(&sqlFetcher{})
+	r.RegisterFetcher(&kafkaFetcher{})
+	r.RegisterFetcher(&commandFetcher{})
+
+	r.RegisterWrapper(&bzWrapper{})
+	r.RegisterWrapper(&gzWrapper{})
+	r.RegisterWrapper(&bgzfWrapper{})
+	r.RegisterWrapper(&xzWrapper{})
+	r.RegisterWrapper(&zstdWrapper{})
+	r.RegisterWrapper(&zipWrapper{})
+	r.RegisterWrapper(&sevenZipWrapper{})
+	r.RegisterWrapper(&tarballWrapper{})
+}
+
+// SetCache sets the Cache used by the Fetchers that r returns, instead of DefaultCache. This
+// allows programs (or tests) in the same binary to keep their downloads in isolated caches with
+// different policies.
+func (r *Registry) SetCache(c *Cache) {
+	r.mu.Lock()
+	r.cache = c
+	r.mu.Unlock()
+}
+
+// Cache returns the Cache used by r.
+func (r *Registry) Cache() *Cache {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cache == nil {
+		return DefaultCache
+	}
+	return r.cache
+}
+
+// RegisterFetcher adds f to the list of known Fetchers for use by r.GetFetcher
+func (r *Registry) RegisterFetcher(f Fetcher) {
+	r.mu.Lock()
+	r.fetchers = append(r.fetchers, f)
+	r.mu.Unlock()
+}
+
+// RegisterWrapper adds w to the list of known Wrappers for use by r.GetFetcher
+func (r *Registry) RegisterWrapper(w Wrapper) {
+	r.mu.Lock()
+	r.wrappers = append(r.wrappers, w)
+	r.mu.Unlock()
+}
+
+// GetFetcher returns a Fetcher (optionally wrapped by a matching Wrapper) that will work on the
+// specified resource string. It returns the first matching Fetcher in registration order,
+// wrapped by every matching Wrapper in registration order. Resources with mirrors (see
+// RegisterMirrors) return a Fetcher which tries each mirror in turn.
+func (r *Registry) GetFetcher(resource string) (Fetcher, error) {
+	if candidates := mirrorCandidates(resource); candidates != nil {
+		return &mirrorFetcher{registry: r, candidates: candidates}, nil
+	}
+	return r.getFetcher(resource)
+}
+
+// getFetcher returns the (wrapped) Fetcher for a single location of a resource.
+func (r *Registry) getFetcher(resource string) (Fetcher, error) {
+	rf, err := r.baseFetcher(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mainpath := resource
+	pathpart := ""
+	furl, err := url.Parse(resource)
+	if err == nil {
+		mainpath = furl.Path
+		pathpart = furl.Fragment
+	} else if strings.Contains(resource, "#") {
+		parts := strings.SplitN(resource, "#", 2)
+		mainpath = parts[0]
+		pathpart = parts[1]
+	}
+	mainpath += forcedSuffix(resource)
+	wrapped := false
+	for _, w := range r.wrappers {
+		w = newInstance(w).(Wrapper)
+		if w.DetectWrap(mainpath, pathpart) {
+			rf, err = w.Wrap(rf, pathpart)
+			wrapped = true
+		}
+	}
+	if !wrapped && DetectCompression {
+		rf = &sniffWrapper{registry: r, wrapped: rf, partname: pathpart}
+	}
+
+	return rf, err
+}
+
+// wrapperFor returns a new instance of the first Wrapper which detects pathname and partname,
+// or nil if there is none.
+func (r *Registry) wrapperFor(pathname, partname string) Wrapper {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, w := range r.wrappers {
+		w = newInstance(w).(Wrapper)
+		if w.DetectWrap(pathname, partname) {
+			return w
+		}
+	}
+	return nil
+}
+
+// baseFetcher returns a new instance of the first Fetcher which detects resource, without any
+// Wrappers applied. Resources split into parts (see RegisterParts) return a multi-part Fetcher.
+func (r *Registry) baseFetcher(resource string) (Fetcher, error) {
+	if parts := findParts(resource); parts != nil {
+		return &partsFetcher{registry: r, parts: parts}, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.fetchers {
+		if f.Detect(resource) {
+			nf := newInstance(f).(Fetcher)
+			if cu, ok := nf.(interface{ setCache(*Cache) }); ok && r.cache != nil {
+				cu.setCache(r.cache)
+			}
+			return nf, nil
+		}
+	}
+	return nil, fmt.Errorf("no defined fetchers match '%s'", resource)
+}
+
+// newInstance returns a shallow copy of a registered Fetcher or Wrapper, so that the
+// instances returned by GetFetcher do not share state and may be used concurrently.
+// Registered values that are not pointers to structs are returned as-is.
+func newInstance(x interface{}) interface{} {
+	v := reflect.ValueOf(x)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return x
+	}
+	nv := reflect.New(v.Elem().Type())
+	nv.Elem().Set(v.Elem())
+	return nv.Interface()
+}
@@ -1,21 +1,170 @@
 package anydata
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jlaffaye/ftp"
+	"github.com/pbnjay/anydata/metrics"
 )
 
+var (
+	// HTTPRetries is the number of times an interrupted HTTP download will be resumed (using a
+	// Range request) before giving up.
+	HTTPRetries = 3
+
+	// HTTPConnections is the number of concurrent connections used to download large files
+	// from servers which support Range requests. The file is split into chunks which are
+	// downloaded in parallel and reassembled in the cache before GetReader returns.
+	HTTPConnections = 1
+
+	// HTTPMinChunkSize is the smallest chunk (in bytes) downloaded by a single connection when
+	// HTTPConnections > 1. Files smaller than 2 chunks are always downloaded sequentially.
+	HTTPMinChunkSize int64 = 4 << 20
+)
+
+// HTTPOptions customizes the requests made by a single HTTP-based fetcher (http://, https://,
+// hdfs:// and Google Drive resources). Use SetHTTPOptions to apply them before calling Fetch.
+type HTTPOptions struct {
+	// Header is added to every request, replacing any headers of the same name (including
+	// those set from a CredentialProvider).
+	Header http.Header
+
+	// UserAgent replaces Go's default User-Agent header if it is set.
+	UserAgent string
+
+	// Jar stores and sends cookies, e.g. for sources which require a session cookie. The same
+	// jar may be shared by several fetchers.
+	Jar http.CookieJar
+
+	// DisableCompression stops requests from asking for gzip-compressed responses. By default
+	// compression is negotiated (and removed) transparently.
+	DisableCompression bool
+
+	// Proxy is used for all requests if it is set, in place of the proxy from SetProxy or the
+	// environment.
+	Proxy *url.URL
+
+	// TLSConfig is used for HTTPS connections if it is set, in place of the options from
+	// SetTLSOptions. See TLSOptions.Config.
+	TLSConfig *tls.Config
+}
+
+// HTTPConfigurable is implemented by Fetchers which accept HTTPOptions.
+type HTTPConfigurable interface {
+	SetHTTPOptions(opts *HTTPOptions)
+}
+
+// SetHTTPOptions applies opts to f, or to the fetcher wrapped by f (wrappers expose the fetcher
+// they read from with an Unwrap() Fetcher method). For example, to request a gzipped file from
+// an API which requires a key:
+//
+//    f, err := anydata.GetFetcher("https://api.example.com/v2/export.csv.gz")
+//    ...
+//    err = anydata.SetHTTPOptions(f, &anydata.HTTPOptions{
+//        Header:    http.Header{"X-Api-Key": {"abc123"}},
+//        UserAgent: "my-loader/1.0",
+//    })
+//
+// An error is returned if f does not make HTTP requests.
+func SetHTTPOptions(f Fetcher, opts *HTTPOptions) error {
+	for f != nil {
+		if hc, ok := f.(HTTPConfigurable); ok {
+			hc.SetHTTPOptions(opts)
+			return nil
+		}
+		uw, ok := f.(interface{ Unwrap() Fetcher })
+		if !ok {
+			break
+		}
+		f = uw.Unwrap()
+	}
+	return fmt.Errorf("%v does not support HTTP options", f)
+}
+
+// transportKey identifies the HTTPOptions which need their own Transport.
+type transportKey struct {
+	proxy         string
+	noCompression bool
+	tls           *tls.Config
+}
+
+var (
+	transportMu sync.Mutex
+	transports  = make(map[transportKey]*http.Transport)
+)
+
+// httpTransport returns the (shared) Transport to use for opts, so that connections are reused
+// across fetches with the same settings.
+func httpTransport(opts *HTTPOptions) http.RoundTripper {
+	key := transportKey{noCompression: opts.DisableCompression, tls: opts.TLSConfig}
+	if key.tls == nil {
+		key.tls = currentTLSConfig()
+	}
+	if opts.Proxy != nil {
+		key.proxy = opts.Proxy.String()
+	}
+
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	if tr, found := transports[key]; found {
+		return tr
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DisableCompression = opts.DisableCompression
+	if key.tls != nil {
+		tr.TLSClientConfig = key.tls.Clone()
+	}
+	tr.Proxy = proxyForRequest
+	if opts.Proxy != nil {
+		tr.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	transports[key] = tr
+	return tr
+}
+
 // An HTTP fetcher for both http:// and https:// URLs. Downloaded files are automatically stored
 // in the cache to save time/bandwidth. Supports HTTP Basic Auth within the URL.
+//
+// The response body is streamed to the reader returned by GetReader, and teed into the cache
+// as it is read. Once the cached copy is too old, it is revalidated using the ETag and
+// Last-Modified headers of the original response, so unchanged files are not downloaded again.
+//
+// If the connection fails part way through, the download is resumed using a Range request.
+// Data from a failed download is also kept in the cache, so that the next Fetch of the
+// resource only downloads the remainder. Servers which do not support Range requests (or
+// whose file has changed) will return the full file, which is handled transparently by Fetch,
+// but fails a download which was interrupted while reading, since the bytes already returned
+// can not be replaced.
 type httpFetcher struct {
-	data []byte
+	cacheRef
+
+	resource  string
+	localPath string
+	resp      *http.Response
+
+	// url is requested in place of resource if it is set (e.g. a resolved shared link)
+	url string
+	// client is used for all requests if it is set
+	client *http.Client
+	// prepare is called on each request before it is sent, if it is set
+	prepare func(req *http.Request) error
+
+	// offset is the length of the partial download that resp continues from
+	offset int64
+
+	opts HTTPOptions
 }
 
 func (n *httpFetcher) String() string {
@@ -32,52 +181,497 @@ func (n *httpFetcher) Detect(resource string) bool {
 	return false
 }
 
+// SetHTTPOptions sets the options used for subsequent requests.
+func (n *httpFetcher) SetHTTPOptions(opts *HTTPOptions) {
+	n.opts = *opts
+}
+
 func (n *httpFetcher) Fetch(resource string) error {
-	n.data = GetCachedFile(resource)
-	if n.data != nil {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *httpFetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "http")
+
+	n.resource = resource
+	n.localPath = n.cache().cachedFilePath(ctx, resource)
+	if n.localPath != "" {
 		return nil
 	}
 
-	furl, err := url.Parse(resource)
+	// check if an old cached copy is still current
+	var resp *http.Response
+	if fn, cinfo := n.cache().staleCachedFile(ctx, resource); fn != "" && (cinfo.ETag != "" || cinfo.LastModified != "") {
+		var err error
+		resp, err = n.revalidate(ctx, cinfo)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			if err = n.cache().touch(resource); err != nil {
+				Logf("%s\n", err.Error())
+			}
+			n.localPath = fn
+			return nil
+		}
+	}
+	if resp != nil {
+		// the file has changed, so this is a fresh download
+		return n.startDownload(ctx, resp)
+	}
+
+	// only resume if we can check that the remote file has not changed
+	offset, validator := n.cache().partialDownload(resource)
+	if validator == "" {
+		offset = 0
+	}
+	resp, err := n.get(ctx, offset, validator)
+	if err != nil && offset > 0 {
+		Logf("unable to resume download of '%s': %s\n", resource, err.Error())
+		resp, err = n.get(ctx, 0, "")
+	}
 	if err != nil {
 		return err
 	}
-	cli := &http.Client{}
-	req, err := http.NewRequest("GET", resource, nil)
+
+	if resp.StatusCode == http.StatusPartialContent {
+		n.setResponse(resp, offset)
+		return nil
+	}
+	return n.startDownload(ctx, resp)
+}
+
+// startDownload begins a fresh download using resp. If multiple connections are enabled and
+// the server supports Range requests, the file is downloaded in parallel into the cache.
+func (n *httpFetcher) startDownload(ctx context.Context, resp *http.Response) error {
+	if HTTPConnections < 2 || resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength < 2*HTTPMinChunkSize {
+		n.setResponse(resp, 0)
+		return nil
+	}
+
+	fn, err := n.parallelDownload(ctx, resp)
+	if err == nil {
+		n.localPath = fn
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+
+	Logf("parallel download of '%s' failed, retrying with one connection: %s\n", n.resource, err.Error())
+	resp, err = n.get(ctx, 0, "")
 	if err != nil {
 		return err
 	}
+	n.setResponse(resp, 0)
+	return nil
+}
+
+// setResponse saves a pending response to be read from offset by GetReader.
+func (n *httpFetcher) setResponse(resp *http.Response, offset int64) {
+	if n.resp != nil {
+		n.resp.Body.Close()
+	}
+	n.resp = resp
+	n.offset = offset
+}
+
+// httpClient returns the client used to make requests.
+func (n *httpFetcher) httpClient() *http.Client {
+	if n.client != nil {
+		return n.client
+	}
+	return &http.Client{Jar: n.opts.Jar, Transport: httpTransport(&n.opts)}
+}
+
+// newRequest creates a GET request for the resource, including any Basic Auth in the URL and
+// the custom headers from the HTTPOptions.
+func (n *httpFetcher) newRequest(ctx context.Context) (*http.Request, error) {
+	u := n.resource
+	if n.url != "" {
+		u = n.url
+	}
+	furl, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
 	if furl.User != nil {
 		passwd, _ := furl.User.Password()
 		req.SetBasicAuth(furl.User.Username(), passwd)
+	} else if c := lookupCredentials(furl.Scheme, furl.Host); c != nil {
+		if err = c.applyHTTP(req); err != nil {
+			return nil, err
+		}
+	}
+	for name, values := range n.opts.Header {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
 	}
-	resp, err := cli.Do(req)
+	if n.opts.UserAgent != "" {
+		req.Header.Set("User-Agent", n.opts.UserAgent)
+	}
+	if n.prepare != nil {
+		if err = n.prepare(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// revalidate makes a conditional request for the resource, which returns a 304 Not Modified
+// response if the cached copy described by cinfo is still current.
+func (n *httpFetcher) revalidate(ctx context.Context, cinfo cachedfile) (*http.Response, error) {
+	req, err := n.newRequest(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if cinfo.ETag != "" {
+		req.Header.Set("If-None-Match", cinfo.ETag)
 	}
+	if cinfo.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cinfo.LastModified)
+	}
+	resp, err := n.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotModified && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http fetch of '%s' failed: %s", n.resource, resp.Status)
+	}
+	return resp, nil
+}
+
+// get requests the resource starting at offset. If validator is not empty it is sent as an
+// If-Range header, so that the full file is returned if it has changed.
+func (n *httpFetcher) get(ctx context.Context, offset int64, validator string) (*http.Response, error) {
+	return n.getRange(ctx, offset, -1, validator)
+}
 
-	n.data, err = ioutil.ReadAll(resp.Body)
+// getRange is like get, but only requests up to (and including) byte end if it is not -1.
+func (n *httpFetcher) getRange(ctx context.Context, offset, end int64, validator string) (*http.Response, error) {
+	req, err := n.newRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 || end >= 0 {
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	resp, err := n.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http fetch of '%s' failed: %s", n.resource, resp.Status)
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		var start int64 = -1
+		fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start)
+		if start != offset {
+			resp.Body.Close()
+			return nil, fmt.Errorf("http fetch of '%s' failed: unexpected Content-Range '%s'",
+				n.resource, resp.Header.Get("Content-Range"))
+		}
+	}
+	return resp, nil
+}
+
+// Stat makes a HEAD request for resource, or requests its first byte if the server does not
+// allow HEAD requests.
+func (n *httpFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	n.resource = resource
+	req, err := n.newRequest(ctx)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	req.Method = "HEAD"
+	resp, err := n.httpClient().Do(req)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
 	resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		if resp, err = n.getRange(ctx, 0, 0, ""); err != nil {
+			return ResourceInfo{}, err
+		}
+		resp.Body.Close()
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ResourceInfo{}, fmt.Errorf("http stat of '%s' failed: %s", resource, resp.Status)
+	}
 
-	PutCachedFile(resource, n.data)
-	return err
+	info := ResourceInfo{Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type"),
+		ETag: resp.Header.Get("ETag")}
+	if resp.StatusCode == http.StatusPartialContent {
+		info.Size = -1
+		var start, end int64
+		fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &info.Size)
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// httpValidator returns the ETag or Last-Modified header of resp, which identify the version
+// of the remote file for If-Range requests.
+func httpValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
 }
 
 func (n *httpFetcher) GetReader() (io.Reader, error) {
-	if n.data == nil || len(n.data) == 0 {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *httpFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.localPath != "" {
+		f, err := n.cache().openFile(n.localPath)
+		if err != nil {
+			return nil, err
+		}
+		return contextReader(ctx, f), nil
+	}
+	if n.resource == "" {
 		return nil, fmt.Errorf("reading from http source failed (did you call Fetch?)")
 	}
 
-	return bytes.NewReader(n.data), nil
+	if n.resp == nil {
+		// a previous reader already consumed the response, so start over
+		if err := n.FetchContext(ctx, n.resource); err != nil {
+			return nil, err
+		}
+		if n.localPath != "" {
+			return n.GetReaderContext(ctx)
+		}
+	}
+
+	resp, offset := n.resp, n.offset
+	n.resp = nil
+	body := &httpBody{n: n, ctx: ctx, body: resp.Body, pos: offset, validator: httpValidator(resp)}
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	tee := &cacheTee{r: reportProgress(n.resource, body, offset, total), label: "http", resumable: true,
+		done: func(fn string) { n.localPath = fn }}
+
+	cw, err := n.cache().openCacheWriter(n.resource, offset > 0)
+	if err != nil && offset > 0 {
+		// the response only has the rest of the file
+		tee.Close()
+		return nil, err
+	}
+	if err != nil {
+		Logf("%s\n", err.Error())
+		return contextReader(ctx, readCloser(limitReader(ctx, n.resource, tee), tee)), nil
+	}
+	tee.cw = cw
+	cw.SetValidators(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	if offset == 0 {
+		cw.SetResumeInfo(body.validator)
+		return contextReader(ctx, readCloser(limitReader(ctx, n.resource, tee), tee)), nil
+	}
+
+	// replay the previously downloaded data before continuing with the response
+	if st, err := cw.f.Stat(); err != nil || st.Size() < offset {
+		// another download of the resource started writing since Fetch
+		tee.Close()
+		return nil, fmt.Errorf("http fetch of '%s' failed: partial download is no longer available", n.resource)
+	}
+	pf, err := os.Open(cw.f.Name())
+	if err != nil {
+		tee.Close()
+		return nil, err
+	}
+	r := io.MultiReader(io.LimitReader(pf, offset), limitReader(ctx, n.resource, tee))
+	return contextReader(ctx, readCloser(r, pf, tee)), nil
+}
+
+// httpBody reads an HTTP response body, and resumes the download with a Range request if the
+// connection fails part way through.
+type httpBody struct {
+	n         *httpFetcher
+	ctx       context.Context
+	body      io.ReadCloser
+	pos       int64
+	validator string
+	retries   int
+}
+
+func (b *httpBody) Read(p []byte) (int, error) {
+	for {
+		nr, err := b.body.Read(p)
+		b.pos += int64(nr)
+		// only resume if we can check that the remote file has not changed
+		if err == nil || err == io.EOF || b.ctx.Err() != nil || b.retries >= HTTPRetries || b.validator == "" {
+			return nr, err
+		}
+
+		b.retries++
+		Logf("resuming download of '%s' at %d bytes: %s\n", b.n.resource, b.pos, err.Error())
+		resp, rerr := b.n.get(b.ctx, b.pos, b.validator)
+		if rerr != nil {
+			return nr, err
+		}
+		if resp.StatusCode != http.StatusPartialContent || httpValidator(resp) != b.validator {
+			// the file may have changed, so the bytes already read can not be continued
+			resp.Body.Close()
+			return nr, fmt.Errorf("http fetch of '%s' failed: server did not resume the download at %d bytes",
+				b.n.resource, b.pos)
+		}
+		b.body.Close()
+		b.body = resp.Body
+		if nr > 0 {
+			return nr, nil
+		}
+	}
+}
+
+func (b *httpBody) Close() error {
+	return b.body.Close()
+}
+
+// parallelDownload downloads the file in HTTPConnections chunks concurrently, writing each into
+// place in a new cache entry. The body of resp (a full response) is used for the first chunk.
+func (n *httpFetcher) parallelDownload(ctx context.Context, resp *http.Response) (string, error) {
+	size := resp.ContentLength
+	nconn := int64(HTTPConnections)
+	chunk := (size + nconn - 1) / nconn
+	if chunk < HTTPMinChunkSize {
+		chunk = HTTPMinChunkSize
+		nconn = (size + chunk - 1) / chunk
+	}
+
+	cw, err := n.cache().newCacheWriter(n.resource)
+	if err != nil {
+		resp.Body.Close()
+		return "", err
+	}
+	cw.SetValidators(resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+	if err = cw.f.Truncate(size); err != nil {
+		resp.Body.Close()
+		cw.Abort()
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	validator := httpValidator(resp)
+	tracker := trackProgress(n.resource, 0, size)
+	errs := make(chan error, nconn)
+	for i := int64(0); i < nconn; i++ {
+		start, end := i*chunk, (i+1)*chunk-1
+		if end >= size {
+			end = size - 1
+		}
+		var body io.ReadCloser
+		if i == 0 {
+			body = contextReader(ctx, resp.Body)
+		}
+		go func() {
+			err := n.downloadChunk(ctx, &chunkWriter{f: cw.f, pos: start, t: tracker}, body, end, validator)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}()
+	}
+
+	for i := int64(0); i < nconn; i++ {
+		if cerr := <-errs; cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		cw.Abort()
+		return "", err
+	}
+	tracker.add(0, true)
+	return cw.Commit()
+}
+
+// downloadChunk writes bytes w.pos through end of the file to w, using body if it is not nil.
+func (n *httpFetcher) downloadChunk(ctx context.Context, w *chunkWriter, body io.ReadCloser, end int64, validator string) error {
+	for retries := 0; ; retries++ {
+		if body == nil {
+			resp, err := n.getRange(ctx, w.pos, end, validator)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
+				return fmt.Errorf("server did not honor range request")
+			}
+			body = resp.Body
+		}
+
+		_, err := io.Copy(w, limitReader(ctx, n.resource, io.LimitReader(body, end-w.pos+1)))
+		body.Close()
+		body = nil
+		if err == nil && w.pos <= end {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || ctx.Err() != nil || retries >= HTTPRetries {
+			return err
+		}
+	}
+}
+
+// chunkWriter writes sequentially to a file starting at pos, recording download metrics.
+type chunkWriter struct {
+	f   *os.File
+	pos int64
+	t   *progressTracker
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.pos)
+	w.pos += int64(n)
+	metrics.Add(metrics.BytesDownloaded, float64(n), "fetcher", "http")
+	w.t.add(int64(n), false)
+	return n, err
 }
 
 ///////////////////
 
-// An FTP fetcher for both ftp:// URLs. Downloaded files are automatically stored in the cache to
-// save time/bandwidth. Uses anonymous authentication by default, so supply username/password in
-// the URL if required.
+// An FTP fetcher for both ftp:// and ftps:// URLs. Downloaded files are automatically stored in
+// the cache to save time/bandwidth. Uses anonymous authentication by default, so supply
+// username/password in the URL if required.
+//
+// ftps:// URLs use implicit TLS on the default port (990), or explicit TLS (AUTH TLS) if another
+// port is given, e.g. ftps://ftp.example.org:21/data.txt. See SetTLSOptions to trust private
+// certificate authorities.
+//
+// The file is streamed to the reader returned by GetReader, and teed into the cache as it is
+// read. The FTP connection is closed when the reader is closed.
 type ftpFetcher struct {
-	data []byte
+	cacheRef
+
+	resource  string
+	localPath string
+	conn      *ftp.ServerConn
+	resp      *ftp.Response
+	size      int64
 }
 
 func (n *ftpFetcher) String() string {
@@ -85,12 +679,19 @@ func (n *ftpFetcher) String() string {
 }
 
 func (n *ftpFetcher) Detect(resource string) bool {
-	return strings.HasPrefix(resource, "ftp://")
+	return strings.HasPrefix(resource, "ftp://") || strings.HasPrefix(resource, "ftps://")
 }
 
 func (n *ftpFetcher) Fetch(resource string) error {
-	n.data = GetCachedFile(resource)
-	if n.data != nil {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *ftpFetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "ftp")
+
+	n.resource = resource
+	n.localPath = n.cache().cachedFilePath(ctx, resource)
+	if n.localPath != "" {
 		return nil
 	}
 
@@ -98,16 +699,30 @@ func (n *ftpFetcher) Fetch(resource string) error {
 	if err != nil {
 		return err
 	}
+	ftpCli, err := ftpConnect(ctx, furl)
+	if err != nil {
+		return err
+	}
 
-	if !strings.Contains(furl.Host, ":") {
-		furl.Host = furl.Host + ":21"
+	size, err := ftpCli.FileSize(furl.Path)
+	if err != nil {
+		size = -1
 	}
-	ftpCli, err := ftp.Connect(furl.Host)
+	resp, err := ftpCli.Retr(furl.Path)
 	if err != nil {
+		ftpCli.Quit()
 		return err
 	}
-	defer ftpCli.Quit()
 
+	n.closeConn()
+	n.conn = ftpCli
+	n.resp = resp
+	n.size = size
+	return nil
+}
+
+// ftpConnect opens and logs in to an FTP connection for furl.
+func ftpConnect(ctx context.Context, furl *url.URL) (*ftp.ServerConn, error) {
 	fusername := "anonymous"
 	fpassword := "anythingoes"
 
@@ -117,30 +732,129 @@ func (n *ftpFetcher) Fetch(resource string) error {
 			fpassword = passwd
 		}
 		fusername = furl.User.Username()
+	} else if c := lookupCredentials(furl.Scheme, furl.Host); c != nil && c.Username != "" {
+		fusername, fpassword = c.Username, c.Password
+	}
+
+	var opts []ftp.DialOption
+	var tlsCfg *tls.Config
+	implicitTLS := false
+	if furl.Scheme == "ftps" {
+		tlsCfg = &tls.Config{}
+		if cfg := currentTLSConfig(); cfg != nil {
+			tlsCfg = cfg.Clone()
+		}
+		tlsCfg.ServerName = furl.Hostname()
+		// many servers require data connections to resume the control connection's session
+		tlsCfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		implicitTLS = furl.Port() == "" || furl.Port() == "990"
+		if implicitTLS {
+			opts = append(opts, ftp.DialWithTLS(tlsCfg))
+		} else {
+			opts = append(opts, ftp.DialWithExplicitTLS(tlsCfg))
+		}
+		if furl.Port() == "" {
+			furl.Host = furl.Host + ":990"
+		}
+	}
+	if furl.Port() == "" {
+		furl.Host = furl.Host + ":21"
+	}
+
+	// the ftp package leaves TLS to the dial function (except for explicit TLS on the control
+	// connection, which is the first one dialed)
+	control := true
+	opts = append(opts, ftp.DialWithDialFunc(func(network, address string) (net.Conn, error) {
+		conn, err := dialProxy(ctx, "ftp", address)
+		if err == nil && tlsCfg != nil && (implicitTLS || !control) {
+			conn = tls.Client(conn, tlsCfg)
+		}
+		control = false
+		return conn, err
+	}))
+	ftpCli, err := ftp.Dial(furl.Host, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	err = ftpCli.Login(fusername, fpassword)
 	if err != nil {
-		return err
+		ftpCli.Quit()
+		return nil, err
 	}
-	defer ftpCli.Logout()
+	return ftpCli, nil
+}
 
-	resp, err := ftpCli.Retr(furl.Path)
+// Stat uses the FTP SIZE and MDTM commands, which most servers support.
+func (n *ftpFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	furl, err := url.Parse(resource)
 	if err != nil {
-		return err
+		return ResourceInfo{}, err
+	}
+	ftpCli, err := ftpConnect(ctx, furl)
+	if err != nil {
+		return ResourceInfo{}, err
 	}
+	defer ftpCli.Quit()
 
-	n.data, err = ioutil.ReadAll(resp)
-	resp.Close()
+	info := ResourceInfo{Size: -1, ContentType: mime.TypeByExtension(path.Ext(furl.Path))}
+	if info.Size, err = ftpCli.FileSize(furl.Path); err != nil {
+		return ResourceInfo{}, fmt.Errorf("ftp stat of '%s' failed: %s", resource, err.Error())
+	}
+	if t, err := ftpCli.GetTime(furl.Path); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
 
-	PutCachedFile(resource, n.data)
-	return err
+// closeConn closes any pending (unread) FTP transfer.
+func (n *ftpFetcher) closeConn() {
+	if n.resp != nil {
+		n.resp.Close()
+		n.conn.Quit()
+		n.resp = nil
+		n.conn = nil
+	}
 }
 
 func (n *ftpFetcher) GetReader() (io.Reader, error) {
-	if n.data == nil || len(n.data) == 0 {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *ftpFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.localPath != "" {
+		f, err := n.cache().openFile(n.localPath)
+		if err != nil {
+			return nil, err
+		}
+		return contextReader(ctx, f), nil
+	}
+	if n.resource == "" {
 		return nil, fmt.Errorf("reading from ftp source failed (did you call Fetch?)")
 	}
 
-	return bytes.NewReader(n.data), nil
+	if n.resp == nil {
+		// a previous reader already consumed the transfer, so start over
+		if err := n.FetchContext(ctx, n.resource); err != nil {
+			return nil, err
+		}
+		if n.localPath != "" {
+			return n.GetReaderContext(ctx)
+		}
+	}
+
+	resp, conn := n.resp, n.conn
+	n.resp, n.conn = nil, nil
+	body := reportProgress(n.resource, resp, 0, n.size)
+	tee := n.cache().newCacheTee(n.resource, body, "ftp", func(fn string) { n.localPath = fn })
+	return contextReader(ctx, readCloser(limitReader(ctx, n.resource, tee), tee, ftpQuitter{conn})), nil
+}
+
+// ftpQuitter closes an FTP connection.
+type ftpQuitter struct {
+	conn *ftp.ServerConn
+}
+
+func (q ftpQuitter) Close() error {
+	return q.conn.Quit()
 }
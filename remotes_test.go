@@ -0,0 +1,744 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestHTTPResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := []byte(strings.Repeat("0123456789abcdef", 4096))
+	modtime := time.Now()
+	var failNext int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") == "" && atomic.CompareAndSwapInt32(&failNext, 1, 0) {
+			// drop the connection half way through the body
+			w.Header().Set("Content-Length", "65536")
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "data.txt", modtime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	read := func(resource string) ([]byte, error) {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return nil, err
+		}
+		if err = f.Fetch(resource); err != nil {
+			return nil, err
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+
+	// resumed within a single read
+	data, err := read(srv.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("resumed download has %d bytes, expected %d", len(data), len(content))
+	}
+
+	// resumed by a later fetch
+	anydata.HTTPRetries = 0
+	defer func() { anydata.HTTPRetries = 3 }()
+	atomic.StoreInt32(&failNext, 1)
+	if _, err = read(srv.URL + "/b.txt"); err == nil {
+		t.Fatal("expected interrupted download to fail")
+	}
+	data, err = read(srv.URL + "/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("resumed download has %d bytes, expected %d", len(data), len(content))
+	}
+	if anydata.GetCachedFile(srv.URL+"/b.txt") == nil {
+		t.Fatal("resumed download was not cached")
+	}
+}
+
+func TestHTTPResumeChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	v1 := []byte(strings.Repeat("0123456789abcdef", 4096))
+	v2 := []byte(strings.Repeat("fedcba9876543210", 4096))
+	modtime := time.Now()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// drop the connection half way through the first version
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", "65536")
+			w.Write(v1[:len(v1)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		// the file changed, so the If-Range request gets the whole file
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "data.txt", modtime, bytes.NewReader(v2))
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/a.txt"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(r); err == nil {
+		t.Fatalf("expected the resumed download to fail, got %d bytes", len(data))
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+	if anydata.GetCachedFile(resource) != nil {
+		t.Fatal("changed download was cached")
+	}
+}
+
+func TestHTTPRevalidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var downloads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Write([]byte("hello world\n"))
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/data.txt"
+	fetch := func() {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if string(data) != "hello world\n" {
+			t.Fatalf("unexpected contents %q", data)
+		}
+	}
+	fetch()
+
+	// age the cache entry so that it must be revalidated
+	infoName := filepath.Join(dir, "cacheinfo.json")
+	idx := make(map[string]map[string]interface{})
+	data, _ := ioutil.ReadFile(infoName)
+	if err = json.Unmarshal(data, &idx); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range idx {
+		entry["fetch_timestamp"] = time.Now().Add(-48 * time.Hour)
+	}
+	data, _ = json.Marshal(idx)
+	ioutil.WriteFile(infoName, data, 0666)
+	anydata.InitCache(dir, 1)
+
+	fetch()
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Fatalf("expected 1 download, got %d", n)
+	}
+}
+
+func TestBandwidthLimitContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := bytes.Repeat([]byte("x"), 64<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	read := func(ctx context.Context, resource string) time.Duration {
+		start := time.Now()
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = anydata.FetchContext(ctx, f, resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := anydata.GetReaderContext(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if data, err := ioutil.ReadAll(r); err != nil || len(data) != len(content) {
+			t.Fatalf("read %d bytes: %v", len(data), err)
+		}
+		return time.Since(start)
+	}
+
+	// the first 32KiB are allowed as a burst, so the rest takes a second
+	limited := anydata.WithBandwidthLimit(context.Background(), 32<<10)
+	if d := read(limited, srv.URL+"/a"); d < 500*time.Millisecond {
+		t.Errorf("limited download took %s", d)
+	}
+	// other fetches are not limited
+	if d := read(context.Background(), srv.URL+"/b"); d > 500*time.Millisecond {
+		t.Errorf("unlimited download took %s", d)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := []byte(strings.Repeat("0123456789abcdef", 4096))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "65536")
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	var last anydata.Progress
+	anydata.SetProgressReporter(anydata.ProgressFunc(func(p anydata.Progress) {
+		last = p
+	}))
+	defer anydata.SetProgressReporter(nil)
+
+	resource := srv.URL + "/data.txt"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+
+	if !last.Done || last.Bytes != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+	if last.Percent() != 100 {
+		t.Fatalf("expected 100%%, got %f", last.Percent())
+	}
+}
+
+func TestHTTPParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	anydata.HTTPConnections, anydata.HTTPMinChunkSize = 4, 1024
+	defer func() { anydata.HTTPConnections, anydata.HTTPMinChunkSize = 1, 4<<20 }()
+
+	content := []byte(strings.Repeat("0123456789abcdef", 4096))
+	var ranges int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranges, 1)
+		}
+		http.ServeContent(w, r, "data.txt", time.Now(), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/data.txt"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(data, content) {
+		t.Fatalf("parallel download has %d bytes, expected %d", len(data), len(content))
+	}
+	if n := atomic.LoadInt32(&ranges); n != 3 {
+		t.Fatalf("expected 3 range requests, got %d", n)
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello\n"))
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "s1" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "abc123" || r.UserAgent() != "anydata-test/1.0" {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	resp, err := (&http.Client{Jar: jar}).Get(srv.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resource := srv.URL + "/data.txt.gz"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = anydata.SetHTTPOptions(f, &anydata.HTTPOptions{
+		Header:    http.Header{"x-api-key": {"abc123"}},
+		UserAgent: "anydata-test/1.0",
+		Jar:       jar,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "hello\n" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+
+	lf, _ := anydata.GetFetcher("data:,hello")
+	if anydata.SetHTTPOptions(lf, &anydata.HTTPOptions{}) == nil {
+		t.Fatal("expected error setting HTTP options on a data URI fetcher")
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "data.example.invalid" {
+			http.Error(w, "unexpected host", http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt32(&proxied, 1)
+		w.Write([]byte("via proxy\n"))
+	}))
+	defer proxy.Close()
+
+	if err = anydata.SetProxy("http", proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	defer anydata.SetProxy("http", "")
+
+	resource := "http://data.example.invalid/file.txt"
+	f, err := anydata.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "via proxy\n" || atomic.LoadInt32(&proxied) != 1 {
+		t.Fatalf("request was not proxied (got %q)", data)
+	}
+}
+
+func TestHTTPSPrivateCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(filepath.Join(dir, "cache"), 1)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure\n"))
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/data.txt"
+	f, _ := anydata.GetFetcher(resource)
+	if err = f.Fetch(resource); err == nil {
+		t.Fatal("expected certificate verification to fail")
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = anydata.SetTLSOptions(&anydata.TLSOptions{CAFile: caFile, MinVersion: tls.VersionTLS12}); err != nil {
+		t.Fatal(err)
+	}
+	defer anydata.SetTLSOptions(nil)
+
+	f, _ = anydata.GetFetcher(resource)
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "secure\n" {
+		t.Fatalf("unexpected contents %q", data)
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var tgz bytes.Buffer
+	zw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(zw)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+	zw.Close()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/data.tar.gz":
+			w.Write(tgz.Bytes())
+		case "/plain.txt":
+			w.Write([]byte("plain"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	resources := []string{
+		srv.URL + "/data.tar.gz#a.txt",
+		srv.URL + "/plain.txt",
+		srv.URL + "/data.tar.gz#c.txt",
+		srv.URL + "/missing.txt",
+		srv.URL + "/data.tar.gz#b.txt",
+	}
+	expect := []string{"a.txt", "plain", "c.txt", "", "b.txt"}
+	results := anydata.FetchAll(resources, &anydata.FetchOptions{Workers: 3})
+	for i, res := range results {
+		if res.Resource != resources[i] {
+			t.Fatalf("result %d is for '%s'", i, res.Resource)
+		}
+		if expect[i] == "" {
+			if res.Err == nil {
+				t.Fatalf("expected an error for '%s'", res.Resource)
+			}
+			continue
+		}
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		data, _ := ioutil.ReadAll(res.Reader)
+		res.Reader.Close()
+		if string(data) != expect[i] {
+			t.Fatalf("unexpected contents %q for '%s'", data, res.Resource)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/missing.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("reference data"))
+	}))
+	defer srv.Close()
+
+	resources := []string{srv.URL + "/a.txt", srv.URL + "/b.txt", srv.URL + "/missing.txt"}
+	var done int32
+	opts := &anydata.FetchOptions{Workers: 2, Done: func(resource string, err error) {
+		atomic.AddInt32(&done, 1)
+	}}
+	errs := anydata.Prefetch(resources, opts)
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if n := atomic.LoadInt32(&done); n != 3 {
+		t.Errorf("expected 3 Done calls, got %d", n)
+	}
+	if data := anydata.GetCachedFile(srv.URL + "/b.txt"); string(data) != "reference data" {
+		t.Errorf("expected b.txt to be cached, got %q", data)
+	}
+
+	// cached files are not downloaded again
+	anydata.Prefetch(resources[:2], nil)
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	var mirrored int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&mirrored, 1)
+		w.Write([]byte("mirrored\n"))
+	}))
+	defer mirror.Close()
+
+	anydata.RegisterMirrors(primary.URL+"/pub/", mirror.URL+"/mirror/pub/")
+
+	// the second fetch (from the mirror URL itself) is served from the same cache entry
+	for _, resource := range []string{primary.URL + "/pub/data.txt", mirror.URL + "/mirror/pub/data.txt"} {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		if string(data) != "mirrored\n" {
+			t.Fatalf("unexpected contents %q", data)
+		}
+	}
+	if n := atomic.LoadInt32(&mirrored); n != 1 {
+		t.Fatalf("expected 1 mirror request, got %d", n)
+	}
+}
+
+func TestStat(t *testing.T) {
+	modtime := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	content := []byte(strings.Repeat("x", 1234))
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/csv")
+		http.ServeContent(w, r, "data.csv", modtime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	info, err := anydata.Stat(srv.URL + "/data.csv.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 1234 || !info.ModTime.Equal(modtime) || info.ContentType != "text/csv" || info.ETag != `"v1"` {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if atomic.LoadInt32(&gets) != 0 {
+		t.Fatal("Stat downloaded the file")
+	}
+
+	f, err := ioutil.TempFile("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(content[:100])
+	f.Close()
+	if info, err = anydata.Stat(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 100 {
+		t.Fatalf("unexpected local file size %d", info.Size)
+	}
+}
+
+func TestExpandHTTPIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"README", "a/x.tsv.gz", "a/y.txt", "b/z.tsv.gz", "c/d/w.tsv.gz"} {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err = ioutil.WriteFile(fn, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	tests := map[string][]string{
+		"/*/*.tsv.gz":       {"/a/x.tsv.gz", "/b/z.tsv.gz"},
+		"/**/*.tsv.gz#data": {"/a/x.tsv.gz#data", "/b/z.tsv.gz#data", "/c/d/w.tsv.gz#data"},
+		"/a/":               {"/a/x.tsv.gz", "/a/y.txt"},
+		"/c/**":             {"/c/d/w.tsv.gz"},
+		"/README":           {"/README"},
+	}
+	for pattern, expect := range tests {
+		got, err := anydata.ExpandResources(srv.URL + pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(expect) {
+			t.Fatalf("%s: expected %v, got %v", pattern, expect, got)
+		}
+		for i := range got {
+			if got[i] != srv.URL+expect[i] {
+				t.Fatalf("%s: expected %v, got %v", pattern, expect, got)
+			}
+		}
+	}
+}
+
+func TestSQLFetcher(t *testing.T) {
+	f, err := anydata.GetFetcher("sqlite:///data/local.db?query=SELECT+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no driver is imported by the tests
+	if err = f.Fetch("sqlite:///data/local.db?query=SELECT+1"); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("expected a missing driver error, got %v", err)
+	}
+	if err = f.Fetch("sqlite:///data/local.db"); err == nil || !strings.Contains(err.Error(), "no query") {
+		t.Errorf("expected a missing query error, got %v", err)
+	}
+}
+
+func TestKafkaResources(t *testing.T) {
+	for _, resource := range []string{
+		"kafka://broker:9092",
+		"kafka://broker:9092/topic?offset=middle",
+		"kafka://broker:9092/topic?group=app&partition=1",
+		"kafka://broker:9092/topic?idle=soon",
+	} {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err == nil {
+			t.Errorf("expected an error for '%s'", resource)
+		}
+	}
+}
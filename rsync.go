@@ -0,0 +1,138 @@
+package anydata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// RsyncCommand is the rsync executable used to fetch rsync:// resources.
+var RsyncCommand = "rsync"
+
+// An rsync fetcher for rsync:// URLs, which runs the rsync command to download files into the
+// cache. When a cached copy exists but is too old, it is used as the basis for rsync's delta
+// transfer, so only the changed parts of the file are downloaded. An interrupted transfer is
+// also kept as the basis for the next attempt.
+//
+// Unlike the other remote fetchers the file is downloaded completely by Fetch, before it can
+// be read.
+type rsyncFetcher struct {
+	cacheRef
+
+	localPath string
+}
+
+func (n *rsyncFetcher) String() string {
+	return "rsync Download"
+}
+
+func (n *rsyncFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "rsync://")
+}
+
+func (n *rsyncFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *rsyncFetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "rsync")
+
+	n.localPath = n.cache().cachedFilePath(ctx, resource)
+	if n.localPath != "" {
+		return nil
+	}
+
+	cw, err := n.cache().openCacheWriter(resource, true)
+	if err != nil {
+		return err
+	}
+
+	// seed the transfer with the old cached copy if there is no partial download
+	if st, err := cw.f.Stat(); err == nil && st.Size() == 0 {
+		if oldPath, _ := n.cache().staleCachedFile(ctx, resource); oldPath != "" {
+			if old, err := openCachedFile(oldPath); err == nil {
+				_, err = io.Copy(cw.f, old)
+				old.Close()
+				if err != nil {
+					cw.Abort()
+					return err
+				}
+			}
+		}
+	}
+
+	src := strings.SplitN(resource, "#", 2)[0]
+	stderr := &bytes.Buffer{}
+	args := []string{"--quiet", "--times", "--partial"}
+	if limit := bandwidthLimit(ctx, resource); limit > 0 {
+		// rsync enforces the limit itself, in KiB/s
+		args = append(args, fmt.Sprintf("--bwlimit=%d", (limit+1023)/1024))
+	}
+	cmd := exec.CommandContext(ctx, RsyncCommand, append(args, src, cw.f.Name())...)
+	cmd.Stderr = stderr
+	if err = cmd.Run(); err != nil {
+		cw.Suspend()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("rsync of '%s' failed: %s", resource, msg)
+		}
+		return fmt.Errorf("rsync of '%s' failed: %s", resource, err.Error())
+	}
+
+	n.localPath, err = cw.Commit()
+	return err
+}
+
+// Stat runs "rsync --list-only" to read the size and modification time of the remote file.
+func (n *rsyncFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	src := strings.SplitN(resource, "#", 2)[0]
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, RsyncCommand, "--list-only", src)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return ResourceInfo{}, fmt.Errorf("rsync stat of '%s' failed: %s", resource, msg)
+		}
+		return ResourceInfo{}, fmt.Errorf("rsync stat of '%s' failed: %s", resource, err.Error())
+	}
+
+	// e.g. "-rw-r--r--      1,234,567 2021/03/04 05:06:07 names.dmp"
+	fields := strings.Fields(stdout.String())
+	if len(fields) < 5 {
+		return ResourceInfo{}, fmt.Errorf("rsync stat of '%s' failed: unexpected listing '%s'",
+			resource, strings.TrimSpace(stdout.String()))
+	}
+	size, err := strconv.ParseInt(strings.Replace(fields[1], ",", "", -1), 10, 64)
+	if err != nil {
+		return ResourceInfo{}, fmt.Errorf("rsync stat of '%s' failed: %s", resource, err.Error())
+	}
+	info := ResourceInfo{Size: size, ContentType: mime.TypeByExtension(path.Ext(src))}
+	if t, err := time.ParseInLocation("2006/01/02 15:04:05", fields[2]+" "+fields[3], time.Local); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+func (n *rsyncFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *rsyncFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.localPath == "" {
+		return nil, fmt.Errorf("reading from rsync source failed (did you call Fetch?)")
+	}
+	f, err := n.cache().openFile(n.localPath)
+	if err != nil {
+		return nil, err
+	}
+	return contextReader(ctx, f), nil
+}
@@ -0,0 +1,99 @@
+package anydata_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+// fakeRsync replaces RsyncCommand with a shell script which logs its arguments to the returned
+// file, and "downloads" a small file.
+func fakeRsync(t *testing.T, dir string) string {
+	log := filepath.Join(dir, "rsync.log")
+	script := filepath.Join(dir, "rsync")
+	err := ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> '`+log+`'
+if [ "$1" = "--list-only" ]; then
+	echo "-rw-r--r--      1,234 2021/03/04 05:06:07 names.dmp"
+	exit 0
+fi
+case "$*" in
+*missing*) echo "rsync: link_stat failed: No such file or directory (2)" >&2; exit 23;;
+esac
+for dest; do :; done
+printf 'id\tname\n1\ta\n' > "$dest"
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := anydata.RsyncCommand
+	anydata.RsyncCommand = script
+	t.Cleanup(func() { anydata.RsyncCommand = old })
+	return log
+}
+
+func TestRsyncFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(filepath.Join(dir, "cache"), 1)
+	log := fakeRsync(t, dir)
+
+	fetch := func(ctx context.Context, resource string) (string, error) {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return "", err
+		}
+		if err = anydata.FetchContext(ctx, f, resource); err != nil {
+			return "", err
+		}
+		r, err := anydata.GetReaderContext(ctx, f)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	ctx := anydata.WithBandwidthLimit(context.Background(), 100000)
+	data, err := fetch(ctx, "rsync://ftp.example.org/pub/names.tsv")
+	if err != nil || data != "id\tname\n1\ta\n" {
+		t.Fatalf("unexpected rsync read %q: %v", data, err)
+	}
+	_, err = fetch(context.Background(), "rsync://ftp.example.org/pub/missing.tsv")
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("expected the rsync error message, got %v", err)
+	}
+
+	logged, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(logged)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected rsync commands:\n%s", logged)
+	}
+	// the limit is rounded up to KiB/s
+	if !strings.HasPrefix(lines[0], "--quiet --times --partial --bwlimit=98 rsync://ftp.example.org/pub/names.tsv ") {
+		t.Errorf("unexpected rsync arguments: %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "--quiet --times --partial rsync://ftp.example.org/pub/missing.tsv ") {
+		t.Errorf("unexpected rsync arguments: %s", lines[1])
+	}
+
+	info, err := anydata.Stat("rsync://ftp.example.org/pub/names.dmp#0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 1234 || info.ModTime.Year() != 2021 {
+		t.Errorf("unexpected stat: %+v", info)
+	}
+}
@@ -0,0 +1,199 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// An S3 fetcher for s3://bucket/key URLs. Downloaded files are automatically stored in the cache
+// to save time/bandwidth.
+//
+// Credentials and settings are loaded from the standard AWS environment variables and shared
+// config files (~/.aws/config and ~/.aws/credentials). If no credentials are available, requests
+// are made anonymously, which works for public datasets. The bucket's region is detected
+// automatically unless AWS_REGION (or a profile region) is set.
+type s3Fetcher struct {
+	cacheRef
+
+	resource  string
+	localPath string
+	body      io.ReadCloser
+	size      int64
+	modTime   time.Time
+}
+
+func (n *s3Fetcher) String() string {
+	return "S3 Download"
+}
+
+func (n *s3Fetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "s3://")
+}
+
+func (n *s3Fetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *s3Fetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "s3")
+
+	n.resource = resource
+	n.localPath = n.cache().cachedFilePath(ctx, resource)
+	if n.localPath != "" {
+		return nil
+	}
+
+	bucket, key, err := s3Location(resource)
+	if err != nil {
+		return err
+	}
+	cli, err := s3Client(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	resp, err := cli.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("s3 fetch of '%s' failed: %s", resource, err.Error())
+	}
+
+	if n.body != nil {
+		n.body.Close()
+	}
+	n.body = resp.Body
+	n.size = -1
+	if resp.ContentLength != nil {
+		n.size = *resp.ContentLength
+	}
+	n.modTime = time.Time{}
+	if resp.LastModified != nil {
+		n.modTime = *resp.LastModified
+	}
+	return nil
+}
+
+// s3Location returns the bucket and key of an s3:// resource.
+func s3Location(resource string) (string, string, error) {
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return "", "", err
+	}
+	bucket := furl.Host
+	key := strings.TrimPrefix(furl.Path, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("s3 resource '%s' must be of the form s3://bucket/key", resource)
+	}
+	return bucket, key, nil
+}
+
+// Stat uses a HeadObject request.
+func (n *s3Fetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	bucket, key, err := s3Location(resource)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	cli, err := s3Client(ctx, bucket)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	resp, err := cli.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ResourceInfo{}, fmt.Errorf("s3 stat of '%s' failed: %s", resource, err.Error())
+	}
+
+	info := ResourceInfo{Size: -1, ContentType: aws.ToString(resp.ContentType), ETag: aws.ToString(resp.ETag)}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		info.ModTime = *resp.LastModified
+	}
+	return info, nil
+}
+
+// s3Client returns a client configured for bucket's region, using anonymous credentials if none
+// are available from the CredentialProvider or the environment.
+func s3Client(ctx context.Context, bucket string) (*s3.Client, error) {
+	hc := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.Proxy = proxyForRequest
+		if cfg := currentTLSConfig(); cfg != nil {
+			tr.TLSClientConfig = cfg.Clone()
+		}
+	})
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithHTTPClient(hc))
+	if err != nil {
+		return nil, err
+	}
+	if c := lookupCredentials("s3", bucket); c != nil && c.Username != "" {
+		creds := aws.Credentials{AccessKeyID: c.Username, SecretAccessKey: c.Password,
+			SessionToken: c.Token, Source: "anydata.CredentialProvider"}
+		cfg.Credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return creds, nil
+		})
+	} else if _, err = cfg.Credentials.Retrieve(ctx); err != nil {
+		cfg.Credentials = aws.AnonymousCredentials{}
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+		region, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(cfg), bucket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine region of s3 bucket '%s': %s", bucket, err.Error())
+		}
+		cfg.Region = region
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func (n *s3Fetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *s3Fetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.localPath != "" {
+		f, err := n.cache().openFile(n.localPath)
+		if err != nil {
+			return nil, err
+		}
+		return contextReader(ctx, f), nil
+	}
+	if n.resource == "" {
+		return nil, fmt.Errorf("reading from s3 source failed (did you call Fetch?)")
+	}
+
+	if n.body == nil {
+		// a previous reader already consumed the object, so start over
+		if err := n.FetchContext(ctx, n.resource); err != nil {
+			return nil, err
+		}
+		if n.localPath != "" {
+			return n.GetReaderContext(ctx)
+		}
+	}
+
+	body := n.body
+	n.body = nil
+	body = reportProgress(n.resource, body, 0, n.size)
+	tee := n.cache().newCacheTee(n.resource, body, "s3", func(fn string) { n.localPath = fn })
+	if tee.cw != nil {
+		tee.cw.SetModTime(n.modTime)
+	}
+	return contextReader(ctx, readCloser(limitReader(ctx, n.resource, tee), tee)), nil
+}
@@ -0,0 +1,146 @@
+package anydata_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/anydata"
+)
+
+// s3Server serves the objects in a fake S3 endpoint, using path-style URLs.
+func s3Server(t *testing.T, objects map[string][]byte) (*httptest.Server, *int32) {
+	var gets int32
+	modtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, found := objects[r.URL.Path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&gets, 1)
+		}
+		http.ServeContent(w, r, "", modtime, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_RESPONSE_CHECKSUM_VALIDATION", "when_required")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	return srv, &gets
+}
+
+func TestS3Fetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := []byte(strings.Repeat("id\tname\n", 1000))
+	_, gets := s3Server(t, map[string][]byte{"/bucket/dir/data.tsv": content})
+
+	for i := 0; i < 2; i++ {
+		f, err := anydata.GetFetcher("s3://bucket/dir/data.tsv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch("s3://bucket/dir/data.tsv"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("read %d bytes: %v", len(data), err)
+		}
+		if c, ok := r.(interface{ Close() error }); ok {
+			c.Close()
+		}
+	}
+	if n := atomic.LoadInt32(gets); n != 1 {
+		t.Errorf("expected the second read to use the cache, got %d downloads", n)
+	}
+
+	info, err := anydata.Stat("s3://bucket/dir/data.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Errorf("unexpected stat: %+v", info)
+	}
+
+	fetch := func(resource string) error {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return err
+		}
+		return f.Fetch(resource)
+	}
+	if err = fetch("s3://bucket/missing.tsv"); err == nil {
+		t.Error("expected an error for a missing object")
+	}
+	for _, resource := range []string{"s3://bucket", "s3://bucket/", "s3:///key"} {
+		if err = fetch(resource); err == nil || !strings.Contains(err.Error(), "s3://bucket/key") {
+			t.Errorf("expected an error for '%s', got %v", resource, err)
+		}
+	}
+}
+
+func TestS3BandwidthLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := bytes.Repeat([]byte("x"), 64<<10)
+	s3Server(t, map[string][]byte{"/limited/a": content, "/other/a": content})
+
+	// host limits apply to the bucket name
+	anydata.SetHostBandwidthLimit("limited", 32<<10)
+	defer anydata.SetHostBandwidthLimit("limited", 0)
+
+	read := func(resource string) time.Duration {
+		start := time.Now()
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = anydata.FetchContext(context.Background(), f, resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := anydata.GetReaderContext(context.Background(), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if data, err := ioutil.ReadAll(r); err != nil || len(data) != len(content) {
+			t.Fatalf("read %d bytes: %v", len(data), err)
+		}
+		return time.Since(start)
+	}
+	if d := read("s3://limited/a"); d < 500*time.Millisecond {
+		t.Errorf("limited download took %s", d)
+	}
+	if d := read("s3://other/a"); d > 500*time.Millisecond {
+		t.Errorf("unlimited download took %s", d)
+	}
+}
@@ -0,0 +1,404 @@
+package anydata
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// SSHForwardAgent enables SSH agent forwarding for sftp:// and scp:// connections when an
+	// agent is available (via SSH_AUTH_SOCK). It is disabled by default, as it allows the remote
+	// host to authenticate as you while connected.
+	SSHForwardAgent = false
+
+	// SSHKeyFiles lists the private key files tried for sftp:// and scp:// authentication, in
+	// addition to any keys held by a running SSH agent. Paths beginning with "~/" are relative
+	// to the user's home directory. Passphrase-protected keys must be loaded into the agent.
+	SSHKeyFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+)
+
+// sshConnect dials the host of furl and authenticates using the password embedded in the URL
+// (or from the CredentialProvider), then the SSH agent, then any readable SSHKeyFiles. The remote host key is checked
+// against ~/.ssh/known_hosts if it exists.
+func sshConnect(ctx context.Context, furl *url.URL) (*ssh.Client, error) {
+	host := furl.Host
+	if furl.Port() == "" {
+		host = net.JoinHostPort(furl.Hostname(), "22")
+	}
+
+	username := os.Getenv("USER")
+	keyFiles := SSHKeyFiles
+	var auths []ssh.AuthMethod
+	if furl.User != nil {
+		username = furl.User.Username()
+		if passwd, haspass := furl.User.Password(); haspass {
+			auths = append(auths, ssh.Password(passwd))
+		}
+	} else if c := lookupCredentials(furl.Scheme, furl.Host); c != nil {
+		if c.Username != "" {
+			username = c.Username
+		}
+		if c.Password != "" {
+			auths = append(auths, ssh.Password(c.Password))
+		}
+		if c.SSHKeyFile != "" {
+			keyFiles = append([]string{c.SSHKeyFile}, keyFiles...)
+		}
+	}
+
+	var aconn net.Conn
+	var agentClient agent.ExtendedAgent
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		var err error
+		if aconn, err = net.Dial("unix", sock); err == nil {
+			agentClient = agent.NewClient(aconn)
+			auths = append(auths, ssh.PublicKeysCallback(agentClient.Signers))
+		}
+	}
+	closeAgent := func() {
+		if aconn != nil {
+			aconn.Close()
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, fn := range keyFiles {
+		if strings.HasPrefix(fn, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				continue
+			}
+			fn = filepath.Join(home, fn[2:])
+		}
+		key, err := ioutil.ReadFile(fn)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			Logf("skipping ssh key %s: %s\n", fn, err.Error())
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+
+	hostKeys := ssh.InsecureIgnoreHostKey()
+	if home, err := os.UserHomeDir(); err == nil {
+		if hk, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err == nil {
+			hostKeys = hk
+		}
+	}
+
+	cfg := &ssh.ClientConfig{
+		User:            username,
+		Auth:            auths,
+		HostKeyCallback: hostKeys,
+	}
+	conn, err := dialProxy(ctx, "ssh", host)
+	if err != nil {
+		closeAgent()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, cfg)
+	if err != nil {
+		conn.Close()
+		closeAgent()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(c, chans, reqs)
+
+	if !SSHForwardAgent || agentClient == nil {
+		closeAgent()
+		return client, nil
+	}
+	if err = agent.ForwardToAgent(client, agentClient); err != nil {
+		client.Close()
+		closeAgent()
+		return nil, err
+	}
+	go func() {
+		// the agent connection is needed until the ssh connection closes
+		client.Wait()
+		closeAgent()
+	}()
+	return client, nil
+}
+
+///////////////////
+
+// An SSH fetcher for sftp:// and scp:// URLs. Downloaded files are automatically stored in the
+// cache to save time/bandwidth. Authenticates with a password embedded in the URL, keys held by
+// a running SSH agent, or unencrypted keys listed in SSHKeyFiles.
+//
+// sftp:// resources are read using the SFTP subsystem, and scp:// resources are read using the
+// remote scp command (for servers which do not provide SFTP). Paths are relative to the login
+// directory unless they begin with "//" (e.g. sftp://host//data/file.txt).
+type sshFetcher struct {
+	cacheRef
+
+	resource  string
+	localPath string
+	body      io.ReadCloser
+	size      int64
+}
+
+func (n *sshFetcher) String() string {
+	return "SFTP/SCP Download"
+}
+
+func (n *sshFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "sftp://") || strings.HasPrefix(resource, "scp://")
+}
+
+func (n *sshFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+func (n *sshFetcher) FetchContext(ctx context.Context, resource string) error {
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "ssh")
+
+	n.resource = resource
+	n.localPath = n.cache().cachedFilePath(ctx, resource)
+	if n.localPath != "" {
+		return nil
+	}
+
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return err
+	}
+	remotePath := strings.TrimPrefix(furl.Path, "/")
+	if remotePath == "" {
+		return fmt.Errorf("%s resource '%s' has no path", furl.Scheme, resource)
+	}
+
+	client, err := sshConnect(ctx, furl)
+	if err != nil {
+		return err
+	}
+
+	var body io.ReadCloser
+	var size int64
+	if furl.Scheme == "scp" {
+		body, size, err = scpOpen(client, remotePath)
+	} else {
+		body, size, err = sftpOpen(client, remotePath)
+	}
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("%s fetch of '%s' failed: %s", furl.Scheme, resource, err.Error())
+	}
+
+	if n.body != nil {
+		n.body.Close()
+	}
+	n.body = readCloser(body, body, client)
+	n.size = size
+	return nil
+}
+
+func (n *sshFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *sshFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.localPath != "" {
+		f, err := n.cache().openFile(n.localPath)
+		if err != nil {
+			return nil, err
+		}
+		return contextReader(ctx, f), nil
+	}
+	if n.resource == "" {
+		return nil, fmt.Errorf("reading from ssh source failed (did you call Fetch?)")
+	}
+
+	if n.body == nil {
+		// a previous reader already consumed the file, so start over
+		if err := n.FetchContext(ctx, n.resource); err != nil {
+			return nil, err
+		}
+		if n.localPath != "" {
+			return n.GetReaderContext(ctx)
+		}
+	}
+
+	body := n.body
+	n.body = nil
+	body = reportProgress(n.resource, body, 0, n.size)
+	tee := n.cache().newCacheTee(n.resource, body, "ssh", func(fn string) { n.localPath = fn })
+	return contextReader(ctx, readCloser(limitReader(ctx, n.resource, tee), tee)), nil
+}
+
+// Stat uses the SFTP subsystem for both sftp:// and scp:// resources.
+func (n *sshFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	furl, err := url.Parse(resource)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	client, err := sshConnect(ctx, furl)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	defer client.Close()
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return ResourceInfo{}, fmt.Errorf("%s stat of '%s' failed: %s", furl.Scheme, resource, err.Error())
+	}
+	defer sc.Close()
+
+	st, err := sc.Stat(strings.TrimPrefix(furl.Path, "/"))
+	if err != nil {
+		return ResourceInfo{}, fmt.Errorf("%s stat of '%s' failed: %s", furl.Scheme, resource, err.Error())
+	}
+	return ResourceInfo{Size: st.Size(), ModTime: st.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(furl.Path))}, nil
+}
+
+// sftpOpen opens remotePath using the SFTP subsystem of client, and returns its size (or -1).
+func sftpOpen(client *ssh.Client, remotePath string) (io.ReadCloser, int64, error) {
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := sc.Open(remotePath)
+	if err != nil {
+		sc.Close()
+		return nil, 0, err
+	}
+	size := int64(-1)
+	if st, err := f.Stat(); err == nil {
+		size = st.Size()
+	}
+	return readCloser(f, f, sc), size, nil
+}
+
+///////////////////
+
+// scpReader reads a single file sent by a remote "scp -f" command.
+type scpReader struct {
+	sess   *ssh.Session
+	stdin  io.WriteCloser
+	r      *bufio.Reader
+	remain int64
+}
+
+// scpOpen starts "scp -f remotePath" on client and reads the file header, returning the size
+// of the file.
+func scpOpen(client *ssh.Client, remotePath string) (io.ReadCloser, int64, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, 0, err
+	}
+	if SSHForwardAgent {
+		agent.RequestAgentForwarding(sess)
+	}
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		sess.Close()
+		return nil, 0, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		sess.Close()
+		return nil, 0, err
+	}
+	if err = sess.Start("scp -f " + shellQuote(remotePath)); err != nil {
+		sess.Close()
+		return nil, 0, err
+	}
+
+	sr := &scpReader{sess: sess, stdin: stdin, r: bufio.NewReader(stdout)}
+	if err = sr.readHeader(); err != nil {
+		sr.Close()
+		return nil, 0, err
+	}
+	return sr, sr.remain, nil
+}
+
+// readHeader reads the "C<mode> <size> <name>" line describing the file, skipping any
+// timestamp ("T") lines.
+func (sr *scpReader) readHeader() error {
+	for {
+		if _, err := sr.stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		line, err := sr.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return fmt.Errorf("empty scp response")
+		}
+		switch line[0] {
+		case 'T':
+			continue
+		case 'C':
+			parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("invalid scp header '%s'", strings.TrimSpace(line))
+			}
+			sr.remain, err = strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid scp header '%s'", strings.TrimSpace(line))
+			}
+			_, err = sr.stdin.Write([]byte{0})
+			return err
+		case 'D':
+			return fmt.Errorf("scp resource is a directory")
+		default:
+			// \x01 (warning) and \x02 (error) are followed by a message
+			return fmt.Errorf("scp: %s", strings.TrimSpace(line[1:]))
+		}
+	}
+}
+
+func (sr *scpReader) Read(p []byte) (int, error) {
+	if sr.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > sr.remain {
+		p = p[:sr.remain]
+	}
+	n, err := sr.r.Read(p)
+	sr.remain -= int64(n)
+	if err == io.EOF && sr.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (sr *scpReader) Close() error {
+	sr.stdin.Close()
+	return sr.sess.Close()
+}
+
+// shellQuote quotes s for use as a single argument to a remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
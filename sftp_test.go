@@ -0,0 +1,190 @@
+package anydata_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpServer starts an SSH server with a read-only SFTP subsystem, which accepts a single user
+// and password. It returns the server's address.
+func sftpServer(t *testing.T, user, password string) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, cfg)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveSFTP(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		ch, chreqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chreqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						if srv, err := sftp.NewServer(ch, sftp.ReadOnly()); err == nil {
+							srv.Serve()
+						}
+						ch.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// hostCredentials provides credentials for a single host, and records the lookups.
+type hostCredentials struct {
+	host  string
+	creds anydata.Credentials
+
+	mu      sync.Mutex
+	lookups []string
+}
+
+func (hc *hostCredentials) Credentials(scheme, host string) (*anydata.Credentials, error) {
+	hc.mu.Lock()
+	hc.lookups = append(hc.lookups, scheme+"://"+host)
+	hc.mu.Unlock()
+	if host == hc.host {
+		return &hc.creds, nil
+	}
+	return nil, nil
+}
+
+func TestSFTPFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(filepath.Join(dir, "cache"), 1)
+
+	// no known_hosts, keys or agent
+	t.Setenv("HOME", dir)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	content := strings.Repeat("id\tname\n", 1000)
+	for _, name := range []string{"a.tsv", "b.tsv", "c.tsv"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := sftpServer(t, "alice", "secret")
+
+	read := func(resource string) (string, error) {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return "", err
+		}
+		if err = f.Fetch(resource); err != nil {
+			return "", err
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	// paths beginning with "//" are absolute
+	data, err := read("sftp://alice:secret@" + addr + "/" + filepath.Join(dir, "a.tsv"))
+	if err != nil || data != content {
+		t.Errorf("unexpected sftp read of %d bytes: %v", len(data), err)
+	}
+	if _, err = read("sftp://alice:wrong@" + addr + "/" + filepath.Join(dir, "b.tsv")); err == nil {
+		t.Error("expected an error for a wrong password")
+	}
+	if _, err = read("sftp://alice:secret@" + addr); err == nil || !strings.Contains(err.Error(), "has no path") {
+		t.Errorf("expected an error for a resource without a path, got %v", err)
+	}
+
+	// without a user in the URL, the CredentialProvider is used
+	hc := &hostCredentials{host: addr, creds: anydata.Credentials{Username: "alice", Password: "secret"}}
+	anydata.SetCredentialProvider(hc)
+	defer anydata.SetCredentialProvider(nil)
+	resource := "sftp://" + addr + "/" + filepath.Join(dir, "c.tsv")
+	if data, err = read(resource); err != nil || data != content {
+		t.Errorf("unexpected sftp read of %d bytes: %v", len(data), err)
+	}
+	if len(hc.lookups) != 1 || hc.lookups[0] != "sftp://"+addr {
+		t.Errorf("unexpected credential lookups: %v", hc.lookups)
+	}
+
+	info, err := anydata.Stat(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Errorf("unexpected stat: %+v", info)
+	}
+}
+
+func TestSSHDetect(t *testing.T) {
+	for _, resource := range []string{"sftp://host/data.txt", "scp://user@host:2222//data.txt"} {
+		desc, err := anydata.Describe(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(desc, "SFTP/SCP Download") {
+			t.Errorf("unexpected fetcher for '%s': %s", resource, desc)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package anydata
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// sharedCache is a read-only cache folder, see AddSharedCache.
+type sharedCache struct {
+	path    string
+	modTime time.Time
+	entries map[string]cachedfile
+}
+
+// AddSharedCache adds a read-only cache folder to the DefaultCache, which is consulted before
+// the (writable) cache set by InitCache. This allows a team to share a single copy of large
+// reference files, e.g. on an NFS mount populated by a nightly job:
+//
+//    anydata.InitCache("/mnt/shared/anydata", 7)  // in the mirror job
+//    ...
+//    anydata.AddSharedCache("/mnt/shared/anydata") // in each user's program
+//    anydata.InitCache(filepath.Join(home, ".cache", "anydata"), 7)
+//
+// Files which are missing or expired in the shared folders are downloaded into the writable
+// cache as usual. Nothing is ever written to a shared folder, and its cacheinfo.json is reloaded
+// when it changes. Shared copies expire in the same way as other cached files (see SetCacheTTL),
+// and are checked against their recorded size but not hashed, since they may be very large.
+// Shared folders are consulted in the order they were added.
+func AddSharedCache(cpath string) error {
+	return DefaultCache.AddShared(cpath)
+}
+
+// AddShared adds a read-only cache folder to c, see AddSharedCache.
+func (c *Cache) AddShared(cpath string) error {
+	st, err := os.Stat(path.Join(cpath, "cacheinfo.json"))
+	if err != nil {
+		return err
+	}
+	index, err := readCacheIndex(cpath)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.shared = append(c.shared, &sharedCache{path: cpath, modTime: st.ModTime(), entries: index})
+	c.mu.Unlock()
+	return nil
+}
+
+// reload re-reads the shared cacheinfo.json if it has been modified since it was loaded.
+func (sc *sharedCache) reload() {
+	st, err := os.Stat(path.Join(sc.path, "cacheinfo.json"))
+	if err != nil || st.ModTime().Equal(sc.modTime) {
+		return
+	}
+	index, err := readCacheIndex(sc.path)
+	if err != nil {
+		Logf("%s\n", err.Error())
+		return
+	}
+	sc.entries, sc.modTime = index, st.ModTime()
+}
+
+// lookup returns the local path and entry of a copy of resource in the shared folder which is
+// recent enough for c, or "" if there is none.
+func (sc *sharedCache) lookup(c *Cache, resource string) (string, cachedfile) {
+	sc.reload()
+	cf, found := sc.entries[cacheKey(resource)]
+	if !found || time.Now().Sub(cf.FetchTime) > c.resourceTTL(resource) {
+		return "", cf
+	}
+	fn := path.Join(sc.path, cf.LocalName)
+	st, err := os.Stat(fn)
+	if err != nil || (cf.Size != 0 && st.Size() != cf.Size) {
+		return "", cf
+	}
+	return fn, cf
+}
+
+// sharedCachedFile returns the local path of a recent copy of resource in a shared cache
+// folder, or "" if there is none. c.mu must be held.
+func (c *Cache) sharedCachedFile(resource string) string {
+	for _, sc := range c.shared {
+		if fn, _ := sc.lookup(c, resource); fn != "" {
+			return fn
+		}
+	}
+	return ""
+}
+
+// sharedFetchTime returns the time that a recent copy of resource in a shared cache folder was
+// fetched. c.mu must be held.
+func (c *Cache) sharedFetchTime(resource string) (time.Time, bool) {
+	for _, sc := range c.shared {
+		if fn, cf := sc.lookup(c, resource); fn != "" {
+			return cf.FetchTime, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -1,125 +1,384 @@
-// A really simple cache method set for persistence to JSON and local files.
+// A really simple cache method set for persistence to JSON and local files. The package-level
+// functions operate on DefaultCache, see cache.go for the Cache type.
 
 package anydata
 
 import (
-	"crypto/md5"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"strings"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type cachedfile struct {
 	LocalName string    `json:"local_path"`
 	FetchTime time.Time `json:"fetch_timestamp"`
+
+	// validators used to check if the remote file has changed (HTTP ETag and Last-Modified)
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// payload size and last use, for evicting the least recently used files
+	Size       int64     `json:"size,omitempty"`
+	AccessTime time.Time `json:"access_timestamp,omitempty"`
+
+	// hex sha256 of the payload, checked before the cached copy is used
+	SHA256 string `json:"sha256,omitempty"`
+
+	// the resource as fetched (before mirrors are mapped to their canonical location), and the
+	// size and modification time of the remote file, to help inspect the cache by hand
+	Resource      string    `json:"resource,omitempty"`
+	RemoteSize    int64     `json:"remote_size,omitempty"`
+	RemoteModTime time.Time `json:"remote_modified,omitempty"`
 }
 
-var (
-	cachePath string
-	cached    map[string]cachedfile
+type cacheTTL struct {
+	prefix string
+	ttl    time.Duration
+}
 
-	// time to cache data files for
-	cacheAge time.Duration
+// SetCacheTTL sets how long cached copies of resources beginning with prefix remain valid,
+// instead of the age given to InitCache. For example, a daily feed and a static reference
+// archive can be cached with different policies:
+//
+//    anydata.SetCacheTTL("https://feeds.example.com/", 12*time.Hour)
+//    anydata.SetCacheTTL("ftp://ftp.ncbi.nih.gov/pub/taxonomy/", 90*24*time.Hour)
+//
+// A ttl of 0 means cached copies are never used without checking the remote file first. If
+// several prefixes match a resource, the longest one applies.
+func SetCacheTTL(prefix string, ttl time.Duration) {
+	DefaultCache.SetTTL(prefix, ttl)
+}
+
+// CacheMode overrides the use of cached copies for a single fetch, see WithCacheMode.
+type CacheMode int
+
+const (
+	// CacheDefault uses cached copies until they expire (see SetCacheTTL).
+	CacheDefault CacheMode = iota
+
+	// CacheNoCache treats cached copies as expired, so they are revalidated with the remote host
+	// (where supported) or downloaded again.
+	CacheNoCache
+
+	// CacheRefresh ignores any cached copy, and downloads the resource again to replace it.
+	CacheRefresh
 )
 
-// InitCache initializes the cache by loading prior cached dates and filenames from
+type cacheModeKey struct{}
+
+// WithCacheMode returns a context which applies mode to fetches made with it, e.g.
+//
+//    err := anydata.FetchContext(anydata.WithCacheMode(ctx, anydata.CacheRefresh), f, resource)
+func WithCacheMode(ctx context.Context, mode CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, mode)
+}
+
+// cacheModeFrom returns the CacheMode set on ctx.
+func cacheModeFrom(ctx context.Context) CacheMode {
+	mode, _ := ctx.Value(cacheModeKey{}).(CacheMode)
+	return mode
+}
+
+// SetCacheMaxSize limits the total size of the files in the cache to maxBytes. When a new file
+// is added and the limit is exceeded, the least recently used files are deleted until the cache
+// fits again. The file just added is never removed, even if it is larger than maxBytes on its
+// own. A limit of 0 (the default) lets the cache grow without bound.
+func SetCacheMaxSize(maxBytes int64) {
+	DefaultCache.SetMaxSize(maxBytes)
+}
+
+// SetCacheCompression compresses files as they are added to the cache, to save space on large
+// uncompressed downloads (e.g. TSV dumps). The format may be "gzip", "zstd" or "" (the default)
+// to store files as downloaded. Files which are already compressed or archived (e.g. .gz, .zip)
+// are always stored verbatim. Compressed files are decompressed transparently when read from
+// the cache, and files cached with a different setting remain readable.
+func SetCacheCompression(format string) error {
+	return DefaultCache.SetCompression(format)
+}
+
+// InitCache initializes the DefaultCache by loading prior cached dates and filenames from
 // <cpath>/cacheinfo.json if it exists, and setting the desired data age (in days).
 // If the cpath folder does not exist, it is created.
-// If cacheinfo.json cannot be loaded, then an empty cache is created.
+// If cacheinfo.json cannot be loaded, then an empty cache is created (and the error is logged,
+// see OpenCache).
 func InitCache(cpath string, ageDays int) {
-	cachePath = cpath
+	if err := OpenCache(cpath, ageDays); err != nil {
+		Logf("%s\n", err.Error())
+	}
+}
+
+// OpenCache is equivalent to InitCache, but returns an error if the cpath folder cannot be
+// created or cacheinfo.json cannot be read. The cache is still initialized (possibly empty) when
+// an error is returned, so callers may choose to continue without the previous contents.
+func OpenCache(cpath string, ageDays int) error {
 	if ageDays < 1 {
 		ageDays = 1
 	}
-	cacheAge = time.Duration(ageDays) * 24 * time.Hour
-	cached = make(map[string]cachedfile)
-
-	// create cachePath if it doesn't exist
-	os.Mkdir(cachePath, 0777)
+	DefaultCache.mu.Lock()
+	defer DefaultCache.mu.Unlock()
+	return DefaultCache.init(cpath, time.Duration(ageDays)*24*time.Hour)
+}
 
-	f, err := os.Open(path.Join(cachePath, "cacheinfo.json"))
-	if err != nil {
-		return
+// readCacheIndex loads <cpath>/cacheinfo.json. The error satisfies os.IsNotExist if there is no
+// index.
+func readCacheIndex(cpath string) (map[string]cachedfile, error) {
+	data, err := ioutil.ReadFile(path.Join(cpath, "cacheinfo.json"))
+	if os.IsNotExist(err) {
+		return nil, err
 	}
-	defer f.Close()
-
-	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("unable to read cache index: %s", err.Error())
 	}
+	index := make(map[string]cachedfile)
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("cache index %s is corrupt: %s", path.Join(cpath, "cacheinfo.json"), err.Error())
+	}
+	return index, nil
+}
 
-	json.Unmarshal(data, &cached)
+// cacheKey strips the fragment from an archive resource, and maps mirrors to their canonical
+// location. (can't use url.Parse cause it may not be a URL...)
+func cacheKey(resource string) string {
+	return canonicalResource(strings.SplitN(resource, "#", 2)[0])
 }
 
-// GetCachedFile returns the contents of a file (identified by resource) from the cache.
-// If the resource is too old or does not exist, returns nil.
-func GetCachedFile(resource string) []byte {
-	if cached == nil {
-		InitCache("cache", 7)
+// fileSHA256 returns the hex sha256 of the contents of fn.
+func fileSHA256(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
 
-	// if its an archive, strip off the fragment
-	// (can't use url.Parse cause it may not be a URL...)
-	rparts := strings.SplitN(resource, "#", 2)
+// cacheSuffixes maps the compression formats used for cached payloads to their file suffixes.
+var cacheSuffixes = map[string]string{"gzip": ".gz", "zstd": ".zst"}
 
-	if cinfo, found := cached[rparts[0]]; found {
-		if time.Now().Sub(cinfo.FetchTime) > cacheAge {
-			log.Printf("Cached copy is too old (%dh)\n", time.Now().Sub(cinfo.FetchTime)/time.Hour)
-			return nil
+// openCachedFile opens a cached payload, decompressing it if it was stored compressed (see
+// SetCacheCompression).
+func openCachedFile(fn string) (io.ReadCloser, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(fn, cacheSuffixes["gzip"]):
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if err != nil {
+			f.Close()
+			return nil, err
 		}
-
-		// cached copy is recent, use it instead of fetching
-		f, err := os.Open(path.Join(cachePath, cinfo.LocalName))
-		if err == nil {
-			data, err := ioutil.ReadAll(f)
+		return readCloser(gz, gz, f), nil
+	case strings.HasSuffix(fn, cacheSuffixes["zstd"]):
+		zd, err := zstd.NewReader(f)
+		if err != nil {
 			f.Close()
-
-			if err == nil {
-				return data
-			}
+			return nil, err
 		}
+		return readCloser(zd, zd.IOReadCloser(), f), nil
 	}
-	return nil
+	return f, nil
 }
 
-// PutCachedFile saves the contents of a file (identified by resource) to the cache.
-func PutCachedFile(resource string, data []byte) {
-	// if its an archive, strip off the fragment
-	// (can't use url.Parse cause it may not be a URL...)
-	rparts := strings.SplitN(resource, "#", 2)
-
-	// sanitize the filename into an md5 hash, and write to local cache dir
-	temphash := md5.New()
-	io.WriteString(temphash, rparts[0])
-	tempname := fmt.Sprintf("%x", temphash.Sum(nil))
-	f, err := os.OpenFile(path.Join(cachePath, tempname), os.O_WRONLY|os.O_CREATE, 0666)
+// compressCachedFile compresses the payload fn using format, unless it is already compressed,
+// and returns the name of the resulting file. fn is removed once it has been compressed.
+func compressCachedFile(fn, format string) (string, error) {
+	f, err := os.Open(fn)
 	if err != nil {
-		log.Println(err.Error())
-		return
+		return "", err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(262); sniffSuffix(head, false) != "" || sniffSuffix(head, true) != "" {
+		return fn, nil
 	}
-	f.Write(data)
-	f.Close()
 
-	// add the cache entry and serialize to disk immediately
-	cached[rparts[0]] = cachedfile{LocalName: tempname, FetchTime: time.Now()}
-	cdata, err := json.Marshal(cached)
+	cfn := fn + cacheSuffixes[format]
+	out, err := os.Create(cfn)
 	if err != nil {
-		log.Println(err.Error())
-		return
+		return "", err
+	}
+	var zw io.WriteCloser
+	if format == "zstd" {
+		zw, err = zstd.NewWriter(out)
+	} else {
+		zw = gzip.NewWriter(out)
+	}
+	if err == nil {
+		_, err = io.Copy(zw, br)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
 	}
-
-	f, err = os.OpenFile(path.Join(cachePath, "cacheinfo.json"), os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		log.Println(err.Error())
-		return
+		os.Remove(cfn)
+		return "", err
 	}
-	f.Write(cdata)
-	f.Close()
+	os.Remove(fn)
+	return cfn, nil
+}
+
+// CacheStats returns the hits, misses, evictions and bytes served and downloaded by the DefaultCache
+// since the program started. The same counts are reported through the metrics package, e.g. to
+// Prometheus (see metrics.SetCollector).
+func CacheStats() CacheCounters {
+	return DefaultCache.Stats()
+}
+
+// PublishCacheStats publishes the DefaultCache counters (see CacheStats) as an expvar variable
+// called name, which is served at /debug/vars by programs that import expvar's HTTP handler.
+// Like expvar.Publish, it panics if name is already in use.
+func PublishCacheStats(name string) {
+	DefaultCache.PublishStats(name)
+}
+
+// ErrNotCached is returned by ReadCachedFile when there is no recent cached copy of a resource.
+var ErrNotCached = errors.New("not in the cache")
+
+// GetCachedFile returns the contents of a file (identified by resource) from the cache.
+// If the resource is too old or does not exist, returns nil.
+func GetCachedFile(resource string) []byte {
+	return DefaultCache.GetFile(resource)
+}
+
+// ReadCachedFile is equivalent to GetCachedFile, but returns ErrNotCached if the resource is
+// too old or does not exist, and any other error if the cached copy cannot be read.
+func ReadCachedFile(resource string) ([]byte, error) {
+	return DefaultCache.ReadFile(resource)
+}
+
+// PutCachedFile saves the contents of a file (identified by resource) to the cache. Errors are
+// logged, see WriteCachedFile.
+func PutCachedFile(resource string, data []byte) {
+	DefaultCache.PutFile(resource, data)
+}
+
+// WriteCachedFile is equivalent to PutCachedFile, but returns an error (e.g. if the disk is
+// full) instead of logging it. The cache is unchanged when an error is returned.
+func WriteCachedFile(resource string, data []byte) error {
+	return DefaultCache.WriteFile(resource, data)
+}
+
+// GetCachedStream opens a file (identified by resource) from the cache, so that it can be read
+// without loading it into memory. If the resource is too old or does not exist, returns nil.
+func GetCachedStream(resource string) io.ReadCloser {
+	return DefaultCache.GetStream(resource)
+}
+
+// CacheWriter streams a new file into the cache, see PutCachedStream.
+type CacheWriter interface {
+	// Write appends data to the cached file.
+	Write(p []byte) (int, error)
+
+	// Close adds the file to the cache, replacing any previous copy.
+	Close() error
+
+	// Abort discards the data written so far, leaving the cache unchanged.
+	Abort()
+}
+
+// PutCachedStream returns a CacheWriter which saves a file (identified by resource) to the
+// cache as it is written, without holding it in memory. The file is only added to the cache
+// when Close is called, so readers never see a partial copy. For example, to cache a large
+// download:
+//
+//    cw, err := anydata.PutCachedStream(resource)
+//    ...
+//    if _, err = io.Copy(cw, resp.Body); err != nil {
+//        cw.Abort()
+//        return err
+//    }
+//    return cw.Close()
+func PutCachedStream(resource string) (CacheWriter, error) {
+	return DefaultCache.PutStream(resource)
+}
+
+// TeeCachedStream returns a reader for r which also saves everything read to the cache as
+// resource. The file is added to the cache once r has been read to the end, and discarded if
+// the reader is closed early. This allows a Fetcher to stream a download to its caller and
+// cache it at the same time.
+func TeeCachedStream(resource string, r io.ReadCloser) io.ReadCloser {
+	return DefaultCache.TeeStream(resource, r)
+}
+
+// cacheStream adapts a cacheWriter to the CacheWriter interface.
+type cacheStream struct {
+	cw *cacheWriter
+}
+
+func (s *cacheStream) Write(p []byte) (int, error) {
+	return s.cw.Write(p)
+}
+
+func (s *cacheStream) Close() error {
+	_, err := s.cw.Commit()
+	return err
+}
+
+func (s *cacheStream) Abort() {
+	s.cw.Abort()
+}
+
+///////////////////
+
+// CacheEntry describes a file in the cache, see ListCache.
+type CacheEntry struct {
+	// Resource is the resource string the file was fetched for, without any fragment. Mirrors
+	// are listed under their canonical prefix (see RegisterMirrors).
+	Resource string
+
+	// LocalPath is the location of the cached copy. It ends in .gz or .zst if the copy was
+	// compressed by the cache (see SetCacheCompression).
+	LocalPath string
+
+	// Size is the size of the cached copy on disk in bytes.
+	Size int64
+
+	// FetchTime is when the file was downloaded (or last revalidated), and AccessTime is when
+	// it was last read from the cache.
+	FetchTime  time.Time
+	AccessTime time.Time
+
+	// Expired is true if the copy is too old to be used without checking the remote file.
+	Expired bool
+}
+
+// ListCache returns every file in the cache, sorted by resource.
+func ListCache() []CacheEntry {
+	return DefaultCache.List()
+}
+
+// EvictCachedFile removes the cached copy of resource, so that it is downloaded again the next
+// time it is fetched. It is not an error if resource is not cached.
+func EvictCachedFile(resource string) error {
+	return DefaultCache.Evict(resource)
+}
 
+// PurgeCache removes every cached file which was fetched more than olderThan ago (or all of
+// them if olderThan is 0), and returns the number of files removed.
+func PurgeCache(olderThan time.Duration) (int, error) {
+	return DefaultCache.Purge(olderThan)
 }
@@ -0,0 +1,526 @@
+package anydata_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestCacheMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+	anydata.SetCacheMaxSize(250)
+	defer anydata.SetCacheMaxSize(0)
+
+	data := bytes.Repeat([]byte("x"), 100)
+	anydata.PutCachedFile("http://example.com/a", data)
+	anydata.PutCachedFile("http://example.com/b", data)
+	anydata.PutCachedFile("http://example.com/c", data)
+
+	if anydata.GetCachedFile("http://example.com/a") != nil {
+		t.Error("expected the least recently used file to be evicted")
+	}
+	for _, resource := range []string{"http://example.com/b", "http://example.com/c"} {
+		if !bytes.Equal(anydata.GetCachedFile(resource), data) {
+			t.Errorf("%s: expected a cached copy", resource)
+		}
+	}
+
+	// sizes and access times are saved in cacheinfo.json
+	anydata.InitCache(dir, 1)
+	anydata.SetCacheMaxSize(100)
+	if anydata.GetCachedFile("http://example.com/b") != nil {
+		t.Error("expected the cache to shrink to the new limit")
+	}
+}
+
+func TestCachedStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	cw, err := anydata.PutCachedStream("http://example.com/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(cw, bytes.NewReader(data))
+	if anydata.GetCachedStream("http://example.com/big") != nil {
+		t.Error("expected no cached copy until the writer is closed")
+	}
+	if err = cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := anydata.GetCachedStream("http://example.com/big")
+	if r == nil {
+		t.Fatal("expected a cached copy")
+	}
+	got, _ := ioutil.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes from the cache, expected %d", len(got), len(data))
+	}
+
+	// a tee only caches streams which are read to the end
+	tee := anydata.TeeCachedStream("http://example.com/tee", ioutil.NopCloser(bytes.NewReader(data)))
+	tee.Read(make([]byte, 10))
+	tee.Close()
+	if anydata.GetCachedStream("http://example.com/tee") != nil {
+		t.Error("expected a partially read stream not to be cached")
+	}
+	tee = anydata.TeeCachedStream("http://example.com/tee", ioutil.NopCloser(bytes.NewReader(data)))
+	ioutil.ReadAll(tee)
+	tee.Close()
+	if !bytes.Equal(anydata.GetCachedFile("http://example.com/tee"), data) {
+		t.Error("expected the stream to be cached")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("feed contents\n"))
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/feed.txt"
+	fetch := func(ctx context.Context) {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = anydata.FetchContext(ctx, f, resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := anydata.GetReaderContext(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(r)
+		r.Close()
+	}
+
+	logs := &bytes.Buffer{}
+	anydata.SetLogger(log.New(logs, "", 0))
+	defer anydata.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+	ctx := context.Background()
+	for i, step := range []struct {
+		ctx  context.Context
+		want int32
+	}{
+		{ctx, 1},
+		{ctx, 1}, // cached
+		{anydata.WithCacheMode(ctx, anydata.CacheRefresh), 2},
+		{anydata.WithCacheMode(ctx, anydata.CacheNoCache), 3},
+		{ctx, 3},
+	} {
+		fetch(step.ctx)
+		if n := atomic.LoadInt32(&requests); n != step.want {
+			t.Errorf("step %d: expected %d requests, got %d", i, step.want, n)
+		}
+	}
+	if strings.Contains(logs.String(), "too old") {
+		t.Errorf("unexpected log output for copies within the TTL: %s", logs.String())
+	}
+
+	anydata.SetCacheTTL(srv.URL+"/", 0)
+	fetch(ctx)
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("expected a request once the TTL is 0, got %d requests", n)
+	}
+}
+
+func TestCacheManagement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	for _, resource := range []string{"http://example.com/b", "http://example.com/a#member", "http://example.com/c"} {
+		anydata.PutCachedFile(resource, []byte("data"))
+	}
+	entries := anydata.ListCache()
+	if len(entries) != 3 || entries[0].Resource != "http://example.com/a" || entries[0].Size != 4 {
+		t.Fatalf("unexpected cache entries %+v", entries)
+	}
+
+	if err = anydata.EvictCachedFile("http://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(entries[0].LocalPath); !os.IsNotExist(err) {
+		t.Error("expected the evicted file to be deleted")
+	}
+	if n, err := anydata.PurgeCache(time.Hour); n != 0 || err != nil {
+		t.Errorf("expected no recent files to be purged, got %d (%v)", n, err)
+	}
+	if n, err := anydata.PurgeCache(0); n != 2 || err != nil {
+		t.Errorf("expected 2 files to be purged, got %d (%v)", n, err)
+	}
+	if entries = anydata.ListCache(); len(entries) != 0 {
+		t.Errorf("expected an empty cache, got %+v", entries)
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a cache folder cannot be created below a regular file
+	notdir := dir + "/file"
+	if err = ioutil.WriteFile(notdir, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = anydata.OpenCache(notdir+"/cache", 1); err == nil {
+		t.Error("expected an error opening a cache below a file")
+	}
+	if err = anydata.WriteCachedFile("http://example.com/a", []byte("data")); err == nil {
+		t.Error("expected an error writing to a broken cache")
+	}
+
+	if err = anydata.OpenCache(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = anydata.ReadCachedFile("http://example.com/a"); err != anydata.ErrNotCached {
+		t.Errorf("expected ErrNotCached, got %v", err)
+	}
+	if err = anydata.WriteCachedFile("http://example.com/a", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := anydata.ReadCachedFile("http://example.com/a"); string(data) != "data" || err != nil {
+		t.Errorf("unexpected cached contents %q (%v)", data, err)
+	}
+
+	if err = ioutil.WriteFile(dir+"/cacheinfo.json", []byte("{corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = anydata.OpenCache(dir, 1); err == nil {
+		t.Error("expected an error opening a corrupt cache index")
+	}
+}
+
+func TestCacheChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	anydata.PutCachedFile("http://example.com/a", []byte("some data"))
+	entries := anydata.ListCache()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected cache entries %+v", entries)
+	}
+
+	// simulate a write truncated by a crash, in a new process
+	if err = ioutil.WriteFile(entries[0].LocalPath, []byte("some"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	anydata.InitCache(dir, 1)
+	if data := anydata.GetCachedFile("http://example.com/a"); data != nil {
+		t.Errorf("expected a corrupt copy to be a cache miss, got %q", data)
+	}
+	if entries = anydata.ListCache(); len(entries) != 0 {
+		t.Errorf("expected the corrupt copy to be removed, got %+v", entries)
+	}
+
+	// the same size, but different contents
+	anydata.PutCachedFile("http://example.com/a", []byte("some data"))
+	entries = anydata.ListCache()
+	if err = ioutil.WriteFile(entries[0].LocalPath, []byte("some date"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	anydata.InitCache(dir, 1)
+	if data := anydata.GetCachedFile("http://example.com/a"); data != nil {
+		t.Errorf("expected a corrupt copy to be a cache miss, got %q", data)
+	}
+	anydata.PutCachedFile("http://example.com/a", []byte("some data"))
+	anydata.InitCache(dir, 1)
+	if data := anydata.GetCachedFile("http://example.com/a"); string(data) != "some data" {
+		t.Errorf("expected the cached copy, got %q", data)
+	}
+}
+
+func TestCacheCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+	if err = anydata.SetCacheCompression("zstd"); err != nil {
+		t.Fatal(err)
+	}
+	defer anydata.SetCacheCompression("")
+
+	text := bytes.Repeat([]byte("id\tname\tvalue\n"), 1000)
+	gzipped := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	anydata.PutCachedFile("http://example.com/a.tsv", text)
+	anydata.PutCachedFile("http://example.com/b.gz", gzipped)
+
+	entries := anydata.ListCache()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected cache entries %+v", entries)
+	}
+	if !strings.HasSuffix(entries[0].LocalPath, ".zst") || entries[0].Size >= int64(len(text)) {
+		t.Errorf("expected a compressed copy of a.tsv, got %+v", entries[0])
+	}
+	if strings.HasSuffix(entries[1].LocalPath, ".zst") || entries[1].Size != int64(len(gzipped)) {
+		t.Errorf("expected a verbatim copy of b.gz, got %+v", entries[1])
+	}
+
+	if data := anydata.GetCachedFile("http://example.com/a.tsv"); !bytes.Equal(data, text) {
+		t.Errorf("unexpected decompressed contents (%d bytes)", len(data))
+	}
+	if data := anydata.GetCachedFile("http://example.com/b.gz"); !bytes.Equal(data, gzipped) {
+		t.Errorf("unexpected verbatim contents %v", data)
+	}
+	if err = anydata.SetCacheCompression("lz4"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	shared, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(shared)
+	private, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(private)
+
+	// populate the shared folder as a mirror job would
+	anydata.InitCache(shared, 1)
+	anydata.PutCachedFile("http://example.com/shared/ref.txt", []byte("reference"))
+
+	anydata.InitCache(private, 1)
+	if err = anydata.AddSharedCache(shared); err != nil {
+		t.Fatal(err)
+	}
+	if err = anydata.AddSharedCache(private + "/missing"); err == nil {
+		t.Error("expected an error adding a folder without a cache index")
+	}
+	if data := anydata.GetCachedFile("http://example.com/shared/ref.txt"); string(data) != "reference" {
+		t.Errorf("expected the shared copy, got %q", data)
+	}
+	if entries := anydata.ListCache(); len(entries) != 0 {
+		t.Errorf("expected nothing in the writable cache, got %+v", entries)
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err = anydata.NewCache(dir, &anydata.CacheOptions{Compression: "lz4"}); err == nil {
+		t.Error("expected an error for an unknown compression format")
+	}
+	c, err := anydata.NewCache(dir, &anydata.CacheOptions{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("isolated\n"))
+	}))
+	defer srv.Close()
+
+	r := anydata.NewRegistry()
+	r.RegisterDefaults()
+	r.SetCache(c)
+	resource := srv.URL + "/isolated.txt"
+	f, err := r.GetFetcher(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Fetch(resource); err != nil {
+		t.Fatal(err)
+	}
+	rd, err := anydata.GetReaderContext(context.Background(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(rd)
+	rd.Close()
+
+	if data := c.GetFile(resource); string(data) != "isolated\n" {
+		t.Errorf("expected the download in the new cache, got %q", data)
+	}
+	if data := anydata.GetCachedFile(resource); data != nil {
+		t.Errorf("expected nothing in the default cache, got %q", data)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := anydata.NewCache(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.PutFile("http://example.com/a", []byte("data"))
+	c.GetFile("http://example.com/a")
+	c.GetFile("http://example.com/b")
+	c.Evict("http://example.com/a")
+
+	want := anydata.CacheCounters{Hits: 1, Misses: 1, Evictions: 1, BytesServed: 4, BytesDownloaded: 4}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestCacheAtomicWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// an index left behind by a crash is cleaned up
+	if err = ioutil.WriteFile(dir+"/cacheinfo.json.tmp123", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	anydata.InitCache(dir, 1)
+	anydata.PutCachedFile("http://example.com/a", []byte("data"))
+	anydata.PutCachedFile("http://example.com/b", []byte("data"))
+
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range names {
+		if strings.Contains(fi.Name(), ".tmp") || strings.HasSuffix(fi.Name(), ".partial") {
+			t.Errorf("unexpected temporary file '%s' in the cache", fi.Name())
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("expected 2 payloads and the index, got %d files", len(names))
+	}
+}
+
+func TestCacheConcurrentDownloads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	content := []byte(strings.Repeat("0123456789abcdef", 8192))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	resource := srv.URL + "/data.txt"
+	open := func() io.Reader {
+		f, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := f.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// the second download starts while the first is half way through
+	a := open()
+	first := make([]byte, len(content)/2)
+	if _, err = io.ReadFull(a, first); err != nil {
+		t.Fatal(err)
+	}
+	b := open()
+	second := make([]byte, 1000)
+	if _, err = io.ReadFull(b, second); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []io.Reader{a, b} {
+		if _, err = io.Copy(ioutil.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data := anydata.GetCachedFile(resource); !bytes.Equal(data, content) {
+		t.Errorf("cached copy has %d bytes, expected %d bytes of the download", len(data), len(content))
+	}
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range names {
+		if strings.Contains(fi.Name(), ".tmp") || strings.Contains(fi.Name(), ".partial") {
+			t.Errorf("unexpected temporary file '%s' in the cache", fi.Name())
+		}
+	}
+}
+
+func TestCacheFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	anydata.InitCache(dir, 1)
+
+	anydata.PutCachedFile("ftp://ftp.example.com/pub/taxdump.tar.gz", []byte("data"))
+	anydata.PutCachedFile("https://example.com/export?format=tsv&name=a b", []byte("data"))
+	entries := anydata.ListCache()
+	if len(entries) != 2 {
+		t.Fatalf("unexpected cache entries %+v", entries)
+	}
+	pattern := regexp.MustCompile(`^(taxdump\.tar\.gz|export)-[0-9a-f]{8}$`)
+	for _, ce := range entries {
+		if name := filepath.Base(ce.LocalPath); !pattern.MatchString(name) {
+			t.Errorf("unexpected cache file name '%s' for '%s'", name, ce.Resource)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package anydata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DetectCompression enables detection of compressed files and archives from their first few
+// bytes, for resources whose names do not have a recognized suffix (e.g. API endpoints which
+// return gzipped data). Gzip, bzip2, xz and zstd files are decompressed, and if the resource
+// has a fragment, zip, 7z and (compressed) tar archives are recognized too.
+var DetectCompression = true
+
+type forcedWrapper struct {
+	prefix string
+	suffix string
+}
+
+var (
+	forcedMu      sync.RWMutex
+	forcedSuffixs []forcedWrapper
+)
+
+// ForceWrapper applies the Wrappers for suffix to every resource beginning with prefix, as if
+// their paths ended with suffix. For example, to decompress a gzipped API response:
+//
+//    anydata.ForceWrapper("https://api.example.com/export?", ".gz")
+//
+// Any suffix recognized by a registered Wrapper may be used, e.g. ".tar.bz2" to extract
+// members from a bzip2'd tarball using the fragment syntax.
+func ForceWrapper(prefix, suffix string) {
+	forcedMu.Lock()
+	forcedSuffixs = append(forcedSuffixs, forcedWrapper{prefix: prefix, suffix: suffix})
+	forcedMu.Unlock()
+}
+
+// forcedSuffix returns the suffix set by ForceWrapper for resource, if any.
+func forcedSuffix(resource string) string {
+	forcedMu.RLock()
+	defer forcedMu.RUnlock()
+	for _, fw := range forcedSuffixs {
+		if strings.HasPrefix(resource, fw.prefix) {
+			return fw.suffix
+		}
+	}
+	return ""
+}
+
+var magicNumbers = []struct {
+	magic      []byte
+	suffix     string
+	compressed bool
+}{
+	{[]byte{0x1f, 0x8b}, ".gz", true},
+	{[]byte("BZh"), ".bz2", true},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0}, ".xz", true},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst", true},
+	{[]byte("PK\x03\x04"), ".zip", false},
+	{[]byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, ".7z", false},
+}
+
+// sniffSuffix returns the file suffix matching the magic number at the start of head, or ""
+// if it is not recognized. If archive is true, compressed files are assumed to be tarballs.
+func sniffSuffix(head []byte, archive bool) string {
+	for _, m := range magicNumbers {
+		if !bytes.HasPrefix(head, m.magic) {
+			continue
+		}
+		if !archive && !m.compressed {
+			return ""
+		}
+		if archive && m.compressed {
+			return ".tar" + m.suffix
+		}
+		return m.suffix
+	}
+	if archive && len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return ".tar"
+	}
+	return ""
+}
+
+///////////////////
+
+// A content sniffing wrapper, which applies the Wrapper matching the first bytes of a resource.
+// It is used by GetFetcher when no Wrapper matches the name of the resource (see
+// DetectCompression).
+type sniffWrapper struct {
+	registry *Registry
+	wrapped  Fetcher
+	partname string
+}
+
+func (n *sniffWrapper) String() string {
+	return fmt.Sprintf("%s", n.wrapped)
+}
+
+func (n *sniffWrapper) Detect(resource string) bool {
+	return false
+}
+
+// Unwrap returns the Fetcher which this wrapper reads from.
+func (n *sniffWrapper) Unwrap() Fetcher {
+	return n.wrapped
+}
+
+func (n *sniffWrapper) Fetch(resource string) error {
+	return n.wrapped.Fetch(resource)
+}
+
+func (n *sniffWrapper) FetchContext(ctx context.Context, resource string) error {
+	return FetchContext(ctx, n.wrapped, resource)
+}
+
+func (n *sniffWrapper) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *sniffWrapper) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	f, err := n.detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return GetReaderContext(ctx, f)
+}
+
+func (n *sniffWrapper) walkMembers(ctx context.Context, fn MemberFunc) error {
+	f, err := n.detect(ctx)
+	if err != nil {
+		return err
+	}
+	if mw, ok := f.(memberWalker); ok {
+		return mw.walkMembers(ctx, fn)
+	}
+	if rc, ok := f.(*readerFetcher); ok {
+		rc.rc.Close()
+	}
+	return fmt.Errorf("%s is not a recognized archive", n.wrapped)
+}
+
+// detect starts reading the wrapped Fetcher, and returns a Fetcher for the stream wrapped by
+// the Wrapper matching its first bytes (if any).
+func (n *sniffWrapper) detect(ctx context.Context) (Fetcher, error) {
+	rc, err := GetReaderContext(ctx, n.wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	// only a single read is made, so that slow streams are not blocked waiting for more data
+	br := bufio.NewReaderSize(rc, 512)
+	br.Peek(1)
+	head, _ := br.Peek(br.Buffered())
+	var f Fetcher = &readerFetcher{rc: readCloser(br, rc)}
+
+	suffix := sniffSuffix(head, n.partname != "")
+	if suffix == "" {
+		return f, nil
+	}
+	w := n.registry.wrapperFor("sniffed"+suffix, n.partname)
+	if w == nil {
+		return f, nil
+	}
+	Logf("detected %s content in %s\n", suffix, n.wrapped)
+	return w.Wrap(f, n.partname)
+}
+
+///////////////////
+
+// readerFetcher is a Fetcher for an already open stream, so that Wrappers can be applied to it.
+type readerFetcher struct {
+	rc io.ReadCloser
+}
+
+func (n *readerFetcher) String() string {
+	return "stream"
+}
+
+func (n *readerFetcher) Detect(resource string) bool {
+	return false
+}
+
+func (n *readerFetcher) Fetch(resource string) error {
+	return nil
+}
+
+func (n *readerFetcher) GetReader() (io.Reader, error) {
+	return n.rc, nil
+}
+
+func (n *readerFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	return n.rc, nil
+}
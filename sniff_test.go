@@ -0,0 +1,67 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestDetectCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gzName := filepath.Join(dir, "export")
+	f, _ := os.Create(gzName)
+	gw := gzip.NewWriter(f)
+	gw.Write([]byte("gzip contents\n"))
+	gw.Close()
+	f.Close()
+
+	tgzName := filepath.Join(dir, "bundle.dat")
+	f, _ = os.Create(tgzName)
+	gw = gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "names.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("hello\n"))
+	tw.Close()
+	gw.Close()
+	f.Close()
+
+	forced := filepath.Join(dir, "forced.txt")
+	f, _ = os.Create(forced)
+	gw = gzip.NewWriter(f)
+	gw.Write([]byte("forced contents\n"))
+	gw.Close()
+	f.Close()
+	anydata.ForceWrapper(forced, ".gz")
+
+	for resource, want := range map[string]string{
+		gzName:                 "gzip contents\n",
+		tgzName + "#names.txt": "hello\n",
+		forced:                 "forced contents\n",
+	} {
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = fr.Fetch(resource); err != nil {
+			t.Fatal(err)
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v)", resource, data, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package anydata
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/anydata/metrics"
+)
+
+// SQLDrivers maps database URL schemes to the database/sql driver used to query them. Drivers
+// are not included in this package, so the program must import one (e.g. "github.com/lib/pq"
+// for postgres://) before these resources can be fetched. Change an entry to use another
+// driver, such as "pgx" for postgres.
+var SQLDrivers = map[string]string{
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"mysql":      "mysql",
+	"sqlite":     "sqlite3",
+	"sqlserver":  "sqlserver",
+}
+
+var (
+	sqlMu sync.RWMutex
+	sqlDB = make(map[string]*sql.DB)
+)
+
+// A SQL database fetcher, which runs the query given in the "query" URL parameter and streams
+// the resulting rows, e.g.:
+//
+//    postgres://user@dbhost/genes?query=SELECT%20symbol,%20name%20FROM%20genes
+//    mysql://user@dbhost:3306/ensembl?query=SELECT%20*%20FROM%20xref
+//    sqlite:///data/local.db?query=SELECT%20*%20FROM%20samples
+//
+// If the URL has no username, the password is obtained from the CredentialProvider. Any other
+// URL parameters are passed on to the driver. The output is one JSON array per line: first the
+// column names, then the values of each row (NULLs are null). Use the "sql" format to parse it.
+//
+// Query results are not cached, the query is run again each time the resource is read.
+type sqlFetcher struct {
+	driver string
+	dsn    string
+	query  string
+}
+
+func (n *sqlFetcher) String() string {
+	return "SQL Database (" + n.driver + ")"
+}
+
+func (n *sqlFetcher) Detect(resource string) bool {
+	i := strings.Index(resource, "://")
+	if i < 1 {
+		return false
+	}
+	_, found := SQLDrivers[resource[:i]]
+	return found
+}
+
+// sqlSource parses a database resource into the driver name, data source name and query.
+func sqlSource(resource string) (driver, dsn, query string, err error) {
+	furl, err := url.Parse(strings.SplitN(resource, "#", 2)[0])
+	if err != nil {
+		return "", "", "", err
+	}
+	driver = SQLDrivers[furl.Scheme]
+	if driver == "" {
+		return "", "", "", fmt.Errorf("'%s' is not a database resource", resource)
+	}
+	q := furl.Query()
+	query = q.Get("query")
+	if query == "" {
+		return "", "", "", fmt.Errorf("database resource '%s' has no query parameter", resource)
+	}
+	q.Del("query")
+	furl.RawQuery = q.Encode()
+
+	if furl.User == nil && furl.Host != "" {
+		if creds := lookupCredentials(furl.Scheme, furl.Hostname()); creds != nil && creds.Username != "" {
+			furl.User = url.UserPassword(creds.Username, creds.Password)
+		}
+	}
+
+	switch furl.Scheme {
+	case "mysql":
+		// go-sql-driver/mysql uses "user:password@tcp(host:port)/dbname?params"
+		host := furl.Host
+		if furl.Port() == "" {
+			host = net.JoinHostPort(furl.Hostname(), "3306")
+		}
+		if furl.User != nil {
+			dsn = furl.User.String() + "@"
+			if pw, ok := furl.User.Password(); ok {
+				dsn = furl.User.Username() + ":" + pw + "@"
+			}
+		}
+		dsn += "tcp(" + host + ")" + furl.Path
+		if furl.RawQuery != "" {
+			dsn += "?" + furl.RawQuery
+		}
+	case "sqlite":
+		// a file path, e.g. "sqlite:///abs/path.db" or "sqlite://./relative.db"
+		dsn = furl.Host + furl.Path
+		if furl.RawQuery != "" {
+			dsn = "file:" + dsn + "?" + furl.RawQuery
+		}
+	default:
+		// postgres and sqlserver drivers accept URLs directly
+		dsn = furl.String()
+	}
+	return driver, dsn, query, nil
+}
+
+// openDB returns a (shared) connection pool for the data source.
+func openDB(driver, dsn string) (*sql.DB, error) {
+	key := driver + "\x00" + dsn
+	sqlMu.RLock()
+	db := sqlDB[key]
+	sqlMu.RUnlock()
+	if db != nil {
+		return db, nil
+	}
+
+	sqlMu.Lock()
+	defer sqlMu.Unlock()
+	if db = sqlDB[key]; db != nil {
+		return db, nil
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		if strings.Contains(err.Error(), "unknown driver") {
+			return nil, fmt.Errorf("sql driver '%s' is not registered (did you import it?)", driver)
+		}
+		return nil, err
+	}
+	sqlDB[key] = db
+	return db, nil
+}
+
+func (n *sqlFetcher) Fetch(resource string) error {
+	return n.FetchContext(context.Background(), resource)
+}
+
+// FetchContext checks that the database can be reached. The query itself is run by
+// GetReaderContext.
+func (n *sqlFetcher) FetchContext(ctx context.Context, resource string) error {
+	var err error
+	n.driver, n.dsn, n.query, err = sqlSource(resource)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(n.driver, n.dsn)
+	if err != nil {
+		return err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		return fmt.Errorf("unable to connect to database for '%s': %s", resource, err.Error())
+	}
+	return nil
+}
+
+// Stat reports an unknown size, since the query results are only available by running it.
+func (n *sqlFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if _, _, _, err := sqlSource(resource); err != nil {
+		return ResourceInfo{}, err
+	}
+	return ResourceInfo{Size: -1, ContentType: "application/x-ndjson"}, nil
+}
+
+func (n *sqlFetcher) GetReader() (io.Reader, error) {
+	return n.GetReaderContext(context.Background())
+}
+
+func (n *sqlFetcher) GetReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if n.query == "" {
+		return nil, fmt.Errorf("no query to run (did you call Fetch?)")
+	}
+	defer metrics.Since(metrics.FetchDuration, time.Now(), "fetcher", "sql")
+
+	db, err := openDB(n.driver, n.dsn)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, n.query)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %s", err.Error())
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+
+	rr := &rowsReader{rows: rows, values: make([]sql.NullString, len(cols))}
+	enc := json.NewEncoder(&rr.buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(cols)
+	rr.enc = enc
+	return rr, nil
+}
+
+// rowsReader encodes query results as JSON lines, one row at a time.
+type rowsReader struct {
+	rows   *sql.Rows
+	values []sql.NullString
+	buf    bytes.Buffer
+	enc    *json.Encoder
+	done   bool
+}
+
+func (r *rowsReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if !r.rows.Next() {
+			r.done = true
+			if err := r.rows.Err(); err != nil {
+				return 0, err
+			}
+			r.rows.Close()
+			return 0, io.EOF
+		}
+		if err := r.nextRow(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// nextRow scans the current row into the buffer.
+func (r *rowsReader) nextRow() error {
+	dest := make([]interface{}, len(r.values))
+	for i := range r.values {
+		dest[i] = &r.values[i]
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	row := make([]*string, len(r.values))
+	for i, v := range r.values {
+		if v.Valid {
+			s := v.String
+			row[i] = &s
+		}
+	}
+	return r.enc.Encode(row)
+}
+
+func (r *rowsReader) Close() error {
+	r.done = true
+	return r.rows.Close()
+}
@@ -0,0 +1,81 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ResourceInfo describes a resource, as reported by Stat.
+type ResourceInfo struct {
+	// Size is the size of the resource in bytes, or -1 if it is unknown.
+	Size int64
+
+	// ModTime is the last modification time, or the zero Time if it is unknown.
+	ModTime time.Time
+
+	// ContentType is the MIME type reported by the server (or guessed from the file extension),
+	// if known.
+	ContentType string
+
+	// ETag is an opaque version identifier (e.g. an HTTP or S3 entity tag), if known.
+	ETag string
+}
+
+// StatFetcher is implemented by Fetchers which can describe a resource without downloading
+// it. All of the built-in Fetchers implement it.
+type StatFetcher interface {
+	Fetcher
+
+	// Stat returns information about resource. Fetch does not need to be called first.
+	Stat(ctx context.Context, resource string) (ResourceInfo, error)
+}
+
+// Stat returns the size, modification time and content type of resource without downloading
+// it, using the DefaultRegistry. This allows callers to skip unchanged files or to show the
+// total size of a batch of downloads, for example.
+//
+// The information describes the file which would be downloaded, so the size of a compressed or
+// archived resource is that of the whole compressed file or archive.
+func Stat(resource string) (ResourceInfo, error) {
+	return DefaultRegistry.StatContext(context.Background(), resource)
+}
+
+// StatContext is equivalent to Stat, but aborts when ctx is done.
+func StatContext(ctx context.Context, resource string) (ResourceInfo, error) {
+	return DefaultRegistry.StatContext(ctx, resource)
+}
+
+// Stat is equivalent to the package-level Stat, but resolves resource using r.
+func (r *Registry) Stat(resource string) (ResourceInfo, error) {
+	return r.StatContext(context.Background(), resource)
+}
+
+// StatContext is equivalent to the package-level StatContext, but resolves resource using r.
+// Each mirror of resource is tried in turn.
+func (r *Registry) StatContext(ctx context.Context, resource string) (ResourceInfo, error) {
+	resource = strings.SplitN(resource, "#", 2)[0]
+	candidates := mirrorCandidates(resource)
+	if candidates == nil {
+		candidates = []string{resource}
+	}
+
+	var err error
+	var info ResourceInfo
+	for _, c := range candidates {
+		var f Fetcher
+		if f, err = r.baseFetcher(c); err != nil {
+			continue
+		}
+		sf, ok := f.(StatFetcher)
+		if !ok {
+			err = fmt.Errorf("%v does not support Stat", f)
+			continue
+		}
+		if info, err = sf.Stat(ctx, c); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return info, err
+}
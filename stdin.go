@@ -0,0 +1,167 @@
+package anydata
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SpoolStdin controls whether standard input is copied into a temporary file in the cache
+// folder when it is first fetched. This allows stdin to be read more than once, and gives
+// wrappers which need random access (such as zip) a seekable file. The temporary file is
+// removed immediately, so it does not outlive the process.
+var SpoolStdin = false
+
+var (
+	stdinOnce sync.Once
+	stdinFile *os.File
+	stdinSize int64
+	stdinErr  error
+
+	stdinMu    sync.Mutex
+	stdinTaken bool
+)
+
+// spoolStdin copies all of standard input into an (unlinked) temporary file.
+func spoolStdin() (*os.File, int64, error) {
+	stdinOnce.Do(func() {
+		stdinFile, stdinErr = ioutil.TempFile(DefaultCache.dir(), "stdin")
+		if stdinErr != nil {
+			return
+		}
+		os.Remove(stdinFile.Name())
+		stdinSize, stdinErr = io.Copy(stdinFile, os.Stdin)
+	})
+	return stdinFile, stdinSize, stdinErr
+}
+
+// A standard input fetcher, which detects the resources "-" and "stdin://". A path may be
+// added to select wrappers, e.g. "stdin:///data.tar.gz#names.txt" to extract a file from a
+// gzipped tarball on stdin.
+//
+// Unless SpoolStdin is set, standard input is streamed directly and can only be read once.
+type stdinFetcher struct{}
+
+func (n *stdinFetcher) String() string {
+	return "Standard Input"
+}
+
+func (n *stdinFetcher) Detect(resource string) bool {
+	return resource == "-" || strings.HasPrefix(resource, "stdin://")
+}
+
+func (n *stdinFetcher) Fetch(resource string) error {
+	if SpoolStdin {
+		_, _, err := spoolStdin()
+		return err
+	}
+	return nil
+}
+
+// Stat returns the size of standard input if it is known, i.e. if it is a redirected file or
+// has been spooled.
+func (n *stdinFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	if SpoolStdin {
+		_, size, err := spoolStdin()
+		return ResourceInfo{Size: size}, err
+	}
+	if st, err := os.Stdin.Stat(); err == nil && st.Mode().IsRegular() {
+		return ResourceInfo{Size: st.Size(), ModTime: st.ModTime()}, nil
+	}
+	return ResourceInfo{Size: -1}, nil
+}
+
+func (n *stdinFetcher) GetReader() (io.Reader, error) {
+	if SpoolStdin {
+		f, size, err := spoolStdin()
+		if err != nil {
+			return nil, err
+		}
+		return io.NewSectionReader(f, 0, size), nil
+	}
+
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+	if stdinTaken {
+		return nil, fmt.Errorf("standard input can only be read once (see SpoolStdin)")
+	}
+	stdinTaken = true
+	return os.Stdin, nil
+}
+
+///////////////////
+
+// An RFC 2397 data URI fetcher, for small inline data sets such as test fixtures:
+//
+//    data:,hello%20world
+//    data:text/plain;base64,aGVsbG8gd29ybGQ=
+//
+// The media type is ignored.
+type dataFetcher struct {
+	data []byte
+}
+
+func (n *dataFetcher) String() string {
+	return "Data URI"
+}
+
+func (n *dataFetcher) Detect(resource string) bool {
+	return strings.HasPrefix(resource, "data:")
+}
+
+func (n *dataFetcher) Fetch(resource string) error {
+	parts := strings.SplitN(strings.TrimPrefix(resource, "data:"), ",", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid data URI (missing ',')")
+	}
+
+	var err error
+	if strings.HasSuffix(parts[0], ";base64") {
+		payload, uerr := url.PathUnescape(parts[1])
+		if uerr != nil {
+			return uerr
+		}
+		n.data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			// also accept unpadded and URL-safe variants
+			n.data, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(
+				strings.NewReplacer("+", "-", "/", "_").Replace(payload), "="))
+		}
+		if err != nil {
+			return fmt.Errorf("invalid data URI - %s", err.Error())
+		}
+		return nil
+	}
+
+	payload, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid data URI - %s", err.Error())
+	}
+	n.data = []byte(payload)
+	return nil
+}
+
+func (n *dataFetcher) Stat(ctx context.Context, resource string) (ResourceInfo, error) {
+	d := &dataFetcher{}
+	if err := d.Fetch(resource); err != nil {
+		return ResourceInfo{}, err
+	}
+	ctype := strings.TrimSuffix(strings.SplitN(strings.TrimPrefix(resource, "data:"), ",", 2)[0], ";base64")
+	if ctype == "" {
+		ctype = "text/plain;charset=US-ASCII"
+	} else if strings.HasPrefix(ctype, ";") {
+		ctype = "text/plain" + ctype
+	}
+	return ResourceInfo{Size: int64(len(d.data)), ContentType: ctype}, nil
+}
+
+func (n *dataFetcher) GetReader() (io.Reader, error) {
+	return bytes.NewReader(n.data), nil
+}
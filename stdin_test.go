@@ -0,0 +1,96 @@
+package anydata
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// withStdin replaces standard input with a pipe which supplies data, and resets the stdin
+// fetcher's state.
+func withStdin(t *testing.T, data []byte) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	stdinOnce, stdinFile, stdinSize, stdinErr, stdinTaken = sync.Once{}, nil, 0, nil, false
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+		if stdinFile != nil {
+			stdinFile.Close()
+		}
+		stdinOnce, stdinFile, stdinSize, stdinErr, stdinTaken = sync.Once{}, nil, 0, nil, false
+	})
+}
+
+func readStdin(resource string) ([]byte, error) {
+	f, err := GetFetcher(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Fetch(resource); err != nil {
+		return nil, err
+	}
+	r, err := f.GetReader()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestStdinFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	InitCache(dir, 1)
+
+	content := bytes.Repeat([]byte("id\tname\n"), 1000)
+	withStdin(t, content)
+	if data, err := readStdin("-"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("read %d bytes: %v", len(data), err)
+	}
+	if _, err = readStdin("-"); err == nil {
+		t.Error("expected an error reading stdin twice")
+	}
+
+	// spooled stdin can be read many times
+	SpoolStdin = true
+	defer func() { SpoolStdin = false }()
+	withStdin(t, content)
+	for i := 0; i < 2; i++ {
+		if data, err := readStdin("stdin://"); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("read %d bytes: %v", len(data), err)
+		}
+	}
+	info, err := Stat("-")
+	if err != nil || info.Size != int64(len(content)) {
+		t.Errorf("unexpected stat: %+v (%v)", info, err)
+	}
+}
+
+func TestStdinWrappers(t *testing.T) {
+	content := bytes.Repeat([]byte("id\tname\n"), 1000)
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(content)
+	zw.Close()
+
+	// the path selects the gzip wrapper
+	withStdin(t, buf.Bytes())
+	if data, err := readStdin("stdin:///data.tsv.gz"); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("read %d bytes: %v", len(data), err)
+	}
+}
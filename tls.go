@@ -0,0 +1,89 @@
+package anydata
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// TLSOptions configures the TLS connections made by the HTTPS, FTPS and S3 fetchers, e.g. for
+// internal data servers which use a private certificate authority.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CA certificates to trust in addition to the system roots.
+	CAFile string
+
+	// CertFile and KeyFile are a PEM client certificate and private key, for servers which
+	// require client authentication.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the minimum TLS version to accept (e.g. tls.VersionTLS12). If zero, Go's
+	// default is used.
+	MinVersion uint16
+
+	// InsecureSkipVerify disables verification of server certificates. Connections are then
+	// open to interception, so this should only be used for testing.
+	InsecureSkipVerify bool
+}
+
+// Config builds a tls.Config from the options, loading any certificate files.
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         o.MinVersion,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs, err = x509.SystemCertPool()
+		if err != nil || cfg.RootCAs == nil {
+			cfg.RootCAs = x509.NewCertPool()
+		}
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in '%s'", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+var (
+	tlsMu     sync.Mutex
+	tlsConfig *tls.Config
+)
+
+// SetTLSOptions sets the TLS options used by all fetchers (HTTPOptions.TLSConfig overrides
+// them for a single HTTP fetch). Passing nil restores the defaults.
+func SetTLSOptions(opts *TLSOptions) error {
+	var cfg *tls.Config
+	if opts != nil {
+		var err error
+		if cfg, err = opts.Config(); err != nil {
+			return err
+		}
+		if opts.InsecureSkipVerify {
+			Logf("warning: TLS certificate verification is disabled\n")
+		}
+	}
+	tlsMu.Lock()
+	tlsConfig = cfg
+	tlsMu.Unlock()
+	return nil
+}
+
+// currentTLSConfig returns the config set by SetTLSOptions, or nil for the defaults.
+func currentTLSConfig() *tls.Config {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	return tlsConfig
+}
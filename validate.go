@@ -0,0 +1,72 @@
+package anydata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Validate checks that resource can be fetched without downloading it, using the
+// DefaultRegistry. It confirms that a Fetcher (and any Wrappers) match the resource, and that
+// the remote host is reachable, accepts the credentials and has the file (see Stat). This
+// allows a batch of resource strings to be checked quickly before a long run.
+//
+// When resource names an archive member (e.g. "data.tar.gz#names.dmp"), the member is also
+// looked up if the archive is a local file or is already in the cache. Otherwise only the
+// archive itself is checked, since finding the member would require downloading it.
+//
+// Fetchers which do not implement StatFetcher are checked by calling Fetch, which may start a
+// download.
+func Validate(resource string) error {
+	return DefaultRegistry.ValidateContext(context.Background(), resource)
+}
+
+// ValidateContext is equivalent to Validate, but aborts when ctx is done.
+func ValidateContext(ctx context.Context, resource string) error {
+	return DefaultRegistry.ValidateContext(ctx, resource)
+}
+
+// Validate is equivalent to the package-level Validate, but resolves resource using r.
+func (r *Registry) Validate(resource string) error {
+	return r.ValidateContext(context.Background(), resource)
+}
+
+// ValidateContext is equivalent to the package-level ValidateContext, but resolves resource
+// using r.
+func (r *Registry) ValidateContext(ctx context.Context, resource string) error {
+	f, err := r.GetFetcher(resource)
+	if err != nil {
+		return err
+	}
+	base, err := r.baseFetcher(resource)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := base.(StatFetcher); ok {
+		if _, err = r.StatContext(ctx, resource); err != nil {
+			return fmt.Errorf("validating '%s' failed: %s", resource, err.Error())
+		}
+	} else if err = FetchContext(ctx, f, resource); err != nil {
+		return fmt.Errorf("validating '%s' failed: %s", resource, err.Error())
+	}
+
+	if _, wrapped := f.(interface{ Unwrap() Fetcher }); !wrapped || !strings.Contains(resource, "#") {
+		// not an archive member
+		return nil
+	}
+	if _, local := base.(*localFetcher); !local && r.Cache().cachedFilePath(ctx, resource) == "" {
+		return nil
+	}
+
+	// the archive is on disk, so the wrappers can find the member without a download
+	if err = FetchContext(ctx, f, resource); err != nil {
+		return fmt.Errorf("validating '%s' failed: %s", resource, err.Error())
+	}
+	rc, err := GetReaderContext(ctx, f)
+	if err != nil {
+		return fmt.Errorf("validating '%s' failed: %s", resource, err.Error())
+	}
+	rc.Close()
+	return nil
+}
@@ -0,0 +1,37 @@
+package anydata_test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tarName := filepath.Join(dir, "data.tar")
+	f, _ := os.Create(tarName)
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "names.txt", Mode: 0666, Size: 6})
+	tw.Write([]byte("hello\n"))
+	tw.Close()
+	f.Close()
+
+	if err = anydata.Validate(tarName + "#names.txt"); err != nil {
+		t.Error(err)
+	}
+	if err = anydata.Validate(tarName + "#missing.txt"); err == nil {
+		t.Error("expected an error for a missing archive member")
+	}
+	if err = anydata.Validate(filepath.Join(dir, "missing.tsv")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
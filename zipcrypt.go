@@ -0,0 +1,274 @@
+package anydata
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type archivePassword struct {
+	prefix   string
+	password string
+}
+
+var (
+	passwordMu       sync.RWMutex
+	archivePasswords []archivePassword
+)
+
+// SetArchivePassword sets the password used to decrypt members of encrypted .zip archives
+// (ZipCrypto or WinZip AES) for resources beginning with prefix. For example:
+//
+//    anydata.SetArchivePassword("sftp://drop.vendor.com/2024/", "s3cret")
+//
+// If no password has been set for a resource, the Password of the credentials returned by the
+// CredentialProvider for the scheme "zip" and the archive's file name as the host (e.g.
+// "export.zip") is used.
+func SetArchivePassword(prefix, password string) {
+	passwordMu.Lock()
+	archivePasswords = append(archivePasswords, archivePassword{prefix: prefix, password: password})
+	passwordMu.Unlock()
+}
+
+// findArchivePassword returns the password for an archive resource, or "" if there is none.
+func findArchivePassword(resource string) string {
+	resource = strings.SplitN(resource, "#", 2)[0]
+	passwordMu.RLock()
+	for _, ap := range archivePasswords {
+		if strings.HasPrefix(resource, ap.prefix) {
+			passwordMu.RUnlock()
+			return ap.password
+		}
+	}
+	passwordMu.RUnlock()
+
+	if c := lookupCredentials("zip", path.Base(resource)); c != nil {
+		return c.Password
+	}
+	return ""
+}
+
+// openEncrypted returns a reader for the decrypted and decompressed contents of an encrypted
+// zip member.
+func openEncrypted(zf *zip.File, password string) (io.ReadCloser, error) {
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	method := zf.Method
+	var r io.Reader
+	if method == 99 {
+		r, method, err = aesReader(zf, raw, password)
+	} else {
+		r, err = zipCryptoReader(zf, raw, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = ioutil.NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("zip: unsupported compression method %d for encrypted '%s'", method, zf.Name)
+	}
+	if zf.CRC32 == 0 {
+		// AE-2 archives do not store the CRC, since the HMAC covers the data
+		return rc, nil
+	}
+	return &crcReader{rc: rc, hash: crc32.NewIEEE(), want: zf.CRC32, name: zf.Name}, nil
+}
+
+// zipCryptoReader returns a reader which decrypts traditional PKWARE (ZipCrypto) encryption.
+func zipCryptoReader(zf *zip.File, raw io.Reader, password string) (io.Reader, error) {
+	zc := newZipCrypto(password)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, fmt.Errorf("zip: reading encryption header of '%s': %s", zf.Name, err)
+	}
+	zc.decrypt(header)
+
+	// the last header byte is the high byte of the CRC, or of the time for streamed entries
+	check := byte(zf.CRC32 >> 24)
+	if zf.Flags&0x8 != 0 {
+		check = byte(zf.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, fmt.Errorf("zip: incorrect password for '%s'", zf.Name)
+	}
+	return &zipCryptoStream{r: raw, zc: zc}, nil
+}
+
+// zipCrypto holds the key state of the traditional PKWARE cipher.
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password string) *zipCrypto {
+	zc := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		zc.update(password[i])
+	}
+	return zc
+}
+
+func (zc *zipCrypto) update(b byte) {
+	zc.keys[0] = crc32.IEEETable[byte(zc.keys[0])^b] ^ (zc.keys[0] >> 8)
+	zc.keys[1] = (zc.keys[1]+zc.keys[0]&0xff)*134775813 + 1
+	zc.keys[2] = crc32.IEEETable[byte(zc.keys[2])^byte(zc.keys[1]>>24)] ^ (zc.keys[2] >> 8)
+}
+
+func (zc *zipCrypto) decrypt(p []byte) {
+	for i := range p {
+		t := zc.keys[2] | 2
+		p[i] ^= byte((t * (t ^ 1)) >> 8)
+		zc.update(p[i])
+	}
+}
+
+type zipCryptoStream struct {
+	r  io.Reader
+	zc *zipCrypto
+}
+
+func (s *zipCryptoStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.zc.decrypt(p[:n])
+	return n, err
+}
+
+// aesReader returns a reader which decrypts WinZip AES encryption, and the compression method
+// of the decrypted data.
+func aesReader(zf *zip.File, raw io.Reader, password string) (io.Reader, uint16, error) {
+	var strength byte
+	var method uint16
+	found := false
+	for extra := zf.Extra; len(extra) >= 4; {
+		tag, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		if tag == 0x9901 && size >= 7 {
+			strength, method = extra[8], binary.LittleEndian.Uint16(extra[9:])
+			found = true
+			break
+		}
+		extra = extra[4+size:]
+	}
+	if !found || strength < 1 || strength > 3 {
+		return nil, 0, fmt.Errorf("zip: '%s' has no valid AES encryption header", zf.Name)
+	}
+
+	keyLen := 8 + 8*int(strength)
+	saltLen := keyLen / 2
+	header := make([]byte, saltLen+2)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, 0, fmt.Errorf("zip: reading encryption header of '%s': %s", zf.Name, err)
+	}
+	key := pbkdf2.Key([]byte(password), header[:saltLen], 1000, 2*keyLen+2, sha1.New)
+	if !bytes.Equal(key[2*keyLen:], header[saltLen:]) {
+		return nil, 0, fmt.Errorf("zip: incorrect password for '%s'", zf.Name)
+	}
+
+	size := int64(zf.CompressedSize64) - int64(len(header)) - 10
+	if size < 0 {
+		return nil, 0, fmt.Errorf("zip: '%s' is truncated", zf.Name)
+	}
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, 0, err
+	}
+	ar := &aesStream{
+		r:     io.LimitReader(raw, size),
+		raw:   raw,
+		block: block,
+		mac:   hmac.New(sha1.New, key[keyLen:2*keyLen]),
+		name:  zf.Name,
+	}
+	return ar, method, nil
+}
+
+// aesStream decrypts WinZip AES data, which uses CTR mode with a little-endian counter
+// starting at 1, and checks the HMAC-SHA1 authentication code at the end.
+type aesStream struct {
+	r     io.Reader
+	raw   io.Reader
+	block cipher.Block
+	mac   hash.Hash
+	name  string
+
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+	checked bool
+}
+
+func (s *aesStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.mac.Write(p[:n])
+	for i := 0; i < n; i++ {
+		if s.used == 0 || s.used == aes.BlockSize {
+			for j := range s.counter {
+				s.counter[j]++
+				if s.counter[j] != 0 {
+					break
+				}
+			}
+			s.block.Encrypt(s.stream[:], s.counter[:])
+			s.used = 0
+		}
+		p[i] ^= s.stream[s.used]
+		s.used++
+	}
+	if err == io.EOF && !s.checked {
+		s.checked = true
+		code := make([]byte, 10)
+		if _, cerr := io.ReadFull(s.raw, code); cerr != nil {
+			return n, fmt.Errorf("zip: reading authentication code of '%s': %s", s.name, cerr)
+		}
+		if !hmac.Equal(s.mac.Sum(nil)[:10], code) {
+			return n, fmt.Errorf("zip: authentication failed for '%s'", s.name)
+		}
+	}
+	return n, err
+}
+
+// crcReader checks the CRC-32 of the data once it has been read completely.
+type crcReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	want uint32
+	name string
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		return n, fmt.Errorf("zip: checksum error in '%s'", c.name)
+	}
+	return n, err
+}
+
+func (c *crcReader) Close() error {
+	return c.rc.Close()
+}
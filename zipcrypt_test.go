@@ -0,0 +1,56 @@
+package anydata_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pbnjay/anydata"
+)
+
+func TestEncryptedZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "names.txt" encrypted with the password "s3cret", using ZipCrypto and AES-256
+	legacy, _ := base64.StdEncoding.DecodeString("UEsDBAoACQAAAMiTUF082AdVHwAAABMAAAAJABwAbmFtZXMudHh0VVQJAAM4bdJqOG3SanV4CwABBAAAAAAEAAAAAOPJqksZxGcsy1nEI1fbHNdcSWL1TZhfC7d94g9k6StQSwcIPNgHVR8AAAATAAAAUEsBAh4DCgAJAAAAyJNQXTzYB1UfAAAAEwAAAAkAGAAAAAAAAQAAAKSBAAAAAG5hbWVzLnR4dFVUBQADOG3SanV4CwABBAAAAAAEAAAAAFBLBQYAAAAAAQABAE8AAAByAAAAAAA=")
+	aes, _ := base64.StdEncoding.DecodeString("UEsDBDMAAQBjAAAAIQAAAAAAKQAAAA0AAAAJAAsAbmFtZXMudHh0AZkHAAIAQUUDAAAAAQIDBAUGBwgJCgsMDQ4P2gH5n7ISf6tIZiXaf3L5crH+YcW318QbVlBLAQIzADMAAQBjAAAAIQAAAAAAKQAAAA0AAAAJAAsAAAAAAAAAAAAAAAAAAABuYW1lcy50eHQBmQcAAgBBRQMAAFBLBQYAAAAAAQABAEIAAABbAAAAAAA=")
+	legacyName := filepath.Join(dir, "legacy.zip")
+	aesName := filepath.Join(dir, "aes.zip")
+	ioutil.WriteFile(legacyName, legacy, 0666)
+	ioutil.WriteFile(aesName, aes, 0666)
+
+	read := func(resource string) (string, error) {
+		fr, err := anydata.GetFetcher(resource)
+		if err != nil {
+			return "", err
+		}
+		if err = fr.Fetch(resource); err != nil {
+			return "", err
+		}
+		r, err := fr.GetReader()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	if _, err = read(aesName + "#names.txt"); err == nil {
+		t.Error("expected an error without a password")
+	}
+	anydata.SetArchivePassword(dir, "s3cret")
+	for resource, want := range map[string]string{
+		legacyName + "#names.txt": "zipcrypto contents\n",
+		aesName + "#names.txt":    "aes contents\n",
+	} {
+		if data, err := read(resource); err != nil || data != want {
+			t.Errorf("%s: read %q (%v)", resource, data, err)
+		}
+	}
+}